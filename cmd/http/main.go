@@ -16,7 +16,7 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	c := httpclientutil.NewClientConn(conn, nil)
+	c := httpclientutil.NewClientConn(conn)
 	req, _ := http.NewRequest("GET", "/", nil)
 	log.Println("time to write 1", c.Ping())
 	resp, err := c.Do(req)
@@ -0,0 +1,242 @@
+// Command httpconncheck probes how an HTTP/1.1 server treats persistent
+// connections and prints what it found:
+//
+//	httpconncheck [-idle 30s] [-timeout 10s] [-insecure] https://example.com/
+//
+// It reports how long the server keeps an idle connection, whether it
+// answers pipelined requests, whether it takes a chunked request body with
+// trailers, and which content coding it picks. Each probe uses its own
+// connection.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zhaojkun/client/httpclientutil"
+)
+
+func main() {
+	idle := flag.Duration("idle", 30*time.Second, "longest idle period to wait for the server to close")
+	timeout := flag.Duration("timeout", 10*time.Second, "timeout of each request")
+	insecure := flag.Bool("insecure", false, "skip TLS certificate verification")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: httpconncheck [flags] url\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	ck, err := newChecker(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "httpconncheck:", err)
+		os.Exit(2)
+	}
+	ck.maxIdle, ck.timeout = *idle, *timeout
+	if *insecure {
+		ck.tls.InsecureSkipVerify = true
+	}
+	failed := false
+	for _, p := range ck.probes() {
+		result, err := p.run()
+		if err != nil {
+			result, failed = "error: "+err.Error(), true
+		}
+		fmt.Printf("%-12s %s\n", p.name+":", result)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+type checker struct {
+	target  *url.URL
+	addr    string
+	tls     *tls.Config // nil for http
+	maxIdle time.Duration
+	timeout time.Duration
+}
+
+func newChecker(target string) (*checker, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	ck := &checker{target: u, maxIdle: 30 * time.Second, timeout: 10 * time.Second}
+	port := u.Port()
+	switch u.Scheme {
+	case "http":
+		if port == "" {
+			port = "80"
+		}
+	case "https":
+		if port == "" {
+			port = "443"
+		}
+		ck.tls = &tls.Config{}
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	ck.addr = net.JoinHostPort(u.Hostname(), port)
+	return ck, nil
+}
+
+type probe struct {
+	name string
+	run  func() (string, error)
+}
+
+func (ck *checker) probes() []probe {
+	return []probe{
+		{"keep-alive", ck.keepAlive},
+		{"pipelining", ck.pipelining},
+		{"trailers", ck.trailers},
+		{"compression", ck.compression},
+	}
+}
+
+func (ck *checker) dial() (*httpclientutil.ClientConn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ck.timeout)
+	defer cancel()
+	return httpclientutil.DialConn(ctx, "tcp", ck.addr, ck.tls)
+}
+
+func (ck *checker) request(method string, body io.Reader) *http.Request {
+	req, _ := http.NewRequest(method, ck.target.String(), body)
+	req.Header.Set("User-Agent", "httpconncheck")
+	return req
+}
+
+// do sends req on cc and reads the response to its end.
+func (ck *checker) do(cc *httpclientutil.ClientConn, req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), ck.timeout)
+	defer cancel()
+	resp, err := cc.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// keepAlive waits, up to maxIdle, for the server to close an idle
+// connection.
+func (ck *checker) keepAlive() (string, error) {
+	cc, err := ck.dial()
+	if err != nil {
+		return "", err
+	}
+	defer cc.Close()
+	resp, err := ck.do(cc, ck.request("GET", nil))
+	if err != nil {
+		return "", err
+	}
+	if resp.Close {
+		return "closes the connection after each response", nil
+	}
+	advertised := ""
+	if ka := resp.Header.Get("Keep-Alive"); ka != "" {
+		advertised = fmt.Sprintf(" (advertises %q)", ka)
+	}
+	start := time.Now()
+	for cc.Reusable() {
+		if time.Since(start) >= ck.maxIdle {
+			return fmt.Sprintf("idle connection still open after %v%s", ck.maxIdle, advertised), nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return fmt.Sprintf("idle connection closed after %v%s", time.Since(start).Round(100*time.Millisecond), advertised), nil
+}
+
+// pipelining writes three requests before reading any response.
+func (ck *checker) pipelining() (string, error) {
+	const n = 3
+	cc, err := ck.dial()
+	if err != nil {
+		return "", err
+	}
+	defer cc.Close()
+	var reqs []*http.Request
+	for i := 0; i < n; i++ {
+		req := ck.request("GET", nil)
+		if err := cc.Write(req); err != nil {
+			return "", err
+		}
+		reqs = append(reqs, req)
+	}
+	timer := time.AfterFunc(ck.timeout, func() { cc.Close() })
+	defer timer.Stop()
+	for i, req := range reqs {
+		resp, err := cc.Read(req)
+		if err != nil {
+			return fmt.Sprintf("not tolerated: %d of %d responses, then %v", i, n, err), nil
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	return fmt.Sprintf("tolerated: %d of %d responses", n, n), nil
+}
+
+// trailers sends a chunked POST with a trailer field, and reports any
+// trailers of the response.
+func (ck *checker) trailers() (string, error) {
+	cc, err := ck.dial()
+	if err != nil {
+		return "", err
+	}
+	defer cc.Close()
+	// A reader of unknown length makes the body go out chunked.
+	req := ck.request("POST", io.MultiReader(strings.NewReader("httpconncheck")))
+	req.Trailer = http.Header{"X-Httpconncheck": {"1"}}
+	resp, err := ck.do(cc, req)
+	if err != nil {
+		return "", err
+	}
+	verdict := "accepted"
+	switch resp.StatusCode {
+	case http.StatusBadRequest, http.StatusLengthRequired, http.StatusNotImplemented:
+		verdict = "rejected"
+	}
+	result := fmt.Sprintf("chunked request with trailer %s (%s)", verdict, resp.Status)
+	if len(resp.Trailer) > 0 {
+		var names []string
+		for name := range resp.Trailer {
+			names = append(names, name)
+		}
+		result += "; response trailers " + strings.Join(names, ", ")
+	}
+	return result, nil
+}
+
+// compression offers the common codings and reports the one chosen.
+func (ck *checker) compression() (string, error) {
+	cc, err := ck.dial()
+	if err != nil {
+		return "", err
+	}
+	defer cc.Close()
+	req := ck.request("GET", nil)
+	req.Header.Set("Accept-Encoding", "br, gzip, deflate")
+	resp, err := ck.do(cc, req)
+	if err != nil {
+		return "", err
+	}
+	if ce := resp.Header.Get("Content-Encoding"); ce != "" {
+		return ce, nil
+	}
+	return "none", nil
+}
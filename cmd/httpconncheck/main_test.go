@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testChecker(t *testing.T, h http.HandlerFunc) *checker {
+	s := httptest.NewUnstartedServer(h)
+	s.Config.IdleTimeout = 200 * time.Millisecond
+	s.Start()
+	t.Cleanup(s.Close)
+	ck, err := newChecker(s.URL + "/probe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ck.maxIdle, ck.timeout = 5*time.Second, 5*time.Second
+	return ck
+}
+
+func TestProbes(t *testing.T) {
+	ck := testChecker(t, func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		if r.Trailer.Get("X-Httpconncheck") == "" && r.Method == "POST" {
+			http.Error(w, "trailer lost", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Trailer", "X-Checksum")
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+		io.WriteString(w, "ok")
+		w.Header().Set("X-Checksum", "abc")
+	})
+	for _, c := range []struct {
+		run  func() (string, error)
+		want string
+	}{
+		{ck.keepAlive, "idle connection closed after"},
+		{ck.pipelining, "tolerated: 3 of 3 responses"},
+		{ck.trailers, "chunked request with trailer accepted (200 OK); response trailers X-Checksum"},
+		{ck.compression, "gzip"},
+	} {
+		got, err := c.run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(got, c.want) {
+			t.Errorf("got %q, want %q", got, c.want)
+		}
+	}
+}
+
+func TestNewCheckerScheme(t *testing.T) {
+	ck, err := newChecker("https://example.com/x")
+	if err != nil || ck.addr != "example.com:443" || ck.tls == nil {
+		t.Errorf("https: %+v, %v", ck, err)
+	}
+	if _, err := newChecker("ftp://example.com/"); err == nil {
+		t.Error("ftp accepted")
+	}
+}
@@ -0,0 +1,178 @@
+package httpclientutil
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AdaptiveLimiter bounds the requests in flight to each host like a fixed
+// per-host limit, but finds the limit itself with AIMD: responses that
+// arrive in time while the limit is in use raise it by one per limit's
+// worth of them, as TCP congestion avoidance does per round trip, and an
+// error, a 429 or 503, or a response slower than Tolerance times the
+// fastest recently seen cuts it by Backoff. The limit thus settles near
+// the concurrency the upstream sustains without queueing. A request counts
+// as in flight until its body is closed or fully read; requests over the
+// limit wait for a slot or their context. It is safe for concurrent use.
+type AdaptiveLimiter struct {
+	Doer Doer
+
+	InitialLimit int     // 10 if zero
+	MinLimit     int     // 1 if zero
+	MaxLimit     int     // 1000 if zero
+	Backoff      float64 // factor applied on overload, 0.9 if zero
+	Tolerance    float64 // latency over the baseline that counts as overload, 2 if zero
+	Clock        Clock   // times responses; nil is the system clock
+
+	hosts shardedMap // host -> *aimdHost
+}
+
+// aimdWindow is the number of samples after which the latency baseline is
+// replaced by the fastest of them, so it follows a slowing upstream.
+const aimdWindow = 100
+
+type aimdHost struct {
+	mu        sync.Mutex
+	limit     float64
+	inFlight  int
+	minRTT    time.Duration // baseline; zero until the first sample
+	windowMin time.Duration
+	samples   int
+	waiters   []chan struct{}
+}
+
+func (al *AdaptiveLimiter) Do(req *http.Request) (*http.Response, error) {
+	h := al.host(req.URL.Host)
+	if err := h.acquire(req); err != nil {
+		return nil, err
+	}
+	clock := clockOrSystem(al.Clock)
+	start := clock.Now()
+	resp, err := al.Doer.Do(req)
+	rtt := clock.Now().Sub(start)
+	overload := err != nil || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable
+	if err != nil {
+		h.release(al, rtt, overload)
+		return nil, err
+	}
+	resp.Body = newNotifyBody(resp.Body, func(error) { h.release(al, rtt, overload) })
+	return resp, nil
+}
+
+// Limit returns the current limit of host.
+func (al *AdaptiveLimiter) Limit(host string) int {
+	h := al.host(host)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return int(h.limit)
+}
+
+func (al *AdaptiveLimiter) host(host string) *aimdHost {
+	return al.hosts.load(host, func() interface{} {
+		limit := al.InitialLimit
+		if limit <= 0 {
+			limit = 10
+		}
+		return &aimdHost{limit: float64(limit)}
+	}).(*aimdHost)
+}
+
+func (al *AdaptiveLimiter) bounds() (min, max float64) {
+	min, max = float64(al.MinLimit), float64(al.MaxLimit)
+	if min <= 0 {
+		min = 1
+	}
+	if max <= 0 {
+		max = 1000
+	}
+	return min, max
+}
+
+func (h *aimdHost) acquire(req *http.Request) error {
+	h.mu.Lock()
+	if h.inFlight < int(h.limit) {
+		h.inFlight++
+		h.mu.Unlock()
+		return nil
+	}
+	ch := make(chan struct{})
+	h.waiters = append(h.waiters, ch)
+	h.mu.Unlock()
+	select {
+	case <-ch:
+		return nil
+	case <-req.Context().Done():
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		for i, w := range h.waiters {
+			if w == ch {
+				h.waiters = append(h.waiters[:i], h.waiters[i+1:]...)
+				return context.Cause(req.Context())
+			}
+		}
+		// Granted meanwhile; give the slot back.
+		h.inFlight--
+		h.grant()
+		return context.Cause(req.Context())
+	}
+}
+
+// release returns a slot and feeds the sample into the limit.
+func (h *aimdHost) release(al *AdaptiveLimiter, rtt time.Duration, overload bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	busy := h.inFlight*2 >= int(h.limit)
+	h.inFlight--
+	if !overload {
+		overload = h.slow(al, rtt)
+	}
+	min, max := al.bounds()
+	switch {
+	case overload:
+		backoff := al.Backoff
+		if backoff <= 0 || backoff >= 1 {
+			backoff = 0.9
+		}
+		h.limit *= backoff
+	case busy:
+		// Only grow a limit that is actually being used.
+		h.limit += 1 / h.limit
+	}
+	if h.limit < min {
+		h.limit = min
+	}
+	if h.limit > max {
+		h.limit = max
+	}
+	h.grant()
+}
+
+// slow records rtt and reports whether it is over the tolerated latency.
+func (h *aimdHost) slow(al *AdaptiveLimiter, rtt time.Duration) bool {
+	if h.windowMin == 0 || rtt < h.windowMin {
+		h.windowMin = rtt
+	}
+	if h.samples++; h.samples >= aimdWindow {
+		h.minRTT, h.windowMin, h.samples = h.windowMin, 0, 0
+	}
+	if h.minRTT == 0 || rtt < h.minRTT {
+		h.minRTT = rtt
+	}
+	tolerance := al.Tolerance
+	if tolerance <= 1 {
+		tolerance = 2
+	}
+	return float64(rtt) > tolerance*float64(h.minRTT)
+}
+
+// grant hands free slots to waiters in arrival order. The caller holds
+// h.mu.
+func (h *aimdHost) grant() {
+	for len(h.waiters) > 0 && h.inFlight < int(h.limit) {
+		close(h.waiters[0])
+		h.waiters = h.waiters[1:]
+		h.inFlight++
+	}
+}
@@ -0,0 +1,83 @@
+package httpclientutil
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// capacityDoer takes a millisecond per request and answers 503 whenever
+// more than capacity requests are in flight.
+type capacityDoer struct {
+	capacity       int32
+	inFlight, shed int32
+}
+
+func (d *capacityDoer) Do(req *http.Request) (*http.Response, error) {
+	n := atomic.AddInt32(&d.inFlight, 1)
+	status := 200
+	if n > d.capacity {
+		status = 503
+		atomic.AddInt32(&d.shed, 1)
+	}
+	time.Sleep(time.Millisecond)
+	body := newNotifyBody(io.NopCloser(strings.NewReader("x")), func(error) { atomic.AddInt32(&d.inFlight, -1) })
+	return &http.Response{StatusCode: status, Body: body, Request: req}, nil
+}
+
+func TestAdaptiveLimiterFindsCapacity(t *testing.T) {
+	d := &capacityDoer{capacity: 8}
+	al := &AdaptiveLimiter{Doer: d, InitialLimit: 1, Clock: newFakeClock()}
+	var wg sync.WaitGroup
+	for g := 0; g < 32; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				if _, err := get(t, al, "http://a.example/"); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if l := al.Limit("a.example"); l < 4 || l > 12 {
+		t.Errorf("limit settled at %d for a capacity of 8", l)
+	}
+	if shed := atomic.LoadInt32(&d.shed); shed > 3200/4 {
+		t.Errorf("%d of 3200 requests over capacity", shed)
+	}
+}
+
+func TestAdaptiveLimiterLatency(t *testing.T) {
+	al := &AdaptiveLimiter{InitialLimit: 10}
+	h := al.host("a.example")
+	sample := func(rtt time.Duration) {
+		h.mu.Lock()
+		h.inFlight = int(h.limit) // keep the limit busy
+		h.mu.Unlock()
+		h.release(al, rtt, false)
+	}
+	// About one step per limit's worth of samples: 10 -> 12.66.
+	for i := 0; i < 30; i++ {
+		sample(10 * time.Millisecond)
+	}
+	if l := al.Limit("a.example"); l != 12 {
+		t.Fatalf("limit after fast responses = %d, want 12", l)
+	}
+	sample(30 * time.Millisecond)
+	if l := al.Limit("a.example"); l != 11 {
+		t.Fatalf("limit after a slow response = %d, want 11", l)
+	}
+	for i := 0; i < 50; i++ {
+		sample(time.Second)
+	}
+	if l := al.Limit("a.example"); l != 1 {
+		t.Fatalf("limit after sustained overload = %d, want the minimum", l)
+	}
+}
@@ -0,0 +1,111 @@
+package httpclientutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// maxErrorBody bounds how much of an error response is kept.
+const maxErrorBody = 64 << 10
+
+// StatusError is returned by CheckResponse for a 4xx or 5xx response
+// without a problem document.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	Body       []byte // up to 64KB of the body
+}
+
+func (e *StatusError) Error() string {
+	return "http: unexpected status " + e.Status
+}
+
+// ProblemError is an application/problem+json error document (RFC 9457).
+type ProblemError struct {
+	StatusCode int         `json:"-"` // of the response, which may differ from Status
+	Header     http.Header `json:"-"`
+
+	Type     string `json:"type,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+
+	// Extensions holds the members not listed above.
+	Extensions map[string]json.RawMessage `json:"-"`
+}
+
+func (e *ProblemError) Error() string {
+	msg := e.Title
+	if msg == "" {
+		msg = http.StatusText(e.StatusCode)
+	}
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	return fmt.Sprintf("http: %d %s", e.StatusCode, msg)
+}
+
+// CheckResponse returns nil for a response below 400. Otherwise it reads
+// and closes the body and returns a *ProblemError if it is a problem
+// document, or a *StatusError.
+func CheckResponse(resp *http.Response) error {
+	if resp.StatusCode < 400 {
+		return nil
+	}
+	body, err := readLimited(resp, maxErrorBody)
+	if err != nil {
+		return err
+	}
+	if isProblemJSON(resp.Header.Get("Content-Type")) {
+		if p, err := decodeProblem(body); err == nil {
+			p.StatusCode, p.Header = resp.StatusCode, resp.Header
+			return p
+		}
+	}
+	return &StatusError{StatusCode: resp.StatusCode, Status: resp.Status, Header: resp.Header, Body: body}
+}
+
+func isProblemJSON(ct string) bool {
+	mt, _, err := mime.ParseMediaType(ct)
+	return err == nil && strings.EqualFold(mt, "application/problem+json")
+}
+
+func decodeProblem(body []byte) (*ProblemError, error) {
+	p := new(ProblemError)
+	if err := json.Unmarshal(body, p); err != nil {
+		return nil, err
+	}
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(body, &members); err != nil {
+		return nil, err
+	}
+	for _, k := range []string{"type", "title", "status", "detail", "instance"} {
+		delete(members, k)
+	}
+	if len(members) > 0 {
+		p.Extensions = members
+	}
+	return p, nil
+}
+
+// CheckingDoer passes each response of Doer through CheckResponse, so
+// callers get an error instead of a 4xx or 5xx response.
+type CheckingDoer struct {
+	Doer Doer
+}
+
+func (c *CheckingDoer) Do(req *http.Request) (*http.Response, error) {
+	resp, err := c.Doer.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckResponse(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,98 @@
+package httpclientutil
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// countingBody counts the bytes read from it and whether it was closed.
+type countingBody struct {
+	io.Reader
+	n      int
+	closed bool
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	b.n += n
+	return n, err
+}
+
+func (b *countingBody) Close() error {
+	b.closed = true
+	return nil
+}
+
+func errorResponse(status int, contentType, body string) (*http.Response, *countingBody) {
+	cb := &countingBody{Reader: strings.NewReader(body)}
+	h := http.Header{}
+	if contentType != "" {
+		h.Set("Content-Type", contentType)
+	}
+	return &http.Response{StatusCode: status, Status: http.StatusText(status), Header: h, Body: cb}, cb
+}
+
+func TestCheckResponseBodyCap(t *testing.T) {
+	resp, cb := errorResponse(http.StatusBadGateway, "text/html", strings.Repeat("x", 10*maxErrorBody))
+	var se *StatusError
+	if err := CheckResponse(resp); !errors.As(err, &se) {
+		t.Fatalf("err = %v, want a *StatusError", err)
+	}
+	if len(se.Body) != maxErrorBody {
+		t.Errorf("kept %d bytes of the body, want %d", len(se.Body), maxErrorBody)
+	}
+	if !cb.closed {
+		t.Error("body not closed")
+	}
+	if se.StatusCode != http.StatusBadGateway || se.Error() != "http: unexpected status Bad Gateway" {
+		t.Errorf("StatusError = %d %q", se.StatusCode, se.Error())
+	}
+
+	// A problem document cut off at the cap is no problem document.
+	long := `{"title":"Too long","detail":"` + strings.Repeat("x", maxErrorBody) + `"}`
+	resp, _ = errorResponse(http.StatusBadRequest, "application/problem+json", long)
+	if err := CheckResponse(resp); !errors.As(err, &se) || len(se.Body) != maxErrorBody {
+		t.Errorf("truncated problem: err = %v", err)
+	}
+}
+
+func TestCheckResponseProblem(t *testing.T) {
+	resp, _ := errorResponse(http.StatusForbidden, "application/problem+json; charset=utf-8",
+		`{"type":"https://example.com/probs/out-of-credit","title":"You do not have enough credit.","status":403,"detail":"Your balance is 30.","balance":30}`)
+	var pe *ProblemError
+	if err := CheckResponse(resp); !errors.As(err, &pe) {
+		t.Fatalf("err = %v, want a *ProblemError", err)
+	}
+	if pe.StatusCode != 403 || pe.Type != "https://example.com/probs/out-of-credit" || string(pe.Extensions["balance"]) != "30" {
+		t.Errorf("problem = %+v", pe)
+	}
+	if want := "http: 403 You do not have enough credit.: Your balance is 30."; pe.Error() != want {
+		t.Errorf("Error() = %q, want %q", pe.Error(), want)
+	}
+
+	// Malformed JSON under the problem type is reported as a plain status.
+	resp, _ = errorResponse(http.StatusInternalServerError, "application/problem+json", "{")
+	var se *StatusError
+	if err := CheckResponse(resp); !errors.As(err, &se) || string(se.Body) != "{" {
+		t.Errorf("malformed problem: err = %v", err)
+	}
+}
+
+func TestCheckingDoer(t *testing.T) {
+	var resp *http.Response
+	var cb *countingBody
+	d := &CheckingDoer{Doer: doerFunc(func(*http.Request) (*http.Response, error) { return resp, nil })}
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	resp, cb = errorResponse(http.StatusOK, "", "fine")
+	got, err := d.Do(req)
+	if err != nil || got != resp || cb.n != 0 || cb.closed {
+		t.Errorf("200: %v, read %d bytes, closed %v", err, cb.n, cb.closed)
+	}
+	resp, _ = errorResponse(http.StatusNotFound, "", "gone")
+	if got, err := d.Do(req); got != nil || err == nil {
+		t.Errorf("404: %v, %v", got, err)
+	}
+}
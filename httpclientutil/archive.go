@@ -0,0 +1,164 @@
+package httpclientutil
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrArchiveDropped ends the body an ArchiveSink reads when the copy fell
+// more than Archiver.MaxBuffered behind the consumer and was abandoned.
+var ErrArchiveDropped = errors.New("http: archive copy dropped, sink too slow")
+
+// ArchiveSink stores archived responses, e.g. in files or an object store.
+type ArchiveSink interface {
+	// Archive is called on a goroutine of its own with a copy of a
+	// response whose Body yields the bytes the consumer reads, as it
+	// reads them. The body ends with io.EOF when the consumer read the
+	// whole body, and with another error when the consumer closed it
+	// early, the response failed, or the copy was dropped.
+	Archive(resp *http.Response) error
+}
+
+// Archiver tees responses to Sink without slowing their consumers: bytes
+// are copied into a buffer the sink drains, and a copy that falls more
+// than MaxBuffered bytes behind is dropped rather than waited for. Set it
+// as ClientConnPool.Archiver, or call Tee on responses from any Doer.
+type Archiver struct {
+	Sink        ArchiveSink
+	MaxBuffered int64       // bytes held for all slow copies together, 8MB if zero
+	OnError     func(error) // called with errors from Sink
+
+	buffered int64 // atomic
+	wg       sync.WaitGroup
+}
+
+// Tee makes resp.Body copy what it yields to the sink. The sink receives
+// its copy at once.
+func (a *Archiver) Tee(resp *http.Response) {
+	cp := new(http.Response)
+	*cp = *resp
+	cp.Header = resp.Header.Clone()
+	s := &archiveStream{a: a}
+	s.cond.L = &s.mu
+	cp.Body = s
+	if resp.ContentLength == 0 || resp.Body == http.NoBody {
+		s.end(io.EOF)
+	}
+	resp.Body = &archiveTee{ReadCloser: resp.Body, s: s}
+	a.wg.Add(1)
+	spawn("archive writer", func() {
+		defer a.wg.Done()
+		err := a.Sink.Archive(cp)
+		s.abandon()
+		if err != nil && a.OnError != nil {
+			a.OnError(err)
+		}
+	})
+}
+
+// Wait blocks until the sink has returned for every response teed so far.
+func (a *Archiver) Wait() {
+	a.wg.Wait()
+}
+
+func (a *Archiver) maxBuffered() int64 {
+	if a.MaxBuffered <= 0 {
+		return 8 << 20
+	}
+	return a.MaxBuffered
+}
+
+// archiveStream is the sink's side of a tee: a buffer the consumer's reads
+// fill and the sink drains.
+type archiveStream struct {
+	a    *Archiver
+	mu   sync.Mutex
+	cond sync.Cond
+	buf  []byte
+	err  error // once set, no more data is added
+	gone bool  // the sink returned
+}
+
+func (s *archiveStream) write(p []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil || s.gone {
+		return
+	}
+	if atomic.AddInt64(&s.a.buffered, int64(len(p))) > s.a.maxBuffered() {
+		atomic.AddInt64(&s.a.buffered, -int64(len(p)))
+		s.finish(ErrArchiveDropped)
+		return
+	}
+	s.buf = append(s.buf, p...)
+	s.cond.Signal()
+}
+
+// finish ends the stream with err after the buffered data. The caller
+// holds s.mu.
+func (s *archiveStream) finish(err error) {
+	if s.err == nil {
+		s.err = err
+		s.cond.Signal()
+	}
+}
+
+func (s *archiveStream) end(err error) {
+	s.mu.Lock()
+	s.finish(err)
+	s.mu.Unlock()
+}
+
+// abandon releases what the sink left unread.
+func (s *archiveStream) abandon() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gone = true
+	atomic.AddInt64(&s.a.buffered, -int64(len(s.buf)))
+	s.buf = nil
+}
+
+func (s *archiveStream) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.buf) == 0 && s.err == nil {
+		s.cond.Wait()
+	}
+	if len(s.buf) == 0 {
+		return 0, s.err
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	atomic.AddInt64(&s.a.buffered, -int64(n))
+	return n, nil
+}
+
+func (s *archiveStream) Close() error {
+	s.abandon()
+	return nil
+}
+
+// archiveTee is the consumer's side, feeding the stream as it reads.
+type archiveTee struct {
+	io.ReadCloser
+	s *archiveStream
+}
+
+func (t *archiveTee) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if n > 0 {
+		t.s.write(p[:n])
+	}
+	if err != nil {
+		t.s.end(err)
+	}
+	return n, err
+}
+
+func (t *archiveTee) Close() error {
+	t.s.end(io.ErrUnexpectedEOF)
+	return t.ReadCloser.Close()
+}
@@ -0,0 +1,87 @@
+package httpclientutil
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memSink keeps archived bodies by path, with the error they ended in.
+type memSink struct {
+	mu     sync.Mutex
+	bodies map[string]string
+	errs   map[string]error
+	gate   chan struct{} // if set, Archive waits on it before reading
+}
+
+func (s *memSink) Archive(resp *http.Response) error {
+	if s.gate != nil {
+		<-s.gate
+	}
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bodies[resp.Request.URL.Path] = resp.Header.Get("X-Path") + ":" + string(b)
+	s.errs[resp.Request.URL.Path] = err
+	return nil
+}
+
+func newMemSink() *memSink {
+	return &memSink{bodies: make(map[string]string), errs: make(map[string]error)}
+}
+
+func TestArchiverPool(t *testing.T) {
+	srv := pathServer(t)
+	sink := newMemSink()
+	a := &Archiver{Sink: sink}
+	p := &ClientConnPool{Archiver: a}
+	defer p.Close()
+	if got := poolGet(t, p, srv.URL+"/full"); got != "/full" {
+		t.Fatalf("body = %q", got)
+	}
+	req, _ := http.NewRequest("GET", srv.URL+"/a/empty", nil)
+	resp, err := p.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	a.Wait()
+	if got := sink.bodies["/full"]; got != ":/full" || sink.errs["/full"] != nil {
+		t.Errorf("archived /full = %q, %v", got, sink.errs["/full"])
+	}
+	if got := sink.bodies["/a/empty"]; got != "/a/empty:" || sink.errs["/a/empty"] != nil {
+		t.Errorf("archived 204 = %q, %v", got, sink.errs["/a/empty"])
+	}
+}
+
+func TestArchiverDropsSlowCopy(t *testing.T) {
+	sink := newMemSink()
+	sink.gate = make(chan struct{})
+	a := &Archiver{Sink: sink, MaxBuffered: 1 << 10}
+	body := strings.Repeat("x", 4<<10)
+	req, _ := http.NewRequest("GET", "http://a.example/big", nil)
+	resp := &http.Response{StatusCode: 200, Header: http.Header{}, ContentLength: -1, Body: io.NopCloser(strings.NewReader(body)), Request: req}
+	a.Tee(resp)
+	// The consumer reads everything while the sink is stuck.
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || !bytes.Equal(b, []byte(body)) {
+		t.Fatalf("consumer read %d bytes, %v", len(b), err)
+	}
+	close(sink.gate)
+	a.Wait()
+	if err := sink.errs["/big"]; !errors.Is(err, ErrArchiveDropped) {
+		t.Errorf("slow copy ended with %v, want ErrArchiveDropped", err)
+	}
+	if got := len(sink.bodies["/big"]); got > 1<<10+1 {
+		t.Errorf("archived %d bytes past MaxBuffered", got)
+	}
+	if a.buffered != 0 {
+		t.Errorf("%d bytes still accounted as buffered", a.buffered)
+	}
+}
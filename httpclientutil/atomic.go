@@ -0,0 +1,30 @@
+package httpclientutil
+
+import "sync/atomic"
+
+type atomicBool struct{ v int32 }
+
+func (b *atomicBool) Load() bool { return atomic.LoadInt32(&b.v) != 0 }
+
+func (b *atomicBool) Store(flag bool) {
+	var v int32
+	if flag {
+		v = 1
+	}
+	atomic.StoreInt32(&b.v, v)
+}
+
+// atomicError holds an error of any concrete type; atomic.Value alone
+// requires every stored value to have the same type.
+type atomicError struct{ v atomic.Value }
+
+type errorBox struct{ err error }
+
+func (e *atomicError) Load() error {
+	if b, ok := e.v.Load().(errorBox); ok {
+		return b.err
+	}
+	return nil
+}
+
+func (e *atomicError) Store(err error) { e.v.Store(errorBox{err}) }
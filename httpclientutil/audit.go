@@ -0,0 +1,247 @@
+package httpclientutil
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AuditRecord describes one finished exchange. Records are values and
+// their headers are private copies, so sinks may keep them.
+type AuditRecord struct {
+	Principal     string // from WithAuditPrincipal
+	Method        string
+	URL           string      // with any password redacted
+	RequestHeader http.Header // redacted
+	Start         time.Time
+	Duration      time.Duration // until the response body was finished
+	StatusCode    int           // zero if no response arrived
+	Header        http.Header   // response header, redacted
+	BytesSent     int64         // request body, if its length was known
+	BytesReceived int64         // response body bytes read by the caller
+	Err           string
+}
+
+// AuditSink receives batches of records from AuditDoer's flusher.
+type AuditSink interface {
+	WriteAudit(records []AuditRecord) error
+}
+
+type auditPrincipalKey struct{}
+
+// WithAuditPrincipal returns a context whose requests are audited as made
+// by who.
+func WithAuditPrincipal(ctx context.Context, who string) context.Context {
+	return context.WithValue(ctx, auditPrincipalKey{}, who)
+}
+
+var errAuditClosed = errors.New("http: audit log closed")
+
+// DefaultRedactedHeaders are replaced by "REDACTED" in audit records when
+// AuditDoer.Redact is nil.
+var DefaultRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// AuditDoer records every request sent through Doer to Sink. Records are
+// queued and written in batches by a background goroutine so auditing
+// never blocks a request; when the queue is full records are dropped and
+// counted. Close flushes what is queued.
+type AuditDoer struct {
+	Doer          Doer
+	Sink          AuditSink
+	Redact        []string      // header names to redact
+	QueueSize     int           // defaults to 1024
+	BatchSize     int           // defaults to 128
+	FlushInterval time.Duration // defaults to one second
+	OnError       func(error)   // called with sink errors
+
+	once    sync.Once
+	mu      sync.RWMutex // guards closed against sends on queue
+	closed  bool
+	queue   chan AuditRecord
+	done    chan struct{}
+	dropped int64
+}
+
+func (a *AuditDoer) Do(req *http.Request) (*http.Response, error) {
+	a.once.Do(a.start)
+	rec := AuditRecord{
+		Method:        req.Method,
+		URL:           req.URL.Redacted(),
+		RequestHeader: a.redact(req.Header),
+		Start:         time.Now(),
+	}
+	rec.Principal, _ = req.Context().Value(auditPrincipalKey{}).(string)
+	if req.ContentLength > 0 {
+		rec.BytesSent = req.ContentLength
+	}
+	resp, err := a.Doer.Do(req)
+	if err != nil {
+		rec.Duration = time.Since(rec.Start)
+		rec.Err = redactURL(err.Error(), req.URL)
+		a.emit(rec)
+		return nil, err
+	}
+	rec.StatusCode = resp.StatusCode
+	rec.Header = a.redact(resp.Header)
+	resp.Body = &auditBody{ReadCloser: resp.Body, a: a, rec: rec}
+	return resp, nil
+}
+
+// Dropped returns how many records were lost to a full queue.
+func (a *AuditDoer) Dropped() int64 {
+	return atomic.LoadInt64(&a.dropped)
+}
+
+// Close stops auditing, flushes the queued records and waits until the
+// sink has received them.
+func (a *AuditDoer) Close() error {
+	a.once.Do(a.start)
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return errAuditClosed
+	}
+	a.closed = true
+	close(a.queue)
+	a.mu.Unlock()
+	<-a.done
+	return nil
+}
+
+func (a *AuditDoer) start() {
+	size := a.QueueSize
+	if size <= 0 {
+		size = 1024
+	}
+	a.queue = make(chan AuditRecord, size)
+	a.done = make(chan struct{})
+	spawn("audit flush", a.flushLoop)
+}
+
+func (a *AuditDoer) emit(rec AuditRecord) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		atomic.AddInt64(&a.dropped, 1)
+		return
+	}
+	select {
+	case a.queue <- rec:
+	default:
+		atomic.AddInt64(&a.dropped, 1)
+	}
+}
+
+func (a *AuditDoer) flushLoop() {
+	defer close(a.done)
+	batchSize := a.BatchSize
+	if batchSize <= 0 {
+		batchSize = 128
+	}
+	interval := a.FlushInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	batch := make([]AuditRecord, 0, batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := a.Sink.WriteAudit(batch); err != nil && a.OnError != nil {
+			a.OnError(err)
+		}
+		batch = make([]AuditRecord, 0, batchSize)
+	}
+	for {
+		select {
+		case rec, ok := <-a.queue:
+			if !ok {
+				flush()
+				return
+			}
+			if batch = append(batch, rec); len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (a *AuditDoer) redact(h http.Header) http.Header {
+	return redactHeader(h, a.Redact)
+}
+
+// redactHeader copies h with the names headers, DefaultRedactedHeaders if
+// nil, replaced by "REDACTED".
+func redactHeader(h http.Header, names []string) http.Header {
+	if names == nil {
+		names = DefaultRedactedHeaders
+	}
+	c := make(http.Header, len(h))
+	for k, vv := range h {
+		c[k] = append([]string(nil), vv...)
+	}
+	for _, name := range names {
+		name = http.CanonicalHeaderKey(name)
+		if _, ok := c[name]; ok {
+			c[name] = []string{"REDACTED"}
+		}
+	}
+	return c
+}
+
+// redactURL replaces u in msg, as a *url.Error quotes it, by its redacted
+// form, so no password leaks through an error.
+func redactURL(msg string, u *url.URL) string {
+	if _, ok := u.User.Password(); !ok {
+		return msg
+	}
+	return strings.ReplaceAll(msg, u.String(), u.Redacted())
+}
+
+// auditBody counts the bytes read and emits the record when the body is
+// finished. Close may come from another goroutine than Read, so the count
+// is kept apart from rec and updated atomically.
+type auditBody struct {
+	io.ReadCloser
+	a        *AuditDoer
+	rec      AuditRecord
+	received int64
+	once     sync.Once
+}
+
+func (b *auditBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(&b.received, int64(n))
+	if err != nil {
+		b.finish(err)
+	}
+	return n, err
+}
+
+func (b *auditBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.finish(nil)
+	return err
+}
+
+func (b *auditBody) finish(err error) {
+	b.once.Do(func() {
+		rec := b.rec
+		rec.BytesReceived = atomic.LoadInt64(&b.received)
+		rec.Duration = time.Since(rec.Start)
+		if err != nil && err != io.EOF {
+			rec.Err = err.Error()
+		}
+		b.a.emit(rec)
+	})
+}
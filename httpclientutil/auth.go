@@ -0,0 +1,292 @@
+package httpclientutil
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Challenge is one challenge of a WWW-Authenticate or Proxy-Authenticate
+// header.
+type Challenge struct {
+	Scheme  string            // as sent, compare with strings.EqualFold
+	Token68 string            // e.g. the blob of a Negotiate challenge
+	Params  map[string]string // auth-params, keyed lower-case
+}
+
+// ParseChallenges parses every challenge in the name header lines of h.
+func ParseChallenges(h http.Header, name string) []Challenge {
+	var cs []Challenge
+	for _, line := range h.Values(name) {
+		var cur *Challenge
+		for line != "" {
+			line = strings.TrimLeft(line, " \t,")
+			tok := authToken(line)
+			if tok == "" {
+				break
+			}
+			rest := strings.TrimLeft(line[len(tok):], " \t")
+			if cur != nil && strings.HasPrefix(rest, "=") && !strings.HasPrefix(rest, "==") {
+				// auth-param of the current challenge.
+				var val string
+				val, line = authValue(strings.TrimLeft(rest[1:], " \t"))
+				cur.Params[strings.ToLower(tok)] = val
+				continue
+			}
+			cs = append(cs, Challenge{Scheme: tok, Params: map[string]string{}})
+			cur = &cs[len(cs)-1]
+			line = rest
+			// A token68 stands alone up to the next comma; anything else
+			// starts the auth-params.
+			if t := token68(line); t != "" {
+				after := strings.TrimLeft(line[len(t):], " \t")
+				if after == "" || after[0] == ',' {
+					cur.Token68 = t
+					line = after
+				}
+			}
+		}
+	}
+	return cs
+}
+
+func authToken(s string) string {
+	i := 0
+	for i < len(s) && isTokenChar(s[i]) {
+		i++
+	}
+	return s[:i]
+}
+
+func token68(s string) string {
+	i := 0
+	for i < len(s) && (isTokenChar(s[i]) || s[i] == '/' || s[i] == '+') && s[i] != '=' {
+		i++
+	}
+	for i < len(s) && s[i] == '=' {
+		i++
+	}
+	return s[:i]
+}
+
+func isTokenChar(c byte) bool {
+	if c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' {
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
+
+// authValue parses a token or quoted-string from the front of s.
+func authValue(s string) (val, rest string) {
+	if !strings.HasPrefix(s, `"`) {
+		t := authToken(s)
+		return t, s[len(t):]
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return b.String(), s[i+1:]
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), ""
+}
+
+// Authenticator answers the challenges of one scheme. Authorize returns
+// the credentials to send in the Authorization (or Proxy-Authorization)
+// header of the retried req.
+type Authenticator interface {
+	Scheme() string
+	Authorize(req *http.Request, c Challenge) (string, error)
+}
+
+// AuthDoer answers a 401 or 407 response by picking the first of
+// Authenticators whose scheme was offered and that can answer the
+// challenge, and resending the request once with its credentials. A
+// request with a body is only resent if GetBody is set. Negotiate and
+// other schemes plug in as custom Authenticators.
+type AuthDoer struct {
+	Doer           Doer
+	Authenticators []Authenticator
+}
+
+func (a *AuthDoer) Do(req *http.Request) (*http.Response, error) {
+	resp, err := a.Doer.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	var challengeHeader, authHeader string
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		challengeHeader, authHeader = "Www-Authenticate", "Authorization"
+	case http.StatusProxyAuthRequired:
+		challengeHeader, authHeader = "Proxy-Authenticate", "Proxy-Authorization"
+	default:
+		return resp, nil
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return resp, nil
+	}
+	cs := ParseChallenges(resp.Header, challengeHeader)
+	for _, auth := range a.Authenticators {
+		for _, c := range cs {
+			if !strings.EqualFold(c.Scheme, auth.Scheme()) {
+				continue
+			}
+			cred, err := auth.Authorize(req, c)
+			if err != nil {
+				continue // maybe the next authenticator can answer
+			}
+			retry := req.Clone(req.Context())
+			if req.GetBody != nil {
+				if retry.Body, err = req.GetBody(); err != nil {
+					return resp, nil
+				}
+			}
+			retry.Header.Set(authHeader, cred)
+			drainBody(resp)
+			return a.Doer.Do(retry)
+		}
+	}
+	return resp, nil
+}
+
+// BasicAuth is the Basic scheme (RFC 7617).
+type BasicAuth struct {
+	Username, Password string
+}
+
+func (b *BasicAuth) Scheme() string { return "Basic" }
+
+func (b *BasicAuth) Authorize(req *http.Request, c Challenge) (string, error) {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(b.Username+":"+b.Password)), nil
+}
+
+// BearerAuth is the Bearer scheme (RFC 6750). Token is asked for a token
+// on every challenge, so it can refresh an expired one; the challenge
+// carries the server's error and scope.
+type BearerAuth struct {
+	Token func(req *http.Request, c Challenge) (string, error)
+}
+
+func (b *BearerAuth) Scheme() string { return "Bearer" }
+
+func (b *BearerAuth) Authorize(req *http.Request, c Challenge) (string, error) {
+	tok, err := b.Token(req, c)
+	if err != nil {
+		return "", err
+	}
+	return "Bearer " + tok, nil
+}
+
+var errDigestUnsupported = errors.New("http: unsupported digest challenge")
+
+// DigestAuth is the Digest scheme (RFC 7616) with MD5 or SHA-256 and
+// qop "auth", or the RFC 2069 form without qop.
+type DigestAuth struct {
+	Username, Password string
+
+	mu    sync.Mutex
+	nonce string
+	nc    int
+}
+
+func (d *DigestAuth) Scheme() string { return "Digest" }
+
+func (d *DigestAuth) Authorize(req *http.Request, c Challenge) (string, error) {
+	var newHash func() hash.Hash
+	algo := c.Params["algorithm"]
+	switch strings.ToUpper(algo) {
+	case "", "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		return "", errDigestUnsupported
+	}
+	h := func(s string) string {
+		x := newHash()
+		x.Write([]byte(s))
+		return hex.EncodeToString(x.Sum(nil))
+	}
+	realm, nonce := c.Params["realm"], c.Params["nonce"]
+	qop := ""
+	if q, ok := c.Params["qop"]; ok {
+		for _, v := range strings.Split(q, ",") {
+			if strings.TrimSpace(v) == "auth" {
+				qop = "auth"
+			}
+		}
+		if qop == "" {
+			return "", errDigestUnsupported
+		}
+	}
+	uri := req.URL.RequestURI()
+	ha1 := h(d.Username + ":" + realm + ":" + d.Password)
+	ha2 := h(req.Method + ":" + uri)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `Digest username=%s, realm=%s, nonce=%s, uri=%s`,
+		quotedString(d.Username), quotedString(realm), quotedString(nonce), quotedString(uri))
+	if qop == "" {
+		fmt.Fprintf(&b, `, response="%s"`, h(ha1+":"+nonce+":"+ha2))
+	} else {
+		var raw [8]byte
+		if _, err := rand.Read(raw[:]); err != nil {
+			return "", err
+		}
+		cnonce := hex.EncodeToString(raw[:])
+		nc := fmt.Sprintf("%08x", d.nextCount(nonce))
+		resp := h(ha1 + ":" + nonce + ":" + nc + ":" + cnonce + ":" + qop + ":" + ha2)
+		fmt.Fprintf(&b, `, response="%s", qop=%s, nc=%s, cnonce="%s"`, resp, qop, nc, cnonce)
+	}
+	if algo != "" {
+		fmt.Fprintf(&b, `, algorithm=%s`, algo)
+	}
+	if opaque, ok := c.Params["opaque"]; ok {
+		fmt.Fprintf(&b, `, opaque=%s`, quotedString(opaque))
+	}
+	return b.String(), nil
+}
+
+// quotedString quotes s as an RFC 9110 quoted-string, escaping only '"'
+// and '\'. Other bytes, UTF-8 included, are written as they are.
+func quotedString(s string) string {
+	var b strings.Builder
+	b.Grow(len(s) + 2)
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// nextCount returns the nonce count for another use of nonce.
+func (d *DigestAuth) nextCount(nonce string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if nonce != d.nonce {
+		d.nonce, d.nc = nonce, 0
+	}
+	d.nc++
+	return d.nc
+}
@@ -0,0 +1,163 @@
+package httpclientutil
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// digestServer is a Doer that challenges requests without valid Digest
+// credentials for user "Mufasa", password "Circle of Life" and records
+// the nonce counts it accepted.
+type digestServer struct {
+	algorithm, qop string
+	nonce          string
+	counts         []string
+	bodies         []string
+}
+
+func (s *digestServer) Do(req *http.Request) (*http.Response, error) {
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody, Request: req}
+	if s.valid(req) {
+		if req.Body != nil {
+			b, _ := io.ReadAll(req.Body)
+			s.bodies = append(s.bodies, string(b))
+		}
+		return resp, nil
+	}
+	resp.StatusCode = http.StatusUnauthorized
+	challenge := `Digest realm="http-auth@example.org", nonce="` + s.nonce + `", opaque="FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS"`
+	if s.qop != "" {
+		challenge += `, qop="` + s.qop + `"`
+	}
+	if s.algorithm != "" {
+		challenge += ", algorithm=" + s.algorithm
+	}
+	resp.Header.Add("WWW-Authenticate", `Basic realm="fallback"`)
+	resp.Header.Add("WWW-Authenticate", challenge)
+	return resp, nil
+}
+
+func (s *digestServer) valid(req *http.Request) bool {
+	cs := ParseChallenges(req.Header, "Authorization")
+	if len(cs) != 1 || !strings.EqualFold(cs[0].Scheme, "Digest") {
+		return false
+	}
+	p := cs[0].Params
+	newHash := md5.New
+	if s.algorithm == "SHA-256" {
+		newHash = func() hash.Hash { return sha256.New() }
+	}
+	h := func(s string) string {
+		x := newHash()
+		x.Write([]byte(s))
+		return hex.EncodeToString(x.Sum(nil))
+	}
+	if p["realm"] != "http-auth@example.org" || p["nonce"] != s.nonce || p["uri"] != req.URL.RequestURI() ||
+		p["opaque"] != "FQhe/qaU925kfnzjCev0ciny7QMkPqMAFRtzCUYo5tdS" || p["algorithm"] != s.algorithm {
+		return false
+	}
+	ha1 := h("Mufasa:http-auth@example.org:Circle of Life")
+	ha2 := h(req.Method + ":" + req.URL.RequestURI())
+	want := h(ha1 + ":" + s.nonce + ":" + ha2)
+	if s.qop != "" {
+		if p["qop"] != "auth" || p["cnonce"] == "" {
+			return false
+		}
+		want = h(ha1 + ":" + s.nonce + ":" + p["nc"] + ":" + p["cnonce"] + ":auth:" + ha2)
+		s.counts = append(s.counts, p["nc"])
+	}
+	return p["response"] == want
+}
+
+func TestDigestAuth(t *testing.T) {
+	for _, tt := range []struct {
+		algorithm, qop string
+		counts         string
+	}{
+		{"", "auth", "00000001 00000002 00000001"},
+		{"MD5", "auth-int, auth", "00000001 00000002 00000001"},
+		{"SHA-256", "auth", "00000001 00000002 00000001"},
+		{"", "", ""}, // RFC 2069
+	} {
+		s := &digestServer{algorithm: tt.algorithm, qop: tt.qop, nonce: "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v"}
+		d := &AuthDoer{Doer: s, Authenticators: []Authenticator{&DigestAuth{Username: "Mufasa", Password: "Circle of Life"}}}
+		send := func(method, target string) {
+			t.Helper()
+			var body io.Reader
+			if method == "POST" {
+				body = strings.NewReader("payload")
+			}
+			req, _ := http.NewRequest(method, target, body)
+			resp, err := d.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("%s %s with %s/%q: %d", method, target, tt.algorithm, tt.qop, resp.StatusCode)
+			}
+		}
+		send("GET", "http://www.example.org/dir/index.html")
+		send("POST", "http://www.example.org/dir/index.html?q=a%20b")
+		s.nonce = "fresh"
+		send("GET", "http://www.example.org/")
+		if got := strings.Join(s.counts, " "); got != tt.counts {
+			t.Errorf("%s/%q: nonce counts %q, want %q", tt.algorithm, tt.qop, got, tt.counts)
+		}
+		if len(s.bodies) != 1 || s.bodies[0] != "payload" {
+			t.Errorf("%s/%q: resent POST bodies %q, want the payload once", tt.algorithm, tt.qop, s.bodies)
+		}
+	}
+}
+
+func TestDigestAuthUnsupported(t *testing.T) {
+	for _, s := range []*digestServer{
+		{qop: "auth-int", nonce: "n"},
+		{algorithm: "SHA-512-256", qop: "auth", nonce: "n"},
+	} {
+		d := &AuthDoer{Doer: s, Authenticators: []Authenticator{&DigestAuth{Username: "Mufasa", Password: "Circle of Life"}}}
+		req, _ := http.NewRequest("GET", "http://www.example.org/", nil)
+		resp, err := d.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("qop %q, algorithm %q: status %d, want the 401 back", s.qop, s.algorithm, resp.StatusCode)
+		}
+	}
+}
+
+func TestAuthDoerBody(t *testing.T) {
+	var auths []string
+	d := &AuthDoer{
+		Doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+			auths = append(auths, req.Header.Get("Authorization"))
+			resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody}
+			if req.Header.Get("Authorization") == "" {
+				resp.StatusCode = http.StatusUnauthorized
+				resp.Header.Set("WWW-Authenticate", `Basic realm="x"`)
+			}
+			return resp, nil
+		}),
+		Authenticators: []Authenticator{&BasicAuth{Username: "Aladdin", Password: "open sesame"}},
+	}
+	// A body without GetBody cannot be sent again.
+	req, _ := http.NewRequest("PUT", "http://example.com/", io.NopCloser(strings.NewReader("x")))
+	if resp, _ := d.Do(req); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("one-shot body: status %d", resp.StatusCode)
+	}
+	req, _ = http.NewRequest("PUT", "http://example.com/", strings.NewReader("x"))
+	if resp, _ := d.Do(req); resp.StatusCode != http.StatusOK {
+		t.Errorf("rewindable body: status %d", resp.StatusCode)
+	}
+	if want := "Basic QWxhZGRpbjpvcGVuIHNlc2FtZQ=="; len(auths) != 3 || auths[2] != want {
+		t.Errorf("Authorization sent %q, want %q last", auths, want)
+	}
+}
@@ -0,0 +1,123 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// batchBufferSize is the write buffer of DoBatch; batches smaller than it
+// go out in a single write.
+const batchBufferSize = 64 << 10
+
+// DoBatch pipelines reqs on cc: all requests are written back to back with
+// one flush, then the responses are read in order. Bodies are read into
+// memory so the next response can follow; callers still close them.
+//
+// Requests written after the batch are handed to the read side once the
+// whole batch has been. Only the last request may set Close. On error
+// DoBatch returns the responses read so far; the requests after them may
+// or may not have been processed by the server.
+func (cc *ClientConn) DoBatch(reqs []*http.Request) ([]*http.Response, error) {
+	resps, _, err := cc.doBatch(reqs)
+	return resps, err
+}
+
+// doBatch is DoBatch that also reports whether any request may have
+// reached the wire. When wrote is false the bodies of reqs are untouched.
+func (cc *ClientConn) doBatch(reqs []*http.Request) (resps []*http.Response, wrote bool, err error) {
+	if len(reqs) == 0 {
+		return nil, false, nil
+	}
+	for _, req := range reqs[:len(reqs)-1] {
+		if req.Close {
+			return nil, false, ErrPipeline
+		}
+	}
+	if err := cc.Ping(); err != nil {
+		return nil, false, err
+	}
+	if cc.iswaiting() && !cc.pipelining.Load() {
+		return nil, false, ErrBodyWaitingRead
+	}
+	cc.wmu.Lock()
+	c, err := cc.writeConn()
+	if err == nil && cc.draining.Load() {
+		err = ErrDraining
+	}
+	if err != nil {
+		cc.wmu.Unlock()
+		return nil, false, err
+	}
+	if err := checkProto(reqs[0].Context(), c); err != nil {
+		cc.we.Store(err)
+		cc.wmu.Unlock()
+		return nil, false, err
+	}
+	if reqs[len(reqs)-1].Close {
+		cc.we.Store(ErrPersistEOF)
+	}
+
+	// Responses may start arriving before their request reaches readLoop;
+	// count the whole batch so they are not taken as unsolicited.
+	unclaimed := int32(len(reqs))
+	atomic.AddInt32(&cc.unclaimed, unclaimed)
+	defer func() { atomic.AddInt32(&cc.unclaimed, -unclaimed) }()
+	prs := make([]*pendingReq, len(reqs))
+	sizes := make([]int64, len(reqs))
+	cw := &countingWriter{w: c}
+	bw := bufio.NewWriterSize(cw, batchBufferSize)
+	for i, req := range reqs {
+		prs[i] = newPendingReq(req)
+		cc.beginExchange(req, c)
+		before := cw.n + int64(bw.Buffered())
+		if err = cc.serialize(req, bw); err != nil {
+			break
+		}
+		sizes[i] = cw.n + int64(bw.Buffered()) - before
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		cc.we.Store(err)
+		cc.wmu.Unlock()
+		return nil, true, err
+	}
+	now := cc.clock.Now()
+	for i, pr := range prs {
+		pr.wroteAt = now
+		if err = cc.wrote(pr, sizes[i]); err != nil {
+			cc.wmu.Unlock()
+			cc.abort(err)
+			return nil, true, err
+		}
+	}
+	prev, mine := cc.takeTurn()
+	cc.wmu.Unlock()
+	defer close(mine)
+	<-prev
+
+	resps = make([]*http.Response, 0, len(reqs))
+	for _, pr := range prs {
+		if err := cc.handOver(pr); err != nil {
+			return resps, true, err
+		}
+		atomic.AddInt32(&cc.unclaimed, -1)
+		unclaimed--
+		resp, err := cc.read(pr)
+		if err != nil {
+			return resps, true, err
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return resps, true, err
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		resps = append(resps, resp)
+	}
+	return resps, true, nil
+}
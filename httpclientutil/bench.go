@@ -0,0 +1,52 @@
+package httpclientutil
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// BenchSample is the timing of one DoN iteration.
+type BenchSample struct {
+	Header time.Duration // until the response header was read
+	Total  time.Duration // until the body was fully read
+	Bytes  int64         // response body size
+	Status int
+}
+
+// DoN sends req n times back to back over cc and discards each response
+// body, so the samples measure the server and the network without dial or
+// TLS costs. A request with a body must have GetBody set. DoN stops at the
+// first error and returns the samples collected so far.
+func (cc *ClientConn) DoN(req *http.Request, n int) ([]BenchSample, error) {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return nil, errors.New("http: DoN needs GetBody to resend the request body")
+	}
+	samples := make([]BenchSample, 0, n)
+	for i := 0; i < n; i++ {
+		r := req
+		if i > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return samples, err
+			}
+			r = req.Clone(req.Context())
+			r.Body = body
+		}
+		start := time.Now()
+		resp, err := cc.Do(r)
+		if err != nil {
+			return samples, err
+		}
+		s := BenchSample{Header: time.Since(start), Status: resp.StatusCode}
+		s.Bytes, err = copyBuffer(struct{ io.Writer }{io.Discard}, resp.Body)
+		resp.Body.Close()
+		s.Total = time.Since(start)
+		if err != nil {
+			return samples, err
+		}
+		samples = append(samples, s)
+	}
+	return samples, nil
+}
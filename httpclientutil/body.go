@@ -1,8 +1,12 @@
 package httpclientutil
 
 import (
+	"bufio"
+	"bytes"
 	"errors"
+	"fmt"
 	"io"
+	"net/http"
 	"sync"
 )
 
@@ -26,23 +30,67 @@ type bodyEOFSignal struct {
 	earlyCloseFn func() error      // optional alt Close func used if io.EOF not seen
 }
 
-func newBodyEOFSingle(body io.ReadCloser, waitch chan bool, closeFn func(error)) io.ReadCloser {
+// closeFn, if not nil, sees how the body ended and returns the error Read
+// reports for it.
+func newBodyEOFSingle(body io.ReadCloser, waitch chan bool, closeFn func(error) error) *bodyEOFSignal {
 	return &bodyEOFSignal{
 		body: body,
 		earlyCloseFn: func() error {
+			if closeFn != nil {
+				closeFn(ErrBodyLeftData)
+			}
 			waitch <- false
 			return nil
 		},
 		fn: func(err error) error {
+			eof := err == io.EOF
 			if closeFn != nil {
-				closeFn(err)
+				err = closeFn(err)
 			}
-			waitch <- (err == io.EOF)
+			waitch <- eof
 			return err
 		},
 	}
 }
 
+// maxDrainBytes bounds how much of an unwanted body is read to keep the
+// connection reusable before giving up and closing it.
+const maxDrainBytes = 256 << 10
+
+// drainBody discards what is left of resp.Body, up to maxDrainBytes, and
+// closes it.
+func drainBody(resp *http.Response) {
+	// Hide io.Discard's ReadFrom so the copy uses the pooled buffer.
+	copyBuffer(struct{ io.Writer }{io.Discard}, io.LimitReader(resp.Body, maxDrainBytes))
+	resp.Body.Close()
+}
+
+// notifyBody calls fn once, when the body is closed or a read fails,
+// whichever comes first. err is nil when the body was closed.
+type notifyBody struct {
+	io.ReadCloser
+	once sync.Once
+	fn   func(err error)
+}
+
+func newNotifyBody(body io.ReadCloser, fn func(error)) io.ReadCloser {
+	return &notifyBody{ReadCloser: body, fn: fn}
+}
+
+func (nb *notifyBody) Read(p []byte) (int, error) {
+	n, err := nb.ReadCloser.Read(p)
+	if err != nil {
+		nb.once.Do(func() { nb.fn(err) })
+	}
+	return n, err
+}
+
+func (nb *notifyBody) Close() error {
+	err := nb.ReadCloser.Close()
+	nb.once.Do(func() { nb.fn(nil) })
+	return err
+}
+
 var errReadOnClosedResBody = errors.New("http: read on closed response body")
 
 func (es *bodyEOFSignal) Read(p []byte) (n int, err error) {
@@ -82,6 +130,15 @@ func (es *bodyEOFSignal) Close() error {
 	return es.condfn(err)
 }
 
+// fail makes later Reads return err, unless the body already ended.
+func (es *bodyEOFSignal) fail(err error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if es.rerr == nil {
+		es.rerr = err
+	}
+}
+
 // caller must hold es.mu.
 func (es *bodyEOFSignal) condfn(err error) error {
 	if es.fn == nil {
@@ -91,3 +148,54 @@ func (es *bodyEOFSignal) condfn(err error) error {
 	es.fn = nil
 	return err
 }
+
+// ErrLengthMismatch matches, via errors.Is, every *LengthMismatchError.
+var ErrLengthMismatch = errors.New("http: body length does not match Content-Length")
+
+// LengthMismatchError is returned in place of the final EOF of a body that
+// ended before its Content-Length, or that was followed by data which is
+// not another response. Either way the connection is out of step with the
+// server and is not reused.
+type LengthMismatchError struct {
+	Declared int64 // Content-Length
+	Read     int64 // bytes of body received
+	Extra    bool  // more data followed the declared length
+}
+
+func (e *LengthMismatchError) Error() string {
+	if e.Extra {
+		return fmt.Sprintf("http: data after the %d bytes of Content-Length", e.Declared)
+	}
+	return fmt.Sprintf("http: body ended after %d of %d bytes of Content-Length", e.Read, e.Declared)
+}
+
+func (e *LengthMismatchError) Is(target error) bool { return target == ErrLengthMismatch }
+
+// lengthCheckBody turns the end of a Content-Length body into a
+// *LengthMismatchError when the length was wrong. r is the connection's
+// reader, buffered data in which is checked once the body is complete.
+type lengthCheckBody struct {
+	io.ReadCloser
+	declared, n int64
+	r           *bufio.Reader
+}
+
+func (b *lengthCheckBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	switch {
+	case err == io.ErrUnexpectedEOF && b.n < b.declared:
+		err = &LengthMismatchError{Declared: b.declared, Read: b.n}
+	case err == io.EOF && b.r.Buffered() > 0:
+		// Only what already arrived with the body counts; the next
+		// response of a pipeline is not a mismatch.
+		peek, _ := b.r.Peek(b.r.Buffered())
+		if len(peek) > 5 {
+			peek = peek[:5]
+		}
+		if !bytes.HasPrefix([]byte("HTTP/"), peek) {
+			err = &LengthMismatchError{Declared: b.declared, Read: b.n, Extra: true}
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,162 @@
+package httpclientutil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrBudgetExceeded = errors.New("http: byte budget exceeded")
+
+// BudgetError is the error of a request, or of a truncated body, once a
+// host or tag used up its byte budget.
+type BudgetError struct {
+	Host  string // set if the host's budget ran out
+	Tag   string // set if the tag's budget ran out
+	Limit int64
+	Used  int64
+}
+
+func (e *BudgetError) Error() string {
+	what := "host " + e.Host
+	if e.Tag != "" {
+		what = "tag " + e.Tag
+	}
+	return fmt.Sprintf("http: byte budget of %s exceeded: %d of %d bytes used", what, e.Used, e.Limit)
+}
+
+func (e *BudgetError) Is(target error) bool { return target == ErrBudgetExceeded }
+
+// ByteBudget caps the bytes, sent and received together, that a host or a
+// WithConnTag tag may use per Window, as counted by Meter, which must be
+// the ByteMeter of the Dialer the requests go through. The first window
+// starts with the first request, so bytes counted before are not held
+// against it. Hosts are the ones
+// requests name, matched against the hosts Meter counts, so a budget does
+// not see through a proxy or Dialer.Hosts. Once a budget is used up,
+// requests fail with a *BudgetError until the next window starts; with
+// Truncate, so do the bodies of responses already being read, which cuts
+// a long download short. Counting is per connection read and write, so a
+// budget may be overrun by a read buffer or a request written whole. It is
+// safe for concurrent use.
+type ByteBudget struct {
+	Doer     Doer
+	Meter    *ByteMeter
+	Hosts    map[string]int64 // bytes per window, by host
+	Tags     map[string]int64 // bytes per window, by tag
+	Window   time.Duration    // zero never renews the budgets
+	Truncate bool
+	Clock    Clock // nil is the system clock
+
+	mu      sync.Mutex
+	started time.Time            // of the current window
+	base    map[string]ByteCount // counts at its start, by "h:"+host and "t:"+tag
+}
+
+func (b *ByteBudget) Do(req *http.Request) (*http.Response, error) {
+	host := req.URL.Hostname()
+	tag, tagged := req.Context().Value(connTagKey{}).(string)
+	if err := b.check(host, tag, tagged); err != nil {
+		return nil, err
+	}
+	resp, err := b.Doer.Do(req)
+	if err != nil || !b.Truncate {
+		return resp, err
+	}
+	resp.Body = &budgetBody{ReadCloser: resp.Body, b: b, host: host, tag: tag, tagged: tagged}
+	return resp, nil
+}
+
+// Remaining returns what is left of host's budget in the current window,
+// or -1 if it has none.
+func (b *ByteBudget) Remaining(host string) int64 {
+	limit, ok := b.Hosts[host]
+	if !ok {
+		return -1
+	}
+	left := limit - b.used("h:", host)
+	if left < 0 {
+		left = 0
+	}
+	return left
+}
+
+// RemainingTag is Remaining for tag.
+func (b *ByteBudget) RemainingTag(tag string) int64 {
+	limit, ok := b.Tags[tag]
+	if !ok {
+		return -1
+	}
+	left := limit - b.used("t:", tag)
+	if left < 0 {
+		left = 0
+	}
+	return left
+}
+
+// check returns a *BudgetError if host or tag has used its budget.
+func (b *ByteBudget) check(host, tag string, tagged bool) error {
+	if limit, ok := b.Hosts[host]; ok {
+		if used := b.used("h:", host); used >= limit {
+			return &BudgetError{Host: host, Limit: limit, Used: used}
+		}
+	}
+	if limit, ok := b.Tags[tag]; ok && tagged {
+		if used := b.used("t:", tag); used >= limit {
+			return &BudgetError{Host: host, Tag: tag, Limit: limit, Used: used}
+		}
+	}
+	return nil
+}
+
+// used returns the bytes of a host or tag in the current window, starting
+// a new one if Window has passed.
+func (b *ByteBudget) used(kind, key string) int64 {
+	var c ByteCount
+	if kind == "h:" {
+		c = b.Meter.Host(key)
+	} else {
+		c = b.Meter.Tag(key)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if now := clockOrSystem(b.Clock).Now(); b.started.IsZero() || b.Window > 0 && now.Sub(b.started) >= b.Window {
+		b.renew(now)
+	}
+	base := b.base[kind+key]
+	if c.Sent < base.Sent || c.Received < base.Received {
+		base = ByteCount{} // the meter was reset
+	}
+	return c.Sent - base.Sent + c.Received - base.Received
+}
+
+// renew starts a window at now: what was counted so far is not held
+// against it. The caller holds mu.
+func (b *ByteBudget) renew(now time.Time) {
+	b.started = now
+	b.base = make(map[string]ByteCount)
+	for host, c := range b.Meter.Hosts() {
+		b.base["h:"+host] = c
+	}
+	for tag, c := range b.Meter.Tags() {
+		b.base["t:"+tag] = c
+	}
+}
+
+// budgetBody fails once its host or tag is over budget.
+type budgetBody struct {
+	io.ReadCloser
+	b         *ByteBudget
+	host, tag string
+	tagged    bool
+}
+
+func (bb *budgetBody) Read(p []byte) (int, error) {
+	if err := bb.b.check(bb.host, bb.tag, bb.tagged); err != nil {
+		return 0, err
+	}
+	return bb.ReadCloser.Read(p)
+}
@@ -0,0 +1,106 @@
+package httpclientutil
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestByteBudget(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Repeat("x", 1000))
+	}))
+	defer s.Close()
+	m := new(ByteMeter)
+	pool := &ClientConnPool{Dialer: &Dialer{Meter: m}}
+	defer pool.Close()
+	clock := newFakeClock()
+	b := &ByteBudget{
+		Doer:   pool,
+		Meter:  m,
+		Hosts:  map[string]int64{"127.0.0.1": 3000},
+		Tags:   map[string]int64{"small": 500},
+		Window: time.Hour,
+		Clock:  clock,
+	}
+	get := func(ctx context.Context) error {
+		t.Helper()
+		req, _ := http.NewRequestWithContext(ctx, "GET", s.URL, nil)
+		resp, err := b.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	for i := 0; i < 2; i++ {
+		if err := get(context.Background()); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	if left := b.Remaining("127.0.0.1"); left <= 0 || left >= 1000 {
+		t.Errorf("Remaining = %d", left)
+	}
+	get(context.Background())
+	err := get(context.Background())
+	var be *BudgetError
+	if !errors.As(err, &be) || !errors.Is(err, ErrBudgetExceeded) || be.Host != "127.0.0.1" || be.Limit != 3000 || be.Used < 3000 {
+		t.Fatalf("over budget: %v", err)
+	}
+	if left := b.Remaining("127.0.0.1"); left != 0 {
+		t.Errorf("Remaining = %d", left)
+	}
+	if left := b.Remaining("other"); left != -1 {
+		t.Errorf("Remaining without a budget = %d", left)
+	}
+
+	// The next window renews the budget.
+	clock.Advance(time.Hour)
+	if err := get(context.Background()); err != nil {
+		t.Fatalf("next window: %v", err)
+	}
+
+	// A tag's budget binds its requests only; the first one goes through,
+	// the one after fails.
+	small := WithConnTag(context.Background(), "small")
+	if err := get(small); err != nil {
+		t.Fatal(err)
+	}
+	if err := get(small); !errors.As(err, &be) || be.Tag != "small" {
+		t.Errorf("tag over budget: %v", err)
+	}
+	if left := b.RemainingTag("small"); left != 0 {
+		t.Errorf("RemainingTag = %d", left)
+	}
+}
+
+func TestByteBudgetTruncate(t *testing.T) {
+	chunk := strings.Repeat("x", 32<<10)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 64; i++ {
+			io.WriteString(w, chunk)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer s.Close()
+	m := new(ByteMeter)
+	pool := &ClientConnPool{Dialer: &Dialer{Meter: m}}
+	defer pool.Close()
+	b := &ByteBudget{Doer: pool, Meter: m, Hosts: map[string]int64{"127.0.0.1": 256 << 10}, Truncate: true}
+	req, _ := http.NewRequest("GET", s.URL, nil)
+	resp, err := b.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if !errors.Is(err, ErrBudgetExceeded) || n >= 1<<20 {
+		t.Errorf("read %d bytes, err %v; want a download cut short", n, err)
+	}
+}
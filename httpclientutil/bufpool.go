@@ -0,0 +1,84 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"io"
+	"sync"
+)
+
+// ConnBufferPool supplies the buffered readers and writers connections use
+// and takes them back, as BufferPool does the buffers of body copies, so
+// a client keeping many connections, a proxy or load generator say,
+// recycles them rather than allocating them. With a pool a connection
+// borrows a writer only while it writes a request, and returns its reader
+// once it is closed with Close and nothing else can read through it: a
+// reader passed on by Hijack, to an upgraded or tunneled connection, or to
+// a response body left unfinished is not put back.
+type ConnBufferPool interface {
+	GetReader(r io.Reader) *bufio.Reader
+	PutReader(br *bufio.Reader)
+	GetWriter(w io.Writer) *bufio.Writer
+	PutWriter(bw *bufio.Writer)
+}
+
+// WithConnBufferPool makes the connection get its buffers from p. p's sizes
+// take precedence over WithBufferSizes, and a reader from WithReader is
+// neither taken from p nor put back.
+func WithConnBufferPool(p ConnBufferPool) Option {
+	return func(cc *ClientConn) { cc.bufPool = p }
+}
+
+// NewConnBufferPool returns a ConnBufferPool backed by sync.Pool, of readers and
+// writers of the given sizes; a size of zero is 4KB.
+func NewConnBufferPool(readSize, writeSize int) ConnBufferPool {
+	if readSize <= 0 {
+		readSize = 4 << 10
+	}
+	if writeSize <= 0 {
+		writeSize = 4 << 10
+	}
+	p := new(syncConnBufferPool)
+	p.readers.New = func() interface{} { return bufio.NewReaderSize(nil, readSize) }
+	p.writers.New = func() interface{} { return bufio.NewWriterSize(nil, writeSize) }
+	return p
+}
+
+type syncConnBufferPool struct {
+	readers, writers sync.Pool
+}
+
+func (p *syncConnBufferPool) GetReader(r io.Reader) *bufio.Reader {
+	br := p.readers.Get().(*bufio.Reader)
+	br.Reset(r)
+	return br
+}
+
+func (p *syncConnBufferPool) PutReader(br *bufio.Reader) {
+	br.Reset(nil) // drop the conn
+	p.readers.Put(br)
+}
+
+func (p *syncConnBufferPool) GetWriter(w io.Writer) *bufio.Writer {
+	bw := p.writers.Get().(*bufio.Writer)
+	bw.Reset(w)
+	return bw
+}
+
+func (p *syncConnBufferPool) PutWriter(bw *bufio.Writer) {
+	bw.Reset(nil)
+	p.writers.Put(bw)
+}
+
+// recycleReader puts r back into the pool once readLoop is done with it,
+// unless someone else may still read through it.
+func (cc *ClientConn) recycleReader(r *bufio.Reader) {
+	if cc.bufPool == nil || r == nil || !cc.pooledReader {
+		return
+	}
+	spawn("reader recycler", func() {
+		<-cc.readDone
+		if !cc.readerShared.Load() {
+			cc.bufPool.PutReader(r)
+		}
+	})
+}
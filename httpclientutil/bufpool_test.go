@@ -0,0 +1,131 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// countingPool is a ConnBufferPool recording what it hands out and gets back.
+type countingPool struct {
+	ConnBufferPool
+	mu               sync.Mutex
+	readers, writers int
+	readersBack      []*bufio.Reader
+	writersBack      int
+}
+
+func (p *countingPool) GetReader(r io.Reader) *bufio.Reader {
+	p.mu.Lock()
+	p.readers++
+	p.mu.Unlock()
+	return p.ConnBufferPool.GetReader(r)
+}
+
+func (p *countingPool) PutReader(br *bufio.Reader) {
+	p.mu.Lock()
+	p.readersBack = append(p.readersBack, br)
+	p.mu.Unlock()
+	p.ConnBufferPool.PutReader(br)
+}
+
+func (p *countingPool) GetWriter(w io.Writer) *bufio.Writer {
+	p.mu.Lock()
+	p.writers++
+	p.mu.Unlock()
+	return p.ConnBufferPool.GetWriter(w)
+}
+
+func (p *countingPool) PutWriter(bw *bufio.Writer) {
+	p.mu.Lock()
+	p.writersBack++
+	p.mu.Unlock()
+	p.ConnBufferPool.PutWriter(bw)
+}
+
+func (p *countingPool) readersReturned() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.readersBack)
+}
+
+func echoServer(c net.Conn, br *bufio.Reader) {
+	for {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		writeResponse(c, req.URL.Path)
+	}
+}
+
+func TestBufferPool(t *testing.T) {
+	p := &countingPool{ConnBufferPool: NewConnBufferPool(8<<10, 0)}
+	cc := rawServer(t, echoServer, WithConnBufferPool(p))
+	if n := cc.r.Size(); n != 8<<10 {
+		t.Errorf("reader of %d bytes", n)
+	}
+	r := cc.r
+	for i := 0; i < 3; i++ {
+		if body := doBody(t, cc); body != "/" {
+			t.Fatalf("body = %q", body)
+		}
+	}
+	p.mu.Lock()
+	if p.readers != 1 || p.writers != 3 || p.writersBack != 3 {
+		t.Errorf("%d readers, %d writers taken, %d back", p.readers, p.writers, p.writersBack)
+	}
+	p.mu.Unlock()
+	cc.Close()
+	waitFor(t, "the reader to go back", func() bool { return p.readersReturned() == 1 })
+	if p.readersBack[0] != r {
+		t.Error("another reader went back")
+	}
+}
+
+func TestBufferPoolKeepsSharedReaders(t *testing.T) {
+	p := &countingPool{ConnBufferPool: NewConnBufferPool(0, 0)}
+
+	// Hijack hands the reader on.
+	cc := rawServer(t, echoServer, WithConnBufferPool(p))
+	doBody(t, cc)
+	c, _ := cc.Hijack()
+	c.Close()
+	cc.Close()
+
+	// So does a body closed with the connection before its end.
+	cc = rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		if _, err := http.ReadRequest(br); err != nil {
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nhalf")
+		io.Copy(io.Discard, br)
+	}, WithConnBufferPool(p))
+	req, _ := http.NewRequest("GET", "http://a.example/", nil)
+	resp, err := cc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc.Close()
+	<-cc.readDone
+	io.ReadAll(resp.Body)
+
+	// A reader of one's own is not the pool's.
+	pc, ps := net.Pipe()
+	defer ps.Close()
+	cc = NewClientConn(pc, WithConnBufferPool(p), WithReader(bufio.NewReader(pc)))
+	cc.Close()
+	<-cc.readDone
+
+	// A reader goes back on the goroutine Close starts; a later Close
+	// that does flushes the ones before.
+	last := rawServer(t, echoServer, WithConnBufferPool(p))
+	last.Close()
+	waitFor(t, "the last reader", func() bool { return p.readersReturned() > 0 })
+	if n := p.readersReturned(); n != 1 {
+		t.Errorf("%d readers back, want only the last", n)
+	}
+}
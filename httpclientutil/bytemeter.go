@@ -0,0 +1,161 @@
+package httpclientutil
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// ByteCount is what connections sent and received, counted on the wire:
+// headers, TLS records and all.
+type ByteCount struct {
+	Sent, Received int64
+}
+
+// ByteMeter counts the bytes of the connections Dialer dials, by host and
+// by WithConnTag tag, for chargeback or egress budgets. The host is the
+// one dialed, so a proxied request counts against the proxy unless the
+// tag tells. Reading the counts is cheap enough to check a budget before
+// each request. It is safe for concurrent use.
+type ByteMeter struct {
+	mu    sync.Mutex
+	hosts map[string]*byteCounter
+	tags  map[string]*byteCounter
+}
+
+// byteCounter is shared by the connections of a host or a tag.
+type byteCounter struct {
+	sent, received int64
+}
+
+func (bc *byteCounter) load() ByteCount {
+	return ByteCount{Sent: atomic.LoadInt64(&bc.sent), Received: atomic.LoadInt64(&bc.received)}
+}
+
+func (bc *byteCounter) swap() ByteCount {
+	return ByteCount{Sent: atomic.SwapInt64(&bc.sent, 0), Received: atomic.SwapInt64(&bc.received, 0)}
+}
+
+// Host returns the count of host.
+func (m *ByteMeter) Host(host string) ByteCount {
+	return m.count(m.hosts, host)
+}
+
+// Tag returns the count of tag.
+func (m *ByteMeter) Tag(tag string) ByteCount {
+	return m.count(m.tags, tag)
+}
+
+// Hosts returns the count of every host seen.
+func (m *ByteMeter) Hosts() map[string]ByteCount {
+	return m.all(m.hosts)
+}
+
+// Tags returns the count of every tag seen.
+func (m *ByteMeter) Tags() map[string]ByteCount {
+	return m.all(m.tags)
+}
+
+// ResetHost zeroes the count of host and returns it as it was, so a
+// billing period closes without losing bytes counted meanwhile.
+func (m *ByteMeter) ResetHost(host string) ByteCount {
+	return m.reset(m.hosts, host)
+}
+
+// ResetTag zeroes the count of tag and returns it as it was.
+func (m *ByteMeter) ResetTag(tag string) ByteCount {
+	return m.reset(m.tags, tag)
+}
+
+// Reset zeroes every count.
+func (m *ByteMeter) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, bc := range m.hosts {
+		bc.swap()
+	}
+	for _, bc := range m.tags {
+		bc.swap()
+	}
+}
+
+func (m *ByteMeter) count(counters map[string]*byteCounter, key string) ByteCount {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if bc := counters[key]; bc != nil {
+		return bc.load()
+	}
+	return ByteCount{}
+}
+
+func (m *ByteMeter) reset(counters map[string]*byteCounter, key string) ByteCount {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if bc := counters[key]; bc != nil {
+		return bc.swap()
+	}
+	return ByteCount{}
+}
+
+func (m *ByteMeter) all(counters map[string]*byteCounter) map[string]ByteCount {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counts := make(map[string]ByteCount, len(counters))
+	for k, bc := range counters {
+		counts[k] = bc.load()
+	}
+	return counts
+}
+
+func (m *ByteMeter) counter(counters *map[string]*byteCounter, key string) *byteCounter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	bc := (*counters)[key]
+	if bc == nil {
+		if *counters == nil {
+			*counters = make(map[string]*byteCounter)
+		}
+		bc = new(byteCounter)
+		(*counters)[key] = bc
+	}
+	return bc
+}
+
+// meter wraps c to count its bytes against host and, if tagged, tag.
+func (m *ByteMeter) meter(c net.Conn, host, tag string, tagged bool) net.Conn {
+	mc := &meteredConn{Conn: c, host: m.counter(&m.hosts, host)}
+	if tagged {
+		mc.tag = m.counter(&m.tags, tag)
+	}
+	return mc
+}
+
+type meteredConn struct {
+	net.Conn
+	host, tag *byteCounter // tag nil if untagged
+}
+
+func (c *meteredConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		atomic.AddInt64(&c.host.received, int64(n))
+		if c.tag != nil {
+			atomic.AddInt64(&c.tag.received, int64(n))
+		}
+	}
+	return n, err
+}
+
+func (c *meteredConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		atomic.AddInt64(&c.host.sent, int64(n))
+		if c.tag != nil {
+			atomic.AddInt64(&c.tag.sent, int64(n))
+		}
+	}
+	return n, err
+}
+
+// NetConn returns the conn being counted, for Probe.
+func (c *meteredConn) NetConn() net.Conn { return c.Conn }
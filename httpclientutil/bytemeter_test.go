@@ -0,0 +1,68 @@
+package httpclientutil
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestByteMeter(t *testing.T) {
+	body := strings.Repeat("x", 1000)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		io.WriteString(w, body)
+	}))
+	defer s.Close()
+	m := new(ByteMeter)
+	p := &ClientConnPool{Dialer: &Dialer{Meter: m}}
+	defer p.Close()
+	do := func(ctx context.Context, upload string) {
+		t.Helper()
+		req, _ := http.NewRequestWithContext(ctx, "POST", s.URL, strings.NewReader(upload))
+		resp, err := p.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	do(WithConnTag(context.Background(), "tenant-a"), strings.Repeat("u", 500))
+	do(context.Background(), "")
+
+	a := m.Tag("tenant-a")
+	if a.Sent < 500 || a.Received < 1000 {
+		t.Errorf("tenant-a = %+v", a)
+	}
+	host := m.Host("127.0.0.1")
+	if host.Sent <= a.Sent || host.Received < 2000 {
+		t.Errorf("host = %+v, tenant-a = %+v", host, a)
+	}
+	if got := m.Tag("tenant-b"); got != (ByteCount{}) {
+		t.Errorf("unseen tag = %+v", got)
+	}
+	if tags := m.Tags(); len(tags) != 1 || tags["tenant-a"] != a {
+		t.Errorf("Tags = %+v", tags)
+	}
+	if hosts := m.Hosts(); len(hosts) != 1 || hosts["127.0.0.1"] != host {
+		t.Errorf("Hosts = %+v", hosts)
+	}
+
+	if got := m.ResetTag("tenant-a"); got != a {
+		t.Errorf("ResetTag = %+v, want %+v", got, a)
+	}
+	if got := m.Tag("tenant-a"); got != (ByteCount{}) {
+		t.Errorf("after ResetTag: %+v", got)
+	}
+	// Open connections go on counting after a reset.
+	do(WithConnTag(context.Background(), "tenant-a"), "")
+	if got := m.Tag("tenant-a"); got.Received < 1000 {
+		t.Errorf("after a reset: %+v", got)
+	}
+	m.Reset()
+	if got := m.Host("127.0.0.1"); got != (ByteCount{}) {
+		t.Errorf("after Reset: %+v", got)
+	}
+}
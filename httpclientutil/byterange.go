@@ -0,0 +1,168 @@
+package httpclientutil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrRangeNotSatisfiable = errors.New("http: requested range not satisfiable")
+	ErrBadContentRange     = errors.New("http: malformed Content-Range")
+	errNoRanges            = errors.New("http: no byte ranges given")
+)
+
+// ByteRange is an inclusive range of byte offsets. An End below zero leaves
+// the range open to the end of the representation; a Start below zero asks
+// for the final -Start bytes.
+type ByteRange struct {
+	Start, End int64
+}
+
+func (br ByteRange) String() string {
+	switch {
+	case br.Start < 0:
+		return strconv.FormatInt(br.Start, 10)
+	case br.End < 0:
+		return strconv.FormatInt(br.Start, 10) + "-"
+	}
+	return strconv.FormatInt(br.Start, 10) + "-" + strconv.FormatInt(br.End, 10)
+}
+
+// SetRanges sets the Range header of req to ask for all of ranges in a
+// single request. At least one range is required.
+func SetRanges(req *http.Request, ranges ...ByteRange) error {
+	if len(ranges) == 0 {
+		return errNoRanges
+	}
+	specs := make([]string, len(ranges))
+	for i, br := range ranges {
+		specs[i] = br.String()
+	}
+	req.Header.Set("Range", "bytes="+strings.Join(specs, ","))
+	return nil
+}
+
+// RangePart is one range of a ranged response. Size is the complete length
+// of the representation, or -1 if the server did not report it. End is -1
+// when a full response of unknown length leaves the range open.
+type RangePart struct {
+	Header     textproto.MIMEHeader
+	Start, End int64
+	Size       int64
+	io.Reader
+}
+
+// RangeReader iterates over the ranges carried by a response, whether the
+// server answered with a single 206 part, a multipart/byteranges body, or
+// the full representation.
+type RangeReader struct {
+	body   io.ReadCloser
+	mr     *multipart.Reader
+	single *RangePart
+}
+
+// ReadRanges prepares resp for iteration with Next. The caller must Close
+// the returned reader, which closes the response body.
+func ReadRanges(resp *http.Response) (*RangeReader, error) {
+	rr := &RangeReader{body: resp.Body}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		rr.single = &RangePart{
+			Header: textproto.MIMEHeader(resp.Header),
+			End:    -1,
+			Size:   resp.ContentLength,
+			Reader: resp.Body,
+		}
+		if resp.ContentLength >= 0 {
+			rr.single.End = resp.ContentLength - 1
+		}
+		return rr, nil
+	case http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		resp.Body.Close()
+		return nil, ErrRangeNotSatisfiable
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("http: unexpected status for range request: %s", resp.Status)
+	}
+	mediatype, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err == nil && mediatype == "multipart/byteranges" {
+		if params["boundary"] == "" {
+			resp.Body.Close()
+			return nil, errors.New("http: multipart/byteranges without boundary")
+		}
+		rr.mr = multipart.NewReader(resp.Body, params["boundary"])
+		return rr, nil
+	}
+	part := &RangePart{Header: textproto.MIMEHeader(resp.Header), Reader: resp.Body}
+	if part.Start, part.End, part.Size, err = parseContentRange(resp.Header.Get("Content-Range")); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	rr.single = part
+	return rr, nil
+}
+
+// Next returns the next range. Reading a part after calling Next again is
+// not allowed. Next returns io.EOF after the last part.
+func (rr *RangeReader) Next() (*RangePart, error) {
+	if rr.mr == nil {
+		part := rr.single
+		rr.single = nil
+		if part == nil {
+			return nil, io.EOF
+		}
+		return part, nil
+	}
+	p, err := rr.mr.NextPart()
+	if err != nil {
+		return nil, err
+	}
+	part := &RangePart{Header: p.Header, Reader: p}
+	if part.Start, part.End, part.Size, err = parseContentRange(p.Header.Get("Content-Range")); err != nil {
+		return nil, err
+	}
+	return part, nil
+}
+
+func (rr *RangeReader) Close() error {
+	return rr.body.Close()
+}
+
+// parseContentRange parses a "bytes first-last/size" value. size is -1 when
+// the server sent "*".
+func parseContentRange(v string) (first, last, size int64, err error) {
+	v = strings.TrimSpace(v)
+	if !strings.HasPrefix(v, "bytes ") {
+		return 0, 0, 0, ErrBadContentRange
+	}
+	v = strings.TrimSpace(v[len("bytes "):])
+	i := strings.IndexByte(v, '/')
+	if i < 0 {
+		return 0, 0, 0, ErrBadContentRange
+	}
+	rng, total := v[:i], v[i+1:]
+	size = -1
+	if total != "*" {
+		if size, err = strconv.ParseInt(total, 10, 64); err != nil || size < 0 {
+			return 0, 0, 0, ErrBadContentRange
+		}
+	}
+	j := strings.IndexByte(rng, '-')
+	if j < 0 {
+		return 0, 0, 0, ErrBadContentRange
+	}
+	first, err1 := strconv.ParseInt(rng[:j], 10, 64)
+	last, err2 := strconv.ParseInt(rng[j+1:], 10, 64)
+	if err1 != nil || err2 != nil || first < 0 || last < first || (size >= 0 && last >= size) {
+		return 0, 0, 0, ErrBadContentRange
+	}
+	return first, last, size, nil
+}
@@ -0,0 +1,88 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCancelWaitingForHeaders(t *testing.T) {
+	hold := make(chan struct{})
+	defer close(hold)
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		http.ReadRequest(br)
+		<-hold
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "http://a.example/", nil)
+	if _, err := cc.Do(req); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want DeadlineExceeded", err)
+	}
+	if err := cc.Ping(); err != context.DeadlineExceeded {
+		t.Errorf("Ping = %v, want the cancellation", err)
+	}
+	waitFor(t, "readLoop to exit", cc.stoped.Load)
+}
+
+func TestCancelDuringBody(t *testing.T) {
+	hold := make(chan struct{})
+	defer close(hold)
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		http.ReadRequest(br)
+		io.WriteString(c, "HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\npartial")
+		<-hold
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "http://a.example/", nil)
+	resp, err := cc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	buf := make([]byte, 7)
+	if _, err := io.ReadFull(resp.Body, buf); err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if _, err := resp.Body.Read(buf); err != context.Canceled {
+		t.Fatalf("blocked Read after cancel: err = %v, want Canceled", err)
+	}
+	if cc.Reusable() {
+		t.Error("connection reusable after a canceled body")
+	}
+}
+
+func TestCancelDuringWrite(t *testing.T) {
+	hold := make(chan struct{})
+	defer close(hold)
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		<-hold // never read, so the request fills the socket buffers
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	body := bytes.NewReader(make([]byte, 64<<20))
+	req, _ := http.NewRequestWithContext(ctx, "POST", "http://a.example/", body)
+	done := make(chan error, 1)
+	go func() {
+		_, err := cc.Do(req)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("err = %v, want DeadlineExceeded", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("write not aborted by the deadline")
+	}
+	if cc.Reusable() {
+		t.Error("connection reusable after an aborted write")
+	}
+}
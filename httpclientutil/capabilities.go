@@ -0,0 +1,117 @@
+package httpclientutil
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Capabilities is what an origin advertised in response to OPTIONS.
+type Capabilities struct {
+	Allow        []string // methods, upper case
+	AcceptPatch  []string // media types accepted by PATCH
+	AcceptRanges bool     // byte ranges are supported
+	Fetched      time.Time
+}
+
+// Supports reports whether method is listed in Allow.
+func (c *Capabilities) Supports(method string) bool {
+	for _, m := range c.Allow {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateMethod returns "PATCH" if the origin accepts it and "PUT"
+// otherwise.
+func (c *Capabilities) UpdateMethod() string {
+	if c.Supports("PATCH") {
+		return "PATCH"
+	}
+	return "PUT"
+}
+
+// CapabilityProber issues OPTIONS requests and caches the result per
+// origin. It is safe for concurrent use.
+type CapabilityProber struct {
+	Doer  Doer
+	TTL   time.Duration // zero keeps results until Forget
+	Clock Clock         // nil is the system clock
+
+	mu    sync.Mutex
+	cache map[string]*Capabilities
+}
+
+// ProbeCapabilities returns the capabilities of the origin of target,
+// sending OPTIONS only if nothing fresh is cached. target may be a bare
+// origin such as "https://example.com", which is probed with "OPTIONS *",
+// or a URL whose path is probed instead.
+func (p *CapabilityProber) ProbeCapabilities(ctx context.Context, target string) (*Capabilities, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	origin := u.Scheme + "://" + u.Host
+	clock := clockOrSystem(p.Clock)
+	p.mu.Lock()
+	c := p.cache[origin]
+	p.mu.Unlock()
+	if c != nil && (p.TTL <= 0 || clock.Now().Sub(c.Fetched) < p.TTL) {
+		return c, nil
+	}
+	req, err := http.NewRequest("OPTIONS", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if u.Path == "" {
+		req.URL.Opaque = "*"
+	}
+	resp, err := p.Doer.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	drainBody(resp)
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("http: OPTIONS %s: %s", origin, resp.Status)
+	}
+	c = &Capabilities{
+		Allow:        splitList(resp.Header["Allow"], strings.ToUpper),
+		AcceptPatch:  splitList(resp.Header["Accept-Patch"], strings.TrimSpace),
+		AcceptRanges: strings.EqualFold(strings.TrimSpace(resp.Header.Get("Accept-Ranges")), "bytes"),
+		Fetched:      clock.Now(),
+	}
+	p.mu.Lock()
+	if p.cache == nil {
+		p.cache = make(map[string]*Capabilities)
+	}
+	p.cache[origin] = c
+	p.mu.Unlock()
+	return c, nil
+}
+
+// Forget drops the cached capabilities of origin.
+func (p *CapabilityProber) Forget(origin string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.cache, origin)
+}
+
+// splitList splits comma separated header values, applying norm to each
+// non-empty element.
+func splitList(values []string, norm func(string) string) []string {
+	var list []string
+	for _, v := range values {
+		for _, e := range strings.Split(v, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				list = append(list, norm(e))
+			}
+		}
+	}
+	return list
+}
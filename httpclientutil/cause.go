@@ -0,0 +1,34 @@
+package httpclientutil
+
+import "context"
+
+// CanceledError is the cause, as context.Cause reports it, of a context
+// this package canceled on its own, for the requests and dials it started
+// and then had no use for. Reason says why; Err is the error behind it,
+// if any. It matches context.Canceled with errors.Is, so code checking
+// for a canceled request keeps working.
+//
+// Everything in the package that stops on a done context returns the
+// context's cause, so a cause given with context.WithCancelCause or
+// context.WithTimeoutCause comes back from Do.
+type CanceledError struct {
+	Reason string
+	Err    error
+}
+
+func (e *CanceledError) Error() string {
+	if e.Err != nil {
+		return "http: canceled, " + e.Reason + ": " + e.Err.Error()
+	}
+	return "http: canceled, " + e.Reason
+}
+
+func (e *CanceledError) Unwrap() error { return e.Err }
+
+func (e *CanceledError) Is(target error) bool { return target == context.Canceled }
+
+var (
+	errDialRaceLost  = &CanceledError{Reason: "another address connected first"}
+	errPageAbandoned = &CanceledError{Reason: "pagination ended before the page"}
+	errStandbyClosed = &CanceledError{Reason: "standby closed"}
+)
@@ -0,0 +1,114 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var errTestCause = errors.New("test cause")
+
+func TestCauseFromDo(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		if _, err := http.ReadRequest(br); err != nil {
+			return
+		}
+		br.ReadByte()
+	})
+	ctx, cancel := context.WithCancelCause(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", "http://a.example/", nil)
+	time.AfterFunc(10*time.Millisecond, func() { cancel(errTestCause) })
+	if _, err := cc.Do(req); err != errTestCause {
+		t.Errorf("Do = %v, want the cause", err)
+	}
+	if err := cc.Ping(); err != errTestCause {
+		t.Errorf("Ping = %v, want the cause", err)
+	}
+}
+
+func TestCauseFromBody(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		if _, err := http.ReadRequest(br); err != nil {
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nhello")
+		br.ReadByte()
+	})
+	ctx, cancel := context.WithTimeoutCause(context.Background(), 20*time.Millisecond, errTestCause)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "http://a.example/", nil)
+	resp, err := cc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if _, err := io.ReadAll(resp.Body); err != errTestCause {
+		t.Errorf("body read = %v, want the cause", err)
+	}
+}
+
+func TestCanceledError(t *testing.T) {
+	err := error(&CanceledError{Reason: "another part failed", Err: io.ErrUnexpectedEOF})
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("%v does not match its causes", err)
+	}
+	if got := err.Error(); got != "http: canceled, another part failed: unexpected EOF" {
+		t.Errorf("Error() = %q", got)
+	}
+}
+
+// causeDoer records the cause each request's context ends with.
+type causeDoer struct {
+	Doer
+	causes chan error
+}
+
+func (d causeDoer) Do(req *http.Request) (*http.Response, error) {
+	resp, err := d.Doer.Do(req)
+	go func() {
+		<-req.Context().Done()
+		d.causes <- context.Cause(req.Context())
+	}()
+	return resp, err
+}
+
+func TestPaginatePrefetchCause(t *testing.T) {
+	url, _ := pagesServer(t, 3)
+	p := &ClientConnPool{MaxConnsPerHost: 2}
+	defer p.Close()
+	d := causeDoer{p, make(chan error, 2)}
+	req, _ := http.NewRequest("GET", url, nil)
+	PaginatePrefetch(context.Background(), d, req, func(*http.Response) error { return ErrStopPaging })
+	var abandoned int
+	for i := 0; i < 2; i++ {
+		var ce *CanceledError
+		if err := <-d.causes; errors.As(err, &ce) && ce == errPageAbandoned {
+			abandoned++
+		}
+	}
+	if abandoned != 1 {
+		t.Errorf("%d requests abandoned, want the prefetch of page 2", abandoned)
+	}
+}
+
+func TestMaintenanceCause(t *testing.T) {
+	clock := newFakeClock()
+	now := clock.Now()
+	m := &MaintenanceDoer{
+		Doer:    newClockDoer(clock),
+		Policy:  MaintenanceWindows{{Start: now, End: now.Add(time.Hour)}},
+		MaxWait: 2 * time.Hour,
+		Clock:   clock,
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(errTestCause)
+	req, _ := http.NewRequestWithContext(ctx, "GET", "http://a.example/", nil)
+	if _, err := m.Do(req); err != errTestCause {
+		t.Errorf("Do = %v, want the cause", err)
+	}
+}
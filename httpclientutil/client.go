@@ -2,11 +2,17 @@ package httpclientutil
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"errors"
 	"io"
+	"log"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
@@ -16,136 +22,648 @@ var (
 	ErrBodyWaitingRead  = &http.ProtocolError{ErrorString: "body data waiting for read"}
 	ErrBodyLeftData     = errors.New("http: some data left in the buffer")
 	ErrServerClosedConn = errors.New("http: server closed connection")
+	ErrEarlyResponse    = &http.ProtocolError{ErrorString: "response received before request was written"}
+	ErrEarlyLimit       = &http.ProtocolError{ErrorString: "too many unsolicited responses buffered"}
+	ErrEarlyTooLarge    = &http.ProtocolError{ErrorString: "unsolicited response body too large"}
+	ErrTunnel           = &http.ProtocolError{ErrorString: "connection switched to tunnel mode"}
 )
 
-var errClosed = errors.New("i/o operation on closed connection")
+// EarlyResponsePolicy controls what a ClientConn does with a response that
+// arrives while no request is being written or awaiting its response, i.e.
+// one nobody asked for. A response that overtakes the request it answers is
+// always held until that request is written.
+type EarlyResponsePolicy int
 
+const (
+	// EarlyResponseDeliver waits for the next request and hands it the
+	// response. This is the default.
+	EarlyResponseDeliver EarlyResponsePolicy = iota
+	// EarlyResponseBuffer reads such responses into memory, up to the
+	// configured limit, and keeps them aside for EarlyResponses.
+	EarlyResponseBuffer
+	// EarlyResponseFail breaks the connection with ErrEarlyResponse.
+	EarlyResponseFail
+)
+
+var (
+	errClosed          = errors.New("i/o operation on closed connection")
+	errRequestCanceled = errors.New("http: request canceled")
+)
+
+// Doer sends a request and returns its response. *ClientConn and
+// *http.Client both implement it, as do the helpers in this package that
+// wrap another Doer.
+type Doer interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// ClientConn state is split by owner. conn and r change only in Hijack
+// and Close and are guarded by mu. Writers hold wmu while they write to
+// conn, so Hijack never hands out a conn mid-request, and take their turn
+// for reqch before releasing it, so requests reach readLoop in wire order
+// without holding up Hijack while they wait. Each request carries its own
+// response channel, so a response is never taken by a later caller. The
+// flags below are read on every request without locking: re is set by
+// readLoop (and by read when the caller gives up), we by write,
+// bodyReading by readLoop when it hands out a body and by the body when it
+// is finished, stoped by readLoop on exit, hijacked by Hijack and
+// pipelining by SetPipelining.
 type ClientConn struct {
-	mu          sync.Mutex // read-write protects the following fields
-	conn        net.Conn
-	r           *bufio.Reader
-	bodyReading bool
-	stoped      bool
-	re, we      error // read/write errors
-	reqch       chan *http.Request
-	respch      chan *http.Response
-	closech     chan struct{}
-	writeReq    func(*http.Request, io.Writer) error
-}
-
-func NewClientConn(c net.Conn, r *bufio.Reader) *ClientConn {
-	if r == nil {
-		r = bufio.NewReader(c)
-	}
+	wmu          sync.Mutex    // serializes writers, see above
+	lastTurn     chan struct{} // closed once the last writer handed over; guarded by wmu
+	mu           sync.Mutex    // protects conn, r, coalescer, interner, written and the early response fields
+	conn         net.Conn
+	r            *bufio.Reader
+	bodyReading  atomicBool
+	stoped       atomicBool
+	hijacked     atomicBool
+	pipelining   atomicBool
+	re, we       atomicError // read/write errors
+	aborted      atomicError // the cause abort stored in re, see abortedWith
+	reqch        chan *pendingReq
+	closech      chan struct{}
+	closeOnce    sync.Once
+	readDone     chan struct{} // closed when readLoop exits
+	writeReq     func(*http.Request, io.Writer) error
+	unclaimed    int32 // requests written or being written but not yet on reqch
+	earlyPolicy  EarlyResponsePolicy
+	earlyMax     int
+	early        []*http.Response
+	coalescer    *writeCoalescer
+	queue        *requestQueue // see WithRequestQueue
+	interner     *headerInterner
+	stats        *connCounters
+	timeouts     connTimeouts
+	active       int32     // exchanges begun and not finished, see beginExchange
+	idle         idleState // guarded by mu
+	on1xx        func(*http.Request, *http.Response)
+	decompress   bool
+	clock        Clock
+	logger       *log.Logger
+	remote       string        // for the logger
+	bufSizes     bufferSizes   // see WithBufferSizes
+	bw           *bufio.Writer // of bufSizes.write, guarded by wmu
+	bufPool      ConnBufferPool
+	collector    Collector
+	pooledReader bool                          // r came from bufPool
+	readerShared atomicBool                    // readLoop left r to a body, tunnel or upgrade
+	written      map[*http.Request]*pendingReq // by Write, awaiting Read; guarded by mu
+	draining     atomicBool                    // set by Drain
+	drained      chan struct{}                 // closed when Drain may close; guarded by mu
+	poller       *IdlePoller                   // see WithIdlePoller
+	parking      parkState
+}
+
+// NewClientConn returns a ClientConn sending requests on c, configured by
+// opts. A nil Option is ignored, so httputil's NewClientConn(c, nil) still
+// compiles and means the same.
+func NewClientConn(c net.Conn, opts ...Option) *ClientConn {
 	cc := &ClientConn{
 		conn:     c,
-		r:        r,
-		reqch:    make(chan *http.Request, 1),
-		respch:   make(chan *http.Response, 1),
+		reqch:    make(chan *pendingReq, 1),
 		writeReq: (*http.Request).Write,
 		closech:  make(chan struct{}),
+		readDone: make(chan struct{}),
+		lastTurn: closedChan,
+		interner: newHeaderInterner(DefaultInternedHeaders),
+		stats:    new(connCounters),
+		clock:    systemClock{},
+	}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(cc)
+		}
+	}
+	switch {
+	case cc.r != nil:
+	case cc.bufPool != nil:
+		cc.r = cc.bufPool.GetReader(statsReader{c, cc.stats})
+		cc.pooledReader = true
+	default:
+		cc.r = newReaderSize(statsReader{c, cc.stats}, cc.bufSizes.read)
 	}
-	go cc.readLoop()
+	if cc.logger != nil && c.RemoteAddr() != nil {
+		cc.remote = c.RemoteAddr().String()
+	}
+	if cc.poller != nil {
+		cc.unparkOnClose(c)
+	}
+	cc.armIdle()
+	spawn("readLoop", cc.readLoop)
 	return cc
 }
 
-func NewProxyClientConn(c net.Conn, r *bufio.Reader) *ClientConn {
-	cc := NewClientConn(c, r)
-	cc.writeReq = (*http.Request).WriteProxy
-	return cc
+// NewProxyClientConn is NewClientConn with WithProxyMode(true) before
+// opts.
+//
+// Deprecated: Use NewClientConn with WithProxyMode.
+func NewProxyClientConn(c net.Conn, opts ...Option) *ClientConn {
+	return NewClientConn(c, append([]Option{WithProxyMode(true)}, opts...)...)
+}
+
+type requestWriterKey struct{}
+
+// WithRequestWriter returns a context under which ClientConn serializes a
+// request with w instead of its own writer, (*http.Request).Write or
+// WriteProxy for a proxy conn. This mixes origin-form and absolute-form
+// requests, or other framings, on one connection. w must write exactly one
+// complete request.
+func WithRequestWriter(ctx context.Context, w func(*http.Request, io.Writer) error) context.Context {
+	return context.WithValue(ctx, requestWriterKey{}, w)
+}
+
+// writeBuffered serializes req to c, through a buffer of the size set
+// with WithBufferSizes if any. The caller holds wmu.
+func (cc *ClientConn) writeBuffered(req *http.Request, c io.Writer) error {
+	if cc.bufPool != nil {
+		bw := cc.bufPool.GetWriter(c)
+		defer cc.bufPool.PutWriter(bw)
+		if err := cc.serialize(req, bw); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}
+	if cc.bufSizes.write <= 0 {
+		return cc.serialize(req, c)
+	}
+	if cc.bw == nil {
+		cc.bw = bufio.NewWriterSize(c, cc.bufSizes.write)
+	} else {
+		cc.bw.Reset(c)
+	}
+	if err := cc.serialize(req, cc.bw); err != nil {
+		return err
+	}
+	return cc.bw.Flush()
+}
+
+// serialize writes req to w with the writer chosen for it.
+func (cc *ClientConn) serialize(req *http.Request, w io.Writer) error {
+	if rw, ok := req.Context().Value(requestWriterKey{}).(func(*http.Request, io.Writer) error); ok && rw != nil {
+		return rw(req, w)
+	}
+	return cc.writeReq(req, w)
+}
+
+// defaultEarlyMax is the EarlyResponseBuffer limit used when none is set.
+const defaultEarlyMax = 8
+
+// SetEarlyResponsePolicy sets how responses arriving ahead of their request
+// are handled. max bounds the number of responses kept by
+// EarlyResponseBuffer, 8 if max <= 0; once exceeded the connection fails
+// with ErrEarlyLimit. A buffered body longer than 256KB fails it with
+// ErrEarlyTooLarge.
+func (cc *ClientConn) SetEarlyResponsePolicy(p EarlyResponsePolicy, max int) {
+	if max <= 0 {
+		max = defaultEarlyMax
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.earlyPolicy = p
+	cc.earlyMax = max
+}
+
+// SetPipelining lets Do write a request while earlier responses are still
+// being read, instead of failing with ErrBodyWaitingRead. Requests go out
+// back to back and responses are matched to them in order (RFC 9112
+// section 9.3.2), so each Do returns once the bodies before its own have
+// been read or closed. When the connection breaks, every request still
+// waiting for its response fails with the error that broke it; the server
+// may have processed some of them, so keep requests that must not be
+// replayed off pipelines, or use PipelineRetrier.
+func (cc *ClientConn) SetPipelining(on bool) {
+	cc.pipelining.Store(on)
 }
 
+// EarlyResponses returns and clears the responses buffered under
+// EarlyResponseBuffer. Their bodies are already read into memory.
+func (cc *ClientConn) EarlyResponses() []*http.Response {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	early := cc.early
+	cc.early = nil
+	return early
+}
+
+// Do sends req and returns its response. A 101 Switching Protocols response
+// has the connection as its Body, an io.ReadWriteCloser speaking the new
+// protocol, as with net/http's Transport; cc takes no more requests then.
+//
+// A deadline on req's context bounds the socket I/O too: it is the write
+// deadline while req is written, and the read deadline while its response
+// header is awaited, so a peer that stops reading or answering fails the
+// request on time. Do then returns the cause of the context, and the
+// connection is closed.
 func (cc *ClientConn) Do(req *http.Request) (*http.Response, error) {
-	err := cc.write(req)
+	if cc.decompress && acceptsAnyEncoding(req) {
+		return cc.doDecompressed(req)
+	}
+	return cc.do(req)
+}
+
+func (cc *ClientConn) do(req *http.Request) (*http.Response, error) {
+	if wc := cc.getCoalescer(); wc != nil && !expectsContinue(req) {
+		return wc.do(req)
+	}
+	if cc.queue != nil && !cc.pipelining.Load() {
+		return cc.doQueued(cc.queue, req)
+	}
+	if cc.iswaiting() && !cc.pipelining.Load() {
+		return nil, ErrBodyWaitingRead
+	}
+	pr, err := cc.write(req, false)
 	if err != nil {
 		return nil, err
 	}
-	return cc.read(req)
+	return cc.read(pr)
 }
+
+// RoundTrip implements http.RoundTripper, so cc can be the Transport of an
+// http.Client and bring along its cookie jar and redirect handling. Every
+// request goes to the peer of cc whatever host its URL names, so such a
+// client should not follow redirects to other origins; see
+// SameOriginRedirects. As the interface requires, the request body is
+// closed even when an error is returned.
+func (cc *ClientConn) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := cc.Do(req)
+	if err != nil && req.Body != nil {
+		req.Body.Close()
+	}
+	return resp, err
+}
+
+// SameOriginRedirects is an http.Client CheckRedirect that follows up to
+// 10 redirects, like the default, but only within the origin of the first
+// request, for clients whose Transport is a single ClientConn.
+func SameOriginRedirects(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("http: stopped after 10 redirects")
+	}
+	first := via[0].URL
+	if !strings.EqualFold(req.URL.Scheme, first.Scheme) || canonicalAddr(req.URL) != canonicalAddr(first) {
+		return http.ErrUseLastResponse
+	}
+	return nil
+}
+
 func (cc *ClientConn) iswaiting() bool {
-	cc.mu.Lock()
-	defer cc.mu.Unlock()
-	return cc.bodyReading
+	return cc.bodyReading.Load()
+}
+
+// pendingReq is a request handed to readLoop and where to deliver its
+// response.
+type pendingReq struct {
+	req   *http.Request
+	respc chan *http.Response // buffered, readLoop never blocks on it
+	cont  chan bool           // if the body waits for 100 Continue, see continueBody
+
+	timerMu     sync.Mutex // guards the response header timer, see armHeaderTimeout
+	headerTimer stopper
+	headerDone  bool
+
+	wroteAt time.Time // set before the handover to readLoop, see wrote
 }
 
-func (cc *ClientConn) write(req *http.Request) error {
+func newPendingReq(req *http.Request) *pendingReq {
+	return &pendingReq{req: req, respc: make(chan *http.Response, 1)}
+}
+
+// write writes req and hands it to readLoop. With async the handover
+// happens on its own goroutine, in order, and write returns once req is on
+// the wire: Write must not wait behind the unread bodies of earlier
+// responses. A failed handover means readLoop is gone, which read notices.
+func (cc *ClientConn) write(req *http.Request, async bool) (*pendingReq, error) {
 	var err error
 	if err = cc.Ping(); err != nil {
-		return err
+		return nil, err
 	}
-	if cc.iswaiting() {
-		return ErrBodyWaitingRead
+	cc.wmu.Lock()
+	c, err := cc.writeConn()
+	if err == nil && cc.draining.Load() {
+		err = ErrDraining
+	}
+	if err != nil {
+		cc.wmu.Unlock()
+		return nil, err
+	}
+	cc.beginExchange(req, c)
+	handed := false // to readLoop, which ends the exchange
+	defer func() {
+		if !handed {
+			cc.endExchange()
+		}
+	}()
+	if err = checkProto(req.Context(), c); err != nil {
+		cc.we.Store(err)
+		cc.wmu.Unlock()
+		return nil, err
 	}
-	cc.mu.Lock()
-	c := cc.conn
 	if req.Close {
-		cc.we = ErrPersistEOF
+		cc.we.Store(ErrPersistEOF)
+	}
+	atomic.AddInt32(&cc.unclaimed, 1)
+	handing := false // the handover goroutine counts req off
+	defer func() {
+		if !handing {
+			atomic.AddInt32(&cc.unclaimed, -1)
+		}
+	}()
+	pr := newPendingReq(req)
+	wreq, cb := req, (*continueBody)(nil)
+	if expectsContinue(req) || isStream(req) {
+		cb = cc.newContinueBody(pr)
+		wreq = cb.request()
+	}
+	stop := cc.watchWrite(req.Context(), c)
+	cw := &countingWriter{w: c}
+	err = cc.writeTimed(req.Context(), c, func() error { return cc.writeBuffered(wreq, cw) })
+	if aborted := stop(); aborted != nil {
+		cc.wmu.Unlock()
+		cc.abort(aborted)
+		return nil, aborted
+	}
+	if err == ErrWriteTimeout || err != nil && err == context.Cause(req.Context()) {
+		cc.wmu.Unlock()
+		cc.abort(err)
+		return nil, err
+	}
+	if cb != nil && cb.handed.Load() {
+		// readLoop has the request already: it got the final response
+		// instead of 100 Continue, or the body followed.
+		handed = true
+		cc.wmu.Unlock()
+		if err != nil && !cb.declined.Load() {
+			cc.abort(err)
+			return nil, err
+		}
+		if err := cc.wrote(pr, cw.n); err != nil {
+			cc.abort(err)
+			return nil, err
+		}
+		cc.armHeaderTimeout(pr)
+		return pr, nil
 	}
-	cc.mu.Unlock()
-	err = cc.writeReq(req, c)
-	cc.mu.Lock()
 	if err != nil {
-		cc.we = err
-		cc.mu.Unlock()
-		return err
+		cc.we.Store(err)
+		cc.wmu.Unlock()
+		return nil, err
+	}
+	pr.wroteAt = cc.clock.Now()
+	if err = cc.wrote(pr, cw.n); err != nil {
+		cc.wmu.Unlock()
+		cc.abort(err)
+		return nil, err
+	}
+	prev, mine := cc.takeTurn()
+	cc.wmu.Unlock()
+	if async {
+		handing, handed = true, true
+		spawn("request handover", func() {
+			defer atomic.AddInt32(&cc.unclaimed, -1)
+			defer close(mine)
+			<-prev
+			if cc.handOver(pr) != nil {
+				cc.endExchange()
+			}
+		})
+		return pr, nil
+	}
+	defer close(mine)
+	<-prev
+	if err = cc.handOver(pr); err != nil {
+		return nil, err
+	}
+	handed = true
+	return pr, nil
+}
+
+// takeTurn queues the caller for handing requests to readLoop, in the
+// order the requests were written. The caller holds wmu, and hands over
+// once prev is closed, then closes mine.
+func (cc *ClientConn) takeTurn() (prev, mine chan struct{}) {
+	mine = make(chan struct{})
+	prev, cc.lastTurn = cc.lastTurn, mine
+	return prev, mine
+}
+
+// handOver passes a written request to readLoop. It gives up when readLoop
+// exits or cc is closed, which a request waiting behind an unread body
+// would otherwise never notice.
+func (cc *ClientConn) handOver(pr *pendingReq) error {
+	if pr.wroteAt.IsZero() {
+		pr.wroteAt = cc.clock.Now()
+	}
+	cc.armHeaderTimeout(pr)
+	cc.armReadDeadline(pr)
+	select {
+	case cc.reqch <- pr:
+		select {
+		case <-cc.readDone:
+			// readLoop left without taking pr and no longer drains
+			// reqch; nothing will stop the timer.
+			pr.stopHeaderTimeout()
+		default:
+		}
+		return nil
+	case <-cc.readDone:
+		pr.stopHeaderTimeout()
+		return cc.readError()
+	case <-cc.closech:
+		pr.stopHeaderTimeout()
+		return ErrClosed
 	}
-	cc.mu.Unlock()
-	cc.reqch <- req
-	return nil
 }
 
-func (cc *ClientConn) read(req *http.Request) (resp *http.Response, err error) {
-	ctx := req.Context()
+func (cc *ClientConn) read(pr *pendingReq) (resp *http.Response, err error) {
+	ctx := pr.req.Context()
 	select {
-	case resp = <-cc.respch:
+	case resp = <-pr.respc:
+	case <-cc.readDone:
+		select {
+		case resp = <-pr.respc:
+		default:
+			err = cc.readError()
+		}
 	case <-ctx.Done():
-		err = ctx.Err()
-		cc.setReadError(err)
+		err = context.Cause(ctx)
+		cc.abort(err)
 	}
 	return
 }
 
+// abort marks cc dead with err, the cause a canceled request reports, and
+// closes the connection so that readLoop and any reader blocked on the
+// socket return at once. Once a request was abandoned midway, the server
+// and cc no longer agree where the next response starts.
+func (cc *ClientConn) abort(err error) {
+	if cc.re.Load() == nil {
+		cc.aborted.Store(err)
+		cc.re.Store(err)
+	}
+	if cc.we.Load() == nil {
+		cc.we.Store(err)
+	}
+	cc.Close()
+}
+
+// watchWrite aborts a write to c that is still going on when ctx is done.
+// The returned stop must be called once the write returned; it reports
+// ctx's error if the write was aborted.
+func (cc *ClientConn) watchWrite(ctx context.Context, c net.Conn) (stop func() error) {
+	if ctx.Done() == nil {
+		return func() error { return nil }
+	}
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	var aborted error
+	spawn("write watcher", func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			aborted = context.Cause(ctx)
+			c.SetWriteDeadline(aLongTimeAgo)
+		case <-done:
+		}
+	})
+	return func() error {
+		close(done)
+		<-exited
+		return aborted
+	}
+}
+
+// aLongTimeAgo is a deadline in the past, which makes pending I/O fail.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// writeConn returns the connection to write to. The caller holds wmu.
+func (cc *ClientConn) writeConn() (net.Conn, error) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.conn == nil {
+		return nil, errClosed
+	}
+	return cc.conn, nil
+}
+
+// Hijack detaches the connection and its buffered reader from cc, which
+// is unusable afterwards. It waits for a request being written to finish,
+// then stops readLoop, so r holds every byte received and not consumed:
+// what follows a 101 Switching Protocols response, say, or the rest of a
+// response body. Stop reading such a body before the call; it fails with
+// http.ErrHijacked afterwards, as do requests awaiting their responses.
 func (cc *ClientConn) Hijack() (c net.Conn, r *bufio.Reader) {
+	cc.wmu.Lock()
+	defer cc.wmu.Unlock()
+	if cc.re.Load() == nil {
+		cc.re.Store(http.ErrHijacked)
+	}
+	c, r = cc.detach()
+	if c == nil {
+		return nil, nil
+	}
+	cc.closeOnce.Do(func() { close(cc.closech) })
+	cc.unpark(false)
+	// Unblock a read in progress; r keeps what it buffered.
+	c.SetReadDeadline(aLongTimeAgo)
+	<-cc.readDone
+	c.SetReadDeadline(time.Time{})
+	return c, r
+}
+
+// HijackConn is Hijack returning one net.Conn, whose reads drain the
+// bytes buffered for cc before reading from the connection.
+func (cc *ClientConn) HijackConn() net.Conn {
+	c, r := cc.Hijack()
+	if c == nil {
+		return nil
+	}
+	if r != nil && r.Buffered() > 0 {
+		return &bufferedConn{Conn: c, r: r}
+	}
+	return c
+}
+
+func (cc *ClientConn) detach() (c net.Conn, r *bufio.Reader) {
 	cc.mu.Lock()
 	defer cc.mu.Unlock()
 	c = cc.conn
 	r = cc.r
 	cc.conn = nil
 	cc.r = nil
+	cc.hijacked.Store(true)
+	cc.stopIdle()
 	return
 }
 
+// Close closes the connection. Unlike Hijack it does not wait for a
+// pending write, which fails instead. Close is idempotent: once cc was
+// closed or hijacked, it does nothing and returns nil.
 func (cc *ClientConn) Close() error {
-	c, _ := cc.Hijack()
+	c, r := cc.detach()
+	cc.closeOnce.Do(func() { close(cc.closech) })
+	cc.unpark(false) // for readLoop to see cc closed and exit
+	cc.recycleReader(r)
 	if c != nil {
 		return c.Close()
 	}
-	close(cc.closech)
 	return nil
 }
 
+// CloseIdle closes cc unless a request is being written or awaits the end
+// of its response, in which case it returns ErrBodyWaitingRead and leaves
+// cc alone. Like Close it returns nil once cc was closed or hijacked. An
+// exchange ends just after its body was read to EOF, so a CloseIdle racing
+// that read may still find it in flight. A request whose write failed is
+// not in flight.
+func (cc *ClientConn) CloseIdle() error {
+	cc.wmu.Lock()
+	defer cc.wmu.Unlock()
+	if !cc.hijacked.Load() && atomic.LoadInt32(&cc.active) > 0 {
+		return ErrBodyWaitingRead
+	}
+	return cc.Close()
+}
+
+// Reusable reports whether cc can carry another request. It turns false
+// once a response asked to close the connection, either with Connection:
+// close or by being HTTP/1.0 without keep-alive, once a request set Close,
+// and once a body was closed before its end, a request was canceled
+// before its response was read, or the connection failed. A
+// response body still being read counts as reusable, though the next
+// request must wait until it is read to EOF. Schedulers can dial a replacement
+// as soon as this turns false instead of when a request fails.
+func (cc *ClientConn) Reusable() bool {
+	return cc.Ping() == nil
+}
+
 func (cc *ClientConn) Ping() error {
-	cc.mu.Lock()
-	defer cc.mu.Unlock()
-	if cc.re != nil { // no point sending if read-side closed or broken
-		return cc.re
+	if err := cc.re.Load(); err != nil { // no point sending if read-side closed or broken
+		return err
 	}
-	if cc.we != nil {
-		return cc.we
+	if err := cc.we.Load(); err != nil {
+		return err
 	}
-	if cc.conn == nil { // connection closed by user in the meantime
+	if cc.hijacked.Load() { // connection closed by user in the meantime
 		return errClosed
 	}
-	if cc.stoped {
+	if cc.stoped.Load() {
 		return errClosed
 	}
 	return nil
 }
 
 func (cc *ClientConn) setReadError(err error) {
-	cc.mu.Lock()
-	defer cc.mu.Unlock()
-	cc.re = err
+	if cc.abortedWith(cc.re.Load()) {
+		return // the conn failed because abort closed it
+	}
+	cc.re.Store(err)
+}
+
+func (cc *ClientConn) readError() error {
+	if err := cc.re.Load(); err != nil {
+		return err
+	}
+	return ErrServerClosedConn
 }
 
 func (cc *ClientConn) readLoop() {
@@ -156,51 +674,228 @@ func (cc *ClientConn) readLoop() {
 			alive = false
 			break
 		}
+		if cc.park(r) {
+			return
+		}
 		_, err := r.Peek(1)
 		if err != nil {
+			if expired := cc.expiredHeaderWait(err); expired != nil {
+				cc.abort(expired)
+				break
+			}
 			cc.setReadError(ErrServerClosedConn)
 			break
 		}
-		rc := <-cc.reqch
-		resp, err := http.ReadResponse(r, rc)
+		// Load the count before polling reqch: a request is handed over
+		// before it stops being counted, so seeing neither means no request
+		// is on its way.
+		unclaimed := atomic.LoadInt32(&cc.unclaimed)
+		var pr *pendingReq
+		select {
+		case pr = <-cc.reqch:
+		default:
+			var err error
+			if unclaimed > 0 {
+				pr, err = cc.nextRequest()
+			} else {
+				pr, err = cc.earlyResponse(r)
+			}
+			if err != nil {
+				cc.setReadError(err)
+				alive = false
+				continue
+			}
+			if pr == nil {
+				continue
+			}
+		}
+		rc := pr.req
+		if b, _ := r.Peek(5); isHTTP2Frame(b) {
+			cc.setReadError(&ProtocolMismatchError{Proto: "h2"})
+			break
+		}
+		var firstByteAt time.Time
+		if _, err := r.Peek(1); err == nil {
+			firstByteAt = cc.clock.Now()
+			traceFirstResponseByte(rc)
+		}
+		clearDeadline := cc.readDeadline(pr)
+		resp, err := cc.readFinalResponse(r, pr)
+		expired := clearDeadline()
+		pr.sendBody(false) // the final response came first
+		pr.stopHeaderTimeout()
+		if err != nil && expired != nil {
+			cc.abort(expired)
+			break
+		}
 		if err != nil {
 			cc.setReadError(err)
 			break
 		}
+		if err := cc.gotResponse(pr, resp, firstByteAt); err != nil {
+			cc.setReadError(err)
+			break
+		}
+		if hi := cc.getInterner(); hi != nil {
+			hi.intern(resp.Header)
+		}
+		if rc.Method == "CONNECT" && resp.StatusCode/100 == 2 {
+			// Whatever follows belongs to the tunnel, not to HTTP framing.
+			// Stop reading and leave the conn and buffer for Hijack.
+			resp.Body = http.NoBody
+			cc.setReadError(ErrTunnel)
+			cc.readerShared.Store(true)
+			pr.respc <- resp
+			break
+		}
+		if isUpgrade(resp) {
+			// The same goes for an upgrade, but the conn is the body,
+			// an io.ReadWriteCloser for the new protocol.
+			if c, err := cc.writeConn(); err == nil {
+				resp.Body = &upgradeBody{r: r, c: c}
+			}
+			cc.setReadError(ErrTunnel)
+			cc.readerShared.Store(true)
+			pr.respc <- resp
+			break
+		}
 		hasBody := rc.Method != "HEAD" && resp.ContentLength != 0
-		if resp.Close || rc.Close || resp.StatusCode <= 199 {
+		if resp.Close || rc.Close || resp.StatusCode == http.StatusSwitchingProtocols {
 			alive = false
 			cc.setReadError(ErrServerClosedConn)
 		}
 		if !hasBody {
+			pr.respc <- resp
+			cc.endExchange()
 			continue
 		}
+		if resp.ContentLength > 0 {
+			resp.Body = &lengthCheckBody{ReadCloser: resp.Body, declared: resp.ContentLength, r: r}
+		}
 		waitForBodyRead := make(chan bool, 2)
-		resp.Body = newBodyEOFSingle(resp.Body, waitForBodyRead, func(err error) {
-			cc.mu.Lock()
-			defer cc.mu.Unlock()
-			cc.bodyReading = false
-			if err != nil && err != io.EOF {
-				cc.re = ErrBodyLeftData
+		body := newBodyEOFSingle(resp.Body, waitForBodyRead, func(err error) error {
+			// Break the connection before clearing bodyReading, so no
+			// request slips in behind a body closed before its end.
+			switch cause := cc.re.Load(); {
+			case err == nil || err == io.EOF:
+			case errors.Is(err, ErrLengthMismatch):
+				atomic.AddInt64(&cc.stats.lengthMismatches, 1)
+				cc.re.Store(err)
+			case cc.abortedWith(cause):
+				// The read failed because abort closed the conn.
+				err = cause
+			default:
+				cc.re.Store(ErrBodyLeftData)
 			}
+			cc.bodyReading.Store(false)
+			return err
 		})
-		cc.respch <- resp
+		resp.Body = body
+		// Mark the body pending before handing it out: a fast reader
+		// may finish it before this goroutine runs again.
 		cc.setBodyReading(true)
+		pr.respc <- resp
 		select {
 		case bodyEOF := <-waitForBodyRead:
 			alive = alive && bodyEOF
 		case <-rc.Cancel:
+			cc.readerShared.Store(true)
 			alive = false
+			cc.abort(errRequestCanceled)
 		case <-rc.Context().Done():
+			cc.readerShared.Store(true)
 			alive = false
+			cc.abort(context.Cause(rc.Context()))
 		case <-cc.closech:
+			cc.readerShared.Store(true)
 			alive = false
+			if err := cc.re.Load(); err == http.ErrHijacked {
+				// The rest of the body belongs to whoever hijacked.
+				body.fail(err)
+			}
 		}
 		cc.setBodyReading(false)
+		cc.endExchange()
 	}
+	cc.logDone()
+	cc.stoped.Store(true)
+	close(cc.readDone)
+	// A request handed over before readDone closed is never read; stop
+	// its header timer, which would otherwise outlive cc. handOver sees
+	// readDone closed for any that come later.
+	select {
+	case pr := <-cc.reqch:
+		pr.stopHeaderTimeout()
+	default:
+	}
+}
+
+// logDone logs why readLoop stopped, unless the user closed or hijacked
+// the connection or it turned into a tunnel.
+func (cc *ClientConn) logDone() {
+	if cc.logger == nil {
+		return
+	}
+	err := cc.re.Load()
+	select {
+	case <-cc.closech:
+		if !cc.abortedWith(err) || err == http.ErrHijacked {
+			return
+		}
+	default:
+	}
+	if err != nil && err != ErrTunnel {
+		cc.logf("connection unusable: %v", err)
+	}
+}
+
+// earlyResponse applies the early response policy to a response that is
+// ready on r while no request is outstanding. It returns the request to read
+// the response for, or nil if the response was consumed.
+func (cc *ClientConn) earlyResponse(r *bufio.Reader) (*pendingReq, error) {
 	cc.mu.Lock()
-	defer cc.mu.Unlock()
-	cc.stoped = true
+	policy, max := cc.earlyPolicy, cc.earlyMax
+	cc.mu.Unlock()
+	switch policy {
+	case EarlyResponseFail:
+		return nil, ErrEarlyResponse
+	case EarlyResponseBuffer:
+		resp, err := http.ReadResponse(r, nil)
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxDrainBytes+1))
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(body) > maxDrainBytes {
+			return nil, ErrEarlyTooLarge
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+		cc.mu.Lock()
+		defer cc.mu.Unlock()
+		if len(cc.early) >= max {
+			return nil, ErrEarlyLimit
+		}
+		cc.early = append(cc.early, resp)
+		cc.logf("buffered a %s response ahead of its request", resp.Status)
+		if resp.Close {
+			return nil, ErrServerClosedConn
+		}
+		return nil, nil
+	}
+	return cc.nextRequest()
+}
+
+// nextRequest waits for the next request to be handed to readLoop.
+func (cc *ClientConn) nextRequest() (*pendingReq, error) {
+	select {
+	case pr := <-cc.reqch:
+		return pr, nil
+	case <-cc.closech:
+		return nil, errClosed
+	}
 }
 
 func (cc *ClientConn) getReader() *bufio.Reader {
@@ -209,7 +904,5 @@ func (cc *ClientConn) getReader() *bufio.Reader {
 	return cc.r
 }
 func (cc *ClientConn) setBodyReading(flag bool) {
-	cc.mu.Lock()
-	defer cc.mu.Unlock()
-	cc.bodyReading = flag
+	cc.bodyReading.Store(flag)
 }
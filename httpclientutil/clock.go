@@ -0,0 +1,89 @@
+package httpclientutil
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the time source of the helpers that wait or let things expire.
+// A nil Clock is the system clock; tests substitute one that runs in
+// virtual time.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is the part of *time.Timer a Clock hands out.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTimer(d time.Duration) Timer { return systemTimer{time.NewTimer(d)} }
+
+type systemTimer struct{ t *time.Timer }
+
+func (t systemTimer) C() <-chan time.Time { return t.t.C }
+func (t systemTimer) Stop() bool          { return t.t.Stop() }
+
+func clockOrSystem(c Clock) Clock {
+	if c == nil {
+		return systemClock{}
+	}
+	return c
+}
+
+// sleep waits d on c, or returns false as soon as done is closed.
+func sleep(c Clock, d time.Duration, done <-chan struct{}) bool {
+	t := c.NewTimer(d)
+	select {
+	case <-t.C():
+		return true
+	case <-done:
+		t.Stop()
+		return false
+	}
+}
+
+// stopper is a timer started by afterFunc.
+type stopper interface {
+	Stop() bool
+}
+
+// afterFunc calls fn in its own goroutine once d has passed on c, like
+// time.AfterFunc, unless stopped first. Verify accounts for the timer
+// under name.
+func afterFunc(c Clock, d time.Duration, name string, fn func()) stopper {
+	if _, ok := c.(systemClock); ok {
+		live.add(name, 1)
+		return &trackedTimer{name: name, stopper: time.AfterFunc(d, func() {
+			defer live.add(name, -1)
+			fn()
+		})}
+	}
+	ft := &funcTimer{t: c.NewTimer(d), stop: make(chan struct{})}
+	spawn(name, func() {
+		select {
+		case <-ft.t.C():
+			fn()
+		case <-ft.stop:
+		}
+	})
+	return ft
+}
+
+type funcTimer struct {
+	t    Timer
+	once sync.Once
+	stop chan struct{}
+}
+
+func (ft *funcTimer) Stop() bool {
+	stopped := ft.t.Stop()
+	ft.once.Do(func() { close(ft.stop) })
+	return stopped
+}
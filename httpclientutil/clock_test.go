@@ -0,0 +1,496 @@
+package httpclientutil
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock runs in virtual time: timers fire only when the clock is
+// advanced, so tests cover minutes of waiting in microseconds.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	timers  []*fakeTimer
+	changed chan struct{} // closed and replaced whenever a timer is added
+}
+
+type fakeTimer struct {
+	c    *fakeClock
+	when time.Time
+	ch   chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), changed: make(chan struct{})}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{c: c, when: c.now.Add(d), ch: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.ch <- c.now
+		return t
+	}
+	c.timers = append(c.timers, t)
+	close(c.changed)
+	c.changed = make(chan struct{})
+	return t
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.ch }
+
+func (t *fakeTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	for i, p := range t.c.timers {
+		if p == t {
+			t.c.timers = append(t.c.timers[:i], t.c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Advance moves the clock forward by d, firing every timer due on the way.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	sort.Slice(c.timers, func(i, j int) bool { return c.timers[i].when.Before(c.timers[j].when) })
+	for len(c.timers) > 0 && !c.timers[0].when.After(c.now) {
+		c.timers[0].ch <- c.timers[0].when
+		c.timers = c.timers[1:]
+	}
+}
+
+// Run calls fn and, until it returns, fast-forwards the clock to each
+// timer fn waits on. The clock only moves once some goroutine is blocked
+// on a timer, so fn sees the same virtual times on every run.
+func (c *fakeClock) Run(t *testing.T, fn func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	deadline := time.After(10 * time.Second)
+	for {
+		c.mu.Lock()
+		changed := c.changed
+		var next time.Duration
+		pending := len(c.timers) > 0
+		for i, tm := range c.timers {
+			if d := tm.when.Sub(c.now); i == 0 || d < next {
+				next = d
+			}
+		}
+		c.mu.Unlock()
+		if pending {
+			c.Advance(next)
+			continue
+		}
+		select {
+		case <-done:
+			return
+		case <-changed:
+		case <-deadline:
+			t.Fatal("simulation did not finish")
+		}
+	}
+}
+
+// clockDoer answers 200 to everything and records the virtual time of
+// each request.
+type clockDoer struct {
+	clock *fakeClock
+	mu    sync.Mutex
+	times map[string][]time.Time // path -> request times
+	serve func(req *http.Request) (int, string)
+	// header is sent with every response.
+	header http.Header
+}
+
+func newClockDoer(c *fakeClock) *clockDoer {
+	return &clockDoer{clock: c, times: make(map[string][]time.Time)}
+}
+
+func (d *clockDoer) Do(req *http.Request) (*http.Response, error) {
+	d.mu.Lock()
+	d.times[req.URL.Path] = append(d.times[req.URL.Path], d.clock.Now())
+	d.mu.Unlock()
+	status, body := 200, ""
+	if d.serve != nil {
+		status, body = d.serve(req)
+	}
+	header := http.Header{}
+	for k, vv := range d.header {
+		header[k] = vv
+	}
+	return &http.Response{StatusCode: status, Header: header, Body: io.NopCloser(strings.NewReader(body)), Request: req}, nil
+}
+
+func (d *clockDoer) offsets(path string, start time.Time) []time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var ds []time.Duration
+	for _, t := range d.times[path] {
+		ds = append(ds, t.Sub(start))
+	}
+	return ds
+}
+
+func checkOffsets(t *testing.T, got []time.Duration, want ...time.Duration) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("requests at %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("requests at %v, want %v", got, want)
+		}
+	}
+}
+
+func get(t *testing.T, d Doer, url string) (*http.Response, error) {
+	t.Helper()
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := d.Do(req)
+	if err == nil {
+		drainBody(resp)
+	}
+	return resp, err
+}
+
+func TestSimPoliteSpacing(t *testing.T) {
+	clock := newFakeClock()
+	start := clock.Now()
+	d := newClockDoer(clock)
+	p := &PoliteDoer{Doer: d, MinInterval: 10 * time.Second, Clock: clock}
+	clock.Run(t, func() {
+		for i := 0; i < 3; i++ {
+			get(t, p, "http://a.example/")
+		}
+		get(t, p, "http://b.example/other")
+		clock.Advance(time.Minute)
+		get(t, p, "http://a.example/")
+	})
+	checkOffsets(t, d.offsets("/", start), 0, 10*time.Second, 20*time.Second, 80*time.Second)
+	checkOffsets(t, d.offsets("/other", start), 20*time.Second)
+}
+
+func TestSimPoliteCanceledWait(t *testing.T) {
+	clock := newFakeClock()
+	p := &PoliteDoer{Doer: newClockDoer(clock), MinInterval: time.Hour, Clock: clock}
+	get(t, p, "http://a.example/")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "http://a.example/", nil)
+	if _, err := p.Do(req); err != context.Canceled {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+}
+
+func TestSimRobotsCrawlDelay(t *testing.T) {
+	clock := newFakeClock()
+	start := clock.Now()
+	d := newClockDoer(clock)
+	d.serve = func(req *http.Request) (int, string) {
+		if req.URL.Path == "/robots.txt" {
+			return 200, "User-agent: *\nCrawl-delay: 30\n"
+		}
+		return 200, ""
+	}
+	rp := &RobotsPolicy{Doer: d, Clock: clock}
+	p := &PoliteDoer{Doer: d, MinInterval: time.Second, Policy: rp, Clock: clock}
+	clock.Run(t, func() {
+		for i := 0; i < 3; i++ {
+			get(t, p, "http://a.example/")
+		}
+	})
+	checkOffsets(t, d.offsets("/", start), 0, 30*time.Second, time.Minute)
+}
+
+func TestSimRobotsRetryAndTTL(t *testing.T) {
+	clock := newFakeClock()
+	start := clock.Now()
+	d := newClockDoer(clock)
+	status := 503
+	d.serve = func(req *http.Request) (int, string) {
+		if req.URL.Path == "/robots.txt" {
+			return status, "User-agent: *\nDisallow: /private\n"
+		}
+		return 200, ""
+	}
+	rp := &RobotsPolicy{Doer: d, TTL: time.Hour, Clock: clock}
+	p := &PoliteDoer{Doer: d, Policy: rp, Clock: clock}
+	if _, err := get(t, p, "http://a.example/"); err != ErrDisallowed {
+		t.Fatalf("err = %v while robots.txt fails, want ErrDisallowed", err)
+	}
+	status = 200
+	clock.Advance(robotsRetryInterval - time.Second)
+	if _, err := get(t, p, "http://a.example/"); err != ErrDisallowed {
+		t.Fatalf("err = %v inside the retry interval", err)
+	}
+	clock.Advance(time.Second)
+	if _, err := get(t, p, "http://a.example/"); err != nil {
+		t.Fatalf("err = %v after the retry interval", err)
+	}
+	if _, err := get(t, p, "http://a.example/private"); err != ErrDisallowed {
+		t.Fatalf("err = %v for a disallowed path", err)
+	}
+	clock.Advance(time.Hour)
+	get(t, p, "http://a.example/")
+	checkOffsets(t, d.offsets("/robots.txt", start), 0, robotsRetryInterval, robotsRetryInterval+time.Hour)
+}
+
+func TestSimPoliteRetryAfter(t *testing.T) {
+	clock := newFakeClock()
+	start := clock.Now()
+	d := newClockDoer(clock)
+	d.header = http.Header{"Retry-After": {"120"}}
+	d.serve = func(req *http.Request) (int, string) {
+		if req.URL.Path == "/busy" {
+			return 503, ""
+		}
+		return 200, ""
+	}
+	p := &PoliteDoer{Doer: d, MinInterval: 10 * time.Second, Clock: clock}
+	clock.Run(t, func() {
+		get(t, p, "http://a.example/busy")
+		get(t, p, "http://a.example/")
+		get(t, p, "http://a.example/")
+		get(t, p, "http://b.example/other")
+	})
+	// The 200s carry Retry-After too, which only a 429 or 503 honors.
+	checkOffsets(t, d.offsets("/", start), 2*time.Minute, 2*time.Minute+10*time.Second)
+	checkOffsets(t, d.offsets("/other", start), 2*time.Minute+10*time.Second)
+}
+
+func TestSimRobotsRetryAfter(t *testing.T) {
+	clock := newFakeClock()
+	start := clock.Now()
+	d := newClockDoer(clock)
+	status := 429
+	d.header = http.Header{"Retry-After": {"300"}}
+	d.serve = func(req *http.Request) (int, string) {
+		if req.URL.Path == "/robots.txt" {
+			return status, ""
+		}
+		return 200, ""
+	}
+	rp := &RobotsPolicy{Doer: d, Clock: clock}
+	p := &PoliteDoer{Doer: d, Policy: rp, Clock: clock}
+	if _, err := get(t, p, "http://a.example/"); err != ErrDisallowed {
+		t.Fatalf("err = %v while robots.txt answers 429, want ErrDisallowed", err)
+	}
+	status = 200
+	clock.Advance(robotsRetryInterval)
+	if _, err := get(t, p, "http://a.example/"); err != ErrDisallowed {
+		t.Fatalf("err = %v inside the Retry-After wait", err)
+	}
+	clock.Advance(5*time.Minute - robotsRetryInterval)
+	if _, err := get(t, p, "http://a.example/"); err != nil {
+		t.Fatalf("err = %v after the Retry-After wait", err)
+	}
+	checkOffsets(t, d.offsets("/robots.txt", start), 0, 5*time.Minute)
+}
+
+func TestSimHARReplaySpeed(t *testing.T) {
+	for _, speed := range []float64{1, 4} {
+		clock := newFakeClock()
+		start := clock.Now()
+		d := newClockDoer(clock)
+		h := new(HAR)
+		for _, off := range []time.Duration{0, 2 * time.Second, 80 * time.Second} {
+			h.Log.Entries = append(h.Log.Entries, HAREntry{
+				StartedDateTime: start.Add(-time.Hour).Add(off),
+				Request:         HARRequest{Method: "GET", URL: "http://a.example/"},
+			})
+		}
+		r := &HARReplayer{Doer: d, Speed: speed, Clock: clock}
+		var err error
+		clock.Run(t, func() { err = r.Replay(context.Background(), h) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		scale := func(d time.Duration) time.Duration { return time.Duration(float64(d) / speed) }
+		checkOffsets(t, d.offsets("/", start), 0, scale(2*time.Second), scale(80*time.Second))
+	}
+}
+
+func TestSimCapabilityTTL(t *testing.T) {
+	clock := newFakeClock()
+	start := clock.Now()
+	d := newClockDoer(clock)
+	p := &CapabilityProber{Doer: d, TTL: time.Minute, Clock: clock}
+	for _, step := range []time.Duration{0, 30 * time.Second, 30 * time.Second, 10 * time.Second} {
+		clock.Advance(step)
+		if _, err := p.ProbeCapabilities(context.Background(), "http://a.example/x"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkOffsets(t, d.offsets("/x", start), 0, time.Minute)
+}
+
+func TestSimFailoverCooldown(t *testing.T) {
+	clock := newFakeClock()
+	d := newClockDoer(clock)
+	var mu sync.Mutex
+	var tried []string
+	euDown := true
+	d.serve = func(req *http.Request) (int, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		tried = append(tried, req.URL.Host)
+		if req.URL.Host == "eu.example" && euDown {
+			return http.StatusServiceUnavailable, ""
+		}
+		return 200, ""
+	}
+	f := &Failover{
+		Doer:     d,
+		Origins:  map[string][]string{"svc.example": {"http://eu.example", "http://us.example"}},
+		Cooldown: 10 * time.Second,
+		Clock:    clock,
+	}
+	// eu fails and is skipped for the cooldown even once it is back, then
+	// takes the traffic again.
+	for _, step := range []struct {
+		advance time.Duration
+		tried   string
+	}{
+		{0, "eu.example us.example"},
+		{5 * time.Second, "us.example"},
+		{4 * time.Second, "us.example"},
+		{time.Second, "eu.example"},
+		{time.Hour, "eu.example"},
+	} {
+		clock.Advance(step.advance)
+		mu.Lock()
+		tried = nil
+		if step.advance > 0 {
+			euDown = false
+		}
+		mu.Unlock()
+		if _, err := get(t, f, "http://svc.example/x"); err != nil {
+			t.Fatal(err)
+		}
+		mu.Lock()
+		got := strings.Join(tried, " ")
+		mu.Unlock()
+		if got != step.tried {
+			t.Fatalf("after %v more: tried %q, want %q", step.advance, got, step.tried)
+		}
+	}
+}
+
+func TestSimPoolIdleReaping(t *testing.T) {
+	s := newCountingServer(t, 0)
+	clock := newFakeClock()
+	p := &ClientConnPool{IdleTimeout: time.Minute, Clock: clock}
+	defer p.Close()
+	// Each request restarts the idle time of the connection it returns.
+	for _, step := range []struct {
+		advance time.Duration
+		conns   int32
+	}{
+		{0, 1},
+		{59 * time.Second, 1},
+		{59 * time.Second, 1},
+		{time.Minute, 2},
+		{time.Hour, 3},
+	} {
+		clock.Advance(step.advance)
+		poolGet(t, p, s.URL+"/x")
+		if n := atomic.LoadInt32(&s.conns); n != step.conns {
+			t.Fatalf("after %v more: %d connections, want %d", step.advance, n, step.conns)
+		}
+		if n := p.idleCount(); n != 1 {
+			t.Fatalf("after %v more: %d idle connections, want the reaped one replaced", step.advance, n)
+		}
+	}
+}
+
+// waitTimer waits for a timer due at when.
+func (c *fakeClock) waitTimer(t *testing.T, when time.Time) {
+	t.Helper()
+	waitFor(t, "a timer due at "+when.Format("15:04:05"), func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for _, tm := range c.timers {
+			if tm.when.Equal(when) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func TestSimStandbyKeepAlive(t *testing.T) {
+	srv := pathServer(t)
+	clock := newFakeClock()
+	start := clock.Now()
+	var mu sync.Mutex
+	var dials []time.Duration
+	s := &Standby{
+		MaxAge: 30 * time.Second,
+		Clock:  clock,
+		Dial: func(ctx context.Context) (*ClientConn, error) {
+			mu.Lock()
+			dials = append(dials, clock.Now().Sub(start))
+			mu.Unlock()
+			var d net.Dialer
+			c, err := d.DialContext(ctx, "tcp", srv.Listener.Addr().String())
+			if err != nil {
+				return nil, err
+			}
+			return NewClientConn(c), nil
+		},
+	}
+	defer s.Close()
+	if err := s.Warm(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	// The warm connection is replaced every MaxAge, never left to idle
+	// into the server's keep-alive timeout.
+	for _, at := range []time.Duration{30 * time.Second, time.Minute, 90 * time.Second} {
+		clock.waitTimer(t, start.Add(at))
+		clock.Advance(at - clock.Now().Sub(start) - time.Second)
+		if n := s.Ready(); n != 1 {
+			t.Fatalf("%d connections warm at %v, want 1", n, clock.Now().Sub(start))
+		}
+		clock.Advance(time.Second)
+	}
+	clock.waitTimer(t, start.Add(2*time.Minute))
+	mu.Lock()
+	got := dials
+	mu.Unlock()
+	checkOffsets(t, got, 0, 30*time.Second, time.Minute, 90*time.Second)
+
+	cc, err := s.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	if got := doBody(t, cc); got != "/" {
+		t.Errorf("body = %q", got)
+	}
+}
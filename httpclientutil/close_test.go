@@ -0,0 +1,89 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCloseIdempotent(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		for answer(c, br, "ok") {
+		}
+	})
+	doBody(t, cc)
+	for i := 0; i < 3; i++ {
+		if err := cc.Close(); err != nil {
+			t.Errorf("Close #%d = %v", i+1, err)
+		}
+	}
+	if err := cc.CloseIdle(); err != nil {
+		t.Errorf("CloseIdle after Close = %v", err)
+	}
+
+	cc = rawServer(t, func(c net.Conn, br *bufio.Reader) { br.ReadByte() })
+	c, _ := cc.Hijack()
+	defer c.Close()
+	if err := cc.Close(); err != nil {
+		t.Errorf("Close after Hijack = %v", err)
+	}
+	if _, err := c.Write([]byte("x")); err != nil {
+		t.Errorf("Close closed the hijacked conn: %v", err)
+	}
+}
+
+func TestCloseIdle(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		for answer(c, br, "hello") {
+		}
+	})
+	req, _ := http.NewRequest("GET", "http://a.example/", nil)
+	resp, err := cc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.CloseIdle(); err != ErrBodyWaitingRead {
+		t.Fatalf("CloseIdle with a body unread = %v, want ErrBodyWaitingRead", err)
+	}
+	if !cc.Reusable() {
+		t.Fatal("CloseIdle broke a busy connection")
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	waitFor(t, "the exchange to end", func() bool { return atomic.LoadInt32(&cc.active) == 0 })
+	if err := cc.CloseIdle(); err != nil {
+		t.Fatalf("CloseIdle when idle = %v", err)
+	}
+	if cc.Reusable() {
+		t.Error("reusable after CloseIdle")
+	}
+}
+
+type brokenReader struct{}
+
+func (brokenReader) Read([]byte) (int, error) { return 0, errors.New("source broke") }
+
+func TestCloseIdleAfterFailedWrite(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		for answer(c, br, "ok") {
+		}
+	})
+	req, _ := http.NewRequest("POST", "http://a.example/", io.MultiReader(strings.NewReader("part"), brokenReader{}))
+	if _, err := cc.Do(req); err == nil || !strings.Contains(err.Error(), "source broke") {
+		t.Fatalf("Do = %v, want the body's error", err)
+	}
+	if cc.Reusable() {
+		t.Error("reusable after a failed write")
+	}
+	if n := atomic.LoadInt32(&cc.active); n != 0 {
+		t.Errorf("%d exchanges in flight after a failed write", n)
+	}
+	if err := cc.CloseIdle(); err != nil {
+		t.Errorf("CloseIdle after a failed write = %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package httpclientutil
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SetWriteCoalescing makes Do hold requests for up to window and send all
+// requests queued meanwhile in one write, like Nagle's algorithm in user
+// space. It pays off when many goroutines share cc for small requests.
+// A batch that reaches 64KB is sent at once. Zero turns coalescing off.
+//
+// Coalesced requests are pipelined, so Do no longer fails with
+// ErrBodyWaitingRead; instead each Do waits until the responses before its
+// own are handed out, and every body must be closed for the next to follow.
+func (cc *ClientConn) SetWriteCoalescing(window time.Duration) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if window <= 0 {
+		cc.coalescer = nil
+		return
+	}
+	cc.coalescer = &writeCoalescer{cc: cc, window: window, lastRead: closedChan}
+}
+
+var closedChan = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+type writeCoalescer struct {
+	cc     *ClientConn
+	window time.Duration
+
+	flushMu sync.Mutex // held while a batch is taken and written
+
+	mu       sync.Mutex // protects the fields below
+	buf      bytes.Buffer
+	batch    []*coalesced
+	lastRead chan struct{} // closed when the last queued request has its response
+}
+
+type coalesced struct {
+	pr      *pendingReq
+	size    int64 // of the serialized request
+	written chan error
+	prev    chan struct{} // closed when the previous request has its response
+	read    chan struct{}
+}
+
+func (cc *ClientConn) getCoalescer() *writeCoalescer {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.coalescer
+}
+
+func (wc *writeCoalescer) do(req *http.Request) (*http.Response, error) {
+	cc := wc.cc
+	cc.mu.Lock()
+	c := cc.conn
+	cc.mu.Unlock()
+	if c == nil {
+		return nil, errClosed
+	}
+	if err := checkProto(req.Context(), c); err != nil {
+		cc.we.Store(err)
+		return nil, err
+	}
+	e := &coalesced{pr: newPendingReq(req), written: make(chan error, 1), read: make(chan struct{})}
+	wc.mu.Lock()
+	if err := cc.Ping(); err != nil {
+		wc.mu.Unlock()
+		return nil, err
+	}
+	n := wc.buf.Len()
+	if err := cc.serialize(req, &wc.buf); err != nil {
+		wc.buf.Truncate(n)
+		wc.mu.Unlock()
+		return nil, err
+	}
+	e.size = int64(wc.buf.Len() - n)
+	if req.Close {
+		cc.we.Store(ErrPersistEOF)
+	}
+	cc.beginExchange(req, c)
+	atomic.AddInt32(&cc.unclaimed, 1)
+	e.prev, wc.lastRead = wc.lastRead, e.read
+	wc.batch = append(wc.batch, e)
+	first, full := len(wc.batch) == 1, wc.buf.Len() >= batchBufferSize
+	wc.mu.Unlock()
+
+	switch {
+	case full:
+		wc.flush()
+	case first:
+		afterFunc(systemClock{}, wc.window, "coalescing timer", wc.flush)
+	}
+	defer close(e.read)
+	if err := <-e.written; err != nil {
+		cc.endExchange() // readLoop never got the request
+		return nil, err
+	}
+	<-e.prev
+	return cc.read(e.pr)
+}
+
+// flush writes the queued batch, if any, and hands its requests to readLoop
+// in order.
+func (wc *writeCoalescer) flush() {
+	wc.flushMu.Lock()
+	wc.mu.Lock()
+	batch := wc.batch
+	data := append([]byte(nil), wc.buf.Bytes()...)
+	wc.batch = nil
+	wc.buf.Reset()
+	wc.mu.Unlock()
+	if len(batch) == 0 {
+		wc.flushMu.Unlock()
+		return
+	}
+
+	cc := wc.cc
+	cc.wmu.Lock()
+	c, err := cc.writeConn()
+	if err == nil {
+		err = cc.writeTimed(context.Background(), c, func() error {
+			_, err := c.Write(data)
+			return err
+		})
+		if err != nil {
+			cc.we.Store(err)
+		}
+	}
+	if err == nil {
+		now := cc.clock.Now()
+		for _, e := range batch {
+			e.pr.wroteAt = now
+			if err = cc.wrote(e.pr, e.size); err != nil {
+				break
+			}
+		}
+	}
+	prev, mine := cc.takeTurn()
+	cc.wmu.Unlock()
+	wc.flushMu.Unlock()
+	if err == ErrWriteTimeout || errors.Is(err, ErrHookPanic) {
+		cc.abort(err)
+	}
+	defer close(mine)
+	<-prev
+	for _, e := range batch {
+		if err == nil {
+			err = cc.handOver(e.pr)
+		}
+		atomic.AddInt32(&cc.unclaimed, -1)
+		e.written <- err
+	}
+}
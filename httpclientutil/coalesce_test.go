@@ -0,0 +1,153 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeLog records each Write to its conn.
+type writeLog struct {
+	net.Conn
+	mu     sync.Mutex
+	writes []string
+}
+
+func (w *writeLog) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	w.writes = append(w.writes, string(b))
+	w.mu.Unlock()
+	return w.Conn.Write(b)
+}
+
+// requestsPerWrite counts the requests in each write logged.
+func (w *writeLog) requestsPerWrite() []int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := make([]int, len(w.writes))
+	for i, s := range w.writes {
+		n[i] = strings.Count(s, " HTTP/1.1\r\n")
+	}
+	return n
+}
+
+// echoIDServer answers each request with its X-Id header, over a
+// connection whose writes are logged.
+func echoIDServer(t *testing.T) (*ClientConn, *writeLog) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		c, err := ln.Accept()
+		ln.Close()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		br := bufio.NewReader(c)
+		for {
+			req, err := http.ReadRequest(br)
+			if err != nil {
+				return
+			}
+			io.Copy(io.Discard, req.Body)
+			writeResponse(c, req.Header.Get("X-Id"))
+		}
+	}()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := &writeLog{Conn: c}
+	cc := NewClientConn(log)
+	t.Cleanup(func() { cc.Close() })
+	return cc, log
+}
+
+// doIDs sends a request per id at once and checks each gets its answer.
+func doIDs(t *testing.T, cc *ClientConn, body string, ids ...int) {
+	t.Helper()
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			req, _ := http.NewRequest("POST", "http://example.com/", strings.NewReader(body))
+			req.Header.Set("X-Id", fmt.Sprint(id))
+			resp, err := cc.Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			b, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(b) != fmt.Sprint(id) {
+				t.Errorf("request %d got the response of %s", id, b)
+			}
+		}(id)
+	}
+	wg.Wait()
+}
+
+func queued(wc *writeCoalescer) int {
+	wc.mu.Lock()
+	defer wc.mu.Unlock()
+	return len(wc.batch)
+}
+
+func TestCoalesceWindow(t *testing.T) {
+	cc, log := echoIDServer(t)
+	cc.SetWriteCoalescing(time.Millisecond)
+	wc := cc.getCoalescer()
+
+	// Hold the flush until all three are queued, as if they came within
+	// the window.
+	wc.flushMu.Lock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		doIDs(t, cc, "", 1, 2, 3)
+	}()
+	waitFor(t, "three queued requests", func() bool { return queued(wc) == 3 })
+	time.Sleep(10 * time.Millisecond) // past the window
+	wc.flushMu.Unlock()
+	<-done
+	doIDs(t, cc, "", 4)
+	if got := fmt.Sprint(log.requestsPerWrite()); got != "[3 1]" {
+		t.Errorf("requests per write %s, want [3 1]", got)
+	}
+}
+
+func TestCoalesceFullBatch(t *testing.T) {
+	cc, log := echoIDServer(t)
+	cc.SetWriteCoalescing(time.Hour)
+	// The fourth body takes the batch past 64KB, which goes out at once
+	// instead of after the window.
+	body := strings.Repeat("x", 20<<10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		doIDs(t, cc, body, 1, 2, 3, 4)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a full batch waited for the window")
+	}
+	if got := fmt.Sprint(log.requestsPerWrite()); got != "[4]" {
+		t.Errorf("requests per write %s, want [4]", got)
+	}
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	if n := len(log.writes[0]); n < batchBufferSize {
+		t.Errorf("batch of %d bytes, want at least %d", n, batchBufferSize)
+	}
+}
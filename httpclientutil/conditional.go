@@ -0,0 +1,96 @@
+package httpclientutil
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/zhaojkun/client/httpclientutil/httpheader"
+)
+
+// ResponseMeta holds the validators of a previously fetched resource.
+type ResponseMeta struct {
+	URL          string
+	ETag         string
+	LastModified string
+	Fetched      time.Time
+	BodySum      string // SHA-256 of the body in hex, kept by Poller
+}
+
+// FetchStatus is the outcome of FetchIfChanged.
+type FetchStatus int
+
+const (
+	NotModified FetchStatus = iota
+	Changed
+)
+
+func (s FetchStatus) String() string {
+	if s == NotModified {
+		return "not modified"
+	}
+	return "changed"
+}
+
+// FetchResult is returned by FetchIfChanged. Response is nil when the
+// resource was not modified; otherwise the caller must close its body.
+type FetchResult struct {
+	Status   FetchStatus
+	Meta     *ResponseMeta
+	Response *http.Response
+
+	// Duplicate is set by Poller when the server sent the body again
+	// and it matched the last one, so Status is NotModified.
+	Duplicate bool
+}
+
+// FetchIfChanged issues a conditional GET for target using the validators
+// in prev, which may be nil for a first fetch. A 304 body is drained so the
+// connection can serve the next request right away. Statuses other than
+// 2xx and 304 are reported as errors.
+func FetchIfChanged(ctx context.Context, d Doer, target string, prev *ResponseMeta) (*FetchResult, error) {
+	req, err := http.NewRequest("GET", target, nil)
+	if err != nil {
+		return nil, err
+	}
+	if prev != nil {
+		if prev.ETag != "" {
+			req.Header.Set("If-None-Match", prev.ETag)
+		}
+		// Servers ignore an If-Modified-Since that is not a valid date,
+		// and need not accept the obsolete formats some still send in
+		// Last-Modified.
+		if t, err := httpheader.ParseTime(prev.LastModified); err == nil {
+			req.Header.Set("If-Modified-Since", t.Format(http.TimeFormat))
+		}
+	}
+	resp, err := d.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	meta := &ResponseMeta{
+		URL:          target,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Fetched:      time.Now(),
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		drainBody(resp)
+		// A 304 need not repeat every validator; keep the old ones.
+		if prev != nil {
+			if meta.ETag == "" {
+				meta.ETag = prev.ETag
+			}
+			if meta.LastModified == "" {
+				meta.LastModified = prev.LastModified
+			}
+		}
+		return &FetchResult{Status: NotModified, Meta: meta}, nil
+	case resp.StatusCode/100 == 2:
+		return &FetchResult{Status: Changed, Meta: meta, Response: resp}, nil
+	}
+	drainBody(resp)
+	return nil, fmt.Errorf("http: GET %s: %s", target, resp.Status)
+}
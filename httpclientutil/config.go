@@ -0,0 +1,314 @@
+package httpclientutil
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Duration is a time.Duration written in JSON as a string such as "1.5s".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.New(`duration must be a string such as "1.5s"`)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Config describes a ClientConnPool and the helpers stacked on it, so that
+// services can keep client tuning in a file. Zero fields take the
+// defaults of the types they configure. The JSON names are the field names
+// with a lower-case first letter.
+type Config struct {
+	DialTimeout Duration          `json:"dialTimeout"`
+	KeepAlive   Duration          `json:"keepAlive"`
+	Hosts       map[string]string `json:"hosts"` // see Dialer.Hosts
+
+	WriteTimeout          Duration `json:"writeTimeout"`
+	ResponseHeaderTimeout Duration `json:"responseHeaderTimeout"`
+	ExpectContinueTimeout Duration `json:"expectContinueTimeout"`
+
+	MaxIdleConnsPerHost int      `json:"maxIdleConnsPerHost"`
+	MaxConnsPerHost     int      `json:"maxConnsPerHost"`
+	IdleTimeout         Duration `json:"idleTimeout"`
+
+	TLS *TLSFileConfig `json:"tls"`
+
+	// Proxy is the URL of a proxy for every request. Otherwise, with
+	// ProxyFromEnvironment, HTTP_PROXY, HTTPS_PROXY and NO_PROXY decide.
+	Proxy                string `json:"proxy"`
+	ProxyFromEnvironment bool   `json:"proxyFromEnvironment"`
+
+	// Limits, if set, puts a Shedder in front of the pool.
+	Limits *LimitConfig `json:"limits"`
+
+	// Failover, if set, puts a Failover in front of the pool.
+	Failover *FailoverConfig `json:"failover"`
+}
+
+// TLSFileConfig is the TLS part of a Config, with PEM files by path.
+type TLSFileConfig struct {
+	CAFile             string `json:"caFile"`   // roots to trust instead of the system's
+	CertFile           string `json:"certFile"` // client certificate, with KeyFile
+	KeyFile            string `json:"keyFile"`
+	ServerName         string `json:"serverName"`
+	MinVersion         string `json:"minVersion"` // "1.0" to "1.3"
+	InsecureSkipVerify bool   `json:"insecureSkipVerify"`
+}
+
+// LimitConfig configures a Shedder.
+type LimitConfig struct {
+	MaxInFlight int `json:"maxInFlight"`
+	MaxQueue    int `json:"maxQueue"`
+}
+
+// FailoverConfig configures a Failover.
+type FailoverConfig struct {
+	Origins  map[string][]string `json:"origins"`
+	Cooldown Duration            `json:"cooldown"`
+}
+
+// ConfigError lists what is wrong with a Config, each problem prefixed by
+// the JSON path of the field.
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "http: invalid client config: " + strings.Join(e.Problems, "; ")
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// LoadConfig decodes and validates a JSON Config. Unknown fields are
+// errors, so a misspelt setting is not silently ignored, and syntax
+// errors give the line and column.
+func LoadConfig(r io.Reader) (*Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	c := new(Config)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		var se *json.SyntaxError
+		if errors.As(err, &se) {
+			line, col := position(data, se.Offset)
+			return nil, fmt.Errorf("http: client config: line %d, column %d: %v", line, col, err)
+		}
+		return nil, fmt.Errorf("http: client config: %v", err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LoadConfigFile is LoadConfig on the named file.
+func LoadConfigFile(name string) (*Config, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c, err := LoadConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return c, nil
+}
+
+// position returns the line and column of the byte a *json.SyntaxError's
+// Offset is just past.
+func position(data []byte, offset int64) (line, col int) {
+	i := int(offset) - 1
+	if i > len(data) {
+		i = len(data)
+	}
+	if i < 0 {
+		i = 0
+	}
+	before := data[:i]
+	line = bytes.Count(before, []byte("\n")) + 1
+	col = i - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
+
+// Validate reports every problem with c as a *ConfigError, or nil.
+func (c *Config) Validate() error {
+	var problems []string
+	bad := func(field, format string, args ...interface{}) {
+		problems = append(problems, field+": "+fmt.Sprintf(format, args...))
+	}
+	for field, d := range map[string]Duration{
+		"dialTimeout":           c.DialTimeout,
+		"keepAlive":             c.KeepAlive,
+		"writeTimeout":          c.WriteTimeout,
+		"responseHeaderTimeout": c.ResponseHeaderTimeout,
+		"expectContinueTimeout": c.ExpectContinueTimeout,
+		"idleTimeout":           c.IdleTimeout,
+	} {
+		if d < 0 {
+			bad(field, "must not be negative, got %v", time.Duration(d))
+		}
+	}
+	if c.MaxConnsPerHost < 0 {
+		bad("maxConnsPerHost", "must not be negative, zero means no limit")
+	}
+	for host, addr := range c.Hosts {
+		if strings.Contains(addr, ":") {
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				bad("hosts."+host, "%v", err)
+			}
+		}
+	}
+	if c.Proxy != "" {
+		if u, err := url.Parse(c.Proxy); err != nil {
+			bad("proxy", "%v", err)
+		} else if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			bad("proxy", "want an http or https URL with a host")
+		}
+	}
+	if t := c.TLS; t != nil {
+		if (t.CertFile == "") != (t.KeyFile == "") {
+			bad("tls", "certFile and keyFile go together")
+		}
+		if _, ok := tlsVersions[t.MinVersion]; t.MinVersion != "" && !ok {
+			bad("tls.minVersion", "%q is not one of 1.0, 1.1, 1.2, 1.3", t.MinVersion)
+		}
+	}
+	if l := c.Limits; l != nil {
+		if l.MaxInFlight <= 0 {
+			bad("limits.maxInFlight", "must be positive")
+		}
+		if l.MaxQueue < 0 {
+			bad("limits.maxQueue", "must not be negative")
+		}
+	}
+	if f := c.Failover; f != nil {
+		if f.Cooldown < 0 {
+			bad("failover.cooldown", "must not be negative")
+		}
+		for host, origins := range f.Origins {
+			if len(origins) == 0 {
+				bad("failover.origins."+host, "needs at least one origin")
+			}
+			for i, o := range origins {
+				u, err := url.Parse(o)
+				if err == nil && (u.Scheme != "http" && u.Scheme != "https" || u.Host == "") {
+					err = errors.New("want an http or https URL with a host")
+				}
+				if err != nil {
+					bad(fmt.Sprintf("failover.origins.%s[%d]", host, i), "%v", err)
+				}
+			}
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return &ConfigError{Problems: problems}
+}
+
+// Build returns the pool c describes and the Doer to send requests
+// through: the pool itself, or the helpers configured in front of it.
+// Close the pool when done.
+func (c *Config) Build() (Doer, *ClientConnPool, error) {
+	if err := c.Validate(); err != nil {
+		return nil, nil, err
+	}
+	p := &ClientConnPool{
+		Dialer:              &Dialer{Hosts: c.Hosts},
+		MaxIdleConnsPerHost: c.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     c.MaxConnsPerHost,
+		IdleTimeout:         time.Duration(c.IdleTimeout),
+	}
+	p.Dialer.Timeout = time.Duration(c.DialTimeout)
+	p.Dialer.KeepAlive = time.Duration(c.KeepAlive)
+	if c.WriteTimeout > 0 {
+		p.ConnOptions = append(p.ConnOptions, WithWriteTimeout(time.Duration(c.WriteTimeout)))
+	}
+	if c.ResponseHeaderTimeout > 0 {
+		p.ConnOptions = append(p.ConnOptions, WithResponseHeaderTimeout(time.Duration(c.ResponseHeaderTimeout)))
+	}
+	if c.ExpectContinueTimeout > 0 {
+		p.ConnOptions = append(p.ConnOptions, WithExpectContinueTimeout(time.Duration(c.ExpectContinueTimeout)))
+	}
+	switch {
+	case c.Proxy != "":
+		u, _ := url.Parse(c.Proxy)
+		p.Proxy = http.ProxyURL(u)
+	case c.ProxyFromEnvironment:
+		p.Proxy = http.ProxyFromEnvironment
+	}
+	if c.TLS != nil {
+		config, err := c.TLS.load()
+		if err != nil {
+			return nil, nil, err
+		}
+		p.TLSConfig = config
+	}
+	var d Doer = p
+	if f := c.Failover; f != nil {
+		d = &Failover{Doer: d, Origins: f.Origins, Cooldown: time.Duration(f.Cooldown)}
+	}
+	if l := c.Limits; l != nil {
+		d = &Shedder{Doer: d, MaxInFlight: l.MaxInFlight, MaxQueue: l.MaxQueue}
+	}
+	return d, p, nil
+}
+
+func (t *TLSFileConfig) load() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         t.ServerName,
+		MinVersion:         tlsVersions[t.MinVersion],
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("http: client config: tls.caFile: %v", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("http: client config: tls.caFile: no PEM certificates in %s", t.CAFile)
+		}
+	}
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("http: client config: tls.certFile: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
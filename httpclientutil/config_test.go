@@ -0,0 +1,97 @@
+package httpclientutil
+
+import (
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "secure")
+	}))
+	defer s.Close()
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+	if err := os.WriteFile(ca, pemData, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadConfig(strings.NewReader(`{
+		"dialTimeout": "2s",
+		"responseHeaderTimeout": "5s",
+		"maxConnsPerHost": 4,
+		"tls": {"caFile": ` + quoteJSON(ca) + `, "minVersion": "1.2"},
+		"limits": {"maxInFlight": 8, "maxQueue": 16}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.DialTimeout != Duration(2*time.Second) || c.Limits.MaxQueue != 16 {
+		t.Errorf("decoded %+v", c)
+	}
+	d, p, err := c.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if sh, ok := d.(*Shedder); !ok || sh.MaxInFlight != 8 || sh.Doer != p {
+		t.Errorf("Doer = %#v, want a Shedder over the pool", d)
+	}
+	if p.MaxConnsPerHost != 4 || len(p.ConnOptions) != 1 || p.Dialer.Timeout != 2*time.Second {
+		t.Errorf("pool = %+v", p)
+	}
+	req, _ := http.NewRequest("GET", s.URL, nil)
+	resp, err := d.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "secure" {
+		t.Errorf("body = %q", b)
+	}
+}
+
+func quoteJSON(s string) string {
+	return `"` + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), `"`, `\"`) + `"`
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for _, tt := range []struct {
+		config string
+		want   []string
+	}{
+		{`{"dialTimout": "1s"}`, []string{`unknown field "dialTimout"`}},
+		{"{\n  \"dialTimeout\": \"1s\",\n  \"keepAlive\" \"1s\"\n}", []string{"line 3, column 15"}},
+		{`{"idleTimeout": 5}`, []string{`duration must be a string`}},
+		{`{
+			"writeTimeout": "-1s",
+			"tls": {"certFile": "c.pem", "minVersion": "1.4"},
+			"limits": {"maxInFlight": 0},
+			"failover": {"origins": {"a.example": ["eu.a.example"]}}
+		}`, []string{
+			"writeTimeout: must not be negative",
+			"tls: certFile and keyFile go together",
+			`tls.minVersion: "1.4"`,
+			"limits.maxInFlight: must be positive",
+			"failover.origins.a.example[0]: want an http or https URL",
+		}},
+	} {
+		_, err := LoadConfig(strings.NewReader(tt.config))
+		if err == nil {
+			t.Errorf("%s: no error", tt.config)
+			continue
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("error %q lacks %q", err, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package httpclientutil
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+)
+
+var ErrTagConnQuota = errors.New("http: connection quota for tag exhausted")
+
+type connTagKey struct{}
+
+// WithConnTag returns a context under which Dialer tags the connections it
+// dials, e.g. with a tenant or job id, so they can be torn down together
+// with CloseTagged.
+func WithConnTag(ctx context.Context, tag string) context.Context {
+	return context.WithValue(ctx, connTagKey{}, tag)
+}
+
+// ConnTag returns the tag c was dialed with, if any.
+func ConnTag(c net.Conn) (string, bool) {
+	if tc, ok := c.(*taggedConn); ok {
+		return tc.tag, true
+	}
+	return "", false
+}
+
+// taggedConn removes itself from its Dialer when closed.
+type taggedConn struct {
+	net.Conn
+	tag  string
+	d    *Dialer
+	once sync.Once
+
+	mu          sync.Mutex
+	beforeClose func() // see notifyClose
+}
+
+// NetConn returns the conn being tracked, for Probe.
+func (c *taggedConn) NetConn() net.Conn { return c.Conn }
+
+func (c *taggedConn) Close() error {
+	c.once.Do(func() { c.d.untrack(c) })
+	c.mu.Lock()
+	fn := c.beforeClose
+	c.mu.Unlock()
+	if fn != nil {
+		fn()
+	}
+	return c.Conn.Close()
+}
+
+// notifyClose has Close call fn first, since CloseTagged closes c behind
+// the back of the ClientConn on it.
+func (c *taggedConn) notifyClose(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.beforeClose = fn
+}
+
+// reserveTag counts a dial against the connection quota of tag.
+func (d *Dialer) reserveTag(tag string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.MaxConnsPerTag > 0 && len(d.tagged[tag])+d.dialing[tag] >= d.MaxConnsPerTag {
+		return ErrTagConnQuota
+	}
+	if d.dialing == nil {
+		d.dialing = make(map[string]int)
+	}
+	d.dialing[tag]++
+	return nil
+}
+
+func (d *Dialer) releaseTag(tag string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.dialing[tag]--; d.dialing[tag] <= 0 {
+		delete(d.dialing, tag)
+	}
+}
+
+func (d *Dialer) track(c net.Conn, tag string) net.Conn {
+	tc := &taggedConn{Conn: c, tag: tag, d: d}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.tagged == nil {
+		d.tagged = make(map[string]map[*taggedConn]struct{})
+	}
+	if d.tagged[tag] == nil {
+		d.tagged[tag] = make(map[*taggedConn]struct{})
+	}
+	d.tagged[tag][tc] = struct{}{}
+	return tc
+}
+
+func (d *Dialer) untrack(c *taggedConn) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.tagged[c.tag], c)
+	if len(d.tagged[c.tag]) == 0 {
+		delete(d.tagged, c.tag)
+	}
+}
+
+// CloseTagged closes every open connection dialed with tag and returns how
+// many were closed. ClientConns using them fail their next request.
+func (d *Dialer) CloseTagged(tag string) int {
+	d.mu.Lock()
+	conns := make([]*taggedConn, 0, len(d.tagged[tag]))
+	for c := range d.tagged[tag] {
+		conns = append(conns, c)
+	}
+	d.mu.Unlock()
+	for _, c := range conns {
+		c.Close()
+	}
+	return len(conns)
+}
+
+// TaggedConns returns the number of open connections dialed with tag.
+func (d *Dialer) TaggedConns(tag string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.tagged[tag])
+}
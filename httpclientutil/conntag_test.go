@@ -0,0 +1,126 @@
+package httpclientutil
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestConnTagQuota(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+	d := &Dialer{MaxConnsPerTag: 2}
+	a, b := WithConnTag(context.Background(), "a"), WithConnTag(context.Background(), "b")
+	dial := func(ctx context.Context) (net.Conn, error) {
+		return d.DialContext(ctx, "tcp", ln.Addr().String())
+	}
+	var open []net.Conn
+	for i := 0; i < 2; i++ {
+		c, err := dial(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tag, ok := ConnTag(c); !ok || tag != "a" {
+			t.Errorf("ConnTag = %q, %v", tag, ok)
+		}
+		open = append(open, c)
+	}
+	if _, err := dial(a); err != ErrTagConnQuota {
+		t.Fatalf("third dial of a: err = %v, want ErrTagConnQuota", err)
+	}
+	for _, ctx := range []context.Context{b, context.Background()} {
+		c, err := dial(ctx)
+		if err != nil {
+			t.Fatalf("another tag or none: %v", err)
+		}
+		defer c.Close()
+	}
+
+	// Closing a connection frees its slot, once however often it is closed.
+	open[0].Close()
+	open[0].Close()
+	if n := d.TaggedConns("a"); n != 1 {
+		t.Fatalf("%d connections of a after one closed, want 1", n)
+	}
+	c, err := dial(a)
+	if err != nil {
+		t.Fatalf("dial after a close: %v", err)
+	}
+	open[0] = c
+	if n := d.CloseTagged("a"); n != 2 {
+		t.Errorf("CloseTagged closed %d, want 2", n)
+	}
+	if n := d.TaggedConns("a"); n != 0 {
+		t.Errorf("%d connections of a after CloseTagged", n)
+	}
+	if n := d.TaggedConns("b"); n != 1 {
+		t.Errorf("CloseTagged of a left %d of b, want 1", n)
+	}
+}
+
+func TestConnTagQuotaCountsDials(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+	d := &Dialer{MaxConnsPerTag: 1}
+	ctx := WithConnTag(context.Background(), "a")
+	dialing, release := make(chan struct{}), make(chan struct{})
+	d.Control = func(network, address string, c syscall.RawConn) error {
+		close(dialing)
+		<-release
+		return nil
+	}
+	done := make(chan error, 1)
+	go func() {
+		c, err := d.DialContext(ctx, "tcp", ln.Addr().String())
+		if err == nil {
+			c.Close()
+		}
+		done <- err
+	}()
+	<-dialing
+	if _, err := d.DialContext(ctx, "tcp", ln.Addr().String()); err != ErrTagConnQuota {
+		t.Errorf("dial while another is in flight: err = %v, want ErrTagConnQuota", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed dial gives its slot back.
+	d.Control = nil
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closed.Close()
+	if _, err := d.DialContext(ctx, "tcp", closed.Addr().String()); err == nil || err == ErrTagConnQuota {
+		t.Fatalf("dial of a closed port: err = %v", err)
+	}
+	c, err := d.DialContext(ctx, "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial after a failed one: %v", err)
+	}
+	c.Close()
+}
@@ -0,0 +1,163 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// errBodyNotSent fails the write of a body held back for 100 Continue when
+// the final response came instead.
+var errBodyNotSent = errors.New("http: final response received before 100 Continue, body not sent")
+
+// defaultExpectContinueTimeout is how long a body waits for 100 Continue
+// when WithExpectContinueTimeout is not given.
+const defaultExpectContinueTimeout = time.Second
+
+// WithExpectContinueTimeout sets how long a request with "Expect:
+// 100-continue" holds back its body for the server's 100 Continue before
+// sending it anyway, one second by default. If the final response comes
+// first, Do returns it and the body is never sent; the connection is not
+// reused then, as the server may still expect the body. The wait counts
+// toward the write timeout.
+func WithExpectContinueTimeout(d time.Duration) Option {
+	return func(cc *ClientConn) { cc.timeouts.expect = d }
+}
+
+func (cc *ClientConn) expectContinueTimeout() time.Duration {
+	if cc.timeouts.expect <= 0 {
+		return defaultExpectContinueTimeout
+	}
+	return cc.timeouts.expect
+}
+
+func expectsContinue(req *http.Request) bool {
+	return req.Body != nil && req.Body != http.NoBody && strings.EqualFold(req.Header.Get("Expect"), "100-continue")
+}
+
+// sendBody tells the body of pr whether to follow, if it waits.
+func (pr *pendingReq) sendBody(ok bool) {
+	select {
+	case pr.cont <- ok:
+	default:
+	}
+}
+
+// continueBody holds back a request body until 100 Continue. The request
+// writer reads the body only after flushing the headers, so the first Read
+// hands the request to readLoop, which can then read the interim response,
+// and waits for its verdict. Reads come from the goroutine writing the
+// request, or first from one the writer starts to probe a body of unknown
+// length, while write holds wmu.
+type continueBody struct {
+	io.ReadCloser
+	cc       *ClientConn
+	pr       *pendingReq
+	stream   *streamBody // for DoStream, which does not wait
+	started  bool
+	handed   atomicBool // pr was handed to readLoop
+	declined atomicBool // the final response came first
+}
+
+func (cc *ClientConn) newContinueBody(pr *pendingReq) *continueBody {
+	pr.cont = make(chan bool, 1)
+	sb, _ := pr.req.Body.(*streamBody)
+	return &continueBody{ReadCloser: pr.req.Body, cc: cc, pr: pr, stream: sb}
+}
+
+// request returns the request to write, with b as its body.
+func (b *continueBody) request() *http.Request {
+	r := *b.pr.req
+	r.Body = b
+	return &r
+}
+
+func (b *continueBody) Read(p []byte) (int, error) {
+	if !b.started {
+		b.started = true
+		if err := b.await(); err != nil {
+			return 0, err
+		}
+	}
+	return b.ReadCloser.Read(p)
+}
+
+func (b *continueBody) await() error {
+	cc := b.cc
+	prev, mine := cc.takeTurn()
+	<-prev
+	err := cc.handOver(b.pr)
+	close(mine)
+	if err != nil {
+		return err
+	}
+	b.handed.Store(true)
+	if b.stream != nil {
+		b.stream.handed <- b.pr
+		return nil
+	}
+	traceWait100Continue(b.pr.req)
+	t := cc.clock.NewTimer(cc.expectContinueTimeout())
+	defer t.Stop()
+	select {
+	case ok := <-b.pr.cont:
+		if !ok {
+			// Whatever follows would be taken for the body.
+			cc.we.Store(ErrPersistEOF)
+			b.declined.Store(true)
+			return errBodyNotSent
+		}
+	case <-t.C():
+	case <-b.pr.req.Context().Done():
+		return context.Cause(b.pr.req.Context())
+	case <-cc.readDone:
+		return cc.readError()
+	case <-cc.closech:
+		return ErrClosed
+	}
+	return nil
+}
+
+// max1xxResponses bounds the interim responses read before the final one.
+const max1xxResponses = 5
+
+var errTooMany1xx = &http.ProtocolError{ErrorString: "too many 1xx informational responses"}
+
+// WithOn1xxResponse calls fn with each informational response, such as
+// 103 Early Hints, that arrives ahead of the final response to req. The
+// interim response has no body. fn runs on the goroutine reading the
+// connection, so it must return quickly.
+func WithOn1xxResponse(fn func(req *http.Request, resp *http.Response)) Option {
+	return func(cc *ClientConn) { cc.on1xx = fn }
+}
+
+// readFinalResponse reads the response to pr, passing over interim 1xx
+// responses. 101 Switching Protocols is final: the connection speaks
+// another protocol after it.
+func (cc *ClientConn) readFinalResponse(r *bufio.Reader, pr *pendingReq) (*http.Response, error) {
+	for n := 0; ; n++ {
+		resp, err := http.ReadResponse(r, pr.req)
+		if err != nil || resp.StatusCode/100 != 1 || resp.StatusCode == http.StatusSwitchingProtocols {
+			return resp, err
+		}
+		if n == max1xxResponses {
+			return nil, errTooMany1xx
+		}
+		if resp.StatusCode == http.StatusContinue && pr.cont != nil {
+			pr.pauseHeaderTimeout()
+			pr.sendBody(true)
+		}
+		if err := trace1xx(pr.req, resp); err != nil {
+			return nil, err
+		}
+		if cc.on1xx != nil {
+			if err := runHook("On1xxResponse", func() error { cc.on1xx(pr.req, resp); return nil }); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
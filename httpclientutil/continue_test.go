@@ -0,0 +1,129 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// bodyEarly reports whether anything arrives on c within a short wait.
+func bodyEarly(c net.Conn, br *bufio.Reader) bool {
+	c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	defer c.SetReadDeadline(time.Time{})
+	_, err := br.Peek(1)
+	return err == nil
+}
+
+func continueRequest(body string) *http.Request {
+	req, _ := http.NewRequest("PUT", "http://a.example/upload", strings.NewReader(body))
+	req.Header.Set("Expect", "100-continue")
+	return req
+}
+
+func TestExpectContinue(t *testing.T) {
+	early := make(chan bool, 1)
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		early <- bodyEarly(c, br)
+		io.WriteString(c, "HTTP/1.1 100 Continue\r\n\r\n")
+		b, _ := io.ReadAll(req.Body)
+		writeResponse(c, "got "+string(b))
+		answer(c, br, "next")
+	}, WithExpectContinueTimeout(5*time.Second))
+	resp, err := cc.Do(continueRequest("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "got payload" {
+		t.Errorf("body = %q", b)
+	}
+	if <-early {
+		t.Error("body sent before 100 Continue")
+	}
+	if got := doBody(t, cc); got != "next" {
+		t.Errorf("next request got %q", got)
+	}
+}
+
+func TestExpectContinueRejected(t *testing.T) {
+	early := make(chan bool, 1)
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		if _, err := http.ReadRequest(br); err != nil {
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 413 Request Entity Too Large\r\nContent-Length: 8\r\n\r\ntoo big!")
+		early <- bodyEarly(c, br)
+	})
+	resp, err := cc.Do(continueRequest("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != 413 || string(b) != "too big!" {
+		t.Errorf("response = %d %q", resp.StatusCode, b)
+	}
+	if <-early {
+		t.Error("body sent after a final response")
+	}
+	if cc.Reusable() {
+		t.Error("connection reusable though the server may wait for the body")
+	}
+}
+
+func TestExpectContinueTimeout(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		answer(c, br, "no interim response")
+	}, WithExpectContinueTimeout(20*time.Millisecond), WithResponseHeaderTimeout(time.Second))
+	resp, err := cc.Do(continueRequest("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "no interim response" {
+		t.Errorf("body = %q", b)
+	}
+}
+
+func TestInterimResponses(t *testing.T) {
+	var hints []string
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		if _, err := http.ReadRequest(br); err != nil {
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 103 Early Hints\r\nLink: </style.css>; rel=preload\r\n\r\n")
+		io.WriteString(c, "HTTP/1.1 102 Processing\r\n\r\n")
+		writeResponse(c, "final")
+		if _, err := http.ReadRequest(br); err != nil {
+			return
+		}
+		for i := 0; i <= max1xxResponses; i++ {
+			io.WriteString(c, "HTTP/1.1 102 Processing\r\n\r\n")
+		}
+	}, WithOn1xxResponse(func(req *http.Request, resp *http.Response) {
+		hints = append(hints, resp.Status+" "+resp.Header.Get("Link"))
+	}))
+	if got := doBody(t, cc); got != "final" {
+		t.Fatalf("body = %q", got)
+	}
+	if len(hints) != 2 || hints[0] != "103 Early Hints </style.css>; rel=preload" || hints[1] != "102 Processing " {
+		t.Errorf("interim responses = %q", hints)
+	}
+	if !cc.Reusable() {
+		t.Fatal("interim responses broke the connection")
+	}
+	req, _ := http.NewRequest("GET", "http://a.example/", nil)
+	if _, err := cc.Do(req); err != errTooMany1xx {
+		t.Errorf("err = %v, want errTooMany1xx", err)
+	}
+}
@@ -0,0 +1,117 @@
+package httpclientutil
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// BufferPool supplies the scratch buffers used for every body copy in this
+// package: draining, WriteBodyTo and the wrappers that tee or transform
+// bodies. Implementations must be safe for concurrent use.
+type BufferPool interface {
+	Get() []byte
+	Put([]byte)
+}
+
+// DefaultBufferSize is the buffer size of the default pool.
+const DefaultBufferSize = 32 << 10
+
+// NewBufferPool returns a pool of size-byte buffers that keeps at most
+// count idle buffers, allocating beyond that on demand. A count of zero or
+// less leaves retention to a sync.Pool.
+func NewBufferPool(size, count int) BufferPool {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	if count <= 0 {
+		p := &syncBufferPool{size: size}
+		p.pool.New = func() interface{} {
+			b := make([]byte, size)
+			return &b
+		}
+		return p
+	}
+	return &boundedBufferPool{size: size, free: make(chan []byte, count)}
+}
+
+type syncBufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+func (p *syncBufferPool) Get() []byte { return *p.pool.Get().(*[]byte) }
+
+func (p *syncBufferPool) Put(b []byte) {
+	if cap(b) >= p.size {
+		b = b[:p.size]
+		p.pool.Put(&b)
+	}
+}
+
+type boundedBufferPool struct {
+	size int
+	free chan []byte
+}
+
+func (p *boundedBufferPool) Get() []byte {
+	select {
+	case b := <-p.free:
+		return b
+	default:
+		return make([]byte, p.size)
+	}
+}
+
+func (p *boundedBufferPool) Put(b []byte) {
+	if cap(b) < p.size {
+		return
+	}
+	select {
+	case p.free <- b[:p.size]:
+	default:
+	}
+}
+
+type bufferPoolHolder struct{ BufferPool }
+
+var bufferPool atomic.Value
+
+func init() {
+	bufferPool.Store(bufferPoolHolder{NewBufferPool(DefaultBufferSize, 0)})
+}
+
+// SetBufferPool replaces the pool used for body copies; nil restores the
+// default. It is meant to be called during program initialization.
+func SetBufferPool(p BufferPool) {
+	if p == nil {
+		p = NewBufferPool(DefaultBufferSize, 0)
+	}
+	bufferPool.Store(bufferPoolHolder{p})
+}
+
+func getBufferPool() BufferPool {
+	return bufferPool.Load().(bufferPoolHolder).BufferPool
+}
+
+// copyBuffer is io.CopyBuffer with a buffer from the configured pool.
+func copyBuffer(dst io.Writer, src io.Reader) (int64, error) {
+	p := getBufferPool()
+	buf := p.Get()
+	defer p.Put(buf)
+	return io.CopyBuffer(dst, src, buf)
+}
+
+// WriteBodyTo streams resp.Body into w and closes it, returning the number
+// of bytes written. It uses w's ReadFrom or the body's WriteTo when
+// available and a buffer from the pool otherwise. On success the body has
+// been read to EOF, so the connection is ready for the next request.
+func WriteBodyTo(resp *http.Response, w io.Writer) (int64, error) {
+	n, err := copyBuffer(w, resp.Body)
+	if err != nil {
+		resp.Body.Close()
+		return n, err
+	}
+	return n, resp.Body.Close()
+}
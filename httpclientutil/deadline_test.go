@@ -0,0 +1,169 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// deadlineConn records the deadlines set on it.
+type deadlineConn struct {
+	net.Conn
+	mu            sync.Mutex
+	writes, reads []time.Time
+}
+
+func (c *deadlineConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writes = append(c.writes, t)
+	c.mu.Unlock()
+	return c.Conn.SetWriteDeadline(t)
+}
+
+func (c *deadlineConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.reads = append(c.reads, t)
+	c.mu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+func TestContextDeadlineOnSocket(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		br := bufio.NewReader(c)
+		for answer(c, br, "ok") {
+		}
+	}()
+	nc, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc := &deadlineConn{Conn: nc}
+	cc := NewClientConn(dc)
+	defer cc.Close()
+
+	deadline := time.Now().Add(time.Minute)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "http://a.example/", nil)
+	resp, err := cc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	dc.mu.Lock()
+	writes, reads := dc.writes, dc.reads
+	dc.mu.Unlock()
+	want := []time.Time{deadline, {}}
+	if !equalTimes(writes, want) {
+		t.Errorf("write deadlines = %v, want %v", writes, want)
+	}
+	// Once before the handover, once by readLoop.
+	if want := []time.Time{deadline, deadline, {}}; !equalTimes(reads, want) {
+		t.Errorf("read deadlines = %v, want %v", reads, want)
+	}
+
+	// A shorter write timeout wins over the context.
+	WithWriteTimeout(time.Second)(cc)
+	dc.mu.Lock()
+	dc.writes = nil
+	dc.mu.Unlock()
+	req, _ = http.NewRequestWithContext(ctx, "GET", "http://a.example/", nil)
+	if resp, err = cc.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	dc.mu.Lock()
+	writes = dc.writes
+	dc.mu.Unlock()
+	if len(writes) != 2 || !writes[0].Before(deadline) || !writes[1].IsZero() {
+		t.Errorf("write deadlines with a write timeout = %v", writes)
+	}
+
+	// Without a deadline the socket is left alone.
+	dc.mu.Lock()
+	dc.writes, dc.reads = nil, nil
+	dc.mu.Unlock()
+	WithWriteTimeout(0)(cc)
+	doBody(t, cc)
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if len(dc.writes)+len(dc.reads) != 0 {
+		t.Errorf("deadlines set without a context deadline: %v, %v", dc.writes, dc.reads)
+	}
+}
+
+func equalTimes(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+var errTestDeadline = errors.New("test deadline")
+
+func TestContextDeadlineStopsHeaderRead(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		http.ReadRequest(br)
+		br.ReadByte() // never answer
+	})
+	ctx, cancel := context.WithTimeoutCause(context.Background(), 50*time.Millisecond, errTestDeadline)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "http://a.example/", nil)
+	// Write and no Read: only readLoop is waiting for the response, and
+	// nothing but the socket deadline wakes it up.
+	if err := cc.Write(req); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the header read to fail", func() bool { return cc.Ping() != nil })
+	if err := cc.Ping(); err != errTestDeadline {
+		t.Errorf("Ping = %v, want the cause of the context", err)
+	}
+	if _, err := cc.Read(req); err != errTestDeadline {
+		t.Errorf("Read = %v, want the cause of the context", err)
+	}
+}
+
+func TestContextDeadlineStopsWrite(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		br.ReadByte() // read nothing more, so the client's writes block
+		time.Sleep(time.Second)
+	})
+	ctx, cancel := context.WithTimeoutCause(context.Background(), 50*time.Millisecond, errTestDeadline)
+	defer cancel()
+	body := strings.NewReader(strings.Repeat("x", 64<<20))
+	req, _ := http.NewRequestWithContext(ctx, "POST", "http://a.example/", body)
+	start := time.Now()
+	if _, err := cc.Do(req); err != errTestDeadline {
+		t.Errorf("Do = %v, want the cause of the context", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Do took %v", d)
+	}
+	if cc.Reusable() {
+		t.Error("conn reusable after a write ran into the deadline")
+	}
+}
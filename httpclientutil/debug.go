@@ -0,0 +1,154 @@
+package httpclientutil
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+)
+
+// maxPoolErrors is how many recent errors a ClientConnPool keeps.
+const maxPoolErrors = 32
+
+// PoolError is a request that failed in a ClientConnPool.
+type PoolError struct {
+	Time   time.Time
+	Method string
+	URL    string
+	Err    string
+}
+
+// PoolState is a snapshot of a ClientConnPool, for debugging.
+type PoolState struct {
+	Hosts  []PoolHostState // in ConnKey order
+	Errors []PoolError     // most recent last
+}
+
+// PoolHostState is the part of a pool keyed by one ConnKey and tag.
+type PoolHostState struct {
+	Key     ConnKey
+	Tag     string
+	Proxy   string // URL of the proxy the connections go through, if any
+	Open    int    // dialed or in use, as counted against MaxConnsPerHost
+	Waiting int    // requests waiting for a connection or a slot to dial in
+	Conns   []PoolConnState
+}
+
+// PoolConnState describes one connection. Connections being dialed are
+// counted in Open but not listed.
+type PoolConnState struct {
+	RemoteAddr string
+	Age        time.Duration
+	Busy       bool          // carrying a request
+	IdleFor    time.Duration // if not busy
+	Requests   int           // sent on it so far
+}
+
+// State returns a snapshot of p: its connections, their ages and the
+// queues for them, and the errors of the latest failed requests.
+func (p *ClientConnPool) State() PoolState {
+	now := clockOrSystem(p.Clock).Now()
+	p.mu.Lock()
+	st := PoolState{Errors: append([]PoolError(nil), p.errs...)}
+	p.mu.Unlock()
+	for _, v := range p.hosts.values() {
+		h := v.(*poolHost)
+		h.mu.Lock()
+		if h.open == 0 && len(h.waiters) == 0 {
+			h.mu.Unlock()
+			continue
+		}
+		key := h.key
+		hs := PoolHostState{Key: key.ConnKey, Tag: key.tag, Proxy: redactedURL(key.proxy), Open: h.open, Waiting: len(h.waiters)}
+		for pc := range h.busy {
+			hs.Conns = append(hs.Conns, pc.state(now, true))
+		}
+		for _, pc := range h.idle {
+			hs.Conns = append(hs.Conns, pc.state(now, false))
+		}
+		h.mu.Unlock()
+		sort.Slice(hs.Conns, func(i, j int) bool { return hs.Conns[i].Age > hs.Conns[j].Age })
+		st.Hosts = append(st.Hosts, hs)
+	}
+	sort.Slice(st.Hosts, func(i, j int) bool {
+		a, b := st.Hosts[i], st.Hosts[j]
+		if a.Key != b.Key {
+			return a.Key.Scheme+"://"+a.Key.Addr < b.Key.Scheme+"://"+b.Key.Addr
+		}
+		if a.Proxy != b.Proxy {
+			return a.Proxy < b.Proxy
+		}
+		return a.Tag < b.Tag
+	})
+	return st
+}
+
+// redactedURL hides the password in a proxy URL.
+func redactedURL(s string) string {
+	if u, err := url.Parse(s); err == nil {
+		return u.Redacted()
+	}
+	return s
+}
+
+// state describes pc. The caller holds the mu of pc's host.
+func (pc *poolConn) state(now time.Time, busy bool) PoolConnState {
+	s := PoolConnState{RemoteAddr: pc.conn.RemoteAddr().String(), Age: now.Sub(pc.dialedAt), Busy: busy, Requests: pc.requests}
+	if !busy {
+		s.IdleFor = now.Sub(pc.idleAt)
+	}
+	return s
+}
+
+func (p *ClientConnPool) noteError(req *http.Request, err error) {
+	e := PoolError{Time: clockOrSystem(p.Clock).Now(), Method: req.Method, URL: req.URL.String(), Err: err.Error()}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.errs) == maxPoolErrors {
+		copy(p.errs, p.errs[1:])
+		p.errs = p.errs[:maxPoolErrors-1]
+	}
+	p.errs = append(p.errs, e)
+}
+
+// DebugHandler renders the live state of a pool and the exchanges a
+// FlightRecorder kept as plain text. Mount it on an admin mux, as with
+// net/http/pprof; either field may be nil.
+type DebugHandler struct {
+	Pool     *ClientConnPool
+	Recorder *FlightRecorder
+}
+
+func (h *DebugHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if h.Pool != nil {
+		st := h.Pool.State()
+		fmt.Fprintf(w, "pool: %d hosts\n", len(st.Hosts))
+		for _, hs := range st.Hosts {
+			fmt.Fprintf(w, "  %s://%s", hs.Key.Scheme, hs.Key.Addr)
+			if hs.Proxy != "" {
+				fmt.Fprintf(w, " via %s", hs.Proxy)
+			}
+			if hs.Tag != "" {
+				fmt.Fprintf(w, " tag %q", hs.Tag)
+			}
+			fmt.Fprintf(w, ": %d open, %d waiting\n", hs.Open, hs.Waiting)
+			for _, c := range hs.Conns {
+				state := "busy"
+				if !c.Busy {
+					state = fmt.Sprintf("idle %v", c.IdleFor.Round(time.Millisecond))
+				}
+				fmt.Fprintf(w, "    %s age %v, %s, %d requests\n", c.RemoteAddr, c.Age.Round(time.Millisecond), state, c.Requests)
+			}
+		}
+		fmt.Fprintf(w, "\nrecent errors: %d\n", len(st.Errors))
+		for _, e := range st.Errors {
+			fmt.Fprintf(w, "  %s %s %s: %s\n", e.Time.Format(time.RFC3339Nano), e.Method, e.URL, e.Err)
+		}
+	}
+	if h.Recorder != nil {
+		fmt.Fprintf(w, "\nrecent exchanges:\n")
+		h.Recorder.Dump(w)
+	}
+}
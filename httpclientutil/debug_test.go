@@ -0,0 +1,54 @@
+package httpclientutil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDebugHandler(t *testing.T) {
+	srv := pathServer(t)
+	p := &ClientConnPool{}
+	defer p.Close()
+	f := &FlightRecorder{Doer: p}
+	if got := poolGet(t, p, srv.URL+"/done"); got != "/done" {
+		t.Fatalf("body = %q", got)
+	}
+	req, _ := http.NewRequest("GET", srv.URL+"/held", nil)
+	held, err := f.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Body.Close()
+	req, _ = http.NewRequest("GET", "http://127.0.0.1:1/refused", nil)
+	if _, err := f.Do(req); err == nil {
+		t.Fatal("request to a closed port succeeded")
+	}
+
+	st := p.State()
+	var hs *PoolHostState
+	for i := range st.Hosts {
+		if st.Hosts[i].Key.Addr == srv.Listener.Addr().String() {
+			hs = &st.Hosts[i]
+		}
+	}
+	if hs == nil || hs.Open != 1 || len(hs.Conns) != 1 {
+		t.Fatalf("state = %+v", st)
+	}
+	if c := hs.Conns[0]; !c.Busy || c.Requests != 2 {
+		t.Errorf("connection = %+v, want busy with its second request", c)
+	}
+	if len(st.Errors) != 1 || st.Errors[0].URL != "http://127.0.0.1:1/refused" {
+		t.Errorf("errors = %+v", st.Errors)
+	}
+
+	rec := httptest.NewRecorder()
+	(&DebugHandler{Pool: p, Recorder: f}).ServeHTTP(rec, httptest.NewRequest("GET", "/debug/client", nil))
+	out := rec.Body.String()
+	for _, want := range []string{"http://" + hs.Key.Addr + ": 1 open, 0 waiting", "busy, 2 requests", "recent errors: 1", "GET " + srv.URL + "/held 200 (in flight)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("page lacks %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// decompressEncodings is the Accept-Encoding WithDecompression sends.
+const decompressEncodings = "gzip, deflate"
+
+// WithDecompression makes Do ask for gzip or deflate coded responses, as
+// net/http's Transport does for gzip, and decode them: the body reads
+// uncompressed, Content-Encoding and Content-Length are removed, and
+// resp.Uncompressed is set. It applies to requests without an
+// Accept-Encoding or Range header of their own; a request that sets
+// Accept-Encoding gets the coded body as sent. A response in any other
+// coding, such as br, which the standard library cannot decode, fails the
+// request with an *UnsupportedEncodingError.
+func WithDecompression(on bool) Option {
+	return func(cc *ClientConn) { cc.decompress = on }
+}
+
+// acceptsAnyEncoding reports whether req leaves the content coding to cc.
+func acceptsAnyEncoding(req *http.Request) bool {
+	return req.Header.Get("Accept-Encoding") == "" && req.Header.Get("Range") == "" && req.Method != "HEAD"
+}
+
+func (cc *ClientConn) doDecompressed(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	r.Header.Set("Accept-Encoding", decompressEncodings)
+	resp, err := cc.do(r)
+	if err != nil {
+		return nil, err
+	}
+	resp.Request = req
+	var newReader func(io.Reader) (io.Reader, error)
+	switch strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))) {
+	case "gzip", "x-gzip":
+		newReader = func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }
+	case "deflate":
+		newReader = newDeflateReader
+	case "", "identity":
+		return resp, nil
+	default:
+		drainBody(resp)
+		return nil, &UnsupportedEncodingError{Encoding: resp.Header.Get("Content-Encoding")}
+	}
+	if resp.Body == http.NoBody {
+		return resp, nil
+	}
+	resp.Body = &decompressBody{body: resp.Body, newReader: newReader}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
+
+// UnsupportedEncodingError is returned by a ClientConn with
+// WithDecompression for a response coded other than as it asked.
+type UnsupportedEncodingError struct {
+	Encoding string // the Content-Encoding of the response
+}
+
+func (e *UnsupportedEncodingError) Error() string {
+	return fmt.Sprintf("http: unsupported Content-Encoding %q", e.Encoding)
+}
+
+// newDeflateReader reads "deflate", which RFC 9110 defines as zlib but
+// some servers send as a raw deflate stream.
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	b, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	// A zlib header is a CM of 8 with a check value over both bytes.
+	if b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// decompressBody decodes body. The decoder is set up on the first Read,
+// so Do does not wait for the first bytes of the body.
+type decompressBody struct {
+	body      io.ReadCloser
+	newReader func(io.Reader) (io.Reader, error)
+	zr        io.Reader
+	err       error
+}
+
+func (b *decompressBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+	if b.zr == nil {
+		b.zr, b.err = b.newReader(b.body)
+		if b.err != nil {
+			return 0, b.err
+		}
+	}
+	n, err := b.zr.Read(p)
+	if err == io.EOF {
+		// The coded stream may end before the body reports EOF, say
+		// ahead of the last chunk; reading on lets the connection be
+		// reused.
+		if _, derr := copyBuffer(struct{ io.Writer }{io.Discard}, io.LimitReader(b.body, maxDrainBytes)); derr != nil {
+			err = derr
+		}
+	}
+	if err != nil {
+		b.err = err
+	}
+	return n, err
+}
+
+func (b *decompressBody) Close() error {
+	return b.body.Close()
+}
@@ -0,0 +1,128 @@
+package httpclientutil
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const decompressText = "the quick brown fox jumps over the lazy dog"
+
+func encodedServer(t *testing.T) *ClientConn {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			io.WriteString(w, decompressText)
+			return
+		}
+		coding := r.URL.Query().Get("coding")
+		if coding == "br" || coding == "identity" {
+			// Sent whatever was asked for, as some servers do.
+			w.Header().Set("Content-Encoding", coding)
+			io.WriteString(w, decompressText)
+			return
+		}
+		var buf bytes.Buffer
+		var zw io.WriteCloser
+		switch coding {
+		case "gzip":
+			zw = gzip.NewWriter(&buf)
+		case "zlib":
+			zw, coding = zlib.NewWriter(&buf), "deflate"
+		case "raw":
+			zw, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+			coding = "deflate"
+		}
+		io.WriteString(zw, decompressText)
+		zw.Close()
+		w.Header().Set("Content-Encoding", coding)
+		if r.URL.Query().Get("chunked") != "" {
+			w.Write(buf.Bytes())
+			w.(http.Flusher).Flush()
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		w.Write(buf.Bytes())
+	}))
+	t.Cleanup(s.Close)
+	cc, err := DialConn(context.Background(), "tcp", s.Listener.Addr().String(), nil, WithDecompression(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return cc
+}
+
+func TestDecompression(t *testing.T) {
+	cc := encodedServer(t)
+	for _, q := range []string{"coding=gzip", "coding=zlib", "coding=raw", "coding=gzip&chunked=1"} {
+		req, _ := http.NewRequest("GET", "http://a.example/?"+q, nil)
+		resp, err := cc.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || string(b) != decompressText {
+			t.Errorf("%s: body %q, %v", q, b, err)
+		}
+		if !resp.Uncompressed || resp.Header.Get("Content-Encoding") != "" || resp.ContentLength != -1 {
+			t.Errorf("%s: Uncompressed %v, Content-Encoding %q, length %d", q, resp.Uncompressed, resp.Header.Get("Content-Encoding"), resp.ContentLength)
+		}
+		if resp.Request != req {
+			t.Errorf("%s: resp.Request is not the caller's", q)
+		}
+		if !cc.Reusable() {
+			t.Fatalf("%s: connection not reusable", q)
+		}
+	}
+}
+
+func TestDecompressionOwnAcceptEncoding(t *testing.T) {
+	cc := encodedServer(t)
+	req, _ := http.NewRequest("GET", "http://a.example/?coding=gzip", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := cc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Uncompressed || resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Errorf("decoded a body the caller asked to get coded")
+	}
+}
+
+func TestDecompressionUnsupported(t *testing.T) {
+	cc := encodedServer(t)
+	req, _ := http.NewRequest("GET", "http://a.example/?coding=br", nil)
+	_, err := cc.Do(req)
+	var ue *UnsupportedEncodingError
+	if !errors.As(err, &ue) || ue.Encoding != "br" {
+		t.Fatalf("err = %v, want an UnsupportedEncodingError for br", err)
+	}
+	if !cc.Reusable() {
+		t.Fatal("connection not reusable after an unsupported coding")
+	}
+	if strings.Contains(decompressEncodings, "br") {
+		t.Errorf("Accept-Encoding %q offers br", decompressEncodings)
+	}
+
+	req, _ = http.NewRequest("GET", "http://a.example/?coding=identity", nil)
+	resp, err := cc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != decompressText || resp.Uncompressed {
+		t.Errorf("identity: body %q, Uncompressed %v", b, resp.Uncompressed)
+	}
+}
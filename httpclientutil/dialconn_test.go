@@ -0,0 +1,46 @@
+package httpclientutil
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDialConnTLS(t *testing.T) {
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	}))
+	s.EnableHTTP2 = true // the server would pick h2 if offered
+	s.StartTLS()
+	defer s.Close()
+	addr := s.Listener.Addr().String()
+
+	cc, err := DialConn(context.Background(), "tcp", addr, &tls.Config{RootCAs: poolOf(s), ServerName: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	if got := doBody(t, cc); got != "HTTP/1.1" {
+		t.Errorf("request went out as %q", got)
+	}
+
+	// Without the test CA the handshake fails and nothing is left open.
+	if _, err := DialConn(context.Background(), "tcp", addr, &tls.Config{}); err == nil {
+		t.Error("handshake with an unknown CA succeeded")
+	}
+}
+
+func TestDialConnPlain(t *testing.T) {
+	s := pathServer(t)
+	cc, err := DialConn(context.Background(), "tcp", s.Listener.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	if got := doBody(t, cc); got != "/" {
+		t.Errorf("body = %q", got)
+	}
+}
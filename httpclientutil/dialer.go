@@ -0,0 +1,382 @@
+package httpclientutil
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IPFamily selects the address family Dialer uses.
+type IPFamily int
+
+const (
+	// FamilyAny leaves the choice to net.Dialer.
+	FamilyAny IPFamily = iota
+	// PreferIPv4 and PreferIPv6 try addresses of one family first and race
+	// the other after FallbackDelay.
+	PreferIPv4
+	PreferIPv6
+	// ForceIPv4 and ForceIPv6 never use the other family.
+	ForceIPv4
+	ForceIPv6
+)
+
+// Dialer dials the connections handed to NewClientConn. The embedded
+// net.Dialer supplies timeouts, keep-alive and the resolver.
+type Dialer struct {
+	net.Dialer
+
+	// Hosts maps host names to the address dialed in their place, like
+	// /etc/hosts, so a staging origin can be reached under its production
+	// name. A value without a port keeps the port of the dialed address.
+	Hosts map[string]string
+
+	// Family restricts or orders the address families dialed. With a
+	// preference, the embedded FallbackDelay sets when the other family is
+	// raced (300ms if zero); a negative delay only tries it after every
+	// preferred address failed, and NoFallback never tries it.
+	Family     IPFamily
+	NoFallback bool
+
+	// LocalAddrs, if set, supplies a rotating source address for each
+	// connection, overriding the embedded LocalAddr. Its family follows the
+	// remote address when that is known before dialing, then Family, and
+	// defaults to IPv4 when the pool holds both.
+	LocalAddrs *LocalAddrPool
+
+	// MaxConnsPerTag caps the open connections per WithConnTag tag; dials
+	// beyond it fail with ErrTagConnQuota. Zero means no limit.
+	MaxConnsPerTag int
+
+	// Meter, if set, counts the bytes of every connection dialed.
+	Meter *ByteMeter
+
+	// DNS, if set, caches the addresses of the hosts dialed, in place of
+	// the embedded Resolver's lookup on every dial.
+	DNS *DNSCache
+
+	// SOCKS, if set, is a socks5:// or socks5h:// proxy URL, with the
+	// username and password to offer if any, that TCP connections are
+	// dialed through; see SOCKSHandshake. With socks5 the target's name
+	// is resolved here, with socks5h by the proxy.
+	SOCKS *url.URL
+
+	mu      sync.Mutex
+	tagged  map[string]map[*taggedConn]struct{}
+	dialing map[string]int // dials in progress per tag
+}
+
+type hostOverrideKey struct{}
+
+// WithHostOverride returns a context under which Dialer connects to target
+// instead of resolving host, taking precedence over Dialer.Hosts.
+func WithHostOverride(ctx context.Context, host, target string) context.Context {
+	m := make(map[string]string)
+	if prev, ok := ctx.Value(hostOverrideKey{}).(map[string]string); ok {
+		for k, v := range prev {
+			m[k] = v
+		}
+	}
+	m[host] = target
+	return context.WithValue(ctx, hostOverrideKey{}, m)
+}
+
+// DialConn dials addr with a zero Dialer and returns a ClientConn on the
+// connection, see Dialer.DialConn.
+func DialConn(ctx context.Context, network, addr string, config *tls.Config, opts ...Option) (*ClientConn, error) {
+	return new(Dialer).DialConn(ctx, network, addr, config, opts...)
+}
+
+// DialConn dials addr and returns a ClientConn ready for requests. With a
+// non-nil config the connection is wrapped in TLS first: config is cloned,
+// ServerName defaults to the host of addr, and ALPN offers only http/1.1,
+// the one protocol ClientConn speaks. A failed handshake closes the
+// connection. opts are passed to NewClientConn.
+//
+// TLS runs in user space, in crypto/tls. Kernel TLS is not an option:
+// crypto/tls hands out neither the traffic keys nor the record sequence
+// numbers the kernel needs to take over the connection.
+func (d *Dialer) DialConn(ctx context.Context, network, addr string, config *tls.Config, opts ...Option) (*ClientConn, error) {
+	c, err := d.dialTLS(ctx, network, addr, config)
+	if err != nil {
+		return nil, err
+	}
+	return NewClientConn(c, opts...), nil
+}
+
+// dialTLS dials addr, completing a TLS handshake if config is not nil.
+func (d *Dialer) dialTLS(ctx context.Context, network, addr string, config *tls.Config) (net.Conn, error) {
+	c, err := d.DialContext(ctx, network, addr)
+	if err != nil || config == nil {
+		return c, err
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return tlsHandshake(ctx, c, config, host)
+}
+
+// tlsHandshake wraps c in TLS for serverName, offering only HTTP/1.1.
+func tlsHandshake(ctx context.Context, c net.Conn, config *tls.Config, serverName string) (net.Conn, error) {
+	if config == nil {
+		config = new(tls.Config)
+	} else {
+		config = config.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = serverName
+	}
+	config.NextProtos = []string{"http/1.1"}
+	tc := tls.Client(c, config)
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.TLSHandshakeStart != nil {
+		trace.TLSHandshakeStart()
+	}
+	err := tc.HandshakeContext(ctx)
+	if trace != nil && trace.TLSHandshakeDone != nil {
+		trace.TLSHandshakeDone(tc.ConnectionState(), err)
+	}
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+func (d *Dialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext dials addr. If ctx carries a tag from WithConnTag the
+// connection is tracked under it until closed.
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	tag, tagged := ctx.Value(connTagKey{}).(string)
+	if tagged {
+		if err := d.reserveTag(tag); err != nil {
+			return nil, err
+		}
+		defer d.releaseTag(tag)
+	}
+	c, err := d.dialProxied(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if d.Meter != nil {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		c = d.Meter.meter(c, host, tag, tagged)
+	}
+	if tagged {
+		c = d.track(c, tag)
+	}
+	return c, nil
+}
+
+func (d *Dialer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	addr = d.mapAddr(ctx, addr)
+	switch d.Family {
+	case ForceIPv4:
+		network = familyNetwork(network, "4")
+	case ForceIPv6:
+		network = familyNetwork(network, "6")
+	case PreferIPv4, PreferIPv6:
+		return d.dialPreferred(ctx, network, addr)
+	}
+	if d.DNS != nil {
+		return d.dialCached(ctx, network, addr)
+	}
+	return d.netDialer(ctx, network, addr).DialContext(ctx, network, addr)
+}
+
+// netDialer returns the net.Dialer for one dial to addr, bound to the
+// per-request local address or the next one from LocalAddrs.
+func (d *Dialer) netDialer(ctx context.Context, network, addr string) *net.Dialer {
+	ip, _ := ctx.Value(localAddrKey{}).(net.IP)
+	if ip == nil && d.LocalAddrs != nil {
+		ip = d.LocalAddrs.Next(d.wantIPv6(addr))
+	}
+	if ip == nil {
+		return &d.Dialer
+	}
+	nd := d.Dialer
+	if strings.HasPrefix(network, "udp") {
+		nd.LocalAddr = &net.UDPAddr{IP: ip}
+	} else {
+		nd.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	return &nd
+}
+
+// wantIPv6 guesses the family of the connection to addr for picking a
+// local address.
+func (d *Dialer) wantIPv6(addr string) bool {
+	host, _, _ := net.SplitHostPort(addr)
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.To4() == nil
+	}
+	switch d.Family {
+	case ForceIPv6, PreferIPv6:
+		return true
+	case ForceIPv4, PreferIPv4:
+		return false
+	}
+	v4, v6 := d.LocalAddrs.families()
+	return v6 && !v4
+}
+
+// familyNetwork pins a "tcp" or "udp" network to one family.
+func familyNetwork(network, family string) string {
+	if network == "tcp" || network == "udp" {
+		return network + family
+	}
+	return network
+}
+
+func (d *Dialer) dialPreferred(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || !strings.HasPrefix(network, "tcp") && !strings.HasPrefix(network, "udp") {
+		return d.netDialer(ctx, network, addr).DialContext(ctx, network, addr)
+	}
+	var ips []net.IPAddr
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IPAddr{{IP: ip}}
+	} else {
+		if ips, err = d.lookupIPAddr(ctx, host); err != nil {
+			return nil, err
+		}
+	}
+	var primary, fallback []string
+	for _, ip := range ips {
+		a := net.JoinHostPort(ip.String(), port)
+		if (ip.IP.To4() != nil) == (d.Family == PreferIPv4) {
+			primary = append(primary, a)
+		} else {
+			fallback = append(fallback, a)
+		}
+	}
+	if d.NoFallback {
+		fallback = nil
+	}
+	switch {
+	case len(primary) == 0 && len(fallback) == 0:
+		return nil, &net.AddrError{Err: "no suitable address found", Addr: host}
+	case len(fallback) == 0:
+		return d.dialSerial(ctx, network, primary)
+	case len(primary) == 0:
+		return d.dialSerial(ctx, network, fallback)
+	}
+	if d.FallbackDelay < 0 {
+		if c, err := d.dialSerial(ctx, network, primary); err == nil {
+			return c, nil
+		}
+		return d.dialSerial(ctx, network, fallback)
+	}
+	return d.dialRace(ctx, network, primary, fallback)
+}
+
+// dialSerial tries addrs in order and returns the first connection.
+func (d *Dialer) dialSerial(ctx context.Context, network string, addrs []string) (net.Conn, error) {
+	var firstErr error
+	for _, a := range addrs {
+		c, err := d.netDialer(ctx, network, a).DialContext(ctx, network, a)
+		if err == nil {
+			return c, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if firstErr == nil {
+		firstErr = errors.New("http: no addresses to dial")
+	}
+	return nil, firstErr
+}
+
+// dialRace dials primary and, after FallbackDelay or a primary failure,
+// fallback in parallel, keeping whichever connects first.
+func (d *Dialer) dialRace(ctx context.Context, network string, primary, fallback []string) (net.Conn, error) {
+	type result struct {
+		c       net.Conn
+		err     error
+		primary bool
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(errDialRaceLost)
+	results := make(chan result, 2)
+	dial := func(addrs []string, isPrimary bool) {
+		c, err := d.dialSerial(ctx, network, addrs)
+		results <- result{c, err, isPrimary}
+	}
+	delay := d.FallbackDelay
+	if delay == 0 {
+		delay = 300 * time.Millisecond
+	}
+	spawn("dial", func() { dial(primary, true) })
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	started, pending := false, 1
+	var firstErr error
+	for {
+		select {
+		case <-timer.C:
+			if !started {
+				started, pending = true, pending+1
+				spawn("dial", func() { dial(fallback, false) })
+			}
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				if pending > 0 {
+					// Close the loser once it reports.
+					spawn("dial race loser", func() {
+						if r := <-results; r.c != nil {
+							r.c.Close()
+						}
+					})
+				}
+				return res.c, nil
+			}
+			if firstErr == nil || res.primary {
+				firstErr = res.err
+			}
+			if !started {
+				started, pending = true, pending+1
+				spawn("dial", func() { dial(fallback, false) })
+			}
+			if pending == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
+
+// mapAddr applies the per-request and static host mappings to addr.
+func (d *Dialer) mapAddr(ctx context.Context, addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	overrides, _ := ctx.Value(hostOverrideKey{}).(map[string]string)
+	target, ok := overrides[host]
+	if !ok {
+		if target, ok = d.Hosts[host]; !ok {
+			return addr
+		}
+	}
+	if _, _, err := net.SplitHostPort(target); err == nil {
+		return target
+	}
+	return net.JoinHostPort(target, port)
+}
@@ -0,0 +1,134 @@
+package httpclientutil
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// dualStackResolver answers every name with 127.0.0.1 and ::1.
+func dualStackResolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			c, s := net.Pipe()
+			go func() {
+				defer s.Close()
+				for {
+					var n [2]byte
+					if _, err := io.ReadFull(s, n[:]); err != nil {
+						return
+					}
+					q := make([]byte, binary.BigEndian.Uint16(n[:]))
+					if _, err := io.ReadFull(s, q); err != nil {
+						return
+					}
+					a := dnsAnswer(q)
+					if a != nil && binary.BigEndian.Uint16(a[len(a)-4:]) == 28 { // AAAA
+						a[7] = 1
+						a = append(a, 0xc0, 12, 0, 28, 0, 1, 0, 0, 0, 60, 0, 16)
+						a = append(a, net.IPv6loopback...)
+					}
+					binary.BigEndian.PutUint16(n[:], uint16(len(a)))
+					s.Write(append(n[:], a...))
+				}
+			}()
+			return c, nil
+		},
+	}
+}
+
+// listenFamily accepts and closes connections on the loopback address of
+// network, "tcp4" or "tcp6", on port if not zero.
+func listenFamily(t *testing.T, network string, port int) net.Listener {
+	host := "127.0.0.1"
+	if network == "tcp6" {
+		host = "::1"
+	}
+	ln, err := net.Listen(network, net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		t.Skipf("no %s loopback: %v", network, err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	return ln
+}
+
+func TestDialerFamily(t *testing.T) {
+	v4 := listenFamily(t, "tcp4", 0)
+	port := v4.Addr().(*net.TCPAddr).Port
+	listenFamily(t, "tcp6", port)
+	addr := net.JoinHostPort("dual.test", strconv.Itoa(port))
+	for _, tt := range []struct {
+		family IPFamily
+		want   string // "4" or "6"
+	}{
+		{ForceIPv4, "4"},
+		{ForceIPv6, "6"},
+		{PreferIPv4, "4"},
+		{PreferIPv6, "6"},
+	} {
+		d := &Dialer{Family: tt.family}
+		d.Resolver = dualStackResolver()
+		c, err := d.DialContext(context.Background(), "tcp", addr)
+		if err != nil {
+			t.Errorf("family %d: %v", tt.family, err)
+			continue
+		}
+		ip := c.RemoteAddr().(*net.TCPAddr).IP
+		c.Close()
+		if got := map[bool]string{true: "4", false: "6"}[ip.To4() != nil]; got != tt.want {
+			t.Errorf("family %d dialed %v, want IPv%s", tt.family, ip, tt.want)
+		}
+	}
+
+	// A literal of the other family is refused, not dialed.
+	d := &Dialer{Family: ForceIPv6}
+	if c, err := d.DialContext(context.Background(), "tcp", v4.Addr().String()); err == nil {
+		c.Close()
+		t.Error("ForceIPv6 dialed an IPv4 literal")
+	}
+}
+
+func TestDialerFallback(t *testing.T) {
+	// Only IPv4 listens, so the preferred IPv6 dial is refused.
+	v4 := listenFamily(t, "tcp4", 0)
+	if ln, err := net.Listen("tcp6", "[::1]:0"); err != nil {
+		t.Skipf("no tcp6 loopback: %v", err)
+	} else {
+		ln.Close()
+	}
+	addr := net.JoinHostPort("dual.test", strconv.Itoa(v4.Addr().(*net.TCPAddr).Port))
+	// Raced after the default delay, and tried only after IPv6 failed.
+	for _, delay := range []time.Duration{0, -1} {
+		d := &Dialer{Family: PreferIPv6}
+		d.Resolver = dualStackResolver()
+		d.FallbackDelay = delay
+		c, err := d.DialContext(context.Background(), "tcp", addr)
+		if err != nil {
+			t.Fatalf("delay %v: %v", delay, err)
+		}
+		if ip := c.RemoteAddr().(*net.TCPAddr).IP; ip.To4() == nil {
+			t.Errorf("delay %v: dialed %v", delay, ip)
+		}
+		c.Close()
+	}
+	d := &Dialer{Family: PreferIPv6, NoFallback: true}
+	d.Resolver = dualStackResolver()
+	if c, err := d.DialContext(context.Background(), "tcp", addr); err == nil {
+		c.Close()
+		t.Error("NoFallback dialed IPv4")
+	}
+}
@@ -0,0 +1,185 @@
+package httpclientutil
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+)
+
+// DiffTarget is one side of a comparison: the origin to send to and the
+// Doer, typically its own ClientConn, to send through.
+type DiffTarget struct {
+	Doer   Doer
+	Origin string // e.g. "https://old.example.com"; empty keeps the request URL
+}
+
+// DiffOptions holds the normalization rules applied before comparing.
+type DiffOptions struct {
+	// IgnoreHeaders are left out of the header comparison. Nil means
+	// DefaultDiffIgnoredHeaders.
+	IgnoreHeaders []string
+
+	// NormalizeBody, if set, rewrites each body before comparison, e.g.
+	// to strip timestamps or reformat JSON.
+	NormalizeBody func(header http.Header, body []byte) []byte
+
+	// MaxBody bounds the bytes of each body compared; defaults to 1MB.
+	MaxBody int64
+}
+
+// DefaultDiffIgnoredHeaders vary between identical origins.
+var DefaultDiffIgnoredHeaders = []string{"Date", "Age", "Expires", "Set-Cookie", "Via", "X-Request-Id", "Server-Timing"}
+
+// HeaderDiff is a header whose values differ; a missing side is nil.
+type HeaderDiff struct {
+	Name string
+	A, B []string
+}
+
+// ResponseDiff is the structured difference of two responses.
+type ResponseDiff struct {
+	StatusA, StatusB int
+	Headers          []HeaderDiff
+	BodyA, BodyB     []byte // normalized, possibly truncated to MaxBody
+	BodyOffset       int    // first differing byte, -1 if bodies match
+	ErrA, ErrB       error  // transport errors, if a side failed
+}
+
+// Equal reports whether no difference was found.
+func (d *ResponseDiff) Equal() bool {
+	return d.ErrA == nil && d.ErrB == nil && d.StatusA == d.StatusB &&
+		len(d.Headers) == 0 && d.BodyOffset < 0
+}
+
+// DiffOrigins sends req to both targets in parallel and compares the
+// responses. req's body is buffered so both sides send the same bytes.
+func DiffOrigins(req *http.Request, a, b DiffTarget, opts *DiffOptions) (*ResponseDiff, error) {
+	if opts == nil {
+		opts = &DiffOptions{}
+	}
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+	type side struct {
+		resp *http.Response
+		body []byte
+		err  error
+	}
+	var sides [2]side
+	var wg sync.WaitGroup
+	for i, t := range []DiffTarget{a, b} {
+		r, err := cloneForOrigin(req, t.Origin, body)
+		if err != nil {
+			return nil, err
+		}
+		wg.Add(1)
+		i, t := i, t
+		spawn("diff request", func() {
+			defer wg.Done()
+			s := &sides[i]
+			if s.resp, s.err = t.Doer.Do(r); s.err != nil {
+				return
+			}
+			s.body, s.err = readLimited(s.resp, opts.MaxBody)
+			if opts.NormalizeBody != nil && s.err == nil {
+				s.body = opts.NormalizeBody(s.resp.Header, s.body)
+			}
+		})
+	}
+	wg.Wait()
+
+	d := &ResponseDiff{ErrA: sides[0].err, ErrB: sides[1].err, BodyOffset: -1}
+	if d.ErrA != nil || d.ErrB != nil {
+		return d, nil
+	}
+	ra, rb := sides[0].resp, sides[1].resp
+	d.StatusA, d.StatusB = ra.StatusCode, rb.StatusCode
+	d.BodyA, d.BodyB = sides[0].body, sides[1].body
+	d.Headers = diffHeaders(ra.Header, rb.Header, opts.IgnoreHeaders)
+	if !bytes.Equal(d.BodyA, d.BodyB) {
+		n := 0
+		for n < len(d.BodyA) && n < len(d.BodyB) && d.BodyA[n] == d.BodyB[n] {
+			n++
+		}
+		d.BodyOffset = n
+	}
+	return d, nil
+}
+
+func cloneForOrigin(req *http.Request, origin string, body []byte) (*http.Request, error) {
+	r := req.Clone(req.Context())
+	if origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil {
+			return nil, err
+		}
+		r.URL.Scheme, r.URL.Host = u.Scheme, u.Host
+		r.Host = ""
+	}
+	if body != nil {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+	return r, nil
+}
+
+// readLimited reads up to max bytes of resp's body (1MB if max <= 0) and
+// drains the rest.
+func readLimited(resp *http.Response, max int64) ([]byte, error) {
+	if max <= 0 {
+		max = 1 << 20
+	}
+	defer drainBody(resp)
+	return io.ReadAll(io.LimitReader(resp.Body, max))
+}
+
+func diffHeaders(a, b http.Header, ignore []string) []HeaderDiff {
+	if ignore == nil {
+		ignore = DefaultDiffIgnoredHeaders
+	}
+	skip := make(map[string]bool, len(ignore))
+	for _, name := range ignore {
+		skip[http.CanonicalHeaderKey(name)] = true
+	}
+	names := make(map[string]bool)
+	for k := range a {
+		names[k] = true
+	}
+	for k := range b {
+		names[k] = true
+	}
+	var diffs []HeaderDiff
+	for name := range names {
+		if skip[name] {
+			continue
+		}
+		va, vb := a[name], b[name]
+		if !equalStrings(va, vb) {
+			diffs = append(diffs, HeaderDiff{Name: name, A: va, B: vb})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Name < diffs[j].Name })
+	return diffs
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,176 @@
+package httpclientutil
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DNSCache keeps the addresses host names resolve to, so that dials skip
+// the lookup while the answer is fresh. Set it as Dialer.DNS. Concurrent
+// lookups of one name share a query, and Prefetch resolves names ahead of
+// the first dial. It is safe for concurrent use.
+type DNSCache struct {
+	// Resolver does the lookups; nil is net.DefaultResolver.
+	Resolver *net.Resolver
+
+	// TTL is how long an answer is kept, a minute if zero. The Go resolver
+	// does not report record TTLs, so one TTL applies to every name; keep
+	// it below the shortest TTL of the names looked up. Failed lookups are
+	// not kept.
+	TTL time.Duration
+
+	// Clock ages the answers; nil is the system clock.
+	Clock Clock
+
+	mu      sync.Mutex
+	entries map[string]*dnsEntry
+}
+
+type dnsEntry struct {
+	done    chan struct{} // closed once the lookup finished
+	addrs   []net.IPAddr
+	err     error
+	expires time.Time
+}
+
+func (c *DNSCache) ttl() time.Duration {
+	if c.TTL > 0 {
+		return c.TTL
+	}
+	return time.Minute
+}
+
+// LookupIPAddr returns the addresses of host, from the cache while they
+// are fresh and otherwise from the resolver.
+func (c *DNSCache) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	e := c.lookup(host)
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+		return nil, context.Cause(ctx)
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	return append([]net.IPAddr(nil), e.addrs...), nil
+}
+
+// Prefetch starts looking up each host not cached or expired, and returns
+// without waiting for the answers. A dial needing one of them before its
+// lookup finished waits for it rather than sending its own query.
+func (c *DNSCache) Prefetch(hosts ...string) {
+	for _, h := range hosts {
+		if net.ParseIP(h) == nil {
+			c.lookup(h)
+		}
+	}
+}
+
+// lookup returns the entry of host, starting a lookup if there is no
+// fresh one. The lookup is not tied to any caller's context, as others may
+// come to wait for it.
+func (c *DNSCache) lookup(host string) *dnsEntry {
+	host = strings.ToLower(host)
+	now := clockOrSystem(c.Clock).Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[host]; ok {
+		select {
+		case <-e.done:
+			if now.Before(e.expires) {
+				return e
+			}
+		default:
+			return e // in flight
+		}
+	}
+	if c.entries == nil {
+		c.entries = make(map[string]*dnsEntry)
+	}
+	e := &dnsEntry{done: make(chan struct{})}
+	c.entries[host] = e
+	r := c.Resolver
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	spawn("DNS lookup", func() {
+		addrs, err := r.LookupIPAddr(context.Background(), host)
+		c.mu.Lock()
+		e.addrs, e.err = addrs, err
+		e.expires = clockOrSystem(c.Clock).Now().Add(c.ttl())
+		if err != nil && c.entries[host] == e {
+			delete(c.entries, host)
+		}
+		c.mu.Unlock()
+		close(e.done)
+	})
+	return e
+}
+
+// Forget drops the cached addresses of host, e.g. after its server moved.
+func (c *DNSCache) Forget(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, strings.ToLower(host))
+}
+
+// dialCached dials addr, looking its host up in d.DNS, for a network
+// possibly pinned to one family by Family.
+func (d *Dialer) dialCached(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil || !strings.HasPrefix(network, "tcp") && !strings.HasPrefix(network, "udp") {
+		return d.netDialer(ctx, network, addr).DialContext(ctx, network, addr)
+	}
+	ips, err := d.DNS.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var addrs []string
+	for _, ip := range ips {
+		v4 := ip.IP.To4() != nil
+		if strings.HasSuffix(network, "4") && !v4 || strings.HasSuffix(network, "6") && v4 {
+			continue
+		}
+		addrs = append(addrs, net.JoinHostPort(ip.String(), port))
+	}
+	if len(addrs) == 0 {
+		return nil, &net.AddrError{Err: "no suitable address found", Addr: host}
+	}
+	return d.dialSerial(ctx, network, addrs)
+}
+
+// lookupIPAddr resolves host through d.DNS if set, else the resolver of
+// the embedded net.Dialer.
+func (d *Dialer) lookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if d.DNS != nil {
+		return d.DNS.LookupIPAddr(ctx, host)
+	}
+	r := d.Resolver
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	return r.LookupIPAddr(ctx, host)
+}
+
+// PrefetchDNS resolves hosts ahead of the requests to them, through
+// Dialer.DNS, after applying the Dialer's host mapping. Without a
+// DNSCache there is nowhere to keep the answers and PrefetchDNS does
+// nothing.
+func (p *ClientConnPool) PrefetchDNS(hosts ...string) {
+	d := p.Dialer
+	if d == nil || d.DNS == nil {
+		return
+	}
+	names := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		mapped, _, err := net.SplitHostPort(d.mapAddr(context.Background(), net.JoinHostPort(h, "0")))
+		if err != nil {
+			mapped = h
+		}
+		names = append(names, mapped)
+	}
+	d.DNS.Prefetch(names...)
+}
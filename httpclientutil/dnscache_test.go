@@ -0,0 +1,116 @@
+package httpclientutil
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeResolver answers every name with 127.0.0.1, over TCP framing on a
+// net.Pipe, and counts the type A queries it gets.
+func fakeResolver() (r *net.Resolver, queries *int32) {
+	queries = new(int32)
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			c, s := net.Pipe()
+			go func() {
+				defer s.Close()
+				for {
+					var n [2]byte
+					if _, err := io.ReadFull(s, n[:]); err != nil {
+						return
+					}
+					q := make([]byte, binary.BigEndian.Uint16(n[:]))
+					if _, err := io.ReadFull(s, q); err != nil {
+						return
+					}
+					a := dnsAnswer(q)
+					if len(a) > len(q) {
+						atomic.AddInt32(queries, 1)
+					}
+					binary.BigEndian.PutUint16(n[:], uint16(len(a)))
+					s.Write(append(n[:], a...))
+				}
+			}()
+			return c, nil
+		},
+	}, queries
+}
+
+func TestDNSCache(t *testing.T) {
+	r, queries := fakeResolver()
+	clock := newFakeClock()
+	c := &DNSCache{Resolver: r, TTL: time.Minute, Clock: clock}
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			addrs, err := c.LookupIPAddr(ctx, "a.test")
+			if err != nil || len(addrs) != 1 || !addrs[0].IP.Equal(net.IPv4(127, 0, 0, 1)) {
+				t.Errorf("addrs = %v, %v", addrs, err)
+			}
+		}()
+	}
+	wg.Wait()
+	c.LookupIPAddr(ctx, "A.test")
+	if n := atomic.LoadInt32(queries); n != 1 {
+		t.Errorf("%d queries for one name, want 1", n)
+	}
+	clock.Advance(time.Minute)
+	c.LookupIPAddr(ctx, "a.test")
+	if n := atomic.LoadInt32(queries); n != 2 {
+		t.Errorf("%d queries after the TTL, want 2", n)
+	}
+
+	c.Prefetch("b.test", "127.0.0.2")
+	waitFor(t, "the prefetch", func() bool { return atomic.LoadInt32(queries) == 3 })
+	c.LookupIPAddr(ctx, "b.test")
+	c.Forget("a.test")
+	c.LookupIPAddr(ctx, "a.test")
+	if n := atomic.LoadInt32(queries); n != 4 {
+		t.Errorf("%d queries, want 4", n)
+	}
+}
+
+func TestPoolPrefetchDNS(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Host)
+	}))
+	defer s.Close()
+	_, port, _ := net.SplitHostPort(s.Listener.Addr().String())
+	r, queries := fakeResolver()
+	d := &Dialer{
+		Hosts: map[string]string{"alias.test": "svc.test"},
+		DNS:   &DNSCache{Resolver: r},
+	}
+	p := &ClientConnPool{Dialer: d}
+	defer p.Close()
+	p.PrefetchDNS("alias.test")
+	waitFor(t, "the prefetch", func() bool { return atomic.LoadInt32(queries) == 1 })
+	for _, host := range []string{"alias.test", "svc.test"} {
+		req, _ := http.NewRequest("GET", "http://"+host+":"+port+"/", nil)
+		resp, err := p.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if !strings.HasPrefix(string(b), host) {
+			t.Errorf("Host = %q, want %s", b, host)
+		}
+	}
+	if n := atomic.LoadInt32(queries); n != 1 {
+		t.Errorf("%d queries, want 1", n)
+	}
+}
@@ -0,0 +1,195 @@
+package httpclientutil
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DoHResolver resolves names with DNS over HTTPS (RFC 8484), sending the
+// queries on a ClientConn it keeps open to the server. Plug it into the
+// embedded net.Dialer of Dialer, or anywhere else a *net.Resolver goes,
+// through Resolver. It is safe for concurrent use; queries take turns on
+// the one connection.
+type DoHResolver struct {
+	// URL is the DoH endpoint, e.g. "https://dns.example/dns-query".
+	URL string
+
+	// Bootstrap lists addresses to dial for URL instead of resolving its
+	// host, e.g. "192.0.2.53:443", tried in order. Without it the
+	// system resolver looks up the DoH server itself.
+	Bootstrap []string
+
+	// TLSConfig is cloned for each connection; ServerName defaults to
+	// the URL's host.
+	TLSConfig *tls.Config
+
+	mu sync.Mutex // serializes queries and guards cc
+	cc *ClientConn
+}
+
+// Resolver returns a pure Go resolver whose queries all go to r.
+func (r *DoHResolver) Resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return &dohConn{r: r}, nil
+		},
+	}
+}
+
+// LookupHost is net.Resolver.LookupHost over DoH.
+func (r *DoHResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	return r.Resolver().LookupHost(ctx, host)
+}
+
+// Close closes the connection to the DoH server.
+func (r *DoHResolver) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cc == nil {
+		return nil
+	}
+	err := r.cc.Close()
+	r.cc = nil
+	return err
+}
+
+// exchange sends one wire-format DNS query and returns the answer.
+func (r *DoHResolver) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for {
+		fresh := false
+		if r.cc == nil || !r.cc.Reusable() {
+			if r.cc != nil {
+				r.cc.Close()
+			}
+			if r.cc, err = r.dial(ctx, u); err != nil {
+				return nil, err
+			}
+			fresh = true
+		}
+		req, err := http.NewRequestWithContext(ctx, "POST", u.String(), bytes.NewReader(query))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/dns-message")
+		req.Header.Set("Accept", "application/dns-message")
+		resp, err := r.cc.Do(req)
+		if err != nil {
+			r.cc.Close()
+			r.cc = nil
+			if fresh || ctx.Err() != nil {
+				return nil, err
+			}
+			continue // the kept connection may have gone stale
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("http: DoH query to %s: %s", u.Host, resp.Status)
+		}
+		return body, nil
+	}
+}
+
+func (r *DoHResolver) dial(ctx context.Context, u *url.URL) (*ClientConn, error) {
+	addrs := r.Bootstrap
+	if len(addrs) == 0 {
+		addrs = []string{canonicalAddr(u)}
+	}
+	var d net.Dialer
+	var firstErr error
+	for _, addr := range addrs {
+		c, err := d.DialContext(ctx, "tcp", addr)
+		if err == nil && u.Scheme == "https" {
+			c, err = tlsHandshake(ctx, c, r.TLSConfig, u.Hostname())
+		}
+		if err == nil {
+			return NewClientConn(c), nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return nil, firstErr
+}
+
+var errDoHFraming = errors.New("http: malformed DNS query from resolver")
+
+// dohConn is the net.Conn the Go resolver dials. It speaks DNS over TCP
+// framing, a two-byte length before each message, and turns every query
+// written into a DoH exchange.
+type dohConn struct {
+	r        *DoHResolver
+	deadline time.Time
+	in       bytes.Buffer // written, not yet sent
+	out      bytes.Buffer // answers with their length prefix
+}
+
+func (c *dohConn) Write(p []byte) (int, error) {
+	c.in.Write(p)
+	for c.in.Len() >= 2 {
+		b := c.in.Bytes()
+		n := int(b[0])<<8 | int(b[1])
+		if n == 0 {
+			return 0, errDoHFraming
+		}
+		if len(b) < 2+n {
+			break
+		}
+		query := append([]byte(nil), b[2:2+n]...)
+		c.in.Next(2 + n)
+		ctx := context.Background()
+		if !c.deadline.IsZero() {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, c.deadline)
+			defer cancel()
+		}
+		answer, err := c.r.exchange(ctx, query)
+		if err != nil {
+			return 0, err
+		}
+		if len(answer) > 0xffff {
+			return 0, errDoHFraming
+		}
+		c.out.Write([]byte{byte(len(answer) >> 8), byte(len(answer))})
+		c.out.Write(answer)
+	}
+	return len(p), nil
+}
+
+func (c *dohConn) Read(p []byte) (int, error) {
+	if c.out.Len() == 0 {
+		return 0, io.EOF
+	}
+	return c.out.Read(p)
+}
+
+func (c *dohConn) Close() error                       { return nil }
+func (c *dohConn) LocalAddr() net.Addr                { return dohAddr{} }
+func (c *dohConn) RemoteAddr() net.Addr               { return dohAddr{} }
+func (c *dohConn) SetDeadline(t time.Time) error      { c.deadline = t; return nil }
+func (c *dohConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *dohConn) SetWriteDeadline(t time.Time) error { c.deadline = t; return nil }
+
+type dohAddr struct{}
+
+func (dohAddr) Network() string { return "doh" }
+func (dohAddr) String() string  { return "doh" }
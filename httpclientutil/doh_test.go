@@ -0,0 +1,89 @@
+package httpclientutil
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// dnsAnswer answers a wire-format query with 127.0.0.1 for type A and no
+// records for anything else.
+func dnsAnswer(q []byte) []byte {
+	if len(q) < 12 {
+		return nil
+	}
+	i := 12
+	for i < len(q) && q[i] != 0 {
+		i += int(q[i]) + 1
+	}
+	if i+5 > len(q) {
+		return nil
+	}
+	question := q[12 : i+5]
+	qtype := binary.BigEndian.Uint16(q[i+1:])
+	resp := append([]byte(nil), q[:2]...)
+	resp = append(resp, 0x81, 0x80, 0, 1, 0, 0, 0, 0, 0, 0)
+	resp = append(resp, question...)
+	if qtype == 1 {
+		resp[7] = 1
+		resp = append(resp, 0xc0, 12, 0, 1, 0, 1, 0, 0, 0, 60, 0, 4, 127, 0, 0, 1)
+	}
+	return resp
+}
+
+func TestDoHResolver(t *testing.T) {
+	var queries, conns int32
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&queries, 1)
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad query", http.StatusBadRequest)
+			return
+		}
+		q, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(dnsAnswer(q))
+	}))
+	s.Config.ConnState = func(_ net.Conn, st http.ConnState) {
+		if st == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	s.StartTLS()
+	defer s.Close()
+	r := &DoHResolver{
+		URL:       "https://example.com/dns-query",
+		Bootstrap: []string{"127.0.0.1:1", s.Listener.Addr().String()},
+		TLSConfig: &tls.Config{RootCAs: poolOf(s)},
+	}
+	defer r.Close()
+	for i := 0; i < 3; i++ {
+		addrs, err := r.LookupHost(context.Background(), "host.test.")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(addrs) != 1 || addrs[0] != "127.0.0.1" {
+			t.Fatalf("LookupHost = %v", addrs)
+		}
+	}
+	if q := atomic.LoadInt32(&queries); q < 3 {
+		t.Errorf("%d queries reached the server", q)
+	}
+	if c := atomic.LoadInt32(&conns); c != 1 {
+		t.Errorf("%d connections, want the first one kept", c)
+	}
+
+	d := &Dialer{}
+	d.Resolver = r.Resolver()
+	_, port, _ := net.SplitHostPort(s.Listener.Addr().String())
+	c, err := d.DialContext(context.Background(), "tcp", net.JoinHostPort("host.test.", port))
+	if err != nil {
+		t.Fatalf("Dialer with the DoH resolver: %v", err)
+	}
+	c.Close()
+}
@@ -0,0 +1,65 @@
+package httpclientutil
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+)
+
+// ErrDraining is the error of a request sent on a ClientConn that Drain
+// is shutting down.
+var ErrDraining = &http.ProtocolError{ErrorString: "connection draining"}
+
+// Drain shuts cc down gracefully: requests sent from now on fail with
+// ErrDraining, while those already written get their responses. Once the
+// last of those bodies is read to its end or closed, Drain closes the
+// connection. If ctx is done first, Drain closes it anyway, which fails
+// the bodies still being read with ErrDraining, and returns ctx's error.
+// Requests Write sent count until Read collected their responses and the
+// bodies are done; a request whose write failed does not count.
+func (cc *ClientConn) Drain(ctx context.Context) error {
+	cc.draining.Store(true)
+	if cc.we.Load() == nil {
+		cc.we.Store(ErrDraining)
+	}
+	// A writer that passed Ping before the flag was set begins its exchange
+	// under wmu, or under the coalescer's lock, or sees the flag there.
+	cc.wmu.Lock()
+	cc.wmu.Unlock()
+	cc.mu.Lock()
+	wc := cc.coalescer
+	cc.mu.Unlock()
+	if wc != nil {
+		wc.mu.Lock()
+		wc.mu.Unlock()
+	}
+
+	cc.mu.Lock()
+	done := closedChan
+	if atomic.LoadInt32(&cc.active) > 0 {
+		if cc.drained == nil {
+			cc.drained = make(chan struct{})
+		}
+		done = cc.drained
+	}
+	cc.mu.Unlock()
+	var err error
+	select {
+	case <-done:
+	case <-cc.readDone:
+	case <-ctx.Done():
+		err = context.Cause(ctx)
+	}
+	cc.abort(ErrDraining)
+	return err
+}
+
+// exchangesDone releases Drain once the last exchange is finished.
+func (cc *ClientConn) exchangesDone() {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.drained != nil {
+		close(cc.drained)
+		cc.drained = nil
+	}
+}
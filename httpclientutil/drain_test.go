@@ -0,0 +1,125 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDrain(t *testing.T) {
+	rest := make(chan struct{})
+	closed := make(chan struct{})
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		if _, err := http.ReadRequest(br); err != nil {
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nhello")
+		<-rest
+		io.WriteString(c, "world")
+		if _, err := br.ReadByte(); err == io.EOF {
+			close(closed)
+		}
+	})
+	req, _ := http.NewRequest("GET", "http://a.example/", nil)
+	resp, err := cc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drained := make(chan error, 1)
+	go func() { drained <- cc.Drain(context.Background()) }()
+	waitFor(t, "Drain to refuse requests", func() bool { return !cc.Reusable() })
+	req, _ = http.NewRequest("GET", "http://a.example/", nil)
+	if err := cc.Write(req); err != ErrDraining {
+		t.Errorf("Write while draining: err = %v, want ErrDraining", err)
+	}
+	select {
+	case err := <-drained:
+		t.Fatalf("Drain returned %v before the body was read", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(rest)
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "helloworld" || err != nil {
+		t.Errorf("body = %q, %v", b, err)
+	}
+	if err := <-drained; err != nil {
+		t.Errorf("Drain = %v", err)
+	}
+	<-closed
+}
+
+func TestDrainTimeout(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		if _, err := http.ReadRequest(br); err != nil {
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nhello")
+		br.ReadByte()
+	})
+	req, _ := http.NewRequest("GET", "http://a.example/", nil)
+	resp, err := cc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := cc.Drain(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Drain = %v, want DeadlineExceeded", err)
+	}
+	if _, err := io.ReadAll(resp.Body); err != ErrDraining {
+		t.Errorf("body read after the forced close: err = %v, want ErrDraining", err)
+	}
+}
+
+func TestDrainIdle(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		for answer(c, br, "ok") {
+		}
+	})
+	if got := doBody(t, cc); got != "ok" {
+		t.Fatalf("body = %q", got)
+	}
+	if err := cc.Drain(context.Background()); err != nil {
+		t.Errorf("Drain = %v", err)
+	}
+	req, _ := http.NewRequest("GET", "http://a.example/", nil)
+	if _, err := cc.Do(req); !errors.Is(err, ErrDraining) {
+		t.Errorf("Do after Drain: err = %v", err)
+	}
+}
+
+func TestDrainAfterFailedWrite(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		for answer(c, br, "ok") {
+		}
+	}, WithIdleTimeout(20*time.Millisecond))
+	req, _ := http.NewRequest("POST", "http://a.example/", brokenReader{})
+	if _, err := cc.Do(req); err == nil {
+		t.Fatal("Do with a broken body succeeded")
+	}
+	// The failed exchange ended, so the idle timer runs again.
+	select {
+	case <-cc.readDone:
+	case <-time.After(time.Second):
+		t.Fatal("idle timeout did not close the conn after a failed write")
+	}
+
+	cc = rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		for answer(c, br, "ok") {
+		}
+	})
+	req, _ = http.NewRequest("POST", "http://a.example/", brokenReader{})
+	cc.Do(req)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := cc.Drain(ctx); err != nil {
+		t.Errorf("Drain after a failed write = %v, want nil at once", err)
+	}
+}
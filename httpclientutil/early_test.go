@@ -0,0 +1,181 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rawServer serves one connection with fn and returns a ClientConn to it.
+func rawServer(t *testing.T, fn func(c net.Conn, br *bufio.Reader), opts ...Option) *ClientConn {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c, err := ln.Accept()
+		ln.Close()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		fn(c, bufio.NewReader(c))
+	}()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := NewClientConn(c, opts...)
+	t.Cleanup(func() {
+		cc.Close()
+		<-done
+	})
+	return cc
+}
+
+func writeResponse(w io.Writer, body string) {
+	fmt.Fprintf(w, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+// answer reads one request and answers it with body.
+func answer(c net.Conn, br *bufio.Reader, body string) bool {
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		return false
+	}
+	io.Copy(io.Discard, req.Body)
+	writeResponse(c, body)
+	return true
+}
+
+// waitFor polls cond for up to a second.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func doBody(t *testing.T, cc *ClientConn) string {
+	t.Helper()
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	resp, err := cc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestEarlyResponseDeliver(t *testing.T) {
+	sent := make(chan struct{})
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		writeResponse(c, "early")
+		close(sent)
+		if _, err := http.ReadRequest(br); err != nil {
+			return
+		}
+		answer(c, br, "late")
+	})
+	<-sent
+	time.Sleep(10 * time.Millisecond) // let readLoop see it first
+	if got := doBody(t, cc); got != "early" {
+		t.Fatalf("first response = %q, want the early one", got)
+	}
+	if got := doBody(t, cc); got != "late" {
+		t.Fatalf("second response = %q", got)
+	}
+}
+
+func TestEarlyResponseBuffer(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		writeResponse(c, "early")
+		answer(c, br, "answer")
+	})
+	cc.SetEarlyResponsePolicy(EarlyResponseBuffer, 0) // 0 keeps the default limit
+	var early []*http.Response
+	waitFor(t, "buffered response", func() bool {
+		early = append(early, cc.EarlyResponses()...)
+		return len(early) > 0
+	})
+	if b, _ := io.ReadAll(early[0].Body); string(b) != "early" {
+		t.Fatalf("buffered body = %q", b)
+	}
+	if got := doBody(t, cc); got != "answer" {
+		t.Fatalf("response = %q", got)
+	}
+}
+
+func TestEarlyResponseBufferLimit(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		writeResponse(c, "one")
+		writeResponse(c, "two")
+		io.Copy(io.Discard, br)
+	})
+	cc.SetEarlyResponsePolicy(EarlyResponseBuffer, 1)
+	waitFor(t, "ErrEarlyLimit", func() bool { return cc.Ping() == ErrEarlyLimit })
+	if n := len(cc.EarlyResponses()); n != 1 {
+		t.Fatalf("kept %d responses, want 1", n)
+	}
+}
+
+func TestEarlyResponseBufferTooLarge(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		writeResponse(c, strings.Repeat("x", maxDrainBytes+1))
+		io.Copy(io.Discard, br)
+	})
+	cc.SetEarlyResponsePolicy(EarlyResponseBuffer, 0)
+	waitFor(t, "ErrEarlyTooLarge", func() bool { return cc.Ping() == ErrEarlyTooLarge })
+	if n := len(cc.EarlyResponses()); n != 0 {
+		t.Fatalf("kept %d responses, want none", n)
+	}
+}
+
+func TestEarlyResponseFail(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		writeResponse(c, "early")
+		io.Copy(io.Discard, br)
+	})
+	cc.SetEarlyResponsePolicy(EarlyResponseFail, 0)
+	waitFor(t, "ErrEarlyResponse", func() bool { return cc.Ping() == ErrEarlyResponse })
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	if _, err := cc.Do(req); err != ErrEarlyResponse {
+		t.Fatalf("Do error = %v, want ErrEarlyResponse", err)
+	}
+}
+
+// A pipelined response that arrives before its request reaches readLoop is
+// not unsolicited.
+func TestEarlyResponseFailPipelined(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		for i := 0; answer(c, br, fmt.Sprint(i)); i++ {
+		}
+	})
+	cc.SetEarlyResponsePolicy(EarlyResponseFail, 0)
+	for round := 0; round < 20; round++ {
+		var reqs []*http.Request
+		for i := 0; i < 5; i++ {
+			req, _ := http.NewRequest("GET", "http://example.com/", nil)
+			reqs = append(reqs, req)
+		}
+		if _, err := cc.DoBatch(reqs); err != nil {
+			t.Fatal(round, err)
+		}
+	}
+}
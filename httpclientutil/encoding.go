@@ -0,0 +1,137 @@
+package httpclientutil
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/zhaojkun/client/httpclientutil/httpheader"
+)
+
+// UnknownEncoding says what an EncodingPolicy does with a response whose
+// Content-Encoding the request did not accept.
+type UnknownEncoding int
+
+const (
+	// UnknownEncodingPassThrough returns the response unchanged. This is
+	// the default.
+	UnknownEncodingPassThrough UnknownEncoding = iota
+	// UnknownEncodingFail closes the body and returns an *EncodingError.
+	UnknownEncodingFail
+)
+
+// EncodingError reports a response in a content coding the request did
+// not accept.
+type EncodingError struct {
+	Encoding string // the offending coding, lower-cased
+	Accepted string // the request's Accept-Encoding
+}
+
+func (e *EncodingError) Error() string {
+	return fmt.Sprintf("http: response in content coding %q, request accepted %q", e.Encoding, e.Accepted)
+}
+
+// EncodingPolicy chooses the Accept-Encoding of each request and checks
+// that responses only use codings it accepted. Identity is always
+// acceptable unless a request explicitly weighs it zero.
+type EncodingPolicy struct {
+	// Accept is advertised on requests without an Accept-Encoding, e.g.
+	// {{"gzip", 1}, {"br", 0.5}}. Empty sends no header, which per RFC
+	// 9110 lets the server pick any coding.
+	Accept []httpheader.QValue
+
+	// ForRequest, if set, picks the list for a request instead; returning
+	// nil falls back to Accept.
+	ForRequest func(*http.Request) []httpheader.QValue
+
+	Unknown UnknownEncoding
+}
+
+// Apply sets the Accept-Encoding of req unless the caller already did.
+func (p *EncodingPolicy) Apply(req *http.Request) {
+	if req.Header.Get("Accept-Encoding") != "" {
+		return
+	}
+	qs := p.Accept
+	if p.ForRequest != nil {
+		if rq := p.ForRequest(req); rq != nil {
+			qs = rq
+		}
+	}
+	if len(qs) > 0 {
+		req.Header.Set("Accept-Encoding", httpheader.FormatQList(qs))
+	}
+}
+
+// Check applies the Unknown policy to resp, against the Accept-Encoding of
+// resp.Request.
+func (p *EncodingPolicy) Check(resp *http.Response) error {
+	if p.Unknown == UnknownEncodingPassThrough || resp.Request == nil {
+		return nil
+	}
+	if _, ok := resp.Request.Header["Accept-Encoding"]; !ok {
+		return nil
+	}
+	accepted := httpheader.ParseQList(resp.Request.Header, "Accept-Encoding")
+	for _, v := range resp.Header.Values("Content-Encoding") {
+		for _, coding := range strings.Split(v, ",") {
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding == "" || acceptsCoding(accepted, coding) {
+				continue
+			}
+			resp.Body.Close()
+			return &EncodingError{Encoding: coding, Accepted: resp.Request.Header.Get("Accept-Encoding")}
+		}
+	}
+	return nil
+}
+
+// acceptsCoding applies the matching rules of RFC 9110 section 12.5.3.
+func acceptsCoding(accepted []httpheader.QValue, coding string) bool {
+	if coding == "x-gzip" {
+		coding = "gzip"
+	}
+	star := -1.0
+	for _, q := range accepted {
+		name := strings.ToLower(q.Value)
+		if name == "x-gzip" {
+			name = "gzip"
+		}
+		switch name {
+		case coding:
+			return q.Q > 0
+		case "*":
+			star = q.Q
+		}
+	}
+	if star >= 0 {
+		return star > 0
+	}
+	return coding == "identity"
+}
+
+// EncodingDoer sends requests through Doer with Accept-Encoding set by
+// Policy and checks the responses against it. Coded bodies are returned
+// as received.
+type EncodingDoer struct {
+	Doer   Doer
+	Policy *EncodingPolicy
+}
+
+func (e *EncodingDoer) Do(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Accept-Encoding") == "" {
+		req = req.Clone(req.Context())
+		e.Policy.Apply(req)
+	}
+	resp, err := e.Doer.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Request == nil {
+		resp.Request = req
+	}
+	if err := e.Policy.Check(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
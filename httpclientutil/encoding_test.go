@@ -0,0 +1,87 @@
+package httpclientutil
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/zhaojkun/client/httpclientutil/httpheader"
+)
+
+type staticDoer struct {
+	header http.Header
+	got    *http.Request
+}
+
+func (d *staticDoer) Do(req *http.Request) (*http.Response, error) {
+	d.got = req
+	return &http.Response{StatusCode: 200, Header: d.header, Body: io.NopCloser(strings.NewReader("x")), Request: req}, nil
+}
+
+func TestEncodingPolicyAdvertises(t *testing.T) {
+	p := &EncodingPolicy{
+		Accept: []httpheader.QValue{{Value: "gzip", Q: 1}, {Value: "br", Q: 0.5}, {Value: "identity", Q: 0.1234}},
+		ForRequest: func(req *http.Request) []httpheader.QValue {
+			if strings.HasSuffix(req.URL.Path, ".gz") {
+				return []httpheader.QValue{{Value: "identity", Q: 1}}
+			}
+			return nil
+		},
+	}
+	for path, want := range map[string]string{
+		"/a":    "gzip, br;q=0.5, identity;q=0.123",
+		"/a.gz": "identity",
+	} {
+		d := &staticDoer{header: http.Header{}}
+		req, _ := http.NewRequest("GET", "http://example.com"+path, nil)
+		if _, err := (&EncodingDoer{Doer: d, Policy: p}).Do(req); err != nil {
+			t.Fatal(err)
+		}
+		if got := d.got.Header.Get("Accept-Encoding"); got != want {
+			t.Errorf("%s: Accept-Encoding = %q, want %q", path, got, want)
+		}
+		if req.Header.Get("Accept-Encoding") != "" {
+			t.Errorf("%s: caller's request modified", path)
+		}
+	}
+}
+
+func TestEncodingPolicyCheck(t *testing.T) {
+	tests := []struct {
+		accept, coding string
+		ok             bool
+	}{
+		{"", "zstd", true}, // nothing advertised, anything goes
+		{"gzip", "gzip", true},
+		{"gzip", "x-gzip", true},
+		{"gzip", "br", false},
+		{"gzip", "gzip, br", false},
+		{"gzip;q=0", "gzip", false},
+		{"*", "br", true},
+		{"gzip, *;q=0", "br", false},
+		{"br", "", true},
+		{"br", "identity", true},
+		{"br, identity;q=0", "identity", false},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		if tt.coding != "" {
+			h.Set("Content-Encoding", tt.coding)
+		}
+		d := &staticDoer{header: h}
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept-Encoding", tt.accept)
+		}
+		p := &EncodingPolicy{Unknown: UnknownEncodingFail}
+		_, err := (&EncodingDoer{Doer: d, Policy: p}).Do(req)
+		if ok := err == nil; ok != tt.ok {
+			t.Errorf("Accept-Encoding %q, Content-Encoding %q: err = %v", tt.accept, tt.coding, err)
+		}
+		p.Unknown = UnknownEncodingPassThrough
+		if _, err := (&EncodingDoer{Doer: d, Policy: p}).Do(req); err != nil {
+			t.Errorf("pass-through failed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package httpclientutil
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// EnvPrefix starts the names of the variables NewClientFromEnv reads.
+const EnvPrefix = "HTTPCLIENT_"
+
+// ConfigFromEnv returns the Config described by the environment, for
+// deployments that configure through variables alone. HTTP_PROXY,
+// HTTPS_PROXY and NO_PROXY pick the proxy, as for net/http, and
+// SSL_CERT_FILE replaces the trusted roots. The rest are prefix followed
+// by
+//
+//	DIAL_TIMEOUT, KEEP_ALIVE, WRITE_TIMEOUT, RESPONSE_HEADER_TIMEOUT,
+//	EXPECT_CONTINUE_TIMEOUT, IDLE_TIMEOUT    durations, such as "1.5s"
+//	MAX_IDLE_CONNS_PER_HOST, MAX_CONNS_PER_HOST    integers
+//	MAX_IN_FLIGHT, MAX_QUEUE    a Shedder in front of the pool
+//	PROXY    a proxy URL overriding HTTP_PROXY and the like
+//
+// Unset and empty variables keep the defaults. Malformed values are
+// reported together in a *ConfigError, by variable name.
+func ConfigFromEnv(prefix string) (*Config, error) {
+	c := &Config{ProxyFromEnvironment: true, Proxy: os.Getenv(prefix + "PROXY")}
+	var problems []string
+	durations := map[string]*Duration{
+		"DIAL_TIMEOUT":            &c.DialTimeout,
+		"KEEP_ALIVE":              &c.KeepAlive,
+		"WRITE_TIMEOUT":           &c.WriteTimeout,
+		"RESPONSE_HEADER_TIMEOUT": &c.ResponseHeaderTimeout,
+		"EXPECT_CONTINUE_TIMEOUT": &c.ExpectContinueTimeout,
+		"IDLE_TIMEOUT":            &c.IdleTimeout,
+	}
+	for name, d := range durations {
+		if s := os.Getenv(prefix + name); s != "" {
+			v, err := time.ParseDuration(s)
+			if err != nil {
+				problems = append(problems, prefix+name+": "+err.Error())
+			}
+			*d = Duration(v)
+		}
+	}
+	var limits LimitConfig
+	ints := map[string]*int{
+		"MAX_IDLE_CONNS_PER_HOST": &c.MaxIdleConnsPerHost,
+		"MAX_CONNS_PER_HOST":      &c.MaxConnsPerHost,
+		"MAX_IN_FLIGHT":           &limits.MaxInFlight,
+		"MAX_QUEUE":               &limits.MaxQueue,
+	}
+	for name, n := range ints {
+		if s := os.Getenv(prefix + name); s != "" {
+			v, err := strconv.Atoi(s)
+			if err != nil {
+				problems = append(problems, prefix+name+": not an integer: "+strconv.Quote(s))
+			}
+			*n = v
+		}
+	}
+	if limits != (LimitConfig{}) {
+		c.Limits = &limits
+	}
+	if ca := os.Getenv("SSL_CERT_FILE"); ca != "" {
+		c.TLS = &TLSFileConfig{CAFile: ca}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return nil, &ConfigError{Problems: problems}
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewClientFromEnv builds the client ConfigFromEnv(EnvPrefix) describes;
+// see Config.Build.
+func NewClientFromEnv() (Doer, *ClientConnPool, error) {
+	c, err := ConfigFromEnv(EnvPrefix)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c.Build()
+}
@@ -0,0 +1,34 @@
+package httpclientutil
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("TEST_DIAL_TIMEOUT", "3s")
+	t.Setenv("TEST_MAX_CONNS_PER_HOST", "6")
+	t.Setenv("TEST_MAX_IN_FLIGHT", "10")
+	t.Setenv("TEST_PROXY", "http://proxy.internal:3128")
+	t.Setenv("SSL_CERT_FILE", "/etc/ssl/internal.pem")
+	c, err := ConfigFromEnv("TEST_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.DialTimeout != Duration(3*time.Second) || c.MaxConnsPerHost != 6 || c.Proxy != "http://proxy.internal:3128" {
+		t.Errorf("config = %+v", c)
+	}
+	if c.Limits == nil || c.Limits.MaxInFlight != 10 || c.TLS == nil || c.TLS.CAFile != "/etc/ssl/internal.pem" {
+		t.Errorf("limits = %+v, tls = %+v", c.Limits, c.TLS)
+	}
+
+	t.Setenv("TEST_IDLE_TIMEOUT", "forever")
+	t.Setenv("TEST_MAX_QUEUE", "lots")
+	_, err = ConfigFromEnv("TEST_")
+	for _, want := range []string{"TEST_IDLE_TIMEOUT: ", `TEST_MAX_QUEUE: not an integer: "lots"`} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error %v lacks %q", err, want)
+		}
+	}
+}
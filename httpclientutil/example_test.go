@@ -0,0 +1,182 @@
+package httpclientutil_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/zhaojkun/client/httpclientutil"
+)
+
+func ExampleDialConn() {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello from %s", r.URL.Path)
+	}))
+	defer s.Close()
+
+	cc, err := httpclientutil.DialConn(context.Background(), "tcp", s.Listener.Addr().String(), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer cc.Close()
+	for _, path := range []string{"/a", "/b"} {
+		req, _ := http.NewRequest("GET", s.URL+path, nil)
+		resp, err := cc.Do(req)
+		if err != nil {
+			log.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		fmt.Println(string(b))
+	}
+	fmt.Println("reusable:", cc.Reusable())
+	// Output:
+	// hello from /a
+	// hello from /b
+	// reusable: true
+}
+
+func ExampleClientConnPool() {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer s.Close()
+
+	p := &httpclientutil.ClientConnPool{MaxConnsPerHost: 4}
+	defer p.Close()
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("GET", s.URL, nil)
+		resp, err := p.Do(req)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// Reading the body to EOF returns the connection to the pool.
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	for _, h := range p.State().Hosts {
+		fmt.Printf("%s: %d open, %d requests\n", h.Key.Scheme, h.Open, h.Conns[0].Requests)
+	}
+	// Output:
+	// http: 1 open, 3 requests
+}
+
+func ExampleRetryingConn() {
+	// The server closes the connection after every response.
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Connection", "close")
+		io.WriteString(w, r.Method)
+	}))
+	defer s.Close()
+
+	dials := 0
+	rc := &httpclientutil.RetryingConn{Dial: func(ctx context.Context) (*httpclientutil.ClientConn, error) {
+		dials++
+		return httpclientutil.DialConn(ctx, "tcp", s.Listener.Addr().String(), nil)
+	}}
+	defer rc.Close()
+	for _, method := range []string{"GET", "PUT", "DELETE"} {
+		req, _ := http.NewRequest(method, s.URL, nil)
+		resp, err := rc.Do(req)
+		if err != nil {
+			log.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		fmt.Println(string(b))
+	}
+	fmt.Println("dials:", dials)
+	// Output:
+	// GET
+	// PUT
+	// DELETE
+	// dials: 3
+}
+
+func ExampleScanElements() {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `<feed><entry><title>first</title></entry><entry><title>second</title></entry><entry><title>third</title></entry></feed>`)
+	}))
+	defer s.Close()
+
+	resp, err := http.Get(s.URL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Entries are decoded as they arrive; the scan stops after two.
+	n := 0
+	err = httpclientutil.ScanElements(resp, false, func(d *xml.Decoder, se xml.StartElement) error {
+		if se.Name.Local != "entry" {
+			return nil
+		}
+		var e struct {
+			Title string `xml:"title"`
+		}
+		if err := d.DecodeElement(&e, &se); err != nil {
+			return err
+		}
+		fmt.Println(e.Title)
+		if n++; n == 2 {
+			return httpclientutil.ErrStopScan
+		}
+		return nil
+	})
+	fmt.Println("err:", err)
+	// Output:
+	// first
+	// second
+	// err: <nil>
+}
+
+func ExampleClientConn_Do_upgrade() {
+	// An upgrade to a line-based echo protocol.
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+		brw.Flush()
+		for {
+			line, err := brw.ReadString('\n')
+			if err != nil {
+				return
+			}
+			brw.WriteString(strings.ToUpper(line))
+			brw.Flush()
+		}
+	}))
+	defer s.Close()
+
+	c, err := net.Dial("tcp", s.Listener.Addr().String())
+	if err != nil {
+		log.Fatal(err)
+	}
+	cc := httpclientutil.NewClientConn(c)
+	req, _ := http.NewRequest("GET", s.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "echo")
+	resp, err := cc.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(resp.Status)
+
+	conn := resp.Body.(io.ReadWriteCloser)
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	io.WriteString(conn, "hello\n")
+	line, _ := r.ReadString('\n')
+	fmt.Print(line)
+	// Output:
+	// 101 Switching Protocols
+	// HELLO
+}
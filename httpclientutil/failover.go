@@ -0,0 +1,132 @@
+package httpclientutil
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Failover sends the requests for a logical host to the first of its
+// origins that is not cooling down, and moves down the list when one fails
+// to connect or answers 5xx. A failed origin is skipped for Cooldown, so
+// after a regional outage traffic returns to it on its own. When every
+// origin is cooling down they are all tried in order regardless.
+//
+// A request whose connection failed never reached the origin and is always
+// resent, given a rewindable body; one that failed later, or got a 5xx, is
+// resent only if it is idempotent. The last origin's response or error is
+// returned as is. It is safe for concurrent use.
+type Failover struct {
+	Doer Doer
+
+	// Origins maps a logical host, as in request URLs, to the base URLs
+	// of its origins in order of preference, e.g. "a.example" to
+	// {"https://eu.a.example", "https://us.a.example:8443"}. Requests are
+	// rewritten to the origin's scheme and host, Host header included.
+	// Hosts without an entry pass through.
+	Origins map[string][]string
+
+	Cooldown time.Duration // 30s if zero
+	Clock    Clock         // nil is the system clock
+
+	mu   sync.Mutex
+	down map[string]time.Time // origin -> end of its cooldown
+}
+
+func (f *Failover) Do(req *http.Request) (*http.Response, error) {
+	origins := f.Origins[req.URL.Host]
+	if len(origins) == 0 {
+		return f.Doer.Do(req)
+	}
+	order := f.order(origins)
+	for i := 0; ; i++ {
+		origin := order[i]
+		u, err := url.Parse(origin)
+		if err != nil {
+			return nil, err
+		}
+		r := req.Clone(req.Context())
+		r.URL.Scheme, r.URL.Host, r.Host = u.Scheme, u.Host, u.Host
+		if i > 0 && req.Body != nil && req.Body != http.NoBody {
+			if r.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		resp, err := f.Doer.Do(r)
+		failed := err != nil || resp.StatusCode/100 == 5
+		if !failed {
+			f.recover(origin)
+			return resp, nil
+		}
+		f.fail(origin)
+		last := i == len(order)-1 || req.Context().Err() != nil || !f.resendable(req, err)
+		if last {
+			return resp, err
+		}
+		if resp != nil {
+			drainBody(resp)
+		}
+	}
+}
+
+// resendable reports whether req may go to the next origin after err, nil
+// for a 5xx.
+func (f *Failover) resendable(req *http.Request, err error) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	var op *net.OpError
+	if errors.As(err, &op) && op.Op == "dial" {
+		return true
+	}
+	return isIdempotent(req)
+}
+
+// order returns origins with those cooling down moved to the end.
+func (f *Failover) order(origins []string) []string {
+	now := clockOrSystem(f.Clock).Now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	up := make([]string, 0, len(origins))
+	var down []string
+	for _, o := range origins {
+		if until, ok := f.down[o]; ok && now.Before(until) {
+			down = append(down, o)
+		} else {
+			up = append(up, o)
+		}
+	}
+	return append(up, down...)
+}
+
+func (f *Failover) fail(origin string) {
+	cooldown := f.Cooldown
+	if cooldown <= 0 {
+		cooldown = 30 * time.Second
+	}
+	now := clockOrSystem(f.Clock).Now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down == nil {
+		f.down = make(map[string]time.Time)
+	}
+	f.down[origin] = now.Add(cooldown)
+}
+
+func (f *Failover) recover(origin string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.down, origin)
+}
+
+// Down reports whether origin is cooling down.
+func (f *Failover) Down(origin string) bool {
+	now := clockOrSystem(f.Clock).Now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	until, ok := f.down[origin]
+	return ok && now.Before(until)
+}
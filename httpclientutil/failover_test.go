@@ -0,0 +1,78 @@
+package httpclientutil
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFailover(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := "http://" + l.Addr().String()
+	l.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	var hosts []string
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts = append(hosts, r.Host)
+		b, _ := io.ReadAll(r.Body)
+		w.Write(b)
+	}))
+	defer good.Close()
+
+	clock := newFakeClock()
+	f := &Failover{
+		Doer:    http.DefaultClient,
+		Origins: map[string][]string{"svc.example": {refused, failing.URL, good.URL}},
+		Clock:   clock,
+	}
+	do := func(method, body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, "http://svc.example/x", strings.NewReader(body))
+		resp, err := f.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode == 200 && string(b) != body {
+			t.Errorf("%s body = %q, want %q", method, b, body)
+		}
+		return resp
+	}
+
+	if resp := do("PUT", "idempotent"); resp.StatusCode != 200 {
+		t.Fatalf("PUT: status %d, want the third origin's 200", resp.StatusCode)
+	}
+	if want := good.Listener.Addr().String(); len(hosts) != 1 || hosts[0] != want {
+		t.Errorf("origin saw Host %q, want %q", hosts, want)
+	}
+	if !f.Down(refused) || !f.Down(failing.URL) || f.Down(good.URL) {
+		t.Errorf("cooldowns: %v", f.down)
+	}
+
+	// The last origin up is tried first, and a POST is not resent after a
+	// 5xx.
+	f.recover(failing.URL)
+	f.fail(good.URL)
+	if resp := do("POST", "once"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("POST: status %d, want the 503 of the failing origin", resp.StatusCode)
+	}
+
+	// A refused connection never reached the origin, so even a POST moves
+	// on.
+	clock.Advance(31 * time.Second)
+	f.Origins["svc.example"] = []string{refused, good.URL}
+	if resp := do("POST", "moved on"); resp.StatusCode != 200 {
+		t.Fatalf("POST after a refused dial: status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,295 @@
+package httpclientutil
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Exchange is what a FlightRecorder kept of one request. Headers are
+// redacted private copies and bodies are cut at FlightRecorder.MaxBody.
+type Exchange struct {
+	Start         time.Time
+	Duration      time.Duration // until the response body was finished, or so far
+	Method        string
+	URL           string
+	RequestHeader http.Header
+	RequestBody   []byte // prefix of what was sent
+	StatusCode    int    // zero if no response arrived
+	Header        http.Header
+	Body          []byte // prefix of what the caller read
+	BytesReceived int64
+	Err           string
+	InFlight      bool // the response body was not finished yet
+}
+
+// FlightRecorder keeps the last Size exchanges through Doer in memory, so
+// that the traffic around an intermittent failure can be dumped after the
+// fact, from a signal handler or a debug endpoint. Unlike AuditDoer it
+// writes nothing until asked and only ever holds a bounded amount.
+type FlightRecorder struct {
+	Doer    Doer
+	Size    int      // exchanges kept, 64 if zero
+	MaxBody int      // body bytes kept per direction, 1KB if zero, none if negative
+	Redact  []string // header names to redact, DefaultRedactedHeaders if nil
+	Clock   Clock
+
+	mu       sync.Mutex
+	ring     []Exchange
+	next     int // slot the next finished exchange goes to
+	inFlight map[*flightEntry]struct{}
+}
+
+func (f *FlightRecorder) Do(req *http.Request) (*http.Response, error) {
+	e := &flightEntry{f: f, clock: clockOrSystem(f.Clock)}
+	e.x = Exchange{
+		Start:         e.clock.Now(),
+		Method:        req.Method,
+		URL:           req.URL.String(),
+		RequestHeader: redactHeader(req.Header, f.Redact),
+		InFlight:      true,
+	}
+	if req.Body != nil && req.Body != http.NoBody && f.maxBody() > 0 {
+		r := *req
+		r.Body = &flightRequestBody{ReadCloser: req.Body, e: e}
+		req = &r
+	}
+	f.begin(e)
+	resp, err := f.Doer.Do(req)
+	if err != nil {
+		e.finish(err)
+		return nil, err
+	}
+	e.mu.Lock()
+	e.x.StatusCode = resp.StatusCode
+	e.x.Header = redactHeader(resp.Header, f.Redact)
+	e.mu.Unlock()
+	resp.Body = &flightBody{ReadCloser: resp.Body, e: e}
+	return resp, nil
+}
+
+// Exchanges returns the finished exchanges kept, oldest first, followed by
+// those still in flight in the order they started.
+func (f *FlightRecorder) Exchanges() []Exchange {
+	f.mu.Lock()
+	xs := make([]Exchange, 0, len(f.ring)+len(f.inFlight))
+	if len(f.ring) == f.size() {
+		xs = append(xs, f.ring[f.next:]...)
+		xs = append(xs, f.ring[:f.next]...)
+	} else {
+		xs = append(xs, f.ring...)
+	}
+	active := make([]*flightEntry, 0, len(f.inFlight))
+	for e := range f.inFlight {
+		active = append(active, e)
+	}
+	f.mu.Unlock()
+	var open []Exchange
+	for _, e := range active {
+		open = append(open, e.snapshot())
+	}
+	sort.Slice(open, func(i, j int) bool { return open[i].Start.Before(open[j].Start) })
+	return append(xs, open...)
+}
+
+// Dump writes the exchanges to w in a plain text form meant for people.
+func (f *FlightRecorder) Dump(w io.Writer) error {
+	for _, x := range f.Exchanges() {
+		if err := x.dump(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServeHTTP serves the dump, so the recorder can be mounted on a debug
+// mux.
+func (f *FlightRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	f.Dump(w)
+}
+
+// DumpOnSignal dumps to w every time the process receives one of sig,
+// e.g. syscall.SIGUSR1, until stop is called.
+func (f *FlightRecorder) DumpOnSignal(w io.Writer, sig ...os.Signal) (stop func()) {
+	ch := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(ch, sig...)
+	spawn("signal dump", func() {
+		for {
+			select {
+			case <-ch:
+				f.Dump(w)
+			case <-done:
+				return
+			}
+		}
+	})
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(done)
+		})
+	}
+}
+
+func (f *FlightRecorder) size() int {
+	if f.Size <= 0 {
+		return 64
+	}
+	return f.Size
+}
+
+func (f *FlightRecorder) maxBody() int {
+	if f.MaxBody == 0 {
+		return 1 << 10
+	}
+	return f.MaxBody
+}
+
+func (f *FlightRecorder) begin(e *flightEntry) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.inFlight == nil {
+		f.inFlight = make(map[*flightEntry]struct{})
+	}
+	f.inFlight[e] = struct{}{}
+}
+
+func (f *FlightRecorder) end(e *flightEntry, x Exchange) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.inFlight, e)
+	if len(f.ring) < f.size() {
+		f.ring = append(f.ring, x)
+		f.next = len(f.ring) % f.size()
+		return
+	}
+	f.ring[f.next] = x
+	f.next = (f.next + 1) % len(f.ring)
+}
+
+func (x *Exchange) dump(w io.Writer) error {
+	state := fmt.Sprint(x.StatusCode)
+	if x.StatusCode == 0 {
+		state = "-"
+	}
+	if x.InFlight {
+		state += " (in flight)"
+	}
+	if _, err := fmt.Fprintf(w, "%s %s %s %s %v, %d bytes\n", x.Start.Format(time.RFC3339Nano), x.Method, x.URL, state, x.Duration, x.BytesReceived); err != nil {
+		return err
+	}
+	if x.Err != "" {
+		fmt.Fprintf(w, "  error: %s\n", x.Err)
+	}
+	dumpHeader(w, "> ", x.RequestHeader)
+	if len(x.RequestBody) > 0 {
+		fmt.Fprintf(w, "> %q\n", x.RequestBody)
+	}
+	dumpHeader(w, "< ", x.Header)
+	if len(x.Body) > 0 {
+		fmt.Fprintf(w, "< %q\n", x.Body)
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func dumpHeader(w io.Writer, prefix string, h http.Header) {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			fmt.Fprintf(w, "%s%s: %s\n", prefix, k, v)
+		}
+	}
+}
+
+// flightEntry is an exchange being recorded; body reads and Exchanges
+// may come from different goroutines, so x is guarded by mu.
+type flightEntry struct {
+	f     *FlightRecorder
+	clock Clock
+	once  sync.Once
+	mu    sync.Mutex
+	x     Exchange
+}
+
+func (e *flightEntry) snapshot() Exchange {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	x := e.x
+	x.Duration = e.clock.Now().Sub(x.Start)
+	x.RequestBody = append([]byte(nil), x.RequestBody...)
+	x.Body = append([]byte(nil), x.Body...)
+	return x
+}
+
+// keep appends what fits of p to *dst.
+func (e *flightEntry) keep(dst *[]byte, p []byte) {
+	if room := e.f.maxBody() - len(*dst); room > 0 {
+		if len(p) > room {
+			p = p[:room]
+		}
+		*dst = append(*dst, p...)
+	}
+}
+
+func (e *flightEntry) finish(err error) {
+	e.once.Do(func() {
+		e.mu.Lock()
+		e.x.Duration = e.clock.Now().Sub(e.x.Start)
+		if err != nil && err != io.EOF {
+			e.x.Err = err.Error()
+		}
+		e.x.InFlight = false
+		x := e.x
+		e.mu.Unlock()
+		e.f.end(e, x)
+	})
+}
+
+type flightRequestBody struct {
+	io.ReadCloser
+	e *flightEntry
+}
+
+func (b *flightRequestBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.e.mu.Lock()
+	b.e.keep(&b.e.x.RequestBody, p[:n])
+	b.e.mu.Unlock()
+	return n, err
+}
+
+type flightBody struct {
+	io.ReadCloser
+	e *flightEntry
+}
+
+func (b *flightBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.e.mu.Lock()
+	b.e.x.BytesReceived += int64(n)
+	b.e.keep(&b.e.x.Body, p[:n])
+	b.e.mu.Unlock()
+	if err != nil {
+		b.e.finish(err)
+	}
+	return n, err
+}
+
+func (b *flightBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.e.finish(nil)
+	return err
+}
@@ -0,0 +1,72 @@
+package httpclientutil
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFlightRecorder(t *testing.T) {
+	upstream := doerFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path == "/fail" {
+			return nil, errors.New("connection reset")
+		}
+		if req.Body != nil {
+			io.Copy(io.Discard, req.Body)
+		}
+		return &http.Response{
+			StatusCode: 200,
+			Header:     http.Header{"Set-Cookie": {"s=1"}},
+			Body:       io.NopCloser(strings.NewReader("reply to " + req.URL.Path)),
+			Request:    req,
+		}, nil
+	})
+	f := &FlightRecorder{Doer: upstream, Size: 2, MaxBody: 8}
+	do := func(path string, body io.Reader) *http.Response {
+		req, _ := http.NewRequest("POST", "http://a.example"+path, body)
+		req.Header.Set("Authorization", "secret")
+		resp, _ := f.Do(req)
+		return resp
+	}
+	drainBody(do("/old", nil))
+	do("/fail", nil)
+	drainBody(do("/post", strings.NewReader("a long request body")))
+	open := do("/open", nil)
+	io.ReadFull(open.Body, make([]byte, 3))
+
+	xs := f.Exchanges()
+	if len(xs) != 3 {
+		t.Fatalf("kept %d exchanges, want the last 2 and one in flight", len(xs))
+	}
+	if xs[0].URL != "http://a.example/fail" || xs[0].Err != "connection reset" || xs[0].StatusCode != 0 {
+		t.Errorf("oldest = %+v", xs[0])
+	}
+	if x := xs[1]; string(x.RequestBody) != "a long r" || string(x.Body) != "reply to" || x.BytesReceived != 14 {
+		t.Errorf("bodies = %q, %q, %d bytes", x.RequestBody, x.Body, x.BytesReceived)
+	}
+	if x := xs[1]; x.RequestHeader.Get("Authorization") != "REDACTED" || x.Header.Get("Set-Cookie") != "REDACTED" {
+		t.Errorf("headers not redacted: %v, %v", x.RequestHeader, x.Header)
+	}
+	if x := xs[2]; !x.InFlight || string(x.Body) != "rep" {
+		t.Errorf("in flight = %+v", x)
+	}
+	open.Body.Close()
+	if xs := f.Exchanges(); len(xs) != 2 || xs[1].URL != "http://a.example/open" || xs[1].InFlight {
+		t.Errorf("after close: %+v", xs)
+	}
+
+	rec := httptest.NewRecorder()
+	f.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/flight", nil))
+	dump := rec.Body.String()
+	for _, want := range []string{"POST http://a.example/post 200", `> "a long r"`, "> Authorization: REDACTED", `< "reply to"`} {
+		if !strings.Contains(dump, want) {
+			t.Errorf("dump lacks %q:\n%s", want, dump)
+		}
+	}
+	if strings.Contains(dump, "secret") {
+		t.Errorf("dump leaks a redacted header:\n%s", dump)
+	}
+}
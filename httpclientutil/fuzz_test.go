@@ -0,0 +1,152 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Fuzz targets for the response parsing paths. Each must return an error
+// or a result for any input, without panicking or hanging; run them with
+// go test -fuzz=FuzzReadLoop and so on.
+
+var fuzzResponses = []string{
+	"HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok",
+	"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n2\r\nok\r\n0\r\n\r\n",
+	"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nffffffffffffffff\r\n",
+	"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n2;ext=\"x\r\nok\r\n0\r\nTrailer: x\r\n\r\n",
+	"HTTP/1.1 200 OK\r\nContent-Length: 99999999999\r\n\r\nshort",
+	"HTTP/1.1 200 OK\r\nContent-Length: 1\r\nContent-Length: 2\r\n\r\nok",
+	"HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 204 No Content\r\n\r\n",
+	"HTTP/1.1 101 Switching Protocols\r\nUpgrade: x\r\n\r\nraw",
+	"HTTP/1.0 200\r\n\r\nuntil close",
+	"HTTP/1.1 999999 Bad\r\n\r\n",
+	"HTTP/1.1\r\n\r\n",
+	"HTTP/2 200\r\n\r\n",
+	"\x00\x00\x12\x04\x00\x00\x00\x00\x00",
+	"HTTP/1.1 200 OK\r\nX: a\r\n b\r\n\r\n",
+	"HTTP/1.1 200 OK\r\n: empty\r\n\r\n",
+	"HTTP/1.1 200 OK\nContent-Length: 0\n\n",
+	"HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\nHTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n",
+}
+
+// FuzzReadLoop serves data as the whole server side of a connection, then
+// closes it. mode%3 picks the early response policy, mode&4 sends two
+// pipelined requests and mode&8 sends data before reading the request.
+func FuzzReadLoop(f *testing.F) {
+	for i, s := range fuzzResponses {
+		f.Add(byte(i), []byte(s))
+	}
+	f.Fuzz(func(t *testing.T, mode byte, data []byte) {
+		client, server := net.Pipe()
+		early := mode&8 != 0
+		go func() {
+			defer server.Close()
+			if early {
+				server.Write(data)
+			}
+			br := bufio.NewReader(server)
+			if _, err := http.ReadRequest(br); err != nil {
+				return
+			}
+			if !early {
+				server.Write(data)
+			}
+		}()
+		cc := NewClientConn(client)
+		defer cc.Close()
+		cc.SetEarlyResponsePolicy(EarlyResponsePolicy(mode%3), 2)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			n := 1
+			if mode&4 != 0 {
+				n = 2
+			}
+			var reqs []*http.Request
+			for i := 0; i < n; i++ {
+				req, _ := http.NewRequestWithContext(ctx, "GET", "http://example.com/", nil)
+				reqs = append(reqs, req)
+			}
+			if n == 1 {
+				if resp, err := cc.Do(reqs[0]); err == nil {
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+				}
+				return
+			}
+			resps, _ := cc.DoBatch(reqs)
+			for _, resp := range resps {
+				resp.Body.Close()
+			}
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			t.Fatalf("Do hung on %q", data)
+		}
+		cc.EarlyResponses()
+	})
+}
+
+func FuzzReadRanges(f *testing.F) {
+	f.Add(206, "bytes 0-1/10", "", "ok")
+	f.Add(206, "", "multipart/byteranges; boundary=B", "--B\r\nContent-Range: bytes 0-1/4\r\n\r\nok\r\n--B\r\nContent-Range: bytes 2-3/4\r\n\r\nno\r\n--B--\r\n")
+	f.Add(206, "", "multipart/byteranges; boundary=B", "--B\r\nContent-Range: bytes 9-1/*\r\n\r\n")
+	f.Add(200, "", "", "whole")
+	f.Fuzz(func(t *testing.T, status int, contentRange, contentType, body string) {
+		resp := &http.Response{
+			StatusCode:    status,
+			Header:        http.Header{},
+			Body:          io.NopCloser(strings.NewReader(body)),
+			ContentLength: -1,
+		}
+		resp.Header.Set("Content-Range", contentRange)
+		resp.Header.Set("Content-Type", contentType)
+		rr, err := ReadRanges(resp)
+		if err != nil {
+			return
+		}
+		defer rr.Close()
+		for {
+			part, err := rr.Next()
+			if err != nil {
+				return
+			}
+			if part.Start < 0 || (part.End >= 0 && part.End < part.Start) {
+				t.Fatalf("bad part bounds %d-%d", part.Start, part.End)
+			}
+			io.Copy(io.Discard, part.Reader)
+		}
+	})
+}
+
+func FuzzParseChallenges(f *testing.F) {
+	f.Add(`Basic realm="x", Digest realm="y", nonce="n", qop="auth,auth-int"`)
+	f.Add(`Bearer abc==, Newauth realm=`)
+	f.Add(`Digest realm="unterminated`)
+	f.Fuzz(func(t *testing.T, v string) {
+		for _, c := range ParseChallenges(http.Header{"Www-Authenticate": {v}}, "Www-Authenticate") {
+			if c.Scheme == "" {
+				t.Fatalf("challenge without scheme from %q", v)
+			}
+		}
+	})
+}
+
+func FuzzParseLinks(f *testing.F) {
+	f.Add(`<https://example.com/2>; rel="next", <https://example.com/5>; rel=last`)
+	f.Add(`<a>; title="\"q\"; x"; rel="next prev"`)
+	f.Add(`<unterminated; rel=next`)
+	f.Fuzz(func(t *testing.T, v string) {
+		ParseLinks(http.Header{"Link": {v}})
+	})
+}
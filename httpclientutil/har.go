@@ -0,0 +1,193 @@
+package httpclientutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HAR is the subset of the HTTP Archive 1.2 format needed to replay
+// recorded requests.
+type HAR struct {
+	Log struct {
+		Entries []HAREntry `json:"entries"`
+	} `json:"log"`
+}
+
+type HAREntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"` // milliseconds
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+}
+
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []HARNameValue `json:"headers"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+}
+
+type HARResponse struct {
+	Status  int            `json:"status"`
+	Headers []HARNameValue `json:"headers"`
+}
+
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"` // "base64" for binary bodies
+}
+
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// ReadHAR decodes a HAR document.
+func ReadHAR(r io.Reader) (*HAR, error) {
+	h := new(HAR)
+	if err := json.NewDecoder(r).Decode(h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// harSkipHeaders are recomputed for the replayed request.
+var harSkipHeaders = map[string]bool{
+	"Host":              true,
+	"Content-Length":    true,
+	"Connection":        true,
+	"Transfer-Encoding": true,
+}
+
+// NewRequest rebuilds the recorded request.
+func (e *HAREntry) NewRequest(ctx context.Context) (*http.Request, error) {
+	var body io.Reader
+	if pd := e.Request.PostData; pd != nil && pd.Text != "" {
+		data := []byte(pd.Text)
+		if pd.Encoding == "base64" {
+			var err error
+			if data, err = base64.StdEncoding.DecodeString(pd.Text); err != nil {
+				return nil, err
+			}
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(e.Request.Method, e.Request.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range e.Request.Headers {
+		name := http.CanonicalHeaderKey(h.Name)
+		// Browsers also record HTTP/2 pseudo-headers like ":authority".
+		if strings.HasPrefix(h.Name, ":") || harSkipHeaders[name] {
+			continue
+		}
+		req.Header.Add(name, h.Value)
+	}
+	if pd := e.Request.PostData; pd != nil && pd.MimeType != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", pd.MimeType)
+	}
+	return req.WithContext(ctx), nil
+}
+
+// HARReplayer sends the requests of a HAR against a live target, keeping
+// their recorded relative start times.
+type HARReplayer struct {
+	Doer Doer
+
+	// Target, if set, replaces the scheme and host of every recorded URL,
+	// e.g. "http://staging.internal:8080".
+	Target string
+
+	// Speed scales the recorded gaps: 1 keeps them, 2 halves them, and
+	// zero sends without waiting.
+	Speed float64
+
+	// Concurrent starts each request at its offset even if earlier ones
+	// are still running, which needs a Doer safe for concurrent use. When
+	// false, requests are sent one after another and a slow response
+	// delays the rest.
+	Concurrent bool
+
+	// Clock times the gaps; nil is the system clock.
+	Clock Clock
+
+	// OnResult is called for every replayed entry. The response body is
+	// drained and closed after it returns.
+	OnResult func(e *HAREntry, resp *http.Response, err error)
+}
+
+// Replay sends all entries of h in recorded order and returns when the
+// last response is done or ctx is canceled.
+func (r *HARReplayer) Replay(ctx context.Context, h *HAR) error {
+	entries := make([]*HAREntry, len(h.Log.Entries))
+	for i := range h.Log.Entries {
+		entries[i] = &h.Log.Entries[i]
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].StartedDateTime.Before(entries[j].StartedDateTime)
+	})
+	var target *url.URL
+	if r.Target != "" {
+		var err error
+		if target, err = url.Parse(r.Target); err != nil {
+			return err
+		}
+	}
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	clock := clockOrSystem(r.Clock)
+	start := clock.Now()
+	for _, e := range entries {
+		if r.Speed > 0 {
+			offset := e.StartedDateTime.Sub(entries[0].StartedDateTime)
+			wait := time.Duration(float64(offset)/r.Speed) - clock.Now().Sub(start)
+			if wait > 0 && !sleep(clock, wait, ctx.Done()) {
+				return context.Cause(ctx)
+			}
+		}
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		if !r.Concurrent {
+			r.replay(ctx, e, target)
+			continue
+		}
+		wg.Add(1)
+		e := e
+		spawn("HAR replay", func() {
+			defer wg.Done()
+			r.replay(ctx, e, target)
+		})
+	}
+	return nil
+}
+
+func (r *HARReplayer) replay(ctx context.Context, e *HAREntry, target *url.URL) {
+	req, err := e.NewRequest(ctx)
+	var resp *http.Response
+	if err == nil {
+		if target != nil {
+			req.URL.Scheme, req.URL.Host = target.Scheme, target.Host
+			req.Host = ""
+		}
+		resp, err = r.Doer.Do(req)
+	}
+	if r.OnResult != nil {
+		r.OnResult(e, resp, err)
+	}
+	if resp != nil {
+		drainBody(resp)
+	}
+}
@@ -0,0 +1,107 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestHijackAfterUpgrade(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		if _, err := http.ReadRequest(br); err != nil {
+			return
+		}
+		// The first bytes of the new protocol share a packet with the 101.
+		io.WriteString(c, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\nhello\n")
+		line, _ := br.ReadString('\n')
+		io.WriteString(c, "echo "+line)
+	})
+	req, _ := http.NewRequest("GET", "http://a.example/", nil)
+	req.Header.Set("Upgrade", "echo")
+	req.Header.Set("Connection", "Upgrade")
+	resp, err := cc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %s", resp.Status)
+	}
+	c := cc.HijackConn()
+	if c == nil {
+		t.Fatal("HijackConn returned no conn")
+	}
+	defer c.Close()
+	r := bufio.NewReader(c)
+	if got, _ := r.ReadString('\n'); got != "hello\n" {
+		t.Errorf("greeting = %q", got)
+	}
+	io.WriteString(c, "ping\n")
+	if got, _ := r.ReadString('\n'); got != "echo ping\n" {
+		t.Errorf("reply = %q", got)
+	}
+}
+
+// Hijack stops readLoop while it waits on an idle connection, so bytes
+// that arrive later reach the caller and no one else.
+func TestHijackIdle(t *testing.T) {
+	hijacked := make(chan struct{})
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		answer(c, br, "first")
+		<-hijacked
+		io.WriteString(c, "raw bytes")
+		io.Copy(io.Discard, br)
+	})
+	if got := doBody(t, cc); got != "first" {
+		t.Fatalf("body = %q", got)
+	}
+	c, r := cc.Hijack()
+	if c == nil {
+		t.Fatal("Hijack returned no conn")
+	}
+	defer c.Close()
+	close(hijacked)
+	b := make([]byte, len("raw bytes"))
+	if _, err := io.ReadFull(r, b); err != nil || string(b) != "raw bytes" {
+		t.Errorf("read %q, %v", b, err)
+	}
+	if err := cc.Ping(); err != http.ErrHijacked {
+		t.Errorf("Ping = %v, want http.ErrHijacked", err)
+	}
+}
+
+func TestHijackMidBody(t *testing.T) {
+	more := make(chan struct{})
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		if _, err := http.ReadRequest(br); err != nil {
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nabcde")
+		<-more
+		io.WriteString(c, "fghij")
+		io.Copy(io.Discard, br)
+	})
+	req, _ := http.NewRequest("GET", "http://a.example/", nil)
+	resp, err := cc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 5)
+	if _, err := io.ReadFull(resp.Body, b); err != nil || string(b) != "abcde" {
+		t.Fatalf("body start %q, %v", b, err)
+	}
+	c, r := cc.Hijack()
+	if c == nil {
+		t.Fatal("Hijack returned no conn")
+	}
+	defer c.Close()
+	close(more)
+	if _, err := io.ReadFull(r, b); err != nil || string(b) != "fghij" {
+		t.Errorf("rest %q, %v", b, err)
+	}
+	if _, err := resp.Body.Read(b); !errors.Is(err, http.ErrHijacked) {
+		t.Errorf("body read after Hijack: err = %v, want http.ErrHijacked", err)
+	}
+}
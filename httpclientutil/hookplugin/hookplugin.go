@@ -0,0 +1,48 @@
+// Package hookplugin loads httpclientutil.Hooks from Go plugins. It lives
+// apart from httpclientutil because importing package plugin turns off
+// the linker's dead code elimination in every binary that links it.
+package hookplugin
+
+import (
+	"fmt"
+	"net/http"
+	"plugin"
+
+	"github.com/zhaojkun/client/httpclientutil"
+)
+
+// Load opens the Go plugin at path, built with -buildmode=plugin by the
+// same toolchain and against the same versions of the packages it shares
+// with the host, and returns its exported
+//
+//	func RequestHook(*http.Request) error
+//	func ResponseHook(*http.Response) error
+//
+// of which it must define at least one, for HookDoer.SetHooks. A plugin
+// cannot be unloaded and opening a path again returns the plugin loaded
+// first, so each new version must be built to a new file.
+func Load(path string) (*httpclientutil.Hooks, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	h := new(httpclientutil.Hooks)
+	if sym, err := p.Lookup("RequestHook"); err == nil {
+		fn, ok := sym.(func(*http.Request) error)
+		if !ok {
+			return nil, fmt.Errorf("http: plugin %s: RequestHook is %T, not func(*http.Request) error", path, sym)
+		}
+		h.Request = fn
+	}
+	if sym, err := p.Lookup("ResponseHook"); err == nil {
+		fn, ok := sym.(func(*http.Response) error)
+		if !ok {
+			return nil, fmt.Errorf("http: plugin %s: ResponseHook is %T, not func(*http.Response) error", path, sym)
+		}
+		h.Response = fn
+	}
+	if h.Request == nil && h.Response == nil {
+		return nil, fmt.Errorf("http: plugin %s defines neither RequestHook nor ResponseHook", path)
+	}
+	return h, nil
+}
@@ -0,0 +1,56 @@
+package hookplugin
+
+import (
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// buildFlags match the test binary, which a plugin must.
+var buildFlags = []string{"build", "-buildmode=plugin"}
+
+const hookSource = `package main
+
+import "net/http"
+
+func RequestHook(req *http.Request) error {
+	req.Header.Set("X-Hooked", "yes")
+	return nil
+}
+
+func main() {}
+`
+
+func TestLoad(t *testing.T) {
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.so")); err == nil {
+		t.Error("loaded a missing plugin")
+	}
+
+	if testing.Short() {
+		t.Skip("builds a plugin")
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "hook.go"), []byte(hookSource), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module hook\n"), 0o644)
+	so := filepath.Join(dir, "hook.so")
+	cmd := exec.Command("go", append(buildFlags, "-o", so, ".")...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("cannot build a plugin here: %v\n%s", err, out)
+	}
+	h, err := Load(so)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Response != nil {
+		t.Error("ResponseHook found in a plugin without one")
+	}
+	req, _ := http.NewRequest("GET", "http://a.example/", nil)
+	if err := h.Request(req); err != nil || req.Header.Get("X-Hooked") != "yes" {
+		t.Errorf("RequestHook: %v, header %q", err, req.Header.Get("X-Hooked"))
+	}
+}
@@ -0,0 +1,5 @@
+//go:build race
+
+package hookplugin
+
+func init() { buildFlags = append(buildFlags, "-race") }
@@ -0,0 +1,53 @@
+package httpclientutil
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// Hooks transform requests before they are sent and responses before they
+// are returned. Either may be nil. An error from Request fails the request
+// unsent; one from Response fails it after closing the body. A panic in
+// either fails the request the same way with a *HookPanicError.
+type Hooks struct {
+	Request  func(*http.Request) error
+	Response func(*http.Response) error
+}
+
+// HookDoer runs the current Hooks around every request through Doer. The
+// hooks can be replaced while requests are in flight, e.g. to hot-patch
+// header logic in a deployed binary by loading a new plugin with package
+// hookplugin on SIGHUP; each request runs with the hooks current when it
+// started.
+type HookDoer struct {
+	Doer  Doer
+	hooks atomic.Value // *Hooks
+}
+
+// SetHooks replaces the hooks; nil removes them.
+func (hd *HookDoer) SetHooks(h *Hooks) {
+	hd.hooks.Store(h)
+}
+
+func (hd *HookDoer) Do(req *http.Request) (*http.Response, error) {
+	h, _ := hd.hooks.Load().(*Hooks)
+	if h == nil {
+		return hd.Doer.Do(req)
+	}
+	if h.Request != nil {
+		// Hooks may edit the header, so give them a copy of their own.
+		req = req.Clone(req.Context())
+		if err := runHook("Hooks.Request", func() error { return h.Request(req) }); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := hd.Doer.Do(req)
+	if err != nil || h.Response == nil {
+		return resp, err
+	}
+	if err := runHook("Hooks.Response", func() error { return h.Response(resp) }); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
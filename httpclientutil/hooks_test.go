@@ -0,0 +1,59 @@
+package httpclientutil
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestHookDoer(t *testing.T) {
+	upstream := doerFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: 200,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader(req.Header.Get("X-Version"))),
+			Request:    req,
+		}, nil
+	})
+	hd := &HookDoer{Doer: upstream}
+	get := func() (string, error) {
+		req, _ := http.NewRequest("GET", "http://a.example/", nil)
+		resp, err := hd.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		if req.Header.Get("X-Version") != "" {
+			t.Error("hook edited the caller's request")
+		}
+		return resp.Header.Get("X-Seen") + string(b), nil
+	}
+	if got, _ := get(); got != "" {
+		t.Errorf("without hooks got %q", got)
+	}
+	hd.SetHooks(&Hooks{
+		Request: func(req *http.Request) error {
+			req.Header.Set("X-Version", "v1")
+			return nil
+		},
+		Response: func(resp *http.Response) error {
+			resp.Header.Set("X-Seen", "yes:")
+			return nil
+		},
+	})
+	if got, _ := get(); got != "yes:v1" {
+		t.Errorf("v1 hooks: got %q", got)
+	}
+	denied := errors.New("denied")
+	hd.SetHooks(&Hooks{Request: func(*http.Request) error { return denied }})
+	if _, err := get(); err != denied {
+		t.Errorf("err = %v, want the hook's", err)
+	}
+	hd.SetHooks(nil)
+	if got, _ := get(); got != "" {
+		t.Errorf("hooks removed: got %q", got)
+	}
+}
@@ -0,0 +1,196 @@
+// Package httpheader parses the date and caching headers of HTTP
+// responses: the HTTP-date fields, Cache-Control, Age and Retry-After,
+// and the weighted lists of the Accept family.
+package httpheader
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrBadDate = errors.New("httpheader: unrecognized date format")
+
+// timeFormats are tried in order. The first three are the formats RFC 9110
+// requires recipients to accept; the rest are seen from broken servers.
+var timeFormats = []string{
+	http.TimeFormat,
+	time.RFC850,
+	time.ANSIC,
+	time.RFC1123,
+	time.RFC1123Z,
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"Mon, 02-Jan-2006 15:04:05 MST",
+	"Monday, 02-Jan-06 15:04:05",
+	time.RFC3339,
+}
+
+// ParseTime parses an HTTP-date as found in Date, Expires, Last-Modified
+// and Retry-After, returning it in UTC.
+func ParseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range timeFormats {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, ErrBadDate
+}
+
+// Time parses header name of h as an HTTP-date. ok is false if the header
+// is missing or malformed.
+func Time(h http.Header, name string) (t time.Time, ok bool) {
+	v := h.Get(name)
+	if v == "" {
+		return time.Time{}, false
+	}
+	t, err := ParseTime(v)
+	return t, err == nil
+}
+
+// CacheControl holds the directives of Cache-Control header lines, keyed
+// by lower-case name. Directives without an argument map to "".
+type CacheControl map[string]string
+
+// ParseCacheControl merges all Cache-Control lines of h.
+func ParseCacheControl(h http.Header) CacheControl {
+	cc := CacheControl{}
+	for _, line := range h.Values("Cache-Control") {
+		for line != "" {
+			var part string
+			part, line = nextDirective(line)
+			name, val := part, ""
+			if i := strings.IndexByte(part, '='); i >= 0 {
+				name, val = part[:i], strings.TrimSpace(part[i+1:])
+				if len(val) >= 2 && val[0] == '"' && val[len(val)-1] == '"' {
+					val = val[1 : len(val)-1]
+				}
+			}
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				if _, dup := cc[name]; !dup {
+					cc[name] = val
+				}
+			}
+		}
+	}
+	return cc
+}
+
+// nextDirective splits off the first comma separated directive of s,
+// honoring quoted strings such as no-cache="Set-Cookie, Foo".
+func nextDirective(s string) (part, rest string) {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			if quoted {
+				i++
+			}
+		case ',':
+			if !quoted {
+				return s[:i], s[i+1:]
+			}
+		}
+	}
+	return s, ""
+}
+
+// Has reports whether directive name is present.
+func (cc CacheControl) Has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// Seconds returns the delta-seconds argument of directive name, such as
+// max-age. ok is false if it is missing or not a number.
+func (cc CacheControl) Seconds(name string) (d time.Duration, ok bool) {
+	v, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	const max = int64(1<<63-1) / int64(time.Second)
+	if n > max {
+		n = max
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// FreshnessLifetime returns how long a response stays fresh for a private
+// cache: max-age, else Expires minus Date. ok is false if the response
+// carries no explicit lifetime.
+func FreshnessLifetime(h http.Header) (d time.Duration, ok bool) {
+	cc := ParseCacheControl(h)
+	if d, ok := cc.Seconds("max-age"); ok {
+		return d, true
+	}
+	if h.Get("Expires") == "" {
+		return 0, false
+	}
+	exp, ok := Time(h, "Expires")
+	if !ok {
+		// An invalid Expires, such as "0", means already expired.
+		return 0, true
+	}
+	date, ok := Time(h, "Date")
+	if !ok {
+		return 0, false
+	}
+	if d := exp.Sub(date); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+// CurrentAge computes the age of a response at now per RFC 9111 section
+// 4.2.3. requestTime and responseTime are when the request was sent and
+// the response received.
+func CurrentAge(h http.Header, requestTime, responseTime, now time.Time) time.Duration {
+	var apparent time.Duration
+	if date, ok := Time(h, "Date"); ok {
+		if apparent = responseTime.Sub(date); apparent < 0 {
+			apparent = 0
+		}
+	}
+	var age time.Duration
+	if n, err := strconv.ParseInt(strings.TrimSpace(h.Get("Age")), 10, 64); err == nil && n > 0 {
+		age = time.Duration(n) * time.Second
+	}
+	corrected := age + responseTime.Sub(requestTime)
+	initial := apparent
+	if corrected > initial {
+		initial = corrected
+	}
+	return initial + now.Sub(responseTime)
+}
+
+// RetryAfter returns the wait asked for by a Retry-After header, given as
+// seconds or as a date relative to now. ok is false if it is missing or
+// malformed; a date in the past yields zero.
+func RetryAfter(h http.Header, now time.Time) (d time.Duration, ok bool) {
+	v := strings.TrimSpace(h.Get("Retry-After"))
+	if v == "" {
+		return 0, false
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if n < 0 {
+			return 0, false
+		}
+		return time.Duration(n) * time.Second, true
+	}
+	t, err := ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if d = t.Sub(now); d < 0 {
+		d = 0
+	}
+	return d, true
+}
@@ -0,0 +1,183 @@
+package httpheader
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+var sunday = time.Date(1994, 11, 6, 8, 49, 37, 0, time.UTC)
+
+func TestParseTime(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want time.Time
+		ok   bool
+	}{
+		{"Sun, 06 Nov 1994 08:49:37 GMT", sunday, true},
+		{"  Sun, 06 Nov 1994 08:49:37 GMT ", sunday, true},
+		{"Sunday, 06-Nov-94 08:49:37 GMT", sunday, true},
+		{"Sun Nov  6 08:49:37 1994", sunday, true},
+		{"Sun, 06 Nov 1994 09:49:37 +0100", sunday, true},
+		{"Sun, 6 Nov 1994 08:49:37 GMT", sunday, true},
+		{"Sun, 06-Nov-1994 08:49:37 GMT", sunday, true},
+		{"1994-11-06T08:49:37Z", sunday, true},
+		{"", time.Time{}, false},
+		{"0", time.Time{}, false},
+		{"-1", time.Time{}, false},
+		{"Sun, 06 Nov 1994", time.Time{}, false},
+		{"Sun, 32 Nov 1994 08:49:37 GMT", time.Time{}, false},
+		{"tomorrow", time.Time{}, false},
+	} {
+		got, err := ParseTime(tt.in)
+		if ok := err == nil; ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("ParseTime(%q) = %v, %v; want %v, ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+		if err != nil && err != ErrBadDate {
+			t.Errorf("ParseTime(%q) err = %v, want ErrBadDate", tt.in, err)
+		}
+		if err == nil && got.Location() != time.UTC {
+			t.Errorf("ParseTime(%q) in %v, want UTC", tt.in, got.Location())
+		}
+	}
+}
+
+func TestTime(t *testing.T) {
+	h := http.Header{"Date": {"Sun, 06 Nov 1994 08:49:37 GMT"}, "Expires": {"0"}}
+	if got, ok := Time(h, "Date"); !ok || !got.Equal(sunday) {
+		t.Errorf("Date = %v, %v", got, ok)
+	}
+	if _, ok := Time(h, "Expires"); ok {
+		t.Error("malformed Expires parsed")
+	}
+	if _, ok := Time(h, "Last-Modified"); ok {
+		t.Error("missing Last-Modified parsed")
+	}
+}
+
+func TestParseCacheControl(t *testing.T) {
+	for _, tt := range []struct {
+		lines []string
+		want  CacheControl
+	}{
+		{nil, CacheControl{}},
+		{[]string{"no-store"}, CacheControl{"no-store": ""}},
+		{[]string{"Max-Age=60, PUBLIC"}, CacheControl{"max-age": "60", "public": ""}},
+		{[]string{"max-age=60", "max-age=0, private"}, CacheControl{"max-age": "60", "private": ""}},
+		{[]string{`no-cache="Set-Cookie, Foo", max-age=5`}, CacheControl{"no-cache": "Set-Cookie, Foo", "max-age": "5"}},
+		{[]string{`private="a\", b", must-revalidate`}, CacheControl{"private": `a\", b`, "must-revalidate": ""}},
+		{[]string{" , ,=3, max-age = 7 ,"}, CacheControl{"max-age": "7"}},
+		{[]string{`no-cache="unterminated, max-age=5`}, CacheControl{"no-cache": `"unterminated, max-age=5`}},
+	} {
+		got := ParseCacheControl(http.Header{"Cache-Control": tt.lines})
+		if len(got) != len(tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.lines, got, tt.want)
+			continue
+		}
+		for k, v := range tt.want {
+			if g, ok := got[k]; !ok || g != v {
+				t.Errorf("%q: got %q, want %q", tt.lines, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestCacheControlSeconds(t *testing.T) {
+	cc := ParseCacheControl(http.Header{"Cache-Control": {`max-age=60, s-maxage="30", stale-if-error=x, min-fresh=-5, max-stale=99999999999999999999, no-cache`}})
+	for _, tt := range []struct {
+		name string
+		want time.Duration
+		ok   bool
+	}{
+		{"max-age", time.Minute, true},
+		{"s-maxage", 30 * time.Second, true},
+		{"stale-if-error", 0, false},
+		{"min-fresh", 0, false},
+		{"max-stale", 0, false}, // overflows int64
+		{"no-cache", 0, false},
+		{"missing", 0, false},
+	} {
+		if got, ok := cc.Seconds(tt.name); got != tt.want || ok != tt.ok {
+			t.Errorf("Seconds(%q) = %v, %v; want %v, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+	cc = CacheControl{"max-age": "9223372036854775807"}
+	if got, ok := cc.Seconds("max-age"); !ok || got <= 0 {
+		t.Errorf("huge max-age = %v, %v; want a positive capped duration", got, ok)
+	}
+	if !cc.Has("max-age") || cc.Has("no-store") {
+		t.Error("Has is wrong")
+	}
+}
+
+func TestFreshnessLifetime(t *testing.T) {
+	date := "Sun, 06 Nov 1994 08:49:37 GMT"
+	for _, tt := range []struct {
+		name string
+		h    http.Header
+		want time.Duration
+		ok   bool
+	}{
+		{"none", http.Header{"Date": {date}}, 0, false},
+		{"max-age", http.Header{"Cache-Control": {"max-age=300"}, "Expires": {"Sun, 06 Nov 1994 08:50:37 GMT"}, "Date": {date}}, 5 * time.Minute, true},
+		{"expires", http.Header{"Expires": {"Sun, 06 Nov 1994 08:50:37 GMT"}, "Date": {date}}, time.Minute, true},
+		{"expires in the past", http.Header{"Expires": {"Sun, 06 Nov 1994 08:48:37 GMT"}, "Date": {date}}, 0, true},
+		{"invalid expires", http.Header{"Expires": {"0"}, "Date": {date}}, 0, true},
+		{"expires without date", http.Header{"Expires": {date}}, 0, false},
+		{"malformed max-age", http.Header{"Cache-Control": {"max-age=soon"}, "Expires": {"Sun, 06 Nov 1994 08:50:37 GMT"}, "Date": {date}}, time.Minute, true},
+	} {
+		if got, ok := FreshnessLifetime(tt.h); got != tt.want || ok != tt.ok {
+			t.Errorf("%s: got %v, %v; want %v, %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestCurrentAge(t *testing.T) {
+	req := sunday
+	resp := sunday.Add(2 * time.Second)
+	now := resp.Add(10 * time.Second)
+	for _, tt := range []struct {
+		name string
+		h    http.Header
+		want time.Duration
+	}{
+		{"no headers", http.Header{}, 12 * time.Second},
+		{"age", http.Header{"Age": {"100"}}, 112 * time.Second},
+		{"old date", http.Header{"Date": {"Sun, 06 Nov 1994 08:48:37 GMT"}}, 72 * time.Second},
+		{"future date", http.Header{"Date": {"Sun, 06 Nov 1994 09:49:37 GMT"}}, 12 * time.Second},
+		{"malformed age", http.Header{"Age": {"old"}}, 12 * time.Second},
+		{"negative age", http.Header{"Age": {"-50"}}, 12 * time.Second},
+	} {
+		if got := CurrentAge(tt.h, req, resp, now); got != tt.want {
+			t.Errorf("%s: age %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := sunday
+	for _, tt := range []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"120", 2 * time.Minute, true},
+		{" 0 ", 0, true},
+		{"-1", 0, false},
+		{"1.5", 0, false},
+		{"Sun, 06 Nov 1994 08:50:37 GMT", time.Minute, true},
+		{"Sunday, 06-Nov-94 08:50:37 GMT", time.Minute, true},
+		{"Sun, 06 Nov 1994 08:00:00 GMT", 0, true},
+		{"soon", 0, false},
+	} {
+		h := http.Header{}
+		if tt.value != "" {
+			h.Set("Retry-After", tt.value)
+		}
+		if got, ok := RetryAfter(h, now); got != tt.want || ok != tt.ok {
+			t.Errorf("RetryAfter(%q) = %v, %v; want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package httpheader
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// QValue is one element of a weighted list such as Accept,
+// Accept-Encoding or Accept-Language. Value keeps any parameters other
+// than q, e.g. "text/html;level=1".
+type QValue struct {
+	Value string
+	Q     float64 // 0 to 1; 0 means "not acceptable"
+}
+
+// ParseQList parses all name lines of h. Elements keep their order;
+// those with a malformed q are dropped.
+func ParseQList(h http.Header, name string) []QValue {
+	var qs []QValue
+	for _, line := range h.Values(name) {
+		for line != "" {
+			var part string
+			part, line = nextDirective(line)
+			if q, ok := parseQValue(part); ok {
+				qs = append(qs, q)
+			}
+		}
+	}
+	return qs
+}
+
+func parseQValue(s string) (QValue, bool) {
+	params := strings.Split(s, ";")
+	qv := QValue{Q: 1}
+	kept := params[:0]
+	for _, p := range params {
+		p = strings.TrimSpace(p)
+		if i := strings.IndexByte(p, '='); i >= 0 && strings.EqualFold(strings.TrimSpace(p[:i]), "q") {
+			q, err := strconv.ParseFloat(strings.TrimSpace(p[i+1:]), 64)
+			if err != nil || q < 0 || q > 1 {
+				return QValue{}, false
+			}
+			qv.Q = q
+			continue
+		}
+		kept = append(kept, p)
+	}
+	qv.Value = strings.Join(kept, ";")
+	return qv, qv.Value != ""
+}
+
+// FormatQList formats qs for a request header, leaving out q=1. Weights
+// are rounded to the three decimals the syntax allows.
+func FormatQList(qs []QValue) string {
+	var b strings.Builder
+	for i, q := range qs {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(q.Value)
+		if q.Q < 1 {
+			b.WriteString(";q=")
+			v := strconv.FormatFloat(q.Q, 'f', 3, 64)
+			b.WriteString(strings.TrimRight(strings.TrimRight(v, "0"), "."))
+		}
+	}
+	return b.String()
+}
+
+// SortQList orders qs by descending q, keeping the original order among
+// equal weights.
+func SortQList(qs []QValue) {
+	sort.SliceStable(qs, func(i, j int) bool { return qs[i].Q > qs[j].Q })
+}
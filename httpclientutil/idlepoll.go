@@ -0,0 +1,258 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// ErrIdlePollerUnsupported is what NewIdlePoller returns where there is
+// neither epoll nor kqueue.
+var ErrIdlePollerUnsupported = errors.New("http: no shared poller on this platform")
+
+var errIdlePollerClosed = errors.New("http: poller closed")
+
+// IdlePoller watches the sockets of idle ClientConns on one epoll or
+// kqueue instance, so that a connection carrying no request does not hold
+// a goroutine blocked reading it. A pool keeping tens of thousands of idle
+// connections saves a goroutine stack for each. Pass it to connections
+// with WithIdlePoller, or to those of a pool in its ConnOptions.
+//
+// A ClientConn parks once no request is outstanding and nothing it
+// received is left unread, and its readLoop resumes when a request is
+// written, the socket becomes readable, say with an early response or the
+// server closing it, or the connection is closed or hijacked. Connections
+// whose socket cannot be reached, through TLS and the wrappers of Dialer
+// or not, run as usual. Close parked connections through their ClientConn
+// or Dialer.CloseTagged: epoll and kqueue drop a socket closed elsewhere
+// without a word, and cc would only notice on its next request.
+//
+// Only idle connections give up their goroutine. There is no io_uring or
+// other completion-based transport for busy ones: readLoop parses each
+// response as a stream, so it would keep a goroutine waiting on every
+// completion and save nothing over the runtime's own netpoller.
+type IdlePoller struct {
+	sys *sysPoller
+
+	mu     sync.Mutex
+	conns  map[int]*ClientConn // parked, by file descriptor
+	closed bool
+
+	done      chan struct{} // closed when loop returns
+	closeOnce sync.Once
+}
+
+// NewIdlePoller starts an IdlePoller. It fails with
+// ErrIdlePollerUnsupported on platforms other than Linux and the BSDs.
+func NewIdlePoller() (*IdlePoller, error) {
+	sys, err := openSysPoller()
+	if err != nil {
+		return nil, err
+	}
+	p := &IdlePoller{sys: sys, conns: make(map[int]*ClientConn), done: make(chan struct{})}
+	spawn("poller", p.loop)
+	return p, nil
+}
+
+// WithIdlePoller parks cc on p whenever it is idle.
+func WithIdlePoller(p *IdlePoller) Option {
+	return func(cc *ClientConn) { cc.poller = p }
+}
+
+// Close stops p. The connections parked on it resume their readLoops,
+// and no more connections park.
+func (p *IdlePoller) Close() error {
+	if p.shut() {
+		p.sys.wake()
+	}
+	<-p.done
+	var err error
+	p.closeOnce.Do(func() { err = p.sys.close() })
+	return err
+}
+
+// shut marks p closed and resumes its connections, unless p was closed
+// already.
+func (p *IdlePoller) shut() bool {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return false
+	}
+	p.closed = true
+	conns := p.conns
+	p.conns = nil
+	p.mu.Unlock()
+	for _, cc := range conns {
+		cc.unpark(false)
+	}
+	return true
+}
+
+func (p *IdlePoller) loop() {
+	defer close(p.done)
+	var ready []int
+	for {
+		var err error
+		ready, err = p.sys.wait(ready[:0])
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return
+		}
+		var woken []*ClientConn
+		for _, fd := range ready {
+			if cc := p.conns[fd]; cc != nil {
+				woken = append(woken, cc)
+			}
+		}
+		p.mu.Unlock()
+		for _, cc := range woken {
+			cc.unpark(true)
+		}
+		if err != nil {
+			// Nothing parks on a poller that cannot wait.
+			p.shut()
+			return
+		}
+	}
+}
+
+func (p *IdlePoller) add(cc *ClientConn, fd int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return errIdlePollerClosed
+	}
+	if err := p.sys.add(fd); err != nil {
+		return err
+	}
+	if stale := p.conns[fd]; stale != nil && stale != cc {
+		// The kernel reused the number, so the socket stale parked on
+		// was closed without an event. Let its readLoop find out.
+		spawn("stale conn wakeup", func() { stale.unpark(false) })
+	}
+	p.conns[fd] = cc
+	return nil
+}
+
+// remove stops watching fd, the socket of rc that cc parked, if it is
+// still open and cc's.
+func (p *IdlePoller) remove(cc *ClientConn, rc syscall.RawConn, fd int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || p.conns[fd] != cc {
+		return
+	}
+	delete(p.conns, fd)
+	rc.Control(func(uintptr) { p.sys.del(fd) })
+}
+
+// parkState is where a ClientConn stands with its IdlePoller; mu orders
+// parking against the requests and closes that end it.
+type parkState struct {
+	mu     sync.Mutex
+	parked bool
+	woken  bool // by the poller: read before parking again
+	rc     syscall.RawConn
+	fd     int
+}
+
+// park hands cc to its poller if it is idle, in which case readLoop must
+// return; unpark starts it again.
+func (cc *ClientConn) park(r *bufio.Reader) bool {
+	if cc.poller == nil {
+		return false
+	}
+	ps := &cc.parking
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.woken {
+		// The socket is readable, or was: wait for what comes, as
+		// without a poller.
+		ps.woken = false
+		return false
+	}
+	// beginExchange counts a request before it calls unpark, which waits
+	// for mu, so a request either shows here or finds cc parked.
+	if r.Buffered() > 0 || atomic.LoadInt32(&cc.active) > 0 || atomic.LoadInt32(&cc.unclaimed) > 0 || len(cc.reqch) > 0 {
+		return false
+	}
+	c, err := cc.writeConn()
+	if err != nil {
+		return false
+	}
+	sc, ok := innerConn(c).(syscall.Conn)
+	if !ok {
+		return false
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+	var fd int
+	var addErr error
+	if err := rc.Control(func(s uintptr) {
+		fd = int(s)
+		addErr = cc.poller.add(cc, fd)
+	}); err != nil || addErr != nil {
+		return false
+	}
+	ps.parked, ps.rc, ps.fd = true, rc, fd
+	return true
+}
+
+// unpark takes cc off its poller and restarts readLoop, if cc is parked.
+// woken tells that the poller found the socket readable.
+func (cc *ClientConn) unpark(woken bool) {
+	if cc.poller == nil {
+		return
+	}
+	ps := &cc.parking
+	ps.mu.Lock()
+	if !ps.parked {
+		ps.mu.Unlock()
+		return
+	}
+	ps.parked, ps.woken = false, woken
+	cc.poller.remove(cc, ps.rc, ps.fd)
+	ps.rc = nil
+	ps.mu.Unlock()
+	spawn("readLoop", cc.readLoop)
+}
+
+// closeNotifier is a connection that can be closed other than through
+// the ClientConn on it, as Dialer.CloseTagged does. The ClientConn must
+// unpark first: epoll and kqueue drop a closed socket without an event.
+type closeNotifier interface {
+	notifyClose(fn func())
+}
+
+// unparkOnClose has the closeNotifiers under c unpark cc before they
+// close.
+func (cc *ClientConn) unparkOnClose(c net.Conn) {
+	for {
+		if n, ok := c.(closeNotifier); ok {
+			n.notifyClose(func() { cc.unpark(false) })
+		}
+		nc, ok := c.(interface{ NetConn() net.Conn })
+		if !ok {
+			return
+		}
+		c = nc.NetConn()
+	}
+}
+
+// innerConn returns the connection under TLS and the wrappers of Dialer.
+func innerConn(c net.Conn) net.Conn {
+	for {
+		nc, ok := c.(interface{ NetConn() net.Conn })
+		if !ok {
+			return c
+		}
+		c = nc.NetConn()
+	}
+}
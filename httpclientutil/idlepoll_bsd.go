@@ -0,0 +1,78 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package httpclientutil
+
+import "syscall"
+
+// sysPoller is a kqueue, and a pipe that wakes its waiter.
+type sysPoller struct {
+	kq     int
+	wakeFD [2]int
+	events [128]syscall.Kevent_t
+}
+
+func openSysPoller() (*sysPoller, error) {
+	kq, err := syscall.Kqueue()
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseOnExec(kq)
+	s := &sysPoller{kq: kq}
+	if err := syscall.Pipe(s.wakeFD[:]); err != nil {
+		syscall.Close(kq)
+		return nil, err
+	}
+	for _, fd := range s.wakeFD {
+		syscall.CloseOnExec(fd)
+		syscall.SetNonblock(fd, true)
+	}
+	if err := s.change(s.wakeFD[0], syscall.EV_ADD); err != nil {
+		s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *sysPoller) change(fd, flags int) error {
+	var ev [1]syscall.Kevent_t
+	syscall.SetKevent(&ev[0], fd, syscall.EVFILT_READ, flags)
+	_, err := syscall.Kevent(s.kq, ev[:], nil, nil)
+	return err
+}
+
+// add watches fd until it is readable or hung up, once.
+func (s *sysPoller) add(fd int) error {
+	return s.change(fd, syscall.EV_ADD|syscall.EV_ONESHOT)
+}
+
+func (s *sysPoller) del(fd int) {
+	s.change(fd, syscall.EV_DELETE)
+}
+
+// wait blocks until some watched fds are ready or wake is called, and
+// appends the ready fds to ready.
+func (s *sysPoller) wait(ready []int) ([]int, error) {
+	n, err := syscall.Kevent(s.kq, nil, s.events[:], nil)
+	if err == syscall.EINTR {
+		return ready, nil
+	}
+	if err != nil {
+		return ready, err
+	}
+	for _, ev := range s.events[:n] {
+		if fd := int(ev.Ident); fd != s.wakeFD[0] {
+			ready = append(ready, fd)
+		}
+	}
+	return ready, nil
+}
+
+func (s *sysPoller) wake() {
+	syscall.Write(s.wakeFD[1], []byte{0})
+}
+
+func (s *sysPoller) close() error {
+	syscall.Close(s.wakeFD[0])
+	syscall.Close(s.wakeFD[1])
+	return syscall.Close(s.kq)
+}
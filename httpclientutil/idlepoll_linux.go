@@ -0,0 +1,66 @@
+package httpclientutil
+
+import "syscall"
+
+// sysPoller is an epoll instance, and a pipe that wakes its waiter.
+type sysPoller struct {
+	epfd   int
+	wakeFD [2]int
+	events [128]syscall.EpollEvent
+}
+
+func openSysPoller() (*sysPoller, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	s := &sysPoller{epfd: epfd}
+	if err := syscall.Pipe2(s.wakeFD[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		syscall.Close(epfd)
+		return nil, err
+	}
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(s.wakeFD[0])}
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, s.wakeFD[0], &ev); err != nil {
+		s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// add watches fd until it is readable or hung up, once.
+func (s *sysPoller) add(fd int) error {
+	ev := syscall.EpollEvent{Events: syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT, Fd: int32(fd)}
+	return syscall.EpollCtl(s.epfd, syscall.EPOLL_CTL_ADD, fd, &ev)
+}
+
+func (s *sysPoller) del(fd int) {
+	syscall.EpollCtl(s.epfd, syscall.EPOLL_CTL_DEL, fd, nil)
+}
+
+// wait blocks until some watched fds are ready or wake is called, and
+// appends the ready fds to ready.
+func (s *sysPoller) wait(ready []int) ([]int, error) {
+	n, err := syscall.EpollWait(s.epfd, s.events[:], -1)
+	if err == syscall.EINTR {
+		return ready, nil
+	}
+	if err != nil {
+		return ready, err
+	}
+	for _, ev := range s.events[:n] {
+		if int(ev.Fd) != s.wakeFD[0] {
+			ready = append(ready, int(ev.Fd))
+		}
+	}
+	return ready, nil
+}
+
+func (s *sysPoller) wake() {
+	syscall.Write(s.wakeFD[1], []byte{0})
+}
+
+func (s *sysPoller) close() error {
+	syscall.Close(s.wakeFD[0])
+	syscall.Close(s.wakeFD[1])
+	return syscall.Close(s.epfd)
+}
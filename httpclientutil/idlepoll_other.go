@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package httpclientutil
+
+type sysPoller struct{}
+
+func openSysPoller() (*sysPoller, error) { return nil, ErrIdlePollerUnsupported }
+
+func (s *sysPoller) add(fd int) error                { return ErrIdlePollerUnsupported }
+func (s *sysPoller) del(fd int)                      {}
+func (s *sysPoller) wait(ready []int) ([]int, error) { return ready, ErrIdlePollerUnsupported }
+func (s *sysPoller) wake()                           {}
+func (s *sysPoller) close() error                    { return nil }
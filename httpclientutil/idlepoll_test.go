@@ -0,0 +1,252 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestIdlePoller(t *testing.T) *IdlePoller {
+	t.Helper()
+	p, err := NewIdlePoller()
+	if err == ErrIdlePollerUnsupported {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func isParked(cc *ClientConn) bool {
+	cc.parking.mu.Lock()
+	defer cc.parking.mu.Unlock()
+	return cc.parking.parked
+}
+
+func liveReadLoops() int {
+	return live.snapshot()["readLoop"]
+}
+
+func TestIdlePollerParksIdleConns(t *testing.T) {
+	Verify(t)
+	p := newTestIdlePoller(t)
+	before := liveReadLoops()
+	var conns []*ClientConn
+	for i := 0; i < 50; i++ {
+		cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+			for answer(c, br, "ok") {
+			}
+		}, WithIdlePoller(p))
+		conns = append(conns, cc)
+	}
+	for round := 0; round < 3; round++ {
+		for _, cc := range conns {
+			if got := doBody(t, cc); got != "ok" {
+				t.Fatalf("body = %q", got)
+			}
+		}
+		for _, cc := range conns {
+			waitFor(t, "the conn to park", func() bool { return isParked(cc) })
+		}
+		if n := liveReadLoops() - before; n != 0 {
+			t.Errorf("round %d: %d readLoops running with every conn idle", round, n)
+		}
+	}
+}
+
+func TestIdlePollerWakesParkedConn(t *testing.T) {
+	Verify(t)
+	p := newTestIdlePoller(t)
+
+	// The server closing the connection.
+	closeNow := make(chan struct{})
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		answer(c, br, "ok")
+		<-closeNow
+	}, WithIdlePoller(p))
+	doBody(t, cc)
+	waitFor(t, "the conn to park", func() bool { return isParked(cc) })
+	close(closeNow)
+	waitFor(t, "the close to be noticed", func() bool { return !cc.Reusable() })
+	if err := cc.Ping(); err != ErrServerClosedConn {
+		t.Errorf("Ping = %v, want ErrServerClosedConn", err)
+	}
+
+	// An early response.
+	sendNow := make(chan struct{})
+	cc = rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		answer(c, br, "ok")
+		<-sendNow
+		writeResponse(c, "early")
+		br.ReadByte()
+	}, WithIdlePoller(p))
+	cc.SetEarlyResponsePolicy(EarlyResponseFail, 0)
+	doBody(t, cc)
+	waitFor(t, "the conn to park", func() bool { return isParked(cc) })
+	close(sendNow)
+	waitFor(t, "the early response to be noticed", func() bool { return !cc.Reusable() })
+	if err := cc.Ping(); err != ErrEarlyResponse {
+		t.Errorf("Ping = %v, want ErrEarlyResponse", err)
+	}
+}
+
+func TestIdlePollerCloseAndHijackParked(t *testing.T) {
+	Verify(t)
+	p := newTestIdlePoller(t)
+	serve := func(c net.Conn, br *bufio.Reader) {
+		for answer(c, br, "ok") {
+		}
+	}
+	cc := rawServer(t, serve, WithIdlePoller(p))
+	doBody(t, cc)
+	waitFor(t, "the conn to park", func() bool { return isParked(cc) })
+	cc.Close()
+	select {
+	case <-cc.readDone:
+	case <-time.After(time.Second):
+		t.Fatal("readLoop did not stop after Close")
+	}
+
+	cc = rawServer(t, serve, WithIdlePoller(p))
+	doBody(t, cc)
+	waitFor(t, "the conn to park", func() bool { return isParked(cc) })
+	done := make(chan net.Conn)
+	go func() {
+		c, _ := cc.Hijack()
+		done <- c
+	}()
+	select {
+	case c := <-done:
+		defer c.Close()
+		io.WriteString(c, "GET / HTTP/1.1\r\nHost: a\r\n\r\n")
+		resp, err := http.ReadResponse(bufio.NewReader(c), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	case <-time.After(time.Second):
+		t.Fatal("Hijack of a parked conn hangs")
+	}
+}
+
+func TestIdlePollerClose(t *testing.T) {
+	Verify(t)
+	p := newTestIdlePoller(t)
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		for answer(c, br, "ok") {
+		}
+	}, WithIdlePoller(p))
+	doBody(t, cc)
+	waitFor(t, "the conn to park", func() bool { return isParked(cc) })
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if isParked(cc) {
+		t.Fatal("conn still parked after IdlePoller.Close")
+	}
+	for i := 0; i < 3; i++ {
+		if got := doBody(t, cc); got != "ok" {
+			t.Fatalf("body = %q", got)
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	if isParked(cc) {
+		t.Error("conn parked on a closed Poller")
+	}
+	if err := p.Close(); err != nil {
+		t.Errorf("second Close = %v", err)
+	}
+}
+
+func TestIdlePollerPool(t *testing.T) {
+	Verify(t)
+	p := newTestIdlePoller(t)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "pooled")
+	}))
+	defer s.Close()
+	pool := &ClientConnPool{ConnOptions: []Option{WithIdlePoller(p)}}
+	defer pool.Close()
+	for i := 0; i < 5; i++ {
+		req, _ := http.NewRequest("GET", s.URL+"/", nil)
+		resp, err := pool.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(b) != "pooled" {
+			t.Fatalf("body = %q", b)
+		}
+		time.Sleep(5 * time.Millisecond) // let it park between requests
+	}
+}
+
+func TestIdlePollerCloseTagged(t *testing.T) {
+	Verify(t)
+	p := newTestIdlePoller(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		br := bufio.NewReader(c)
+		for answer(c, br, "ok") {
+		}
+	}()
+	d := new(Dialer)
+	cc, err := d.DialConn(WithConnTag(context.Background(), "job"), "tcp", ln.Addr().String(), nil, WithIdlePoller(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	doBody(t, cc)
+	waitFor(t, "the conn to park", func() bool { return isParked(cc) })
+	if n := d.CloseTagged("job"); n != 1 {
+		t.Fatalf("CloseTagged closed %d conns", n)
+	}
+	waitFor(t, "the close to be noticed", func() bool { return !cc.Reusable() })
+	if err := cc.Ping(); err != ErrServerClosedConn {
+		t.Errorf("Ping = %v, want ErrServerClosedConn", err)
+	}
+}
+
+func TestIdlePollerRemoveKeepsReusedFD(t *testing.T) {
+	Verify(t)
+	p := newTestIdlePoller(t)
+	serve := func(c net.Conn, br *bufio.Reader) {
+		for answer(c, br, "ok") {
+		}
+	}
+	cc, other := rawServer(t, serve, WithIdlePoller(p)), rawServer(t, serve, WithIdlePoller(p))
+	doBody(t, cc)
+	waitFor(t, "the conn to park", func() bool { return isParked(cc) })
+	cc.parking.mu.Lock()
+	fd := cc.parking.fd
+	cc.parking.mu.Unlock()
+	// Pretend the kernel handed fd to other since.
+	p.mu.Lock()
+	p.conns[fd] = other
+	p.mu.Unlock()
+	doBody(t, cc) // unparks cc
+	p.mu.Lock()
+	got := p.conns[fd]
+	delete(p.conns, fd)
+	p.mu.Unlock()
+	if got != other {
+		t.Error("unparking a conn removed the entry of the conn that reused its fd")
+	}
+}
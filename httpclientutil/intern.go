@@ -0,0 +1,61 @@
+package httpclientutil
+
+import "net/http"
+
+// DefaultInternedHeaders are the response headers a ClientConn interns
+// unless SetInternedHeaders says otherwise.
+var DefaultInternedHeaders = []string{
+	"Content-Type", "Content-Encoding", "Cache-Control", "Server",
+	"Vary", "Accept-Ranges", "Connection",
+}
+
+// maxInternedValues bounds the distinct values remembered per connection,
+// so a header with ever-changing values cannot grow the table.
+const maxInternedValues = 512
+
+// SetInternedHeaders sets the response headers whose values cc interns:
+// a value seen before on this connection is replaced by the earlier
+// string, so responses kept around share one copy instead of one per
+// response. Nil or empty names turn interning off.
+func (cc *ClientConn) SetInternedHeaders(names []string) {
+	var hi *headerInterner
+	if len(names) > 0 {
+		hi = newHeaderInterner(names)
+	}
+	cc.mu.Lock()
+	cc.interner = hi
+	cc.mu.Unlock()
+}
+
+type headerInterner struct {
+	names  []string
+	values map[string]string
+}
+
+func newHeaderInterner(names []string) *headerInterner {
+	hi := &headerInterner{values: make(map[string]string)}
+	for _, name := range names {
+		hi.names = append(hi.names, http.CanonicalHeaderKey(name))
+	}
+	return hi
+}
+
+// intern is only called from readLoop.
+func (hi *headerInterner) intern(h http.Header) {
+	for _, name := range hi.names {
+		vv := h[name]
+		for i, v := range vv {
+			if s, ok := hi.values[v]; ok {
+				vv[i] = s
+			} else if len(hi.values) < maxInternedValues {
+				hi.values[v] = v
+			}
+		}
+	}
+}
+
+func (cc *ClientConn) getInterner() *headerInterner {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return cc.interner
+}
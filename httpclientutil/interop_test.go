@@ -0,0 +1,212 @@
+//go:build interop
+
+package httpclientutil
+
+// The interop tests run ClientConn against real servers in containers:
+//
+//	go test -tags interop -run Interop ./httpclientutil
+//
+// They need docker on the PATH. To test a server already running instead,
+// set INTEROP_<NAME>_ADDR, e.g. INTEROP_NGINX_ADDR=127.0.0.1:8080, and
+// INTEROP_<NAME>_IMAGE picks another image.
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+var interopServers = []struct {
+	name, image string
+}{
+	{"nginx", "nginx:alpine"},
+	{"apache", "httpd:alpine"},
+	{"caddy", "caddy:alpine"},
+	{"h2o", "lkwg82/h2o-http2-server"},
+}
+
+func TestInterop(t *testing.T) {
+	for _, s := range interopServers {
+		s := s
+		t.Run(s.name, func(t *testing.T) {
+			addr := interopAddr(t, s.name, s.image)
+			for _, c := range []struct {
+				name string
+				fn   func(*testing.T, string)
+			}{
+				{"keep-alive", interopKeepAlive},
+				{"chunked", interopChunked},
+				{"100-continue", interopContinue},
+				{"close", interopClose},
+			} {
+				t.Run(c.name, func(t *testing.T) { c.fn(t, addr) })
+			}
+		})
+	}
+}
+
+// interopAddr returns where the server called name listens, starting a
+// container of image for it unless the environment names one.
+func interopAddr(t *testing.T, name, image string) string {
+	env := "INTEROP_" + strings.ToUpper(name)
+	if addr := os.Getenv(env + "_ADDR"); addr != "" {
+		return addr
+	}
+	if img := os.Getenv(env + "_IMAGE"); img != "" {
+		image = img
+	}
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker not found; set " + env + "_ADDR")
+	}
+	out, err := exec.Command("docker", "run", "-d", "--rm", "-p", "127.0.0.1::80", image).Output()
+	if err != nil {
+		t.Fatalf("starting %s: %v", image, err)
+	}
+	id := strings.TrimSpace(string(out))
+	t.Cleanup(func() { exec.Command("docker", "rm", "-f", id).Run() })
+	out, err = exec.Command("docker", "port", id, "80/tcp").Output()
+	if err != nil {
+		t.Fatalf("port of %s: %v", image, err)
+	}
+	addr := strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		// The port is published before the server listens; a request tells.
+		cc, err := DialConn(context.Background(), "tcp", addr, nil)
+		if err == nil {
+			resp, err := interopGet(cc, addr, false)
+			cc.Close()
+			if err == nil {
+				resp.Body.Close()
+				return addr
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s did not come up on %s", image, addr)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+func dialInterop(t *testing.T, addr string) *ClientConn {
+	t.Helper()
+	cc, err := DialConn(context.Background(), "tcp", addr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return cc
+}
+
+func interopGet(cc *ClientConn, addr string, closeConn bool) (*http.Response, error) {
+	req, _ := http.NewRequest("GET", "http://"+addr+"/", nil)
+	req.Close = closeConn
+	return cc.Do(req)
+}
+
+// drain reads resp to its end and reports whether the server meant to
+// keep the connection.
+func drain(t *testing.T, resp *http.Response) bool {
+	t.Helper()
+	defer resp.Body.Close()
+	if _, err := io.Copy(io.Discard, resp.Body); err != nil {
+		t.Fatalf("reading %s body: %v", resp.Status, err)
+	}
+	return !resp.Close
+}
+
+// agrees checks that cc is as reusable as the server said it would be,
+// and that a request on it then succeeds.
+func agrees(t *testing.T, cc *ClientConn, addr string, keep bool) {
+	t.Helper()
+	if cc.Reusable() != keep {
+		t.Fatalf("Reusable = %v, the server %s keep-alive", cc.Reusable(), map[bool]string{true: "offered", false: "refused"}[keep])
+	}
+	if !keep {
+		return
+	}
+	resp, err := interopGet(cc, addr, false)
+	if err != nil {
+		t.Fatalf("request after: %v", err)
+	}
+	drain(t, resp)
+}
+
+func interopKeepAlive(t *testing.T, addr string) {
+	cc := dialInterop(t, addr)
+	for i := 0; i < 3; i++ {
+		resp, err := interopGet(cc, addr, false)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		if !drain(t, resp) {
+			t.Fatalf("request %d: server closed the connection", i)
+		}
+	}
+}
+
+// interopChunked sends a body of unknown length, which goes out chunked.
+// Static servers refuse or ignore it, but must consume it either way.
+func interopChunked(t *testing.T, addr string) {
+	cc := dialInterop(t, addr)
+	body := io.MultiReader(strings.NewReader("hello, "), bytes.NewReader(bytes.Repeat([]byte("x"), 64<<10)))
+	req, _ := http.NewRequest("POST", "http://"+addr+"/", body)
+	resp, err := cc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	agrees(t, cc, addr, drain(t, resp))
+}
+
+func interopContinue(t *testing.T, addr string) {
+	cc := dialInterop(t, addr)
+	req, _ := http.NewRequest("PUT", "http://"+addr+"/upload", strings.NewReader("payload"))
+	req.Header.Set("Expect", "100-continue")
+	continued := false
+	trace := &httptrace.ClientTrace{Got100Continue: func() { continued = true }}
+	resp, err := cc.Do(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keep := drain(t, resp)
+	if !continued {
+		// The server answered before the body, and may still expect
+		// it, so cc gives up the connection whatever it said.
+		t.Logf("final response %s without 100 Continue", resp.Status)
+		keep = false
+	}
+	agrees(t, cc, addr, keep)
+}
+
+func interopClose(t *testing.T, addr string) {
+	cc := dialInterop(t, addr)
+	resp, err := interopGet(cc, addr, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drain(t, resp)
+	if cc.Reusable() {
+		t.Fatal("reusable after Connection: close")
+	}
+	if _, err := interopGet(cc, addr, false); err != ErrPersistEOF && err != ErrServerClosedConn {
+		t.Errorf("request after close: err = %v", err)
+	}
+	c, r := cc.Hijack()
+	if c == nil {
+		return
+	}
+	// The server closes its end too.
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = r.ReadByte()
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Error("server kept the connection open after Connection: close")
+	}
+}
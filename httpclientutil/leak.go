@@ -0,0 +1,113 @@
+package httpclientutil
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TB is the part of testing.TB that Verify uses.
+type TB interface {
+	Helper()
+	Cleanup(func())
+	Errorf(format string, args ...interface{})
+}
+
+// verifyGrace is how long Verify waits for goroutines to wind down: one
+// that a Close woke up still has to notice.
+var verifyGrace = 2 * time.Second
+
+// Verify checks at the end of test t that every goroutine and timer this
+// package started during the test is gone: the readLoop of each
+// ClientConn, the goroutines writing, watching or handing over requests,
+// the response header, idle and coalescing timers, and those of dialers,
+// pools and the other helpers. Call it first thing in the test, so that
+// the check runs after the test's deferred calls and other cleanups have
+// closed what it opened. A ClientConn, pool or body left open fails it.
+//
+// The accounting is global, so tests calling Verify must not run in
+// parallel with tests using this package.
+func Verify(t TB) {
+	t.Helper()
+	before := live.snapshot()
+	t.Cleanup(func() {
+		t.Helper()
+		deadline := time.Now().Add(verifyGrace)
+		for {
+			leaked := live.since(before)
+			if leaked == "" {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Errorf("httpclientutil: still running after the test: %s", leaked)
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+// live counts the running goroutines and armed timers of the package by
+// name.
+var live = &liveSet{n: make(map[string]int)}
+
+type liveSet struct {
+	mu sync.Mutex
+	n  map[string]int
+}
+
+func (s *liveSet) add(name string, delta int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.n[name] += delta
+}
+
+func (s *liveSet) snapshot() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := make(map[string]int, len(s.n))
+	for name, c := range s.n {
+		n[name] = c
+	}
+	return n
+}
+
+// since describes what runs now in excess of before, or returns "".
+func (s *liveSet) since(before map[string]int) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var leaked []string
+	for name, c := range s.n {
+		if extra := c - before[name]; extra > 0 {
+			leaked = append(leaked, fmt.Sprintf("%d %s", extra, name))
+		}
+	}
+	sort.Strings(leaked)
+	return strings.Join(leaked, ", ")
+}
+
+// spawn runs fn in a goroutine that Verify accounts for under name.
+func spawn(name string, fn func()) {
+	live.add(name, 1)
+	go func() {
+		defer live.add(name, -1)
+		fn()
+	}()
+}
+
+// trackedTimer is a timer started by afterFunc, counted from the start
+// until it is stopped or its function returns.
+type trackedTimer struct {
+	stopper
+	name string
+}
+
+func (t *trackedTimer) Stop() bool {
+	stopped := t.stopper.Stop()
+	if stopped {
+		live.add(t.name, -1)
+	}
+	return stopped
+}
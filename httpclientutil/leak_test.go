@@ -0,0 +1,125 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingTB is a TB whose cleanups run when the test calls end.
+type recordingTB struct {
+	cleanups []func()
+	errors   []string
+}
+
+func (tb *recordingTB) Helper()           {}
+func (tb *recordingTB) Cleanup(fn func()) { tb.cleanups = append(tb.cleanups, fn) }
+func (tb *recordingTB) Errorf(format string, args ...interface{}) {
+	tb.errors = append(tb.errors, fmt.Sprintf(format, args...))
+}
+
+func (tb *recordingTB) end() {
+	for i := len(tb.cleanups) - 1; i >= 0; i-- {
+		tb.cleanups[i]()
+	}
+}
+
+func TestVerifyReportsLeak(t *testing.T) {
+	defer func(d time.Duration) { verifyGrace = d }(verifyGrace)
+	verifyGrace = 50 * time.Millisecond
+	tb := new(recordingTB)
+	Verify(tb)
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	cc := NewClientConn(c1, WithIdleTimeout(time.Hour))
+	tb.end()
+	if len(tb.errors) != 1 || !strings.Contains(tb.errors[0], "1 readLoop") || !strings.Contains(tb.errors[0], "1 idle timer") {
+		t.Errorf("Verify reported %q, want the readLoop and idle timer of an open ClientConn", tb.errors)
+	}
+
+	cc.Close()
+	tb = new(recordingTB)
+	Verify(tb)
+	tb.end()
+	if len(tb.errors) != 0 {
+		t.Errorf("Verify reported %q with nothing started", tb.errors)
+	}
+}
+
+func TestVerifyClosedMidBody(t *testing.T) {
+	Verify(t)
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		http.ReadRequest(br)
+		io.WriteString(c, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nhello")
+		br.ReadByte()
+	}, WithIdleTimeout(time.Hour), WithResponseHeaderTimeout(time.Hour))
+	req, _ := http.NewRequest("GET", "http://a.example/", nil)
+	if _, err := cc.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	// Closing with the body unread ends the exchange after cc was closed,
+	// which must not arm the idle timer again.
+	cc.Close()
+}
+
+func TestVerifyClosedWithPipelinedRequest(t *testing.T) {
+	Verify(t)
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		http.ReadRequest(br)
+		http.ReadRequest(br)
+		io.WriteString(c, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nhello")
+		br.ReadByte()
+	}, WithResponseHeaderTimeout(time.Hour))
+	first, _ := http.NewRequest("GET", "http://a.example/1", nil)
+	second, _ := http.NewRequest("GET", "http://a.example/2", nil)
+	for _, req := range []*http.Request{first, second} {
+		if err := cc.Write(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := cc.Read(first); err != nil {
+		t.Fatal(err)
+	}
+	// The second request never reaches readLoop; its header timer must
+	// stop all the same.
+	cc.Close()
+	if _, err := cc.Read(second); err == nil {
+		t.Error("Read after Close succeeded")
+	}
+}
+
+func TestVerifyCoalescingAndPool(t *testing.T) {
+	Verify(t)
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		for answer(c, br, "ok") {
+		}
+	}, WithIdleTimeout(time.Hour), WithResponseHeaderTimeout(time.Hour))
+	cc.SetWriteCoalescing(time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if got := doBody(t, cc); got != "ok" {
+			t.Fatalf("body = %q", got)
+		}
+	}
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer s.Close()
+	p := &ClientConnPool{IdleTimeout: time.Hour}
+	defer p.Close()
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("GET", s.URL+"/", nil)
+		resp, err := p.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
@@ -0,0 +1,58 @@
+package httpclientutil
+
+import (
+	"net/http"
+	"net/http/httputil"
+)
+
+// ErrLineTooLong is the error a response body reports for a malformed
+// chunked encoding, the same value as httputil.ErrLineTooLong.
+var ErrLineTooLong = httputil.ErrLineTooLong
+
+// Write, Read and Pending complete the API of the deprecated
+// httputil.ClientConn, which ClientConn otherwise shares: Do, Hijack and
+// Close take the same arguments, and ErrPersistEOF, ErrClosed and
+// ErrPipeline mean the same. Code written against it migrates by changing
+// the import; a NewClientConn or NewProxyClientConn call passing a reader
+// other than nil passes WithReader(r) instead.
+
+// Write writes req, for a later Read to collect the response. Requests
+// written back to back are pipelined, as with httputil.ClientConn, whether
+// or not SetPipelining was called. Write does not go through the write
+// coalescer.
+func (cc *ClientConn) Write(req *http.Request) error {
+	pr, err := cc.write(req, true)
+	if err != nil {
+		return err
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.written == nil {
+		cc.written = make(map[*http.Request]*pendingReq)
+	}
+	cc.written[req] = pr
+	return nil
+}
+
+// Read returns the response to req, which Write sent. It waits for the
+// bodies of the responses before it to be read or closed. A request Write
+// did not send, or whose response was read already, fails with
+// ErrPipeline.
+func (cc *ClientConn) Read(req *http.Request) (*http.Response, error) {
+	cc.mu.Lock()
+	pr, ok := cc.written[req]
+	delete(cc.written, req)
+	cc.mu.Unlock()
+	if !ok {
+		return nil, ErrPipeline
+	}
+	return cc.read(pr)
+}
+
+// Pending returns the number of requests Write sent whose responses have
+// not been collected by Read.
+func (cc *ClientConn) Pending() int {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	return len(cc.written)
+}
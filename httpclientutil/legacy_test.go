@@ -0,0 +1,70 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWriteRead(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		for {
+			req, err := http.ReadRequest(br)
+			if err != nil {
+				return
+			}
+			writeResponse(c, req.URL.Path)
+		}
+	})
+	// Each Write returns though the bodies before it are unread.
+	var reqs []*http.Request
+	for _, path := range []string{"/a", "/b", "/c"} {
+		req, _ := http.NewRequest("GET", "http://a.example"+path, nil)
+		if err := cc.Write(req); err != nil {
+			t.Fatal(err)
+		}
+		reqs = append(reqs, req)
+	}
+	if n := cc.Pending(); n != 3 {
+		t.Errorf("Pending = %d, want 3", n)
+	}
+	for i, req := range reqs {
+		resp, err := cc.Read(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(b) != req.URL.Path {
+			t.Errorf("response %d = %q", i, b)
+		}
+	}
+	if n := cc.Pending(); n != 0 {
+		t.Errorf("Pending = %d after reading", n)
+	}
+	if _, err := cc.Read(reqs[0]); err != ErrPipeline {
+		t.Errorf("second Read of a request: err = %v, want ErrPipeline", err)
+	}
+}
+
+func TestErrLineTooLong(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		if _, err := http.ReadRequest(br); err != nil {
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n1;"+strings.Repeat("x", 5000)+"\r\n")
+	})
+	req, _ := http.NewRequest("GET", "http://a.example/", nil)
+	resp, err := cc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if _, err := io.ReadAll(resp.Body); !errors.Is(err, ErrLineTooLong) {
+		t.Errorf("err = %v, want ErrLineTooLong", err)
+	}
+}
@@ -0,0 +1,76 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestLengthMismatch(t *testing.T) {
+	for _, tt := range []struct {
+		name, raw string
+		want      *LengthMismatchError
+	}{
+		{"short", "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nabc", &LengthMismatchError{Declared: 10, Read: 3}},
+		{"long", "HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nabcdef", &LengthMismatchError{Declared: 3, Read: 3, Extra: true}},
+		{"exact", "HTTP/1.1 200 OK\r\nContent-Length: 3\r\n\r\nabc", nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			hold := make(chan struct{})
+			cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+				if _, err := http.ReadRequest(br); err != nil {
+					return
+				}
+				io.WriteString(c, tt.raw)
+				if tt.want != nil && !tt.want.Extra {
+					return // close to cut the body short
+				}
+				<-hold
+			})
+			defer close(hold)
+			req, _ := http.NewRequest("GET", "http://a.example/", nil)
+			resp, err := cc.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if tt.want == nil {
+				if err != nil || !cc.Reusable() || cc.Stats().LengthMismatches != 0 {
+					t.Fatalf("err = %v, reusable %v, stats %+v", err, cc.Reusable(), cc.Stats())
+				}
+				return
+			}
+			var lm *LengthMismatchError
+			if !errors.As(err, &lm) || *lm != *tt.want || !errors.Is(err, ErrLengthMismatch) {
+				t.Fatalf("err = %#v, want %#v", err, tt.want)
+			}
+			if cc.Reusable() {
+				t.Error("connection reusable after a length mismatch")
+			}
+			if n := cc.Stats().LengthMismatches; n != 1 {
+				t.Errorf("LengthMismatches = %d, want 1", n)
+			}
+		})
+	}
+}
+
+func TestLengthCheckPipelined(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		http.ReadRequest(br)
+		// The second response arrives with the first body.
+		io.WriteString(c, "HTTP/1.1 200 OK\r\nContent-Length: 1\r\n\r\naHTTP/1.1 200 OK\r\nContent-Length: 1\r\n\r\nb")
+		http.ReadRequest(br)
+	})
+	for _, want := range []string{"a", "b"} {
+		if got := doBody(t, cc); got != want {
+			t.Fatalf("body = %q, want %q", got, want)
+		}
+	}
+	if n := cc.Stats().LengthMismatches; n != 0 {
+		t.Errorf("LengthMismatches = %d for a back-to-back response", n)
+	}
+}
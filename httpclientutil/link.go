@@ -0,0 +1,247 @@
+package httpclientutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrStopPaging is returned by a Paginate callback to stop without error.
+var ErrStopPaging = errors.New("http: stop paging")
+
+// Link is one link of a Link header (RFC 8288).
+type Link struct {
+	URL    string
+	Rel    []string          // relation types, lower-cased
+	Params map[string]string // other parameters, keyed lower-case
+}
+
+// HasRel reports whether l has relation type rel.
+func (l Link) HasRel(rel string) bool {
+	for _, r := range l.Rel {
+		if strings.EqualFold(r, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseLinks parses all Link header lines of h. Malformed links are
+// skipped.
+func ParseLinks(h http.Header) []Link {
+	var links []Link
+	for _, line := range h.Values("Link") {
+		for {
+			line = strings.TrimLeft(line, " \t,")
+			if !strings.HasPrefix(line, "<") {
+				break
+			}
+			end := strings.IndexByte(line, '>')
+			if end < 0 {
+				break
+			}
+			l := Link{URL: line[1:end], Params: map[string]string{}}
+			line = line[end+1:]
+			for {
+				line = strings.TrimLeft(line, " \t")
+				if !strings.HasPrefix(line, ";") {
+					break
+				}
+				var name, val string
+				name, val, line = nextLinkParam(line[1:])
+				if name == "rel" {
+					if l.Rel == nil {
+						l.Rel = strings.Fields(strings.ToLower(val))
+					}
+				} else if _, dup := l.Params[name]; !dup && name != "" {
+					l.Params[name] = val
+				}
+			}
+			links = append(links, l)
+		}
+	}
+	return links
+}
+
+// nextLinkParam parses `name[=value|="value"]` from the front of s.
+func nextLinkParam(s string) (name, val, rest string) {
+	i := strings.IndexAny(s, "=;,")
+	if i < 0 || s[i] != '=' {
+		if i < 0 {
+			i = len(s)
+		}
+		return strings.ToLower(strings.TrimSpace(s[:i])), "", s[i:]
+	}
+	name = strings.ToLower(strings.TrimSpace(s[:i]))
+	s = strings.TrimLeft(s[i+1:], " \t")
+	if strings.HasPrefix(s, `"`) {
+		var b strings.Builder
+		for j := 1; j < len(s); j++ {
+			switch c := s[j]; c {
+			case '\\':
+				if j+1 < len(s) {
+					j++
+					b.WriteByte(s[j])
+				}
+			case '"':
+				return name, b.String(), s[j+1:]
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return name, b.String(), ""
+	}
+	j := strings.IndexAny(s, ";,")
+	if j < 0 {
+		j = len(s)
+	}
+	return name, strings.TrimSpace(s[:j]), s[j:]
+}
+
+// Paginate sends req through d and keeps following its rel="next" link,
+// calling fn with every page until there is no next link or fn returns an
+// error; ErrStopPaging ends the walk without one. Follow-up requests are
+// GETs that keep req's header. Each body is drained and closed after fn
+// returns, so pages can share one connection. A next link to another
+// origin is followed without the Authorization and Cookie headers.
+// Statuses other than 2xx end the walk with an error.
+func Paginate(ctx context.Context, d Doer, req *http.Request, fn func(*http.Response) error) error {
+	return paginate(ctx, d, req, fn, false)
+}
+
+// PaginatePrefetch is Paginate, but the request for the next page goes out
+// as soon as the current page's header names it, so the server works on it
+// while fn reads the current page. d must run concurrent requests on
+// separate connections, as ClientConnPool and http.Client do; a single
+// ClientConn has the prefetch wait behind the body fn is reading. When the
+// walk ends early the prefetched request is canceled.
+func PaginatePrefetch(ctx context.Context, d Doer, req *http.Request, fn func(*http.Response) error) error {
+	return paginate(ctx, d, req, fn, true)
+}
+
+func paginate(ctx context.Context, d Doer, req *http.Request, fn func(*http.Response) error, prefetch bool) error {
+	seen := make(map[string]bool)
+	var next *pageFetch
+	for {
+		seen[req.URL.String()] = true
+		if next == nil {
+			next = fetchPage(ctx, d, req, false)
+		}
+		resp, err := next.wait()
+		done := next.cancel
+		next = nil
+		if err != nil {
+			done(nil)
+			return err
+		}
+		if resp.StatusCode/100 != 2 {
+			drainBody(resp)
+			done(nil)
+			return fmt.Errorf("http: paginating %s: unexpected status %s", req.URL, resp.Status)
+		}
+		nextReq, nextErr := nextPage(req, resp, seen)
+		if prefetch && nextReq != nil {
+			next = fetchPage(ctx, d, nextReq, true)
+		}
+		err = fn(resp)
+		drainBody(resp)
+		done(nil)
+		if err != nil {
+			if next != nil {
+				next.abandon()
+			}
+			if err == ErrStopPaging {
+				return nil
+			}
+			return err
+		}
+		if nextReq == nil {
+			return nextErr
+		}
+		req = nextReq
+	}
+}
+
+// nextPage returns the request for the page resp's next link names, or
+// nil with the reason the walk cannot go on, nil at the last page.
+func nextPage(req *http.Request, resp *http.Response, seen map[string]bool) (*http.Request, error) {
+	var next string
+	for _, l := range ParseLinks(resp.Header) {
+		if l.HasRel("next") {
+			next = l.URL
+			break
+		}
+	}
+	if next == "" {
+		return nil, nil
+	}
+	u, err := req.URL.Parse(next)
+	if err != nil {
+		return nil, err
+	}
+	if seen[u.String()] {
+		return nil, fmt.Errorf("http: paginating %s: next link loops back", u)
+	}
+	header := req.Header.Clone()
+	if u.Scheme != req.URL.Scheme || u.Host != req.URL.Host {
+		// Like net/http on redirects, don't hand credentials to
+		// another origin the server points at.
+		for _, name := range crossOriginStripHeaders {
+			header.Del(name)
+		}
+	}
+	return &http.Request{
+		Method:     "GET",
+		URL:        u,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Host:       u.Host,
+	}, nil
+}
+
+// pageFetch is a page request under its own context, which is canceled
+// once the page was read, or sooner to abandon it.
+type pageFetch struct {
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+	resp   *http.Response
+	err    error
+}
+
+// fetchPage sends req through d, on its own goroutine with async.
+func fetchPage(ctx context.Context, d Doer, req *http.Request, async bool) *pageFetch {
+	ctx, cancel := context.WithCancelCause(ctx)
+	f := &pageFetch{cancel: cancel, done: make(chan struct{})}
+	do := func() {
+		defer close(f.done)
+		f.resp, f.err = d.Do(req.WithContext(ctx))
+	}
+	if async {
+		spawn("page fetch", do)
+	} else {
+		do()
+	}
+	return f
+}
+
+func (f *pageFetch) wait() (*http.Response, error) {
+	<-f.done
+	return f.resp, f.err
+}
+
+// abandon cancels the request and closes the response it may still get.
+func (f *pageFetch) abandon() {
+	f.cancel(errPageAbandoned)
+	spawn("abandoned page", func() {
+		if resp, err := f.wait(); err == nil {
+			resp.Body.Close()
+		}
+	})
+}
+
+// crossOriginStripHeaders are dropped when a next link leaves the origin.
+var crossOriginStripHeaders = []string{"Authorization", "Proxy-Authorization", "Www-Authenticate", "Cookie", "Cookie2"}
@@ -0,0 +1,92 @@
+package httpclientutil
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pagesServer serves pages 1 to n, each linking to the next, and reports
+// every request it gets on asked.
+func pagesServer(t *testing.T, n int) (url string, asked chan int) {
+	asked = make(chan int, 2*n)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		asked <- page
+		if page < n {
+			w.Header().Set("Link", fmt.Sprintf(`</items?page=%d>; rel="next"`, page+1))
+		}
+		fmt.Fprintf(w, "page %d", page)
+	}))
+	t.Cleanup(s.Close)
+	return s.URL + "/items?page=1", asked
+}
+
+func TestPaginate(t *testing.T) {
+	url, _ := pagesServer(t, 3)
+	req, _ := http.NewRequest("GET", url, nil)
+	var got []string
+	err := Paginate(context.Background(), http.DefaultClient, req, func(resp *http.Response) error {
+		b, _ := io.ReadAll(resp.Body)
+		got = append(got, string(b))
+		return nil
+	})
+	if err != nil || strings.Join(got, ",") != "page 1,page 2,page 3" {
+		t.Errorf("pages %q, err = %v", got, err)
+	}
+}
+
+func TestPaginatePrefetch(t *testing.T) {
+	url, asked := pagesServer(t, 3)
+	p := &ClientConnPool{MaxConnsPerHost: 2}
+	defer p.Close()
+	req, _ := http.NewRequest("GET", url, nil)
+	var got []string
+	err := PaginatePrefetch(context.Background(), p, req, func(resp *http.Response) error {
+		page := <-asked
+		if page < 3 {
+			// The next page is asked for while this one is being read.
+			select {
+			case next := <-asked:
+				if next != page+1 {
+					t.Errorf("prefetched page %d after %d", next, page)
+				}
+				asked <- next
+			case <-time.After(time.Second):
+				t.Errorf("page %d not prefetched", page+1)
+			}
+		}
+		b, _ := io.ReadAll(resp.Body)
+		got = append(got, string(b))
+		return nil
+	})
+	if err != nil || strings.Join(got, ",") != "page 1,page 2,page 3" {
+		t.Errorf("pages %q, err = %v", got, err)
+	}
+}
+
+func TestPaginatePrefetchStop(t *testing.T) {
+	url, asked := pagesServer(t, 3)
+	p := &ClientConnPool{MaxConnsPerHost: 2}
+	defer p.Close()
+	req, _ := http.NewRequest("GET", url, nil)
+	pages := 0
+	err := PaginatePrefetch(context.Background(), p, req, func(resp *http.Response) error {
+		pages++
+		return ErrStopPaging
+	})
+	if err != nil || pages != 1 {
+		t.Errorf("%d pages, err = %v", pages, err)
+	}
+	// The abandoned prefetch of page 2 goes no further.
+	time.Sleep(20 * time.Millisecond)
+	if n := len(asked); n > 2 {
+		t.Errorf("%d requests after stopping at page 1", n)
+	}
+}
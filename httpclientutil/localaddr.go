@@ -0,0 +1,131 @@
+package httpclientutil
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+)
+
+// LocalAddrPool hands out source addresses for outgoing connections in
+// rotation, so connections spread over many local IPs. It is safe for
+// concurrent use.
+type LocalAddrPool struct {
+	mu     sync.Mutex
+	v4, v6 addrRing
+}
+
+type addrRing struct {
+	ranges []addrRange
+	size   *big.Int // total addresses over all ranges
+	next   *big.Int
+}
+
+type addrRange struct {
+	base *big.Int
+	size *big.Int
+	v4   bool
+}
+
+// NewLocalAddrPool builds a pool from IP addresses and CIDR prefixes such
+// as "192.0.2.10" or "2001:db8::/64". The network and broadcast addresses
+// of IPv4 prefixes wider than /31 are skipped.
+func NewLocalAddrPool(specs ...string) (*LocalAddrPool, error) {
+	p := &LocalAddrPool{}
+	for _, spec := range specs {
+		var ip net.IP
+		ones, bits := -1, 0
+		if strings.Contains(spec, "/") {
+			_, n, err := net.ParseCIDR(spec)
+			if err != nil {
+				return nil, err
+			}
+			ip = n.IP
+			ones, bits = n.Mask.Size()
+		} else if ip = net.ParseIP(spec); ip == nil {
+			return nil, &net.ParseError{Type: "IP address", Text: spec}
+		}
+		r := addrRange{v4: ip.To4() != nil, size: big.NewInt(1)}
+		if r.v4 {
+			ip = ip.To4()
+		}
+		r.base = new(big.Int).SetBytes(ip)
+		if ones >= 0 {
+			r.size.Lsh(r.size, uint(bits-ones))
+			if r.v4 && bits-ones > 1 {
+				r.base.Add(r.base, big.NewInt(1))
+				r.size.Sub(r.size, big.NewInt(2))
+			}
+		}
+		if r.v4 {
+			p.v4.add(r)
+		} else {
+			p.v6.add(r)
+		}
+	}
+	if p.v4.size == nil && p.v6.size == nil {
+		return nil, errors.New("http: empty local address pool")
+	}
+	return p, nil
+}
+
+func (ar *addrRing) add(r addrRange) {
+	if ar.size == nil {
+		ar.size, ar.next = new(big.Int), new(big.Int)
+	}
+	ar.ranges = append(ar.ranges, r)
+	ar.size.Add(ar.size, r.size)
+}
+
+// Next returns the next IPv6 address if v6 is set and an IPv4 address
+// otherwise, or nil if the pool has none of that family.
+func (p *LocalAddrPool) Next(v6 bool) net.IP {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ar := &p.v4
+	if v6 {
+		ar = &p.v6
+	}
+	if ar.size == nil {
+		return nil
+	}
+	i := new(big.Int).Set(ar.next)
+	ar.next.Add(ar.next, big.NewInt(1))
+	if ar.next.Cmp(ar.size) >= 0 {
+		ar.next.SetInt64(0)
+	}
+	for _, r := range ar.ranges {
+		if i.Cmp(r.size) < 0 {
+			return rangeIP(r, i)
+		}
+		i.Sub(i, r.size)
+	}
+	return nil
+}
+
+// families reports which address families the pool holds.
+func (p *LocalAddrPool) families() (v4, v6 bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.v4.size != nil, p.v6.size != nil
+}
+
+func rangeIP(r addrRange, i *big.Int) net.IP {
+	n := new(big.Int).Add(r.base, i).Bytes()
+	ip := make(net.IP, net.IPv6len)
+	if r.v4 {
+		ip = ip[:net.IPv4len]
+	}
+	copy(ip[len(ip)-len(n):], n)
+	return ip
+}
+
+type localAddrKey struct{}
+
+// WithLocalAddr returns a context under which Dialer binds connections to
+// ip instead of taking an address from its LocalAddrs pool.
+func WithLocalAddr(ctx context.Context, ip net.IP) context.Context {
+	return context.WithValue(ctx, localAddrKey{}, ip)
+}
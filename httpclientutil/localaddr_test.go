@@ -0,0 +1,104 @@
+package httpclientutil
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+)
+
+func nextIPs(p *LocalAddrPool, v6 bool, n int) string {
+	ips := make([]string, n)
+	for i := range ips {
+		ips[i] = p.Next(v6).String()
+	}
+	return strings.Join(ips, " ")
+}
+
+func TestLocalAddrPoolRotation(t *testing.T) {
+	for _, tt := range []struct {
+		specs []string
+		v6    bool
+		want  string
+	}{
+		// The network and broadcast addresses of a /30 are skipped, and
+		// the pool starts over once the prefix is used up.
+		{[]string{"192.0.2.0/30"}, false, "192.0.2.1 192.0.2.2 192.0.2.1 192.0.2.2"},
+		{[]string{"192.0.2.0/31"}, false, "192.0.2.0 192.0.2.1 192.0.2.0"},
+		{[]string{"192.0.2.9/32", "198.51.100.0/30"}, false, "192.0.2.9 198.51.100.1 198.51.100.2 192.0.2.9"},
+		{[]string{"192.0.2.9", "2001:db8::/126"}, true, "2001:db8:: 2001:db8::1 2001:db8::2 2001:db8::3 2001:db8::"},
+		{[]string{"192.0.2.9", "2001:db8::/126"}, false, "192.0.2.9 192.0.2.9"},
+		{[]string{"10.0.0.255/23"}, false, "10.0.0.1 10.0.0.2"},
+	} {
+		p, err := NewLocalAddrPool(tt.specs...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := nextIPs(p, tt.v6, len(strings.Fields(tt.want))); got != tt.want {
+			t.Errorf("%q v6=%v: %s, want %s", tt.specs, tt.v6, got, tt.want)
+		}
+	}
+
+	// A /64 is too large to count with an int64 and still wraps.
+	p, err := NewLocalAddrPool("2001:db8::/64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := nextIPs(p, true, 2); got != "2001:db8:: 2001:db8::1" {
+		t.Errorf("/64: %s", got)
+	}
+	p.v6.next.Sub(p.v6.size, p.v6.next.SetInt64(1))
+	if got := nextIPs(p, true, 2); got != "2001:db8::ffff:ffff:ffff:ffff 2001:db8::" {
+		t.Errorf("end of /64: %s", got)
+	}
+	if ip := p.Next(false); ip != nil {
+		t.Errorf("IPv4 from an IPv6 pool: %v", ip)
+	}
+}
+
+func TestLocalAddrPoolErrors(t *testing.T) {
+	for _, specs := range [][]string{nil, {"192.0.2.300"}, {"192.0.2.0/33"}, {"host.example"}} {
+		if _, err := NewLocalAddrPool(specs...); err == nil {
+			t.Errorf("NewLocalAddrPool(%q) accepted", specs)
+		}
+	}
+}
+
+func TestDialerLocalAddrs(t *testing.T) {
+	seen := make(chan string, 4)
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			seen <- c.RemoteAddr().(*net.TCPAddr).IP.String()
+			c.Close()
+		}
+	}()
+	pool, err := NewLocalAddrPool("127.0.0.8/30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &Dialer{LocalAddrs: pool}
+	ctx := context.Background()
+	for _, ctx := range []context.Context{ctx, ctx, ctx, WithLocalAddr(ctx, net.IPv4(127, 0, 0, 1))} {
+		c, err := d.DialContext(ctx, "tcp", ln.Addr().String())
+		if err != nil {
+			t.Skipf("cannot bind loopback aliases: %v", err)
+		}
+		c.Close()
+	}
+	var got []string
+	for i := 0; i < 4; i++ {
+		got = append(got, <-seen)
+	}
+	if s := strings.Join(got, " "); s != "127.0.0.9 127.0.0.10 127.0.0.9 127.0.0.1" {
+		t.Errorf("connections came from %s", s)
+	}
+}
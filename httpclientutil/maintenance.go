@@ -0,0 +1,200 @@
+package httpclientutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrMaintenance = errors.New("http: upstream in maintenance")
+
+// MaintenanceError is the error of a request held off by a maintenance
+// window that ends at Until.
+type MaintenanceError struct {
+	Host  string
+	Until time.Time
+}
+
+func (e *MaintenanceError) Error() string {
+	return fmt.Sprintf("http: %s in maintenance until %s", e.Host, e.Until.Format(time.RFC3339))
+}
+
+func (e *MaintenanceError) Is(target error) bool { return target == ErrMaintenance }
+
+// MaintenancePolicy tells whether the upstream of req is in maintenance at
+// now, and if so until when.
+type MaintenancePolicy interface {
+	InMaintenance(req *http.Request, now time.Time) (until time.Time, ok bool)
+}
+
+// MaintenanceWindow is a span of time a host is down for maintenance: a
+// one-off from Start to End, or with Daily the same time of day every day,
+// read in Start's location, so 02:00 to 03:00 stays local through a change
+// of daylight saving time. A daily End before Start's time of day wraps
+// past midnight. Weekdays, if set, limits a daily window to the days it
+// starts on.
+type MaintenanceWindow struct {
+	Host       string // empty for every host
+	Start, End time.Time
+	Daily      bool
+	Weekdays   []time.Weekday
+}
+
+// MaintenanceWindows is a MaintenancePolicy of fixed windows. Windows that
+// overlap or adjoin hold requests until the last of them ends.
+type MaintenanceWindows []MaintenanceWindow
+
+func (ws MaintenanceWindows) InMaintenance(req *http.Request, now time.Time) (until time.Time, ok bool) {
+	host := req.URL.Hostname()
+	for {
+		extended := false
+		for _, w := range ws {
+			if w.Host != "" && w.Host != host {
+				continue
+			}
+			if end, in := w.until(now); in && end.After(until) {
+				if !ok || !end.Equal(until) {
+					extended = true
+				}
+				until, ok = end, true
+			}
+		}
+		if !extended {
+			return until, ok
+		}
+		// See whether another window picks up where this one ends.
+		now = until
+	}
+}
+
+// until returns the end of the occurrence of w that now falls in, if any.
+func (w MaintenanceWindow) until(now time.Time) (time.Time, bool) {
+	if !w.Daily {
+		return w.End, !now.Before(w.Start) && now.Before(w.End)
+	}
+	loc := w.Start.Location()
+	local := now.In(loc)
+	// The occurrence that now falls in started today or, when it wraps
+	// past midnight, yesterday.
+	for _, days := range []int{0, -1} {
+		day := local.AddDate(0, 0, days)
+		start := time.Date(day.Year(), day.Month(), day.Day(), w.Start.Hour(), w.Start.Minute(), w.Start.Second(), 0, loc)
+		end := time.Date(day.Year(), day.Month(), day.Day(), w.End.Hour(), w.End.Minute(), w.End.Second(), 0, loc)
+		if !end.After(start) {
+			end = end.AddDate(0, 0, 1)
+		}
+		if !w.onWeekday(start.Weekday()) {
+			continue
+		}
+		if !now.Before(start) && now.Before(end) {
+			return end, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (w MaintenanceWindow) onWeekday(d time.Weekday) bool {
+	if len(w.Weekdays) == 0 {
+		return true
+	}
+	for _, wd := range w.Weekdays {
+		if wd == d {
+			return true
+		}
+	}
+	return false
+}
+
+// MaintenanceDoer holds off requests while Policy has their upstream in
+// maintenance. A request ranked by WithPriority above UrgentAbove goes
+// through regardless. Others wait for the window to end, if it ends within
+// MaxWait, and are then released in arrival order, ReleaseInterval apart
+// so the backlog does not hit the upstream all at once as it comes back.
+// Longer windows, or any with a zero MaxWait, fail them at once with a
+// *MaintenanceError. A waiting request fails with its context's error if
+// that is done first. It is safe for concurrent use.
+type MaintenanceDoer struct {
+	Doer            Doer
+	Policy          MaintenancePolicy
+	UrgentAbove     int           // 0 lets only requests of positive priority through
+	MaxWait         time.Duration // longest window to wait out
+	ReleaseInterval time.Duration
+	Clock           Clock // nil is the system clock
+
+	mu          sync.Mutex
+	lastTurn    chan struct{} // closed once the latest waiter was released
+	lastRelease time.Time
+}
+
+func (d *MaintenanceDoer) Do(req *http.Request) (*http.Response, error) {
+	if requestPriority(req) <= d.UrgentAbove {
+		if err := d.wait(req); err != nil {
+			return nil, err
+		}
+	}
+	return d.Doer.Do(req)
+}
+
+// wait returns once req's upstream is out of maintenance and the requests
+// that waited before it were released, or with the error req fails with.
+func (d *MaintenanceDoer) wait(req *http.Request) error {
+	clock := clockOrSystem(d.Clock)
+	now := clock.Now()
+	until, ok := d.Policy.InMaintenance(req, now)
+	if !ok {
+		return nil
+	}
+	if until.Sub(now) > d.MaxWait {
+		return &MaintenanceError{Host: req.URL.Hostname(), Until: until}
+	}
+	d.mu.Lock()
+	prev := d.lastTurn
+	if prev == nil {
+		prev = closedChan
+	}
+	mine := make(chan struct{})
+	d.lastTurn = mine
+	d.mu.Unlock()
+	released := false
+	defer func() {
+		if !released {
+			// Pass the turn on once it comes.
+			spawn("maintenance turn", func() {
+				<-prev
+				close(mine)
+			})
+		}
+	}()
+	done := req.Context().Done()
+	for ok {
+		if !sleep(clock, until.Sub(now), done) {
+			return context.Cause(req.Context())
+		}
+		now = clock.Now()
+		if until, ok = d.Policy.InMaintenance(req, now); ok && until.Sub(now) > d.MaxWait {
+			return &MaintenanceError{Host: req.URL.Hostname(), Until: until}
+		}
+	}
+	select {
+	case <-prev:
+	case <-done:
+		return context.Cause(req.Context())
+	}
+	defer close(mine)
+	released = true
+	if d.ReleaseInterval > 0 {
+		d.mu.Lock()
+		next := d.lastRelease.Add(d.ReleaseInterval)
+		d.mu.Unlock()
+		if wait := next.Sub(clock.Now()); wait > 0 && !sleep(clock, wait, done) {
+			return context.Cause(req.Context())
+		}
+		d.mu.Lock()
+		d.lastRelease = clock.Now()
+		d.mu.Unlock()
+	}
+	return nil
+}
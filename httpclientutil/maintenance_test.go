@@ -0,0 +1,118 @@
+package httpclientutil
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceWindows(t *testing.T) {
+	zone := time.FixedZone("UTC+2", 2*3600)
+	at := func(day, hour, min int) time.Time { return time.Date(2020, 1, day, hour, min, 0, 0, zone) }
+	ws := MaintenanceWindows{
+		{Host: "a.example", Daily: true, Start: at(1, 23, 0), End: at(1, 1, 0)},
+		{Host: "b.example", Daily: true, Start: at(1, 2, 0), End: at(1, 3, 0), Weekdays: []time.Weekday{time.Saturday}},
+		{Start: at(6, 10, 0), End: at(6, 11, 0)},
+		{Start: at(6, 11, 0), End: at(6, 12, 0)},
+	}
+	req := func(host string) *http.Request {
+		r, _ := http.NewRequest("GET", "http://"+host+"/", nil)
+		return r
+	}
+	for _, c := range []struct {
+		host  string
+		now   time.Time
+		until time.Time // zero for none
+	}{
+		{"a.example", at(1, 23, 30), at(2, 1, 0)},
+		{"a.example", at(2, 0, 30), at(2, 1, 0)},
+		{"a.example", at(2, 1, 0), time.Time{}},
+		{"a.example", at(2, 22, 59), time.Time{}},
+		{"a.example", at(2, 23, 30).UTC(), at(3, 1, 0)},
+		{"c.example", at(1, 23, 30), time.Time{}},
+		{"b.example", at(1, 2, 30), time.Time{}}, // a Wednesday
+		{"b.example", at(4, 2, 30), at(4, 3, 0)}, // a Saturday
+		{"c.example", at(6, 10, 30), at(6, 12, 0)},
+		{"c.example", at(6, 12, 0), time.Time{}},
+	} {
+		until, ok := ws.InMaintenance(req(c.host), c.now)
+		if ok != !c.until.IsZero() || !until.Equal(c.until) {
+			t.Errorf("%s at %v: until %v, %v; want %v", c.host, c.now, until, ok, c.until)
+		}
+	}
+}
+
+func TestSimMaintenanceQueue(t *testing.T) {
+	clock := newFakeClock()
+	start := clock.Now()
+	d := newClockDoer(clock)
+	m := &MaintenanceDoer{
+		Doer:            d,
+		Policy:          MaintenanceWindows{{Start: start, End: start.Add(time.Hour)}},
+		MaxWait:         2 * time.Hour,
+		ReleaseInterval: time.Second,
+		Clock:           clock,
+	}
+	timers := func(n int) func() bool {
+		return func() bool {
+			clock.mu.Lock()
+			defer clock.mu.Unlock()
+			return len(clock.timers) == n
+		}
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			get(t, m, "http://a.example/queued")
+		}()
+		waitFor(t, "the request to queue", timers(i+1))
+	}
+	// Urgent requests go through.
+	urgent, _ := http.NewRequestWithContext(WithPriority(context.Background(), 1), "GET", "http://a.example/urgent", nil)
+	if _, err := m.Do(urgent); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Hour)
+	for i := 0; i < 2; i++ {
+		waitFor(t, "the release spacing", timers(1))
+		clock.Advance(time.Second)
+	}
+	wg.Wait()
+	checkOffsets(t, d.offsets("/queued", start), time.Hour, time.Hour+time.Second, time.Hour+2*time.Second)
+	checkOffsets(t, d.offsets("/urgent", start), 0)
+}
+
+func TestMaintenanceReject(t *testing.T) {
+	clock := newFakeClock()
+	now := clock.Now()
+	m := &MaintenanceDoer{
+		Doer:    newClockDoer(clock),
+		Policy:  MaintenanceWindows{{Start: now, End: now.Add(time.Hour)}},
+		MaxWait: time.Minute,
+		Clock:   clock,
+	}
+	_, err := get(t, m, "http://a.example/")
+	var me *MaintenanceError
+	if !errors.As(err, &me) || !errors.Is(err, ErrMaintenance) || !me.Until.Equal(now.Add(time.Hour)) || me.Host != "a.example" {
+		t.Fatalf("err = %v", err)
+	}
+
+	// A canceled wait passes the turn on.
+	m.MaxWait = 2 * time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "http://a.example/", nil)
+	if _, err := m.Do(req); err != context.Canceled {
+		t.Fatalf("canceled: %v", err)
+	}
+	clock.Run(t, func() {
+		if _, err := get(t, m, "http://a.example/"); err != nil {
+			t.Errorf("after a canceled wait: %v", err)
+		}
+	})
+}
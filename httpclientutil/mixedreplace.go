@@ -0,0 +1,74 @@
+package httpclientutil
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// ReplacePart is one part of a multipart/x-mixed-replace stream, a frame
+// of an MJPEG camera say, which replaces the part before it. Size is its
+// Content-Length, or -1 if the part has none; the boundary ends it either
+// way.
+type ReplacePart struct {
+	Header textproto.MIMEHeader
+	Size   int64
+	io.Reader
+}
+
+// ReplaceReader iterates over the parts of a multipart/x-mixed-replace
+// response as they arrive. Such a stream usually lasts as long as the
+// connection, so Next blocks until the next part starts; cancel the
+// request or Close the reader to stop.
+type ReplaceReader struct {
+	body io.ReadCloser
+	mr   *multipart.Reader
+}
+
+// ReadReplaceParts prepares resp for iteration with Next. A boundary that
+// already starts with "--", as some devices declare it, matches the
+// delimiter lines they send. The caller must Close the returned reader,
+// which closes the response body.
+func ReadReplaceParts(resp *http.Response) (*ReplaceReader, error) {
+	mediatype, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediatype != "multipart/x-mixed-replace" {
+		resp.Body.Close()
+		return nil, errors.New("http: response is not multipart/x-mixed-replace")
+	}
+	boundary := strings.TrimPrefix(params["boundary"], "--")
+	if boundary == "" {
+		resp.Body.Close()
+		return nil, errors.New("http: multipart/x-mixed-replace without boundary")
+	}
+	return &ReplaceReader{body: resp.Body, mr: multipart.NewReader(resp.Body, boundary)}, nil
+}
+
+// Next returns the next part. Reading a part after calling Next again is
+// not allowed; what is left of it is skipped. Next returns io.EOF after
+// the closing boundary, and io.ErrUnexpectedEOF if the stream ends
+// without one.
+func (rr *ReplaceReader) Next() (*ReplacePart, error) {
+	p, err := rr.mr.NextPart()
+	if err != nil {
+		if err != io.EOF && errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	part := &ReplacePart{Header: p.Header, Size: -1, Reader: p}
+	if cl := p.Header.Get("Content-Length"); cl != "" {
+		if n, err := strconv.ParseInt(cl, 10, 64); err == nil && n >= 0 {
+			part.Size = n
+		}
+	}
+	return part, nil
+}
+
+func (rr *ReplaceReader) Close() error {
+	return rr.body.Close()
+}
@@ -0,0 +1,85 @@
+package httpclientutil
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func replaceServer(t *testing.T, contentType, body string) *http.Response {
+	t.Helper()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(s.Close)
+	resp, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestReadReplaceParts(t *testing.T) {
+	for _, boundary := range []string{"frame", "--frame"} {
+		var body string
+		for i := 0; i < 3; i++ {
+			body += fmt.Sprintf("--frame\r\nContent-Type: image/jpeg\r\nContent-Length: 7\r\n\r\nframe %d\r\n", i)
+		}
+		body += "--frame--\r\n"
+		rr, err := ReadReplaceParts(replaceServer(t, "multipart/x-mixed-replace; boundary="+boundary, body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; ; i++ {
+			p, err := rr.Next()
+			if err == io.EOF {
+				if i != 3 {
+					t.Errorf("boundary %q: %d parts", boundary, i)
+				}
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := io.ReadAll(p)
+			if string(b) != fmt.Sprintf("frame %d", i) || p.Size != 7 || p.Header.Get("Content-Type") != "image/jpeg" {
+				t.Errorf("part %d: %q, %+v", i, b, p)
+			}
+		}
+		rr.Close()
+	}
+}
+
+func TestReadReplacePartsTruncated(t *testing.T) {
+	rr, err := ReadReplaceParts(replaceServer(t, "multipart/x-mixed-replace;boundary=b", "--b\r\n\r\nfirst\r\n--b\r\n\r\nsec"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rr.Close()
+	// A part left unread is skipped.
+	if p, err := rr.Next(); err != nil || p.Size != -1 {
+		t.Fatalf("first: %+v, %v", p, err)
+	}
+	p, err := rr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(p); err != io.ErrUnexpectedEOF {
+		t.Errorf("reading the cut part: %v", err)
+	}
+	if _, err := rr.Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Next after the cut: %v", err)
+	}
+}
+
+func TestReadReplacePartsContentType(t *testing.T) {
+	if _, err := ReadReplaceParts(replaceServer(t, "image/jpeg", "x")); err == nil {
+		t.Error("image/jpeg accepted")
+	}
+	if _, err := ReadReplaceParts(replaceServer(t, "multipart/x-mixed-replace", "x")); err == nil {
+		t.Error("no boundary accepted")
+	}
+}
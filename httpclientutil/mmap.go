@@ -0,0 +1,108 @@
+package httpclientutil
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+)
+
+var errNoMmap = errors.New("mmap not supported")
+
+// MappedFile is a read-only file for use as a large request body. Where the
+// platform allows it the file is memory-mapped and advised for sequential
+// access, so multi-GB uploads are copied straight out of the page cache;
+// elsewhere, or if mapping fails, it reads the file normally.
+type MappedFile struct {
+	f    *os.File
+	data []byte // nil when reading through f
+	size int64
+	off  int64
+}
+
+// OpenMapped opens name for reading and maps it if possible.
+func OpenMapped(name string) (*MappedFile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	m := &MappedFile{f: f, size: fi.Size()}
+	if data, err := mmapFile(f, m.size); err == nil {
+		m.data = data
+	}
+	return m, nil
+}
+
+// Size returns the length of the file.
+func (m *MappedFile) Size() int64 { return m.size }
+
+// Mapped reports whether reads are served from a memory mapping.
+func (m *MappedFile) Mapped() bool { return m.data != nil }
+
+func (m *MappedFile) Read(p []byte) (int, error) {
+	n, err := m.ReadAt(p, m.off)
+	m.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (m *MappedFile) ReadAt(p []byte, off int64) (int, error) {
+	if m.data == nil {
+		return m.f.ReadAt(p, off)
+	}
+	if off < 0 {
+		return 0, errors.New("http: negative offset")
+	}
+	if off >= m.size {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *MappedFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += m.off
+	case io.SeekEnd:
+		offset += m.size
+	}
+	if offset < 0 {
+		return 0, errors.New("http: negative position")
+	}
+	m.off = offset
+	return offset, nil
+}
+
+// Close unmaps and closes the file.
+func (m *MappedFile) Close() error {
+	if m.data != nil {
+		munmap(m.data)
+		m.data = nil
+	}
+	return m.f.Close()
+}
+
+// SetBody makes m the body of req, with a GetBody that rereads it from the
+// start for redirects and retries. The request never closes m; close it
+// once the request is done.
+func (m *MappedFile) SetBody(req *http.Request) {
+	req.ContentLength = m.size
+	req.Body = io.NopCloser(io.NewSectionReader(m, 0, m.size))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(io.NewSectionReader(m, 0, m.size)), nil
+	}
+	if m.size == 0 {
+		req.Body = http.NoBody
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package httpclientutil
+
+import "os"
+
+func mmapFile(f *os.File, size int64) ([]byte, error) { return nil, errNoMmap }
+
+func munmap(data []byte) error { return nil }
@@ -0,0 +1,123 @@
+package httpclientutil
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func writeTemp(t *testing.T, data string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "body")
+	if err := os.WriteFile(name, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// checkMappedReads reads m, expected to hold "0123456789", every way it
+// can be read.
+func checkMappedReads(t *testing.T, m *MappedFile) {
+	t.Helper()
+	if m.Size() != 10 {
+		t.Fatalf("Size = %d", m.Size())
+	}
+	b, err := io.ReadAll(m)
+	if err != nil || string(b) != "0123456789" {
+		t.Fatalf("ReadAll = %q, %v", b, err)
+	}
+	p := make([]byte, 4)
+	if n, err := m.ReadAt(p, 8); n != 2 || err != io.EOF || string(p[:n]) != "89" {
+		t.Errorf("ReadAt past the end = %d, %v, %q", n, err, p[:n])
+	}
+	if n, err := m.ReadAt(p, 10); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt at the end = %d, %v", n, err)
+	}
+	if _, err := m.ReadAt(p, -1); err == nil {
+		t.Error("ReadAt of a negative offset succeeded")
+	}
+	if pos, err := m.Seek(-3, io.SeekEnd); err != nil || pos != 7 {
+		t.Errorf("Seek from the end = %d, %v", pos, err)
+	}
+	if n, _ := m.Read(p); string(p[:n]) != "789" {
+		t.Errorf("Read after Seek = %q", p[:n])
+	}
+	if _, err := m.Seek(-20, io.SeekCurrent); err == nil {
+		t.Error("Seek before the start succeeded")
+	}
+
+	// A body that is read any number of times, as for a redirect.
+	var (
+		mu  sync.Mutex
+		got []string
+	)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		got = append(got, string(b))
+		mu.Unlock()
+		if r.URL.Path == "/from" {
+			http.Redirect(w, r, "/to", http.StatusTemporaryRedirect)
+		}
+	}))
+	defer s.Close()
+	req, _ := http.NewRequest("PUT", s.URL+"/from", nil)
+	m.SetBody(req)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	mu.Lock()
+	defer mu.Unlock()
+	if len(got) != 2 || got[0] != "0123456789" || got[1] != got[0] {
+		t.Errorf("server read %q, want the body twice", got)
+	}
+}
+
+func TestMappedFile(t *testing.T) {
+	m, err := OpenMapped(writeTemp(t, "0123456789"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if want := runtime.GOOS == "linux"; m.Mapped() != want {
+		t.Errorf("Mapped = %v on %s", m.Mapped(), runtime.GOOS)
+	}
+	checkMappedReads(t, m)
+}
+
+func TestMappedFileFallback(t *testing.T) {
+	// The reads a failed mapping leaves to the file.
+	f, err := os.Open(writeTemp(t, "0123456789"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &MappedFile{f: f, size: 10}
+	defer m.Close()
+	checkMappedReads(t, m)
+
+	// An empty file cannot be mapped anywhere.
+	m, err = OpenMapped(writeTemp(t, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if m.Mapped() {
+		t.Error("empty file mapped")
+	}
+	req, _ := http.NewRequest("PUT", "http://example.com/", nil)
+	m.SetBody(req)
+	if req.Body != http.NoBody || req.ContentLength != 0 {
+		t.Errorf("empty body = %v, length %d", req.Body, req.ContentLength)
+	}
+
+	if _, err := OpenMapped(filepath.Join(t.TempDir(), "missing")); !os.IsNotExist(err) {
+		t.Errorf("missing file: err = %v", err)
+	}
+}
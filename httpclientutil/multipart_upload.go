@@ -0,0 +1,274 @@
+package httpclientutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// MultipartUpload drives an S3-style multipart upload: initiate, PUT the
+// parts in parallel with one worker per Doer, then complete, or abort on
+// failure. Sign is applied to every request before it is sent, which is
+// where SigV4 or any other scheme plugs in.
+type MultipartUpload struct {
+	Doers    []Doer // one worker per Doer, e.g. one per ClientConn
+	URL      string // object URL
+	PartSize int64  // defaults to 8MB; S3 requires at least 5MB
+	Sign     func(*http.Request) error
+	Header   http.Header // added to the initiate request, e.g. Content-Type
+
+	UploadID string // set by Initiate
+}
+
+// CompletedPart identifies an uploaded part for the complete request.
+type CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+const defaultPartSize = 8 << 20
+
+// Upload uploads size bytes of r as a multipart upload and aborts it if any
+// step fails.
+func (m *MultipartUpload) Upload(ctx context.Context, r io.ReaderAt, size int64) error {
+	if len(m.Doers) == 0 {
+		return errors.New("http: multipart upload needs at least one Doer")
+	}
+	if err := m.Initiate(ctx); err != nil {
+		return err
+	}
+	parts, err := m.uploadParts(ctx, r, size)
+	if err == nil {
+		err = m.Complete(ctx, parts)
+	}
+	if err != nil {
+		// Use a fresh context: ctx may be what failed.
+		m.Abort(context.Background())
+	}
+	return err
+}
+
+// Initiate starts the upload and stores its id in m.UploadID.
+func (m *MultipartUpload) Initiate(ctx context.Context) error {
+	req, err := m.newRequest(ctx, "POST", url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return err
+	}
+	for k, vv := range m.Header {
+		req.Header[k] = append([]string(nil), vv...)
+	}
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := m.do(0, req, &result); err != nil {
+		return err
+	}
+	if result.UploadID == "" {
+		return errors.New("http: multipart initiate returned no UploadId")
+	}
+	m.UploadID = result.UploadID
+	return nil
+}
+
+// UploadPart uploads one part through the worker'th Doer and returns its
+// ETag.
+func (m *MultipartUpload) UploadPart(ctx context.Context, worker, number int, data []byte) (string, error) {
+	q := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {m.UploadID}}
+	req, err := m.newRequest(ctx, "PUT", q, data)
+	if err != nil {
+		return "", err
+	}
+	resp, err := m.send(worker, req)
+	if err != nil {
+		return "", err
+	}
+	drainBody(resp)
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		return "", fmt.Errorf("http: part %d returned no ETag", number)
+	}
+	return etag, nil
+}
+
+// Complete assembles the uploaded parts into the final object.
+func (m *MultipartUpload) Complete(ctx context.Context, parts []CompletedPart) error {
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []CompletedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	req, err := m.newRequest(ctx, "POST", url.Values{"uploadId": {m.UploadID}}, body)
+	if err != nil {
+		return err
+	}
+	// S3 reports some completion failures as 200 with an Error document.
+	var result struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := m.do(0, req, &result); err != nil {
+		return err
+	}
+	if result.XMLName.Local == "Error" {
+		return fmt.Errorf("http: multipart complete failed: %s: %s", result.Code, result.Message)
+	}
+	return nil
+}
+
+// Abort discards the upload and any parts stored so far.
+func (m *MultipartUpload) Abort(ctx context.Context) error {
+	req, err := m.newRequest(ctx, "DELETE", url.Values{"uploadId": {m.UploadID}}, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := m.send(0, req)
+	if err != nil {
+		return err
+	}
+	drainBody(resp)
+	return nil
+}
+
+func (m *MultipartUpload) uploadParts(ctx context.Context, r io.ReaderAt, size int64) ([]CompletedPart, error) {
+	partSize := m.PartSize
+	if partSize <= 0 {
+		partSize = defaultPartSize
+	}
+	n := int((size + partSize - 1) / partSize)
+	if n == 0 {
+		n = 1 // an empty object is still one part
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	numbers := make(chan int)
+	parts := make([]CompletedPart, n)
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel(&CanceledError{Reason: "another part failed", Err: err})
+		})
+	}
+	for w := range m.Doers {
+		wg.Add(1)
+		worker := w
+		spawn("upload worker", func() {
+			defer wg.Done()
+			for number := range numbers {
+				off := int64(number-1) * partSize
+				length := partSize
+				if off+length > size {
+					length = size - off
+				}
+				data := make([]byte, length)
+				if n, err := r.ReadAt(data, off); int64(n) < length {
+					if err == nil || err == io.EOF {
+						err = fmt.Errorf("http: upload source ended at byte %d of %d", off+int64(n), size)
+					}
+					fail(err)
+					continue
+				}
+				etag, err := m.UploadPart(ctx, worker, number, data)
+				if err != nil {
+					fail(err)
+					continue
+				}
+				parts[number-1] = CompletedPart{PartNumber: number, ETag: etag}
+			}
+		})
+	}
+feed:
+	for number := 1; number <= n; number++ {
+		select {
+		case numbers <- number:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(numbers)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if ctx.Err() != nil {
+		return nil, context.Cause(ctx)
+	}
+	return parts, nil
+}
+
+func (m *MultipartUpload) newRequest(ctx context.Context, method string, q url.Values, body []byte) (*http.Request, error) {
+	u, err := url.Parse(m.URL)
+	if err != nil {
+		return nil, err
+	}
+	// Keep the query of the object URL, such as a presigned token.
+	merged := u.Query()
+	for k, vv := range q {
+		merged[k] = vv
+	}
+	// S3 expects the valueless "uploads" key without a trailing '='.
+	_, initiate := merged["uploads"]
+	delete(merged, "uploads")
+	u.RawQuery = merged.Encode()
+	if initiate {
+		if u.RawQuery != "" {
+			u.RawQuery += "&"
+		}
+		u.RawQuery += "uploads"
+	}
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, u.String(), rd)
+	if err != nil {
+		return nil, err
+	}
+	return req.WithContext(ctx), nil
+}
+
+// send signs req, issues it through the worker'th Doer and returns the
+// response if it succeeded.
+func (m *MultipartUpload) send(worker int, req *http.Request) (*http.Response, error) {
+	if m.Sign != nil {
+		if err := m.Sign(req); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := m.Doers[worker%len(m.Doers)].Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		drainBody(resp)
+		return nil, fmt.Errorf("http: multipart %s failed: %s", req.Method, resp.Status)
+	}
+	return resp, nil
+}
+
+// do is send followed by decoding an XML response body into v.
+func (m *MultipartUpload) do(worker int, req *http.Request, v interface{}) error {
+	resp, err := m.send(worker, req)
+	if err != nil {
+		return err
+	}
+	defer drainBody(resp)
+	return xml.NewDecoder(resp.Body).Decode(v)
+}
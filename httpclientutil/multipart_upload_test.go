@@ -0,0 +1,115 @@
+package httpclientutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// s3Server accepts one multipart upload and records the query of every
+// request.
+type s3Server struct {
+	*httptest.Server
+	mu      sync.Mutex
+	queries []string
+	parts   map[string][]byte // partNumber -> data
+	object  []byte            // set by complete
+	aborted bool
+	failPUT bool
+}
+
+func newS3Server(t *testing.T) *s3Server {
+	s := &s3Server{parts: make(map[string][]byte)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.queries = append(s.queries, r.Method+" "+r.URL.RawQuery)
+		q := r.URL.Query()
+		switch {
+		case r.Method == "POST" && (r.URL.RawQuery == "uploads" || strings.HasSuffix(r.URL.RawQuery, "&uploads")):
+			io.WriteString(w, "<InitiateMultipartUploadResult><UploadId>u1</UploadId></InitiateMultipartUploadResult>")
+		case q.Get("uploadId") != "u1":
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == "PUT":
+			if s.failPUT {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			s.parts[q.Get("partNumber")] = body
+			w.Header().Set("ETag", fmt.Sprintf(`"%s"`, q.Get("partNumber")))
+		case r.Method == "POST":
+			var doc struct {
+				Parts []CompletedPart `xml:"Part"`
+			}
+			if err := xml.Unmarshal(body, &doc); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			for _, p := range doc.Parts {
+				s.object = append(s.object, s.parts[fmt.Sprint(p.PartNumber)]...)
+			}
+			io.WriteString(w, "<CompleteMultipartUploadResult/>")
+		case r.Method == "DELETE":
+			s.aborted = true
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestMultipartUploadKeepsQuery(t *testing.T) {
+	s := newS3Server(t)
+	body := []byte("0123456789")
+	m := &MultipartUpload{
+		Doers:    []Doer{http.DefaultClient, http.DefaultClient},
+		URL:      s.URL + "/bucket/key?X-Token=a%2Bb&versionId=7",
+		PartSize: 4,
+	}
+	if err := m.Upload(context.Background(), bytes.NewReader(body), int64(len(body))); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !bytes.Equal(s.object, body) {
+		t.Errorf("object = %q, want %q", s.object, body)
+	}
+	sort.Strings(s.queries)
+	want := []string{
+		"POST X-Token=a%2Bb&uploadId=u1&versionId=7",
+		"POST X-Token=a%2Bb&versionId=7&uploads",
+		"PUT X-Token=a%2Bb&partNumber=1&uploadId=u1&versionId=7",
+		"PUT X-Token=a%2Bb&partNumber=2&uploadId=u1&versionId=7",
+		"PUT X-Token=a%2Bb&partNumber=3&uploadId=u1&versionId=7",
+	}
+	if strings.Join(s.queries, "\n") != strings.Join(want, "\n") {
+		t.Errorf("queries:\n%s\nwant:\n%s", strings.Join(s.queries, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestMultipartUploadAborts(t *testing.T) {
+	s := newS3Server(t)
+	s.failPUT = true
+	m := &MultipartUpload{Doers: []Doer{http.DefaultClient}, URL: s.URL + "/bucket/key", PartSize: 4}
+	err := m.Upload(context.Background(), strings.NewReader("0123456789"), 10)
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Fatalf("err = %v, want the failed PUT", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.aborted {
+		t.Error("failed upload not aborted")
+	}
+	if s.queries[0] != "POST uploads" {
+		t.Errorf("initiate query = %q, want the bare uploads key", s.queries[0])
+	}
+}
@@ -0,0 +1,180 @@
+package httpclientutil
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/zhaojkun/client/httpclientutil/httpheader"
+)
+
+// Weighted lists values in order of preference for an Accept-style
+// header, the first at q=1 and each next one 0.1 lower, down to 0.1.
+func Weighted(values ...string) []httpheader.QValue {
+	qs := make([]httpheader.QValue, len(values))
+	for i, v := range values {
+		q := float64(10-i) / 10
+		if q < 0.1 {
+			q = 0.1
+		}
+		qs[i] = httpheader.QValue{Value: v, Q: q}
+	}
+	return qs
+}
+
+// NegotiationMismatch says what a Negotiation does with a response it did
+// not ask for.
+type NegotiationMismatch int
+
+const (
+	// NegotiationPassThrough returns the response unchanged. This is the
+	// default.
+	NegotiationPassThrough NegotiationMismatch = iota
+	// NegotiationFail closes the body and returns a *NegotiationError.
+	NegotiationFail
+)
+
+// NegotiationError reports a response whose Content-Type or
+// Content-Language the request did not accept.
+type NegotiationError struct {
+	Header   string // "Content-Type" or "Content-Language"
+	Got      string
+	Accepted string
+}
+
+func (e *NegotiationError) Error() string {
+	return fmt.Sprintf("http: response %s %q not in %q", e.Header, e.Got, e.Accepted)
+}
+
+// Negotiation sets the Accept and Accept-Language of requests and checks
+// that successful responses match them. Error responses are not checked,
+// since servers commonly answer those in a fixed format.
+type Negotiation struct {
+	Accept   []httpheader.QValue // media ranges, e.g. Weighted("application/json", "*/*")
+	Language []httpheader.QValue // language ranges, e.g. Weighted("de", "en")
+	Mismatch NegotiationMismatch
+}
+
+// Apply sets the headers the caller has not set on req.
+func (n *Negotiation) Apply(req *http.Request) {
+	if len(n.Accept) > 0 && req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", httpheader.FormatQList(n.Accept))
+	}
+	if len(n.Language) > 0 && req.Header.Get("Accept-Language") == "" {
+		req.Header.Set("Accept-Language", httpheader.FormatQList(n.Language))
+	}
+}
+
+// Check applies the Mismatch policy to resp, against the headers of
+// resp.Request.
+func (n *Negotiation) Check(resp *http.Response) error {
+	if n.Mismatch == NegotiationPassThrough || resp.Request == nil || resp.StatusCode/100 != 2 {
+		return nil
+	}
+	h := resp.Request.Header
+	if ct := resp.Header.Get("Content-Type"); ct != "" && h.Get("Accept") != "" {
+		if !acceptsMediaType(httpheader.ParseQList(h, "Accept"), ct) {
+			resp.Body.Close()
+			return &NegotiationError{Header: "Content-Type", Got: ct, Accepted: h.Get("Accept")}
+		}
+	}
+	if cl := resp.Header.Get("Content-Language"); cl != "" && h.Get("Accept-Language") != "" {
+		ranges := httpheader.ParseQList(h, "Accept-Language")
+		ok := false
+		for _, tag := range strings.Split(cl, ",") {
+			ok = ok || acceptsLanguage(ranges, strings.TrimSpace(tag))
+		}
+		if !ok {
+			resp.Body.Close()
+			return &NegotiationError{Header: "Content-Language", Got: cl, Accepted: h.Get("Accept-Language")}
+		}
+	}
+	return nil
+}
+
+// acceptsMediaType reports whether the most specific range matching ct has
+// a non-zero weight (RFC 9110 section 12.5.1).
+func acceptsMediaType(ranges []httpheader.QValue, ct string) bool {
+	mt, params, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	best, q := -1, 0.0
+	for _, r := range ranges {
+		rt, rparams, err := mime.ParseMediaType(r.Value)
+		if err != nil {
+			continue
+		}
+		spec := mediaRangeMatch(rt, rparams, mt, params)
+		if spec > best {
+			best, q = spec, r.Q
+		}
+	}
+	return best >= 0 && q > 0
+}
+
+// mediaRangeMatch returns how specific a match of range rt;rparams is for
+// mt;params, or -1 if it does not match.
+func mediaRangeMatch(rt string, rparams map[string]string, mt string, params map[string]string) int {
+	switch {
+	case rt == "*/*":
+		return 0
+	case strings.HasSuffix(rt, "/*"):
+		if !strings.HasPrefix(mt, rt[:len(rt)-1]) {
+			return -1
+		}
+		return 1
+	case rt != mt:
+		return -1
+	}
+	for k, v := range rparams {
+		if !strings.EqualFold(params[k], v) {
+			return -1
+		}
+	}
+	return 2 + len(rparams)
+}
+
+// acceptsLanguage applies basic filtering (RFC 4647 section 3.3.1), using
+// the weight of the longest matching range.
+func acceptsLanguage(ranges []httpheader.QValue, tag string) bool {
+	tag = strings.ToLower(tag)
+	best, q := -1, 0.0
+	for _, r := range ranges {
+		rv := strings.ToLower(r.Value)
+		if rv == "*" || tag == rv || strings.HasPrefix(tag, rv+"-") {
+			spec := len(rv)
+			if rv == "*" {
+				spec = 0
+			}
+			if spec > best {
+				best, q = spec, r.Q
+			}
+		}
+	}
+	return best >= 0 && q > 0
+}
+
+// NegotiatingDoer sends requests through Doer with the headers of
+// Negotiation and checks the responses against it.
+type NegotiatingDoer struct {
+	Doer        Doer
+	Negotiation *Negotiation
+}
+
+func (n *NegotiatingDoer) Do(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	n.Negotiation.Apply(req)
+	resp, err := n.Doer.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Request == nil {
+		resp.Request = req
+	}
+	if err := n.Negotiation.Check(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
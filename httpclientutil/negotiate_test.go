@@ -0,0 +1,64 @@
+package httpclientutil
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/zhaojkun/client/httpclientutil/httpheader"
+)
+
+func TestWeighted(t *testing.T) {
+	if got := httpheader.FormatQList(Weighted("application/json", "application/xml", "*/*")); got != "application/json, application/xml;q=0.9, */*;q=0.8" {
+		t.Fatalf("Weighted = %q", got)
+	}
+}
+
+func TestNegotiationCheck(t *testing.T) {
+	tests := []struct {
+		accept, lang string
+		ct, cl       string
+		status       int
+		ok           bool
+	}{
+		{"application/json", "", "application/json; charset=utf-8", "", 200, true},
+		{"application/json", "", "text/html", "", 200, false},
+		{"application/json", "", "text/html", "", 404, true}, // errors are not checked
+		{"text/*", "", "text/plain", "", 200, true},
+		{"text/*, text/html;q=0", "", "text/html", "", 200, false},
+		{"*/*;q=0.1, image/png;q=0", "", "image/png", "", 200, false},
+		{"text/html;level=1", "", "text/html", "", 200, false},
+		{"text/html;level=1", "", "text/html;level=1", "", 200, true},
+		{"", "de, en;q=0.5", "", "en-GB", 200, true},
+		{"", "de", "", "fr", 200, false},
+		{"", "de", "", "fr, de-AT", 200, true},
+		{"", "*, fr;q=0", "", "fr-CA", 200, false},
+		{"application/json", "", "", "", 204, true},
+	}
+	for _, tt := range tests {
+		h := http.Header{}
+		if tt.ct != "" {
+			h.Set("Content-Type", tt.ct)
+		}
+		if tt.cl != "" {
+			h.Set("Content-Language", tt.cl)
+		}
+		d := &staticDoer{header: h}
+		n := &Negotiation{Mismatch: NegotiationFail}
+		if tt.accept != "" {
+			n.Accept = httpheader.ParseQList(http.Header{"Accept": {tt.accept}}, "Accept")
+		}
+		if tt.lang != "" {
+			n.Language = httpheader.ParseQList(http.Header{"L": {tt.lang}}, "L")
+		}
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		resp, err := d.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.Apply(req)
+		resp.StatusCode = tt.status
+		if err := n.Check(resp); (err == nil) != tt.ok {
+			t.Errorf("Accept %q/%q, response %q/%q: err = %v", tt.accept, tt.lang, tt.ct, tt.cl, err)
+		}
+	}
+}
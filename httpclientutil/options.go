@@ -0,0 +1,76 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net/http"
+)
+
+// Option configures a ClientConn in NewClientConn. Options apply in order,
+// so a later one overrides an earlier one setting the same thing.
+type Option func(*ClientConn)
+
+// WithReader makes the connection read through r, which reads from the
+// conn and may hold data already received, as after Hijack.
+func WithReader(r *bufio.Reader) Option {
+	return func(cc *ClientConn) { cc.r = r }
+}
+
+// WithProxyMode writes requests in absolute form, with
+// (*http.Request).WriteProxy, as a forward proxy wants them. Off, the
+// default, writes them in origin form with (*http.Request).Write.
+func WithProxyMode(on bool) Option {
+	return func(cc *ClientConn) {
+		if on {
+			cc.writeReq = (*http.Request).WriteProxy
+		} else {
+			cc.writeReq = (*http.Request).Write
+		}
+	}
+}
+
+// WithWriteRequestFunc serializes requests with fn instead of
+// (*http.Request).Write. fn must write exactly one complete request. A
+// request's WithRequestWriter context takes precedence.
+func WithWriteRequestFunc(fn func(*http.Request, io.Writer) error) Option {
+	return func(cc *ClientConn) { cc.writeReq = fn }
+}
+
+// WithBufferSizes sets the size of the buffer responses are read through,
+// unless WithReader supplies the reader, and of the one requests are
+// written through. A size of zero keeps the default of 4KB.
+func WithBufferSizes(read, write int) Option {
+	return func(cc *ClientConn) { cc.bufSizes = bufferSizes{read: read, write: write} }
+}
+
+type bufferSizes struct {
+	read, write int
+}
+
+func newReaderSize(c io.Reader, size int) *bufio.Reader {
+	if size <= 0 {
+		return bufio.NewReader(c)
+	}
+	return bufio.NewReaderSize(c, size)
+}
+
+// WithLogger logs to l why the connection stopped being usable, unless it
+// was closed or hijacked by its user, and responses buffered ahead of
+// their requests.
+func WithLogger(l *log.Logger) Option {
+	return func(cc *ClientConn) { cc.logger = l }
+}
+
+func (cc *ClientConn) logf(format string, args ...interface{}) {
+	if cc.logger != nil {
+		cc.logger.Printf("httpclientutil: %s: "+format, append([]interface{}{cc.remote}, args...)...)
+	}
+}
+
+// WithClock runs the response header, idle and 100 Continue timeouts on c
+// instead of the system clock. Write deadlines stay on the system clock,
+// which the conn enforces.
+func WithClock(c Clock) Option {
+	return func(cc *ClientConn) { cc.clock = clockOrSystem(c) }
+}
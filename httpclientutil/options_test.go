@@ -0,0 +1,159 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// requestLines serves every request on c with its request line as the
+// body.
+func requestLines(c net.Conn, br *bufio.Reader) {
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if line == "\r\n" {
+			continue
+		}
+		for {
+			h, err := br.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if h == "\r\n" {
+				break
+			}
+		}
+		writeResponse(c, strings.TrimSpace(line))
+	}
+}
+
+func TestWithProxyMode(t *testing.T) {
+	for _, c := range []struct {
+		opts []Option
+		want string
+	}{
+		{nil, "GET /p HTTP/1.1"},
+		{[]Option{nil}, "GET /p HTTP/1.1"},
+		{[]Option{WithProxyMode(true)}, "GET http://a.example/p HTTP/1.1"},
+		{[]Option{WithProxyMode(true), WithProxyMode(false)}, "GET /p HTTP/1.1"},
+		{[]Option{WithWriteRequestFunc(func(req *http.Request, w io.Writer) error {
+			_, err := io.WriteString(w, "GET /custom HTTP/1.1\r\nHost: a.example\r\n\r\n")
+			return err
+		})}, "GET /custom HTTP/1.1"},
+	} {
+		cc := rawServer(t, requestLines, c.opts...)
+		req, _ := http.NewRequest("GET", "http://a.example/p", nil)
+		resp, err := cc.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(b) != c.want {
+			t.Errorf("request line %q, want %q", b, c.want)
+		}
+	}
+}
+
+func TestWithReader(t *testing.T) {
+	c, s := net.Pipe()
+	defer s.Close()
+	// A response received before the ClientConn took over the conn.
+	var early bytes.Buffer
+	writeResponse(&early, "early")
+	cc := NewClientConn(c, WithReader(bufio.NewReader(io.MultiReader(&early, c))))
+	defer cc.Close()
+	go http.ReadRequest(bufio.NewReader(s))
+	if body := doBody(t, cc); body != "early" {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestWithBufferSizes(t *testing.T) {
+	big := strings.Repeat("h", 8000)
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		writeResponse(c, req.Header.Get("X-Big"))
+	}, WithBufferSizes(64<<10, 16<<10))
+	if n := cc.r.Size(); n != 64<<10 {
+		t.Errorf("read buffer of %d bytes", n)
+	}
+	req, _ := http.NewRequest("GET", "http://a.example/", nil)
+	req.Header.Set("X-Big", big)
+	resp, err := cc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != big {
+		t.Errorf("header of %d bytes came back as %d", len(big), len(b))
+	}
+	if n := cc.bw.Size(); n != 16<<10 {
+		t.Errorf("write buffer of %d bytes", n)
+	}
+}
+
+func TestWithLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := log.New(&buf, "", 0)
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		if _, err := http.ReadRequest(br); err != nil {
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nConnection: close\r\n\r\nok")
+	}, WithLogger(l))
+	if body := doBody(t, cc); body != "ok" {
+		t.Fatalf("body = %q", body)
+	}
+	<-cc.readDone
+	if got := buf.String(); !strings.Contains(got, "connection unusable: "+ErrServerClosedConn.Error()) {
+		t.Errorf("log = %q", got)
+	}
+
+	// Closing it oneself is not worth a line.
+	buf.Reset()
+	quiet := rawServer(t, func(c net.Conn, br *bufio.Reader) { io.Copy(io.Discard, br) }, WithLogger(l))
+	quiet.Close()
+	<-quiet.readDone
+	if got := buf.String(); got != "" {
+		t.Errorf("log after Close = %q", got)
+	}
+}
+
+func TestWithClock(t *testing.T) {
+	clock := newFakeClock()
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		if _, err := http.ReadRequest(br); err != nil {
+			return
+		}
+		writeResponse(c, "ok")
+		io.Copy(io.Discard, br)
+	}, WithClock(clock), WithIdleTimeout(time.Minute))
+	if body := doBody(t, cc); body != "ok" {
+		t.Fatalf("body = %q", body)
+	}
+	waitFor(t, "the idle timer", func() bool {
+		clock.mu.Lock()
+		defer clock.mu.Unlock()
+		return len(clock.timers) == 1
+	})
+	clock.Advance(59 * time.Second)
+	if err := cc.Ping(); err != nil {
+		t.Fatalf("before the idle timeout: %v", err)
+	}
+	clock.Advance(time.Second)
+	waitFor(t, "the idle timeout", func() bool { return cc.Ping() == ErrIdleTimeout })
+}
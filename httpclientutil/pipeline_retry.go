@@ -0,0 +1,185 @@
+package httpclientutil
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// PipelineError reports a batch that PipelineRetrier could not finish.
+type PipelineError struct {
+	Responses []*http.Response // the answered requests, in order
+	Err       error            // why the last connection failed
+	Unsafe    bool             // the rest was not resent because it is not idempotent
+}
+
+func (e *PipelineError) Error() string {
+	if e.Unsafe {
+		return fmt.Sprintf("http: pipeline broke after %d responses, rest not safe to resend: %v", len(e.Responses), e.Err)
+	}
+	return fmt.Sprintf("http: pipeline broke after %d responses: %v", len(e.Responses), e.Err)
+}
+
+func (e *PipelineError) Unwrap() error { return e.Err }
+
+// PipelineRetrier sends batches with DoBatch over a connection it keeps.
+// When the connection breaks partway through, the requests without a
+// response are resent in order on a fresh connection from Dial.
+//
+// Once written, a request that got no response may still have been
+// processed, so following RFC 9112 section 9.3.2 the rest is only resent
+// when every request in it is idempotent (by method or an Idempotency-Key
+// header) and has a rewindable body; otherwise DoBatch stops with a
+// PipelineError. Requests that never reached the wire, because the kept
+// connection had already been closed or a dial failed, are always retried.
+type PipelineRetrier struct {
+	Dial func(ctx context.Context) (*ClientConn, error)
+
+	// MaxAttempts bounds the connections used for one batch; defaults to 3.
+	MaxAttempts int
+
+	kept keptConn
+}
+
+func (p *PipelineRetrier) DoBatch(reqs []*http.Request) ([]*http.Response, error) {
+	if len(reqs) == 0 {
+		return nil, nil
+	}
+	for _, req := range reqs[:len(reqs)-1] {
+		if req.Close {
+			return nil, ErrPipeline
+		}
+	}
+	attempts := p.MaxAttempts
+	if attempts <= 0 {
+		attempts = 3
+	}
+	ctx := reqs[0].Context()
+	var resps []*http.Response
+	var lastErr error
+	pending := reqs
+	sent := false // pending may have reached a server
+	for try := 0; len(pending) > 0 && try < attempts; try++ {
+		if sent {
+			var ok bool
+			if pending, ok = rewindRequests(pending); !ok {
+				return resps, &PipelineError{Responses: resps, Err: lastErr, Unsafe: true}
+			}
+			sent = false
+		}
+		cc, err := p.kept.get(ctx, p.Dial)
+		if err != nil {
+			lastErr = err
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		got, wrote, err := cc.doBatch(pending)
+		resps = append(resps, got...)
+		pending = pending[len(got):]
+		if err != nil || cc.Ping() != nil {
+			p.kept.discard(cc)
+		}
+		if err != nil {
+			lastErr, sent = err, wrote
+			if ctx.Err() != nil {
+				break
+			}
+		}
+	}
+	if len(pending) > 0 {
+		return resps, &PipelineError{Responses: resps, Err: lastErr}
+	}
+	return resps, nil
+}
+
+// Close closes the kept connection.
+func (p *PipelineRetrier) Close() error {
+	return p.kept.close()
+}
+
+// keptConn is the connection a retrier keeps between calls.
+type keptConn struct {
+	mu sync.Mutex
+	cc *ClientConn
+}
+
+// get returns the kept connection, or one from dial if there is none or
+// the kept one is known to be unusable.
+func (k *keptConn) get(ctx context.Context, dial func(context.Context) (*ClientConn, error)) (*ClientConn, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.cc != nil {
+		if k.cc.Ping() == nil {
+			return k.cc, nil
+		}
+		k.cc.Close()
+		k.cc = nil
+	}
+	cc, err := dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	k.cc = cc
+	return cc, nil
+}
+
+func (k *keptConn) discard(cc *ClientConn) {
+	k.mu.Lock()
+	if k.cc == cc {
+		k.cc = nil
+	}
+	k.mu.Unlock()
+	cc.Close()
+}
+
+func (k *keptConn) close() error {
+	k.mu.Lock()
+	cc := k.cc
+	k.cc = nil
+	k.mu.Unlock()
+	if cc != nil {
+		return cc.Close()
+	}
+	return nil
+}
+
+// isIdempotent reports whether req may be sent twice with the effect of
+// once.
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	_, ok := req.Header["Idempotency-Key"]
+	if !ok {
+		_, ok = req.Header["X-Idempotency-Key"]
+	}
+	return ok
+}
+
+// rewindRequests returns copies of reqs with fresh bodies, or false if any
+// of them may not be resent.
+func rewindRequests(reqs []*http.Request) ([]*http.Request, bool) {
+	out := make([]*http.Request, len(reqs))
+	for i, req := range reqs {
+		if !isIdempotent(req) {
+			return nil, false
+		}
+		r := req.Clone(req.Context())
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return nil, false
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, false
+			}
+			r.Body = body
+		}
+		out[i] = r
+	}
+	return out, true
+}
@@ -0,0 +1,237 @@
+package httpclientutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// retryServer answers with "METHOD PATH BODY" and closes the connection
+// after every closeEvery-th response if closeEvery > 0.
+type retryServer struct {
+	*httptest.Server
+	closeEvery int32
+	n          int32
+	dials      int32
+}
+
+func newRetryServer(t *testing.T, closeEvery int32) *retryServer {
+	s := &retryServer{closeEvery: closeEvery}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		line := fmt.Sprintf("%s %s %s", r.Method, r.URL.Path, b)
+		if every := atomic.LoadInt32(&s.closeEvery); every > 0 && atomic.AddInt32(&s.n, 1)%every == 0 {
+			w.Header().Set("Connection", "close")
+		}
+		io.WriteString(w, line)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *retryServer) retrier(t *testing.T) *PipelineRetrier {
+	p := &PipelineRetrier{MaxAttempts: 10, Dial: func(ctx context.Context) (*ClientConn, error) {
+		atomic.AddInt32(&s.dials, 1)
+		c, err := net.Dial("tcp", s.Listener.Addr().String())
+		if err != nil {
+			return nil, err
+		}
+		return NewClientConn(c), nil
+	}}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func batchRequests(t *testing.T, base string, methods ...string) []*http.Request {
+	var reqs []*http.Request
+	for i, m := range methods {
+		var body io.Reader
+		if m == "PUT" || m == "POST" {
+			body = strings.NewReader(fmt.Sprint("body", i))
+		}
+		req, err := http.NewRequest(m, fmt.Sprintf("%s/%d", base, i), body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs
+}
+
+func checkResponses(t *testing.T, reqs []*http.Request, resps []*http.Response) {
+	t.Helper()
+	for i, resp := range resps {
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		want := fmt.Sprintf("%s /%d ", reqs[i].Method, i)
+		if reqs[i].Method == "PUT" || reqs[i].Method == "POST" {
+			want += fmt.Sprint("body", i)
+		}
+		if string(b) != want {
+			t.Errorf("response %d = %q, want %q", i, b, want)
+		}
+	}
+}
+
+func TestPipelineRetrierNoFailure(t *testing.T) {
+	s := newRetryServer(t, 0)
+	p := s.retrier(t)
+	for round := 0; round < 3; round++ {
+		reqs := batchRequests(t, s.URL, "GET", "POST", "GET")
+		resps, err := p.DoBatch(reqs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkResponses(t, reqs, resps)
+	}
+	if d := atomic.LoadInt32(&s.dials); d != 1 {
+		t.Errorf("dialed %d times, want the connection kept", d)
+	}
+}
+
+func TestPipelineRetrierResendsInOrder(t *testing.T) {
+	s := newRetryServer(t, 3)
+	p := s.retrier(t)
+	reqs := batchRequests(t, s.URL, "GET", "GET", "GET", "GET", "HEAD", "GET", "GET", "DELETE")
+	resps, err := p.DoBatch(reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resps) != len(reqs) {
+		t.Fatalf("%d responses for %d requests", len(resps), len(reqs))
+	}
+	for i, resp := range resps {
+		if resp.Request.URL.Path != fmt.Sprintf("/%d", i) {
+			t.Errorf("response %d answers %s", i, resp.Request.URL.Path)
+		}
+	}
+	if d := atomic.LoadInt32(&s.dials); d < 3 {
+		t.Errorf("dialed %d times, want a new connection after each close", d)
+	}
+}
+
+func TestPipelineRetrierRewindsBodies(t *testing.T) {
+	s := newRetryServer(t, 2)
+	p := s.retrier(t)
+	reqs := batchRequests(t, s.URL, "PUT", "PUT", "PUT", "PUT", "PUT")
+	resps, err := p.DoBatch(reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResponses(t, reqs, resps)
+}
+
+func TestPipelineRetrierRefusesUnsafeResend(t *testing.T) {
+	s := newRetryServer(t, 2)
+	p := s.retrier(t)
+	reqs := batchRequests(t, s.URL, "GET", "GET", "GET", "POST", "GET")
+	resps, err := p.DoBatch(reqs)
+	var pe *PipelineError
+	if !errors.As(err, &pe) || !pe.Unsafe {
+		t.Fatalf("err = %v, want an unsafe PipelineError", err)
+	}
+	if len(resps) != 2 || len(pe.Responses) != 2 {
+		t.Fatalf("got %d responses, want the 2 answered", len(resps))
+	}
+	checkResponses(t, reqs, resps)
+}
+
+func TestPipelineRetrierIdempotencyKey(t *testing.T) {
+	s := newRetryServer(t, 1)
+	p := s.retrier(t)
+	reqs := batchRequests(t, s.URL, "GET", "POST", "POST")
+	for _, req := range reqs {
+		req.Header.Set("Idempotency-Key", req.URL.Path)
+	}
+	resps, err := p.DoBatch(reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResponses(t, reqs, resps)
+}
+
+func TestPipelineRetrierBodyWithoutGetBody(t *testing.T) {
+	s := newRetryServer(t, 1)
+	p := s.retrier(t)
+	reqs := batchRequests(t, s.URL, "GET", "PUT")
+	reqs[1].GetBody = nil
+	_, err := p.DoBatch(reqs)
+	var pe *PipelineError
+	if !errors.As(err, &pe) || !pe.Unsafe {
+		t.Fatalf("err = %v, want an unsafe PipelineError", err)
+	}
+}
+
+// A kept connection the server closed while idle fails before anything is
+// written, so even a POST is sent on a new connection.
+func TestPipelineRetrierIdleClosedConn(t *testing.T) {
+	s := newRetryServer(t, 0)
+	p := s.retrier(t)
+	if _, err := p.DoBatch(batchRequests(t, s.URL, "GET")); err != nil {
+		t.Fatal(err)
+	}
+	cc, _ := p.kept.get(context.Background(), p.Dial)
+	s.CloseClientConnections()
+	waitFor(t, "closed connection", func() bool { return cc.Ping() != nil })
+	reqs := batchRequests(t, s.URL, "POST", "POST")
+	resps, err := p.DoBatch(reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResponses(t, reqs, resps)
+}
+
+func TestPipelineRetrierDialFailure(t *testing.T) {
+	s := newRetryServer(t, 0)
+	p := s.retrier(t)
+	dial := p.Dial
+	failures := 2
+	p.Dial = func(ctx context.Context) (*ClientConn, error) {
+		if failures > 0 {
+			failures--
+			return nil, errors.New("dial failed")
+		}
+		return dial(ctx)
+	}
+	reqs := batchRequests(t, s.URL, "POST", "GET")
+	resps, err := p.DoBatch(reqs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResponses(t, reqs, resps)
+}
+
+func TestPipelineRetrierGivesUp(t *testing.T) {
+	s := newRetryServer(t, 1)
+	p := s.retrier(t)
+	p.MaxAttempts = 2
+	reqs := batchRequests(t, s.URL, "GET", "GET", "GET", "GET")
+	resps, err := p.DoBatch(reqs)
+	var pe *PipelineError
+	if !errors.As(err, &pe) || pe.Unsafe {
+		t.Fatalf("err = %v, want a PipelineError", err)
+	}
+	if len(resps) != 2 {
+		t.Fatalf("got %d responses, want one per attempt", len(resps))
+	}
+}
+
+func TestPipelineRetrierCloseInMiddle(t *testing.T) {
+	s := newRetryServer(t, 0)
+	p := s.retrier(t)
+	reqs := batchRequests(t, s.URL, "GET", "GET")
+	reqs[0].Close = true
+	if _, err := p.DoBatch(reqs); err != ErrPipeline {
+		t.Fatalf("err = %v, want ErrPipeline", err)
+	}
+	if d := atomic.LoadInt32(&s.dials); d != 0 {
+		t.Errorf("dialed %d times for an invalid batch", d)
+	}
+}
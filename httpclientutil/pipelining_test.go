@@ -0,0 +1,101 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestPipelining(t *testing.T) {
+	got := make(chan string, 3)
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		for i := 0; i < 3; i++ {
+			req, err := http.ReadRequest(br)
+			if err != nil {
+				return
+			}
+			got <- req.URL.Path
+			writeResponse(c, req.URL.Path)
+		}
+	})
+	cc.SetPipelining(true)
+	req, _ := http.NewRequest("GET", "http://a.example/1", nil)
+	first, err := cc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-got
+
+	// The first body is still unread, yet the next requests go out.
+	type result struct {
+		body string
+		err  error
+	}
+	results := make([]chan result, 2)
+	for i, path := range []string{"/2", "/3"} {
+		results[i] = make(chan result, 1)
+		req, _ := http.NewRequest("GET", "http://a.example"+path, nil)
+		go func(ch chan result) {
+			resp, err := cc.Do(req)
+			if err != nil {
+				ch <- result{err: err}
+				return
+			}
+			b, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			ch <- result{string(b), err}
+		}(results[i])
+		if p := <-got; p != path {
+			t.Fatalf("server got %s, want %s", p, path)
+		}
+	}
+	b, _ := io.ReadAll(first.Body)
+	first.Body.Close()
+	if string(b) != "/1" {
+		t.Errorf("first body = %q", b)
+	}
+	for i, want := range []string{"/2", "/3"} {
+		if r := <-results[i]; r.err != nil || r.body != want {
+			t.Errorf("response %d = %q, %v; want %q", i+2, r.body, r.err, want)
+		}
+	}
+}
+
+func TestPipelineBreaks(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		if !answer(c, br, "one") {
+			return
+		}
+		// Take two more requests and hang up without answering.
+		http.ReadRequest(br)
+		http.ReadRequest(br)
+	})
+	cc.SetPipelining(true)
+	req, _ := http.NewRequest("GET", "http://a.example/1", nil)
+	first, err := cc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			req, _ := http.NewRequest("GET", "http://a.example/n", nil)
+			resp, err := cc.Do(req)
+			if err == nil {
+				resp.Body.Close()
+			}
+			errs <- err
+		}()
+	}
+	drainBody(first)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err == nil {
+			t.Error("request on a broken pipeline succeeded")
+		}
+	}
+	if cc.Reusable() {
+		t.Error("broken connection still reusable")
+	}
+}
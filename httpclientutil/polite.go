@@ -0,0 +1,371 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrDisallowed = errors.New("http: request disallowed by crawl policy")
+
+// CrawlPolicy decides whether a crawler may send req. A policy that also
+// implements CrawlDelay(host) can ask for more spacing than MinInterval.
+type CrawlPolicy interface {
+	Allow(req *http.Request) (bool, error)
+}
+
+// PoliteDoer spaces requests to each host at least MinInterval apart and
+// consults Policy before sending. Requests wait for their slot, or fail
+// with the context error if it is canceled first. A 429 or 503 response
+// with Retry-After holds the next request to its host back until the wait
+// is over. It is safe for concurrent use.
+type PoliteDoer struct {
+	Doer        Doer
+	MinInterval time.Duration
+	Policy      CrawlPolicy
+	Clock       Clock // nil is the system clock
+
+	hosts shardedMap // host -> *politeHost
+}
+
+type politeHost struct {
+	mu   sync.Mutex
+	next time.Time // earliest start of the next request
+}
+
+func (p *PoliteDoer) Do(req *http.Request) (*http.Response, error) {
+	if p.Policy != nil {
+		ok, err := p.Policy.Allow(req)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrDisallowed
+		}
+	}
+	host := req.URL.Host
+	interval := p.MinInterval
+	if d, ok := p.Policy.(interface{ CrawlDelay(string) time.Duration }); ok {
+		if cd := d.CrawlDelay(host); cd > interval {
+			interval = cd
+		}
+	}
+	clock := clockOrSystem(p.Clock)
+	if wait := p.reserve(clock, host, interval); wait > 0 {
+		if !sleep(clock, wait, req.Context().Done()) {
+			return nil, context.Cause(req.Context())
+		}
+	}
+	resp, err := p.Doer.Do(req)
+	if err == nil {
+		if wait, ok := retryAfter(resp, clock.Now()); ok {
+			p.holdOff(host, clock.Now().Add(wait))
+		}
+	}
+	return resp, err
+}
+
+// reserve books the next slot for host and returns how long to wait for it.
+func (p *PoliteDoer) reserve(clock Clock, host string, interval time.Duration) time.Duration {
+	h := p.hosts.load(host, func() interface{} { return new(politeHost) }).(*politeHost)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := clock.Now()
+	start := h.next
+	if start.Before(now) {
+		start = now
+	}
+	h.next = start.Add(interval)
+	return start.Sub(now)
+}
+
+// holdOff keeps the next request to host from starting before t.
+func (p *PoliteDoer) holdOff(host string, t time.Time) {
+	h := p.hosts.load(host, func() interface{} { return new(politeHost) }).(*politeHost)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.next.Before(t) {
+		h.next = t
+	}
+}
+
+// RobotsPolicy is a CrawlPolicy backed by each host's /robots.txt, fetched
+// through Doer on first use and cached for TTL. An unreachable robots.txt
+// or a 429 or 5xx answer disallows the host until it is fetched again a
+// minute later, or once the wait of a Retry-After header is over; another
+// 4xx answer allows everything. A fetch cut short by the caller's
+// context is not cached.
+type RobotsPolicy struct {
+	Doer      Doer
+	UserAgent string
+	TTL       time.Duration // zero caches for a day
+	Clock     Clock         // nil is the system clock
+
+	mu    sync.Mutex
+	hosts map[string]*robotsRules
+}
+
+type robotsRules struct {
+	fetched  time.Time
+	deny     bool // disallow everything
+	rules    []robotsRule
+	delay    time.Duration
+	fetching chan struct{} // closed once fetched is set, or on abort
+
+	transient bool          // denied for a failure that may clear up soon
+	retry     time.Duration // Retry-After of a transient failure, if any
+	aborted   bool          // the fetching request's context ended; set before fetching closes
+}
+
+// robotsRetryInterval is how long a host stays denied after robots.txt
+// could not be fetched or the server failed.
+const robotsRetryInterval = time.Minute
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+func (rp *RobotsPolicy) Allow(req *http.Request) (bool, error) {
+	if req.URL.Path == "/robots.txt" {
+		return true, nil
+	}
+	rr, err := rp.rules(req)
+	if err != nil {
+		return false, err
+	}
+	if rr.deny {
+		return false, nil
+	}
+	return rr.allowed(req.URL.EscapedPath()), nil
+}
+
+// CrawlDelay returns the Crawl-delay of host, if it has been fetched.
+func (rp *RobotsPolicy) CrawlDelay(host string) time.Duration {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	if rr := rp.hosts[host]; rr != nil && !rr.fetched.IsZero() {
+		return rr.delay
+	}
+	return 0
+}
+
+func (rp *RobotsPolicy) rules(req *http.Request) (*robotsRules, error) {
+	ttl := rp.TTL
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	host := req.URL.Host
+	ctx := req.Context()
+	clock := clockOrSystem(rp.Clock)
+	for {
+		rp.mu.Lock()
+		if rp.hosts == nil {
+			rp.hosts = make(map[string]*robotsRules)
+		}
+		rr := rp.hosts[host]
+		if rr != nil && rr.fetched.IsZero() {
+			// Someone else is fetching it.
+			rp.mu.Unlock()
+			select {
+			case <-rr.fetching:
+				if rr.aborted {
+					continue // their context ended; fetch it ourselves
+				}
+				return rr, nil
+			case <-ctx.Done():
+				return nil, context.Cause(ctx)
+			}
+		}
+		if rr != nil && clock.Now().Sub(rr.fetched) < rr.lifetime(ttl) {
+			rp.mu.Unlock()
+			return rr, nil
+		}
+		rr = &robotsRules{fetching: make(chan struct{})}
+		rp.hosts[host] = rr
+		rp.mu.Unlock()
+
+		rp.fetch(req, rr)
+		rp.mu.Lock()
+		if ctx.Err() != nil {
+			// The caller gave up, which says nothing about the host.
+			rr.aborted = true
+			if rp.hosts[host] == rr {
+				delete(rp.hosts, host)
+			}
+			rp.mu.Unlock()
+			close(rr.fetching)
+			return nil, context.Cause(ctx)
+		}
+		rr.fetched = clock.Now()
+		rp.mu.Unlock()
+		close(rr.fetching)
+		return rr, nil
+	}
+}
+
+// lifetime returns how long rr stays cached.
+func (rr *robotsRules) lifetime(ttl time.Duration) time.Duration {
+	if !rr.transient {
+		return ttl
+	}
+	retry := robotsRetryInterval
+	if rr.retry > 0 {
+		retry = rr.retry
+	}
+	if retry < ttl {
+		return retry
+	}
+	return ttl
+}
+
+func (rp *RobotsPolicy) fetch(orig *http.Request, rr *robotsRules) {
+	u := *orig.URL
+	u.Path, u.RawPath, u.RawQuery, u.Fragment = "/robots.txt", "", "", ""
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		rr.deny = true
+		return
+	}
+	if rp.UserAgent != "" {
+		req.Header.Set("User-Agent", rp.UserAgent)
+	}
+	resp, err := rp.Doer.Do(req.WithContext(orig.Context()))
+	if err != nil {
+		rr.deny, rr.transient = true, true
+		return
+	}
+	defer drainBody(resp)
+	switch {
+	case resp.StatusCode/100 == 2:
+		rr.parse(io.LimitReader(resp.Body, 500<<10), rp.UserAgent)
+	case resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusTooManyRequests:
+	default:
+		rr.deny, rr.transient = true, true
+		rr.retry, _ = retryAfter(resp, clockOrSystem(rp.Clock).Now())
+	}
+}
+
+// parse keeps the rules of the group matching agent, falling back to the
+// "*" group.
+func (rr *robotsRules) parse(r io.Reader, agent string) {
+	agent = strings.ToLower(agent)
+	if i := strings.IndexByte(agent, '/'); i >= 0 {
+		agent = agent[:i]
+	}
+	var (
+		specific, generic   []robotsRule
+		specDelay, genDelay time.Duration
+		matchSpec, matchGen bool
+		haveSpec            bool
+		inAgents            bool // still reading the User-agent lines of a group
+	)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:i]))
+		val := strings.TrimSpace(line[i+1:])
+		if key == "user-agent" {
+			if !inAgents {
+				matchSpec, matchGen = false, false
+				inAgents = true
+			}
+			ua := strings.ToLower(val)
+			switch {
+			case ua == "*":
+				matchGen = true
+			case agent != "" && strings.Contains(agent, ua):
+				matchSpec, haveSpec = true, true
+			}
+			continue
+		}
+		inAgents = false
+		var rule *robotsRule
+		switch key {
+		case "allow":
+			rule = &robotsRule{allow: true, pattern: val}
+		case "disallow":
+			if val != "" {
+				rule = &robotsRule{pattern: val}
+			}
+		case "crawl-delay":
+			if secs, err := strconv.ParseFloat(val, 64); err == nil && secs > 0 {
+				d := time.Duration(secs * float64(time.Second))
+				if matchSpec {
+					specDelay = d
+				}
+				if matchGen {
+					genDelay = d
+				}
+			}
+		}
+		if rule == nil {
+			continue
+		}
+		if matchSpec {
+			specific = append(specific, *rule)
+		}
+		if matchGen {
+			generic = append(generic, *rule)
+		}
+	}
+	if haveSpec {
+		rr.rules, rr.delay = specific, specDelay
+	} else {
+		rr.rules, rr.delay = generic, genDelay
+	}
+}
+
+// allowed applies the longest matching rule to path; Allow wins ties.
+func (rr *robotsRules) allowed(path string) bool {
+	best, allow := -1, true
+	for _, r := range rr.rules {
+		if !robotsMatch(r.pattern, path) {
+			continue
+		}
+		if n := len(r.pattern); n > best || (n == best && r.allow) {
+			best, allow = n, r.allow
+		}
+	}
+	return allow
+}
+
+// robotsMatch matches path against a robots.txt pattern supporting '*'
+// and a trailing '$'.
+func robotsMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	path = path[len(parts[0]):]
+	if len(parts) == 1 {
+		return !anchored || path == ""
+	}
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(path, part)
+		if i < 0 {
+			return false
+		}
+		path = path[i+len(part):]
+	}
+	last := parts[len(parts)-1]
+	if anchored {
+		return strings.HasSuffix(path, last)
+	}
+	return strings.Contains(path, last)
+}
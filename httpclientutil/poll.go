@@ -0,0 +1,93 @@
+package httpclientutil
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"sync"
+)
+
+// defaultPollMaxBody is the largest body Poller hashes when MaxBody is
+// zero.
+const defaultPollMaxBody = 1 << 20
+
+// Poller fetches URLs repeatedly with FetchIfChanged, keeping the
+// validators of each, and also reports NotModified for a 2xx body equal
+// to the last one, for servers that send neither ETag nor Last-Modified
+// or change them on every response. Bodies are hashed in memory; a
+// longer one than MaxBody is always reported as changed. It is safe for
+// concurrent use.
+type Poller struct {
+	Doer    Doer
+	MaxBody int64 // 1MB if zero
+
+	mu   sync.Mutex
+	meta map[string]*ResponseMeta
+}
+
+// Poll fetches target. A changed response comes with its body read into
+// memory; the caller must still close it.
+func (p *Poller) Poll(ctx context.Context, target string) (*FetchResult, error) {
+	p.mu.Lock()
+	prev := p.meta[target]
+	p.mu.Unlock()
+	res, err := FetchIfChanged(ctx, p.Doer, target, prev)
+	if err != nil {
+		return nil, err
+	}
+	if res.Status == Changed {
+		if err := p.hash(res); err != nil {
+			return nil, err
+		}
+		if prev != nil && res.Meta.BodySum != "" && res.Meta.BodySum == prev.BodySum {
+			res.Response.Body.Close()
+			res.Status, res.Response, res.Duplicate = NotModified, nil, true
+		}
+	} else if prev != nil {
+		res.Meta.BodySum = prev.BodySum
+	}
+	p.mu.Lock()
+	if p.meta == nil {
+		p.meta = make(map[string]*ResponseMeta)
+	}
+	p.meta[target] = res.Meta
+	p.mu.Unlock()
+	return res, nil
+}
+
+// Forget drops what Poller knows of target, so the next Poll fetches it
+// unconditionally.
+func (p *Poller) Forget(target string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.meta, target)
+}
+
+// hash reads the body of res into memory and records its sum, leaving it
+// unset for a body longer than MaxBody, which is replayed unread.
+func (p *Poller) hash(res *FetchResult) error {
+	max := p.MaxBody
+	if max <= 0 {
+		max = defaultPollMaxBody
+	}
+	resp := res.Response
+	body, err := io.ReadAll(io.LimitReader(resp.Body, max+1))
+	if err != nil {
+		resp.Body.Close()
+		return err
+	}
+	if int64(len(body)) > max {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return nil
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	sum := sha256.Sum256(body)
+	res.Meta.BodySum = hex.EncodeToString(sum[:])
+	return nil
+}
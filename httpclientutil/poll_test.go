@@ -0,0 +1,129 @@
+package httpclientutil
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPollerDedupe(t *testing.T) {
+	var version int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// No validators, and a body that changes only when version does.
+		io.WriteString(w, strings.Repeat("v", int(atomic.LoadInt32(&version))+1))
+	}))
+	defer s.Close()
+	p := &Poller{Doer: http.DefaultClient}
+	poll := func() *FetchResult {
+		t.Helper()
+		res, err := p.Poll(context.Background(), s.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	res := poll()
+	if res.Status != Changed || res.Duplicate || res.Meta.BodySum == "" {
+		t.Fatalf("first poll: %+v", res)
+	}
+	b, _ := io.ReadAll(res.Response.Body)
+	res.Response.Body.Close()
+	if string(b) != "v" {
+		t.Errorf("body = %q", b)
+	}
+	if res := poll(); res.Status != NotModified || !res.Duplicate || res.Response != nil {
+		t.Errorf("same body: %+v", res)
+	}
+	atomic.StoreInt32(&version, 1)
+	if res := poll(); res.Status != Changed {
+		t.Errorf("new body: %+v", res)
+	} else {
+		res.Response.Body.Close()
+	}
+	p.Forget(s.URL)
+	if res := poll(); res.Status != Changed {
+		t.Errorf("after Forget: %+v", res)
+	} else {
+		res.Response.Body.Close()
+	}
+}
+
+func TestPollerETag(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"1"`)
+		io.WriteString(w, "body")
+	}))
+	defer s.Close()
+	p := &Poller{Doer: http.DefaultClient}
+	res, err := p.Poll(context.Background(), s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Response.Body.Close()
+	sum := res.Meta.BodySum
+	res, err = p.Poll(context.Background(), s.URL)
+	if err != nil || res.Status != NotModified || res.Duplicate {
+		t.Fatalf("304: %+v, %v", res, err)
+	}
+	if res.Meta.BodySum != sum {
+		t.Error("304 lost the body sum")
+	}
+}
+
+func TestPollerLargeBody(t *testing.T) {
+	body := strings.Repeat("x", 100)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+	defer s.Close()
+	p := &Poller{Doer: http.DefaultClient, MaxBody: 10}
+	for i := 0; i < 2; i++ {
+		res, err := p.Poll(context.Background(), s.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Status != Changed {
+			t.Fatalf("poll %d of an unhashed body: %v", i, res.Status)
+		}
+		b, _ := io.ReadAll(res.Response.Body)
+		res.Response.Body.Close()
+		if string(b) != body {
+			t.Errorf("body = %q", b)
+		}
+	}
+}
+
+func TestFetchIfChangedLastModified(t *testing.T) {
+	var got []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("If-Modified-Since"))
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer s.Close()
+	for _, lm := range []string{
+		"Sun, 06 Nov 1994 08:49:37 GMT",
+		"Sunday, 06-Nov-94 08:49:37 GMT", // obsolete RFC 850 form
+		"Sun Nov  6 08:49:37 1994",       // obsolete asctime form
+		"yesterday",
+	} {
+		res, err := FetchIfChanged(context.Background(), http.DefaultClient, s.URL, &ResponseMeta{LastModified: lm})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Meta.LastModified != lm {
+			t.Errorf("kept Last-Modified %q, want %q", res.Meta.LastModified, lm)
+		}
+	}
+	want := []string{"Sun, 06 Nov 1994 08:49:37 GMT", "Sun, 06 Nov 1994 08:49:37 GMT", "Sun, 06 Nov 1994 08:49:37 GMT", ""}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("If-Modified-Since sent %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,459 @@
+package httpclientutil
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClientConnPool keeps ClientConns to many hosts and sends each request on
+// an idle one, dialing when there is none. Connections are keyed by
+// ConnKey, so virtual hosts that Dialer maps to one address share them (a
+// TLS connection only with the hosts its certificate covers), and by
+// WithConnTag tag, so a tenant's requests only use connections counted
+// against its quota. A connection goes back to the pool once the response
+// body is closed or read to EOF, and is retired instead when it can no
+// longer be reused. It is safe for concurrent use.
+//
+// A request with a unix socket URL, unix:///var/run/docker.sock:/info say,
+// goes to that socket, on connections keyed by its path; see SplitUnixURL.
+//
+// The pool is a Doer, so the limiting and failover helpers of this package
+// wrap it like any other.
+type ClientConnPool struct {
+	// Dialer dials new connections; nil is a zero Dialer.
+	Dialer *Dialer
+
+	// TLSConfig is cloned for each https connection, with ServerName
+	// defaulting to the request's host.
+	TLSConfig *tls.Config
+
+	// ConnOptions are passed to NewClientConn for each connection, e.g.
+	// WithResponseHeaderTimeout.
+	ConnOptions []Option
+
+	// NewConn, if set, configures each connection before first use, e.g.
+	// with SetEarlyResponsePolicy.
+	NewConn func(*ClientConn)
+
+	// ProbeIdle, if set, checks an idle connection before it carries a
+	// request, and the connection is retired if it fails: ClientConn.Probe
+	// peeks at the socket for a close the connection has not noticed yet,
+	// and ProbeRequest sends a request. A failed probe does not fail the
+	// request, which goes on the next connection.
+	ProbeIdle func(context.Context, *ClientConn) error
+
+	// Archiver, if set, tees every response to its sink.
+	Archiver *Archiver
+
+	// Proxy, if set, returns the proxy for a request, nil for none, like
+	// http.Transport.Proxy; http.ProxyFromEnvironment follows HTTP_PROXY,
+	// HTTPS_PROXY and NO_PROXY. Plain http requests go to the proxy in
+	// absolute form, sharing its connections whatever their origin; https
+	// requests get a CONNECT tunnel per origin. Credentials in the proxy
+	// URL are sent as Basic Proxy-Authorization. A socks5:// or socks5h://
+	// URL dials through a SOCKS5 proxy instead, as Dialer.SOCKS does, for
+	// connections that are direct as far as HTTP goes.
+	Proxy func(*http.Request) (*url.URL, error)
+
+	MaxIdleConnsPerHost int           // 2 if zero; negative keeps no idle connections
+	MaxConnsPerHost     int           // dialed or in use; zero means no limit
+	IdleTimeout         time.Duration // 90s if zero
+	Clock               Clock         // ages idle connections; nil is the system clock
+
+	// hosts maps poolKey.id to *poolHost. Each host has its own lock, so
+	// requests to different hosts only meet on the shard lock for the
+	// lookup.
+	hosts  shardedMap
+	closed atomicBool
+
+	mu   sync.Mutex  // guards errs
+	errs []PoolError // the last maxPoolErrors, oldest first
+}
+
+type poolKey struct {
+	ConnKey
+	tag   string
+	proxy string // URL of the proxy, if any
+}
+
+// id is k as a hosts key. The tag goes last, as the only part that may
+// contain spaces.
+func (k poolKey) id() string {
+	return k.Scheme + " " + k.Addr + " " + k.proxy + " " + k.tag
+}
+
+func (k poolKey) viaSOCKS() bool {
+	return strings.HasPrefix(k.proxy, "socks5://") || strings.HasPrefix(k.proxy, "socks5h://")
+}
+
+type poolHost struct {
+	key poolKey
+
+	mu      sync.Mutex             // guards the rest
+	idle    []*poolConn            // most recently used last
+	busy    map[*poolConn]struct{} // carrying a request
+	open    int                    // connections counted against MaxConnsPerHost
+	waiters []*poolWaiter          // for a connection or a free slot, in arrival order
+}
+
+type poolConn struct {
+	cc       *ClientConn
+	conn     net.Conn // as dialed, for CanServeHost
+	anyHost  bool     // a connection to a proxy, for any origin
+	key      poolKey
+	dialedAt time.Time
+	idleAt   time.Time
+	requests int // guarded by the mu of its host
+}
+
+type poolWaiter struct {
+	host string
+	// ready receives an idle connection that can serve host, or nil
+	// for a slot to dial in.
+	ready chan *poolConn
+}
+
+func (p *ClientConnPool) Do(req *http.Request) (*http.Response, error) {
+	resp, err := p.do(req)
+	if err != nil {
+		p.noteError(req, err)
+	}
+	return resp, err
+}
+
+func (p *ClientConnPool) do(req *http.Request) (*http.Response, error) {
+	req = unixRequest(req)
+	key, err := p.key(req)
+	if err != nil {
+		return nil, err
+	}
+	if key.proxy != "" && key.Scheme == "http" && !key.viaSOCKS() {
+		req = withProxyAuth(req, key.proxy)
+	}
+	traceGetConn(req.Context(), key.Addr)
+	pc, reused, err := p.get(req, key)
+	if err != nil {
+		return nil, err
+	}
+	p.lease(pc)
+	resp, err := pc.cc.Do(req)
+	if err != nil && reused && req.Context().Err() == nil {
+		// The server may have closed the idle connection just as the
+		// request went out; a fresh connection tells.
+		p.retire(pc)
+		retry, ok := rewindRequests([]*http.Request{req})
+		if !ok {
+			return nil, err
+		}
+		req = retry[0]
+		if pc, err = p.dial(req, key); err != nil {
+			return nil, err
+		}
+		p.lease(pc)
+		resp, err = pc.cc.Do(req)
+	}
+	if err != nil {
+		p.retire(pc)
+		return nil, err
+	}
+	resp.Body = newNotifyBody(resp.Body, func(error) { p.put(pc) })
+	if p.Archiver != nil {
+		p.Archiver.Tee(resp)
+	}
+	return resp, nil
+}
+
+// CloseIdleConnections closes the connections not carrying a request.
+func (p *ClientConnPool) CloseIdleConnections() {
+	var idle []*poolConn
+	for _, v := range p.hosts.values() {
+		h := v.(*poolHost)
+		h.mu.Lock()
+		idle = append(idle, h.idle...)
+		h.open -= len(h.idle)
+		h.idle = nil
+		p.grant(h)
+		h.mu.Unlock()
+	}
+	for _, pc := range idle {
+		pc.cc.Close()
+	}
+}
+
+// Close closes the idle connections and makes further requests fail with
+// ErrClosed. Connections in use are closed when their response is done.
+func (p *ClientConnPool) Close() error {
+	// A host added after the loop below takes its first lock after the
+	// store, and sees the pool closed.
+	p.closed.Store(true)
+	for _, v := range p.hosts.values() {
+		h := v.(*poolHost)
+		h.mu.Lock()
+		// Wake the waiters, which see the pool closed.
+		for _, w := range h.waiters {
+			h.open++
+			w.ready <- nil
+		}
+		h.waiters = nil
+		h.mu.Unlock()
+	}
+	p.CloseIdleConnections()
+	return nil
+}
+
+func (p *ClientConnPool) key(req *http.Request) (poolKey, error) {
+	tag, _ := req.Context().Value(connTagKey{}).(string)
+	if socket, ok := unixSocket(req); ok {
+		return poolKey{ConnKey: ConnKey{Scheme: "unix", Addr: socket}, tag: tag}, nil
+	}
+	key := poolKey{ConnKey: p.Dialer.ConnKey(req.Context(), req.URL), tag: tag}
+	if p.Proxy == nil {
+		return key, nil
+	}
+	proxy, err := p.Proxy(req)
+	if err != nil || proxy == nil {
+		return key, err
+	}
+	key.proxy = proxy.String()
+	if key.Scheme == "http" && !isSOCKS(proxy) {
+		key.ConnKey = ConnKey{Scheme: "http", Addr: canonicalAddr(proxy)}
+	}
+	return key, nil
+}
+
+func (p *ClientConnPool) maxIdle() int {
+	if p.MaxIdleConnsPerHost == 0 {
+		return 2
+	}
+	return p.MaxIdleConnsPerHost
+}
+
+func (p *ClientConnPool) idleTimeout() time.Duration {
+	if p.IdleTimeout <= 0 {
+		return 90 * time.Second
+	}
+	return p.IdleTimeout
+}
+
+// get returns an idle connection that can serve req, or dials one once
+// MaxConnsPerHost allows.
+func (p *ClientConnPool) get(req *http.Request, key poolKey) (pc *poolConn, reused bool, err error) {
+	host := req.URL.Hostname()
+	now := clockOrSystem(p.Clock).Now()
+	h := p.host(key)
+	h.mu.Lock()
+	if p.closed.Load() {
+		h.mu.Unlock()
+		return nil, false, ErrClosed
+	}
+	var stale []*poolConn
+	for i := len(h.idle) - 1; i >= 0; i-- {
+		c := h.idle[i]
+		if !c.cc.Reusable() || now.Sub(c.idleAt) >= p.idleTimeout() {
+			h.idle = append(h.idle[:i], h.idle[i+1:]...)
+			h.open--
+			stale = append(stale, c)
+			continue
+		}
+		if c.canServe(host) {
+			h.idle = append(h.idle[:i], h.idle[i+1:]...)
+			pc = c
+			break
+		}
+	}
+	if len(stale) > 0 {
+		p.grant(h)
+	}
+	if pc == nil && (p.MaxConnsPerHost <= 0 || h.open < p.MaxConnsPerHost) {
+		h.open++
+		h.mu.Unlock()
+		closeAll(stale)
+		pc, err = p.dialReserved(req, key)
+		return pc, false, err
+	}
+	if pc != nil {
+		h.mu.Unlock()
+		closeAll(stale)
+		if p.ProbeIdle != nil && runHook("ClientConnPool.ProbeIdle", func() error { return p.ProbeIdle(req.Context(), pc.cc) }) != nil {
+			p.retire(pc)
+			if req.Context().Err() != nil {
+				return nil, false, context.Cause(req.Context())
+			}
+			return p.get(req, key)
+		}
+		return pc, true, nil
+	}
+	w := &poolWaiter{host: host, ready: make(chan *poolConn, 1)}
+	h.waiters = append(h.waiters, w)
+	h.mu.Unlock()
+	closeAll(stale)
+	select {
+	case pc = <-w.ready:
+	case <-req.Context().Done():
+		h.mu.Lock()
+		for i, o := range h.waiters {
+			if o == w {
+				h.waiters = append(h.waiters[:i], h.waiters[i+1:]...)
+				h.mu.Unlock()
+				return nil, false, context.Cause(req.Context())
+			}
+		}
+		h.mu.Unlock()
+		// Served meanwhile; pass it on.
+		if pc = <-w.ready; pc != nil {
+			p.put(pc)
+		} else {
+			p.release(key)
+		}
+		return nil, false, context.Cause(req.Context())
+	}
+	if pc != nil {
+		return pc, true, nil
+	}
+	if p.closed.Load() {
+		p.release(key)
+		return nil, false, ErrClosed
+	}
+	pc, err = p.dialReserved(req, key)
+	return pc, false, err
+}
+
+// dial reserves a slot, waiting for none, and dials.
+func (p *ClientConnPool) dial(req *http.Request, key poolKey) (*poolConn, error) {
+	h := p.host(key)
+	h.mu.Lock()
+	h.open++
+	h.mu.Unlock()
+	return p.dialReserved(req, key)
+}
+
+// dialReserved dials for a slot already counted in open, and frees it again
+// if the dial fails.
+func (p *ClientConnPool) dialReserved(req *http.Request, key poolKey) (*poolConn, error) {
+	d := p.Dialer
+	if d == nil {
+		d = new(Dialer)
+	}
+	var config *tls.Config
+	if strings.EqualFold(req.URL.Scheme, "https") {
+		if config = p.TLSConfig; config == nil {
+			config = new(tls.Config)
+		}
+	}
+	var c net.Conn
+	var err error
+	if socket, ok := unixSocket(req); ok {
+		c, err = d.DialContext(req.Context(), "unix", socket)
+	} else if key.proxy != "" {
+		c, err = p.dialProxy(d, req, key.proxy, config)
+	} else {
+		c, err = d.dialTLS(req.Context(), "tcp", canonicalAddr(req.URL), config)
+	}
+	if err != nil {
+		p.release(key)
+		return nil, err
+	}
+	pc := &poolConn{conn: c, key: key, dialedAt: clockOrSystem(p.Clock).Now()}
+	if key.proxy != "" && config == nil && !key.viaSOCKS() {
+		pc.cc = NewClientConn(c, append([]Option{WithProxyMode(true)}, p.ConnOptions...)...)
+		pc.anyHost = true
+	} else {
+		pc.cc = NewClientConn(c, p.ConnOptions...)
+	}
+	if p.NewConn != nil {
+		if err := runHook("ClientConnPool.NewConn", func() error { p.NewConn(pc.cc); return nil }); err != nil {
+			pc.cc.Close()
+			p.release(key)
+			return nil, err
+		}
+	}
+	return pc, nil
+}
+
+// put returns pc after its response is done, to a waiter or the idle list.
+func (p *ClientConnPool) put(pc *poolConn) {
+	if !pc.cc.Reusable() {
+		p.retire(pc)
+		return
+	}
+	h := p.host(pc.key)
+	h.mu.Lock()
+	delete(h.busy, pc)
+	for i, w := range h.waiters {
+		if pc.canServe(w.host) {
+			h.waiters = append(h.waiters[:i], h.waiters[i+1:]...)
+			h.mu.Unlock()
+			w.ready <- pc
+			return
+		}
+	}
+	if p.closed.Load() || len(h.idle) >= p.maxIdle() || len(h.waiters) > 0 {
+		// Nobody here can use it: free its slot for a waiter, if any.
+		h.open--
+		p.grant(h)
+		h.mu.Unlock()
+		pc.cc.Close()
+		return
+	}
+	pc.idleAt = clockOrSystem(p.Clock).Now()
+	h.idle = append(h.idle, pc)
+	h.mu.Unlock()
+}
+
+// retire closes a broken pc and frees its slot.
+func (p *ClientConnPool) retire(pc *poolConn) {
+	pc.cc.Close()
+	h := p.host(pc.key)
+	h.mu.Lock()
+	delete(h.busy, pc)
+	h.mu.Unlock()
+	p.release(pc.key)
+}
+
+// lease records pc as carrying a request, for State.
+func (p *ClientConnPool) lease(pc *poolConn) {
+	h := p.host(pc.key)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.busy == nil {
+		h.busy = make(map[*poolConn]struct{})
+	}
+	h.busy[pc] = struct{}{}
+	pc.requests++
+}
+
+// release frees a slot of key for the next waiter.
+func (p *ClientConnPool) release(key poolKey) {
+	h := p.host(key)
+	h.mu.Lock()
+	h.open--
+	p.grant(h)
+	h.mu.Unlock()
+}
+
+// grant hands free slots to waiters. The caller holds h.mu.
+func (p *ClientConnPool) grant(h *poolHost) {
+	for len(h.waiters) > 0 && (p.MaxConnsPerHost <= 0 || h.open < p.MaxConnsPerHost) {
+		w := h.waiters[0]
+		h.waiters = h.waiters[1:]
+		h.open++
+		w.ready <- nil
+	}
+}
+
+// host returns the state of key, adding it on first use.
+func (p *ClientConnPool) host(key poolKey) *poolHost {
+	return p.hosts.load(key.id(), func() interface{} { return &poolHost{key: key} }).(*poolHost)
+}
+
+func closeAll(conns []*poolConn) {
+	for _, pc := range conns {
+		pc.cc.Close()
+	}
+}
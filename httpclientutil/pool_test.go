@@ -0,0 +1,240 @@
+package httpclientutil
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingServer serves the request path and counts the connections
+// accepted and the most handled at once.
+type countingServer struct {
+	*httptest.Server
+	conns           int32
+	active, maxSeen int32
+	delay           time.Duration
+}
+
+func newCountingServer(t *testing.T, delay time.Duration) *countingServer {
+	s := &countingServer{delay: delay}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&s.active, 1)
+		defer atomic.AddInt32(&s.active, -1)
+		for {
+			m := atomic.LoadInt32(&s.maxSeen)
+			if n <= m || atomic.CompareAndSwapInt32(&s.maxSeen, m, n) {
+				break
+			}
+		}
+		time.Sleep(s.delay)
+		if r.URL.Path == "/close" {
+			w.Header().Set("Connection", "close")
+		}
+		io.WriteString(w, r.Host+r.URL.Path)
+	}))
+	s.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&s.conns, 1)
+		}
+	}
+	s.Start()
+	t.Cleanup(s.Close)
+	return s
+}
+
+func poolGet(t *testing.T, p *ClientConnPool, url string) string {
+	t.Helper()
+	req, _ := http.NewRequest("GET", url, nil)
+	resp, err := p.Do(req)
+	if err != nil {
+		t.Error(err)
+		return ""
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Error(err)
+	}
+	return string(b)
+}
+
+func (p *ClientConnPool) idleCount() int {
+	n := 0
+	for _, v := range p.hosts.values() {
+		h := v.(*poolHost)
+		h.mu.Lock()
+		n += len(h.idle)
+		h.mu.Unlock()
+	}
+	return n
+}
+
+func TestPoolReuse(t *testing.T) {
+	s := newCountingServer(t, 0)
+	p := &ClientConnPool{}
+	defer p.Close()
+	for i := 0; i < 5; i++ {
+		if got, want := poolGet(t, p, s.URL+"/x"), s.Listener.Addr().String()+"/x"; got != want {
+			t.Fatalf("body = %q, want %q", got, want)
+		}
+	}
+	if n := atomic.LoadInt32(&s.conns); n != 1 {
+		t.Errorf("%d connections for sequential requests, want 1", n)
+	}
+
+	// A response asking to close retires its connection.
+	poolGet(t, p, s.URL+"/close")
+	poolGet(t, p, s.URL+"/x")
+	if n := atomic.LoadInt32(&s.conns); n != 2 {
+		t.Errorf("%d connections after Connection: close, want 2", n)
+	}
+
+	// So does the server closing an idle one.
+	s.CloseClientConnections()
+	if got := poolGet(t, p, s.URL+"/y"); got == "" {
+		t.Error("no response after the server closed the idle connection")
+	}
+}
+
+func TestPoolLimits(t *testing.T) {
+	s := newCountingServer(t, 5*time.Millisecond)
+	p := &ClientConnPool{MaxConnsPerHost: 3, MaxIdleConnsPerHost: 1}
+	defer p.Close()
+	var wg sync.WaitGroup
+	for i := 0; i < 12; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			poolGet(t, p, s.URL+"/x")
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&s.maxSeen); n > 3 {
+		t.Errorf("%d requests at once, want at most MaxConnsPerHost", n)
+	}
+	if n := atomic.LoadInt32(&s.conns); n > 3 {
+		t.Errorf("%d connections dialed for a limit of 3", n)
+	}
+	if n := p.idleCount(); n != 1 {
+		t.Errorf("%d idle connections kept, want 1", n)
+	}
+}
+
+func TestPoolHostsLockedApart(t *testing.T) {
+	a, b := newCountingServer(t, 0), newCountingServer(t, 0)
+	p := &ClientConnPool{}
+	defer p.Close()
+	poolGet(t, p, a.URL+"/x")
+	req, _ := http.NewRequest("GET", a.URL, nil)
+	key, err := p.key(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := p.host(key)
+	h.mu.Lock()
+	done := make(chan string, 1)
+	go func() { done <- poolGet(t, p, b.URL+"/y") }()
+	select {
+	case got := <-done:
+		if want := b.Listener.Addr().String() + "/y"; got != want {
+			t.Errorf("body = %q, want %q", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("a request to one host waited for the lock of another")
+		h.mu.Unlock()
+		<-done
+		return
+	}
+	h.mu.Unlock()
+	if n := len(p.State().Hosts); n != 2 {
+		t.Errorf("State shows %d hosts, want 2", n)
+	}
+}
+
+func TestPoolWaiterCanceled(t *testing.T) {
+	s := newCountingServer(t, 0)
+	p := &ClientConnPool{MaxConnsPerHost: 1}
+	defer p.Close()
+	req, _ := http.NewRequest("GET", s.URL+"/x", nil)
+	resp, err := p.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req2, _ := http.NewRequestWithContext(ctx, "GET", s.URL+"/x", nil)
+	if _, err := p.Do(req2); err != context.DeadlineExceeded {
+		t.Fatalf("waiting past the deadline: err = %v", err)
+	}
+	resp.Body.Close()
+	if got := poolGet(t, p, s.URL+"/x"); got == "" {
+		t.Error("connection not reusable after a waiter gave up")
+	}
+}
+
+func TestPoolSharesVirtualHosts(t *testing.T) {
+	s := newCountingServer(t, 0)
+	addr := s.Listener.Addr().String()
+	d := &Dialer{Hosts: map[string]string{"a.test": addr, "b.test": addr}}
+	p := &ClientConnPool{Dialer: d}
+	defer p.Close()
+	_, port, _ := net.SplitHostPort(addr)
+	for _, host := range []string{"a.test", "b.test", "a.test"} {
+		if got, want := poolGet(t, p, "http://"+host+":"+port+"/v"), host+":"+port+"/v"; got != want {
+			t.Errorf("body = %q, want %q", got, want)
+		}
+	}
+	if n := atomic.LoadInt32(&s.conns); n != 1 {
+		t.Errorf("%d connections for two virtual hosts at one address, want 1", n)
+	}
+
+	// Tagged requests keep to their own connections.
+	req, _ := http.NewRequestWithContext(WithConnTag(context.Background(), "tenant"), "GET", "http://a.test:"+port+"/v", nil)
+	resp, err := p.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drainBody(resp)
+	if n := atomic.LoadInt32(&s.conns); n != 2 {
+		t.Errorf("%d connections after a tagged request, want 2", n)
+	}
+}
+
+func TestPoolClosed(t *testing.T) {
+	p := &ClientConnPool{}
+	p.Close()
+	req, _ := http.NewRequest("GET", "http://a.example/", nil)
+	if _, err := p.Do(req); err != ErrClosed {
+		t.Errorf("Do on a closed pool: err = %v, want ErrClosed", err)
+	}
+}
+
+func TestPoolTLS(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Host)
+	}))
+	defer s.Close()
+	_, port, _ := net.SplitHostPort(s.Listener.Addr().String())
+	d := &Dialer{Hosts: map[string]string{"example.com": "127.0.0.1", "other.test": "127.0.0.1"}}
+	p := &ClientConnPool{Dialer: d, TLSConfig: &tls.Config{RootCAs: poolOf(s)}}
+	defer p.Close()
+	if got, want := poolGet(t, p, "https://example.com:"+port+"/"), "example.com:"+port; got != want {
+		t.Errorf("body = %q, want %q", got, want)
+	}
+	// other.test shares the address but not the certificate, so it dials
+	// its own connection and fails verification.
+	req, _ := http.NewRequest("GET", "https://other.test:"+port+"/", nil)
+	if _, err := p.Do(req); err == nil {
+		t.Error("request for a host outside the certificate succeeded")
+	}
+	if n := p.idleCount(); n != 1 {
+		t.Errorf("%d idle connections, want the example.com one", n)
+	}
+}
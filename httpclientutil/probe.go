@@ -0,0 +1,63 @@
+package httpclientutil
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// errNoPeek is returned by peekConn for a conn without a socket to peek at.
+var errNoPeek = errors.New("http: connection cannot be peeked")
+
+// Probe is Ping, but for an idle connection it also asks the socket
+// whether the server has closed or reset it, which Ping misses until
+// readLoop has run. Bytes waiting on the socket are left to readLoop,
+// which applies the early response policy. On a connection carrying a
+// request, or one whose socket cannot be peeked at (a net.Pipe, or any
+// conn on Windows), Probe is Ping. A closed connection stays unusable, so
+// Reusable turns false too.
+func (cc *ClientConn) Probe(ctx context.Context) error {
+	if err := cc.Ping(); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	if atomic.LoadInt32(&cc.active) != 0 {
+		return nil
+	}
+	c, err := cc.writeConn()
+	if err != nil {
+		return err
+	}
+	switch err := peekConn(innerConn(c)); err {
+	case nil, errNoPeek:
+		return cc.Ping()
+	case io.EOF:
+		cc.setReadError(ErrServerClosedConn)
+		return ErrServerClosedConn
+	default:
+		cc.setReadError(err)
+		return err
+	}
+}
+
+// ProbeRequest returns a probe for ClientConnPool.ProbeIdle that sends a
+// copy of req on the connection and reads the response to its end. Any
+// response will do, so a HEAD of a cheap resource or an OPTIONS * (URL
+// with Opaque "*") suits. It proves what Probe cannot: that the server
+// still answers, where a dead peer or a silent middlebox sent no reset.
+// req must have no body.
+func ProbeRequest(req *http.Request) func(context.Context, *ClientConn) error {
+	return func(ctx context.Context, cc *ClientConn) error {
+		resp, err := cc.Do(req.Clone(ctx))
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !unix
+
+package httpclientutil
+
+import "net"
+
+func peekConn(c net.Conn) error { return errNoPeek }
@@ -0,0 +1,141 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func tcpPair(t *testing.T) (client, server net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	client, err = net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err = ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func TestPeekConn(t *testing.T) {
+	c, s := tcpPair(t)
+	if err := peekConn(c); err != nil && err != errNoPeek {
+		t.Fatalf("open: %v", err)
+	}
+	if err := peekConn(c); err == errNoPeek {
+		t.Skip("no socket peeking on this platform")
+	}
+	io.WriteString(s, "x")
+	s.Close()
+	// Waiting data is seen but not consumed; after it, the close.
+	if err := peekConn(c); err != nil {
+		t.Errorf("data waiting: %v", err)
+	}
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(c, b); err != nil || b[0] != 'x' {
+		t.Fatalf("read %q, %v", b, err)
+	}
+	waitFor(t, "the close", func() bool { return peekConn(c) == io.EOF })
+	if err := peekConn(&net.TCPConn{}); err != errNoPeek {
+		t.Errorf("unconnected conn: %v", err)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	closed := make(chan struct{})
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		if _, err := http.ReadRequest(br); err != nil {
+			return
+		}
+		writeResponse(c, "ok")
+		<-closed
+	})
+	if body := doBody(t, cc); body != "ok" {
+		t.Fatalf("body = %q", body)
+	}
+	if err := cc.Probe(context.Background()); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := cc.Probe(ctx); err != context.Canceled {
+		t.Errorf("canceled: %v", err)
+	}
+	close(closed)
+	waitFor(t, "the close", func() bool {
+		return cc.Probe(context.Background()) == ErrServerClosedConn
+	})
+	if cc.Reusable() {
+		t.Error("Reusable after a failed probe")
+	}
+}
+
+func TestPoolProbeIdle(t *testing.T) {
+	var conns, heads int32
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			atomic.AddInt32(&heads, 1)
+		}
+	}))
+	s.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	s.Start()
+	defer s.Close()
+	get := func(p *ClientConnPool) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", s.URL, nil)
+		resp, err := p.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	head, _ := http.NewRequest("HEAD", s.URL+"/health", nil)
+	p := &ClientConnPool{ProbeIdle: ProbeRequest(head)}
+	defer p.Close()
+	for i := 0; i < 3; i++ {
+		get(p)
+	}
+	if n := atomic.LoadInt32(&heads); n != 2 {
+		t.Errorf("%d probes, want one per reuse", n)
+	}
+	if n := atomic.LoadInt32(&conns); n != 1 {
+		t.Errorf("%d connections, want 1", n)
+	}
+
+	// A failed probe retires the connection and the request dials.
+	failing := &ClientConnPool{ProbeIdle: func(context.Context, *ClientConn) error {
+		return errors.New("stale")
+	}}
+	defer failing.Close()
+	get(failing)
+	get(failing)
+	if n := atomic.LoadInt32(&conns); n != 3 {
+		t.Errorf("%d connections, want 3", n)
+	}
+	if st := failing.State(); len(st.Hosts) != 1 || st.Hosts[0].Open != 1 {
+		t.Errorf("state after a failed probe: %+v", st)
+	}
+}
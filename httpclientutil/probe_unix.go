@@ -0,0 +1,45 @@
+//go:build unix
+
+package httpclientutil
+
+import (
+	"io"
+	"net"
+	"syscall"
+)
+
+// peekConn reads c's socket without consuming: nil if it is open with
+// nothing or something to read, io.EOF if the peer closed it, or the
+// socket error, a reset say.
+func peekConn(c net.Conn) error {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return errNoPeek
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return errNoPeek
+	}
+	var (
+		buf  [1]byte
+		n    int
+		perr error
+	)
+	// Go sockets are non-blocking, so this returns EAGAIN at once on an
+	// open socket with nothing to read.
+	err = rc.Read(func(fd uintptr) bool {
+		n, _, perr = syscall.Recvfrom(int(fd), buf[:], syscall.MSG_PEEK)
+		return true
+	})
+	switch {
+	case err != nil:
+		return err
+	case perr == syscall.EAGAIN || perr == syscall.EWOULDBLOCK || perr == syscall.EINTR:
+		return nil
+	case perr != nil:
+		return perr
+	case n == 0:
+		return io.EOF
+	}
+	return nil
+}
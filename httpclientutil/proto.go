@@ -0,0 +1,49 @@
+package httpclientutil
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net"
+)
+
+// ProtocolMismatchError is returned when the peer speaks something other
+// than HTTP/1.x on the connection, typically because TLS negotiated h2
+// through ALPN. ClientConn only frames HTTP/1.x, so the connection must be
+// dialed with NextProtos restricted to "http/1.1", or handed to an HTTP/2
+// client instead.
+type ProtocolMismatchError struct {
+	Proto string // protocol detected on the connection
+}
+
+func (e *ProtocolMismatchError) Error() string {
+	return "http: connection speaks " + e.Proto + " but ClientConn speaks HTTP/1.x; " +
+		"restrict tls.Config.NextProtos to http/1.1 or use an HTTP/2 client"
+}
+
+// checkProto completes a pending TLS handshake on c and reports an error if
+// ALPN selected a protocol other than HTTP/1.x.
+func checkProto(ctx context.Context, c net.Conn) error {
+	if tc, ok := c.(*tls.Conn); ok && !tc.ConnectionState().HandshakeComplete {
+		if err := tc.HandshakeContext(ctx); err != nil {
+			return err
+		}
+	}
+	cs, ok := c.(interface{ ConnectionState() tls.ConnectionState })
+	if !ok {
+		return nil
+	}
+	switch p := cs.ConnectionState().NegotiatedProtocol; p {
+	case "", "http/1.0", "http/1.1":
+		return nil
+	default:
+		return &ProtocolMismatchError{Proto: p}
+	}
+}
+
+// isHTTP2Frame reports whether b starts like the SETTINGS frame an HTTP/2
+// server sends first, instead of an HTTP/1.x status line.
+func isHTTP2Frame(b []byte) bool {
+	const frameSettings = 0x4
+	return len(b) >= 4 && !bytes.HasPrefix(b, []byte("HTTP/")) && b[3] == frameSettings
+}
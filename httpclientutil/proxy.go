@@ -0,0 +1,73 @@
+package httpclientutil
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// canServe reports whether pc may carry a request for host.
+func (pc *poolConn) canServe(host string) bool {
+	return pc.anyHost || CanServeHost(pc.conn, host)
+}
+
+// proxyAuth returns the Proxy-Authorization for the credentials in proxy,
+// if any.
+func proxyAuth(proxy *url.URL) string {
+	if proxy.User == nil {
+		return ""
+	}
+	password, _ := proxy.User.Password()
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(proxy.User.Username()+":"+password))
+}
+
+// withProxyAuth returns req with the credentials of proxy added, sharing
+// everything with req but the header.
+func withProxyAuth(req *http.Request, proxy string) *http.Request {
+	u, err := url.Parse(proxy)
+	if err != nil || u.User == nil || req.Header.Get("Proxy-Authorization") != "" {
+		return req
+	}
+	r := *req
+	r.Header = req.Header.Clone()
+	if r.Header == nil {
+		r.Header = make(http.Header)
+	}
+	r.Header.Set("Proxy-Authorization", proxyAuth(u))
+	return &r
+}
+
+// dialProxy dials proxy for req. For an https request, config not nil, it
+// opens a tunnel to the origin and completes the TLS handshake with it
+// inside; otherwise the connection to the proxy is returned as is. A SOCKS
+// proxy always gives a connection to the origin.
+func (p *ClientConnPool) dialProxy(d *Dialer, req *http.Request, proxy string, config *tls.Config) (net.Conn, error) {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, err
+	}
+	if isSOCKS(u) {
+		return d.dialTLS(withSOCKSProxy(req.Context(), u), "tcp", canonicalAddr(req.URL), config)
+	}
+	var proxyConfig *tls.Config
+	if u.Scheme == "https" {
+		if proxyConfig = p.TLSConfig; proxyConfig == nil {
+			proxyConfig = new(tls.Config)
+		}
+	}
+	ctx := req.Context()
+	c, err := d.dialTLS(ctx, "tcp", canonicalAddr(u), proxyConfig)
+	if err != nil || config == nil {
+		return c, err
+	}
+	cc := NewClientConn(c)
+	tunnel, err := cc.ConnectTunnel(ctx, canonicalAddr(req.URL), proxyAuth(u))
+	if err != nil {
+		cc.Close()
+		c.Close()
+		return nil, err
+	}
+	return tlsHandshake(ctx, tunnel, config, req.URL.Hostname())
+}
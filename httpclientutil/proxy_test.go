@@ -0,0 +1,84 @@
+package httpclientutil
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+)
+
+// proxyServer forwards CONNECT tunnels and answers plain requests itself
+// with what it was asked.
+func proxyServer(t *testing.T) (*httptest.Server, *int32) {
+	var tunnels int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "CONNECT" {
+			io.WriteString(w, "proxied "+r.URL.String()+" "+r.Header.Get("Proxy-Authorization"))
+			return
+		}
+		atomic.AddInt32(&tunnels, 1)
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		c, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			target.Close()
+			return
+		}
+		go func() {
+			io.Copy(target, brw)
+			target.Close()
+		}()
+		io.Copy(c, target)
+		c.Close()
+	}))
+	t.Cleanup(s.Close)
+	return s, &tunnels
+}
+
+func TestPoolProxyPlain(t *testing.T) {
+	proxy, _ := proxyServer(t)
+	u, _ := url.Parse(proxy.URL)
+	u.User = url.UserPassword("u", "p")
+	p := &ClientConnPool{Proxy: http.ProxyURL(u)}
+	defer p.Close()
+	if got := poolGet(t, p, "http://a.example/x"); got != "proxied http://a.example/x Basic dTpw" {
+		t.Errorf("a.example: %q", got)
+	}
+	if got := poolGet(t, p, "http://b.example/y"); got != "proxied http://b.example/y Basic dTpw" {
+		t.Errorf("b.example: %q", got)
+	}
+	st := p.State()
+	if len(st.Hosts) != 1 || len(st.Hosts[0].Conns) != 1 || st.Hosts[0].Conns[0].Requests != 2 {
+		t.Errorf("origins did not share the proxy connection: %+v", st.Hosts)
+	}
+	if st.Hosts[0].Proxy != "http://u:xxxxx@"+u.Host {
+		t.Errorf("proxy shown as %q", st.Hosts[0].Proxy)
+	}
+}
+
+func TestPoolProxyTunnel(t *testing.T) {
+	proxy, tunnels := proxyServer(t)
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "secret "+r.URL.Path)
+	}))
+	defer origin.Close()
+	u, _ := url.Parse(proxy.URL)
+	p := &ClientConnPool{Proxy: http.ProxyURL(u), TLSConfig: &tls.Config{RootCAs: poolOf(origin)}}
+	defer p.Close()
+	for _, path := range []string{"/1", "/2"} {
+		if got := poolGet(t, p, origin.URL+path); got != "secret "+path {
+			t.Errorf("%s: %q", path, got)
+		}
+	}
+	if n := atomic.LoadInt32(tunnels); n != 1 {
+		t.Errorf("opened %d tunnels, want one reused", n)
+	}
+}
@@ -0,0 +1,90 @@
+package httpclientutil
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+)
+
+// WithRequestQueue makes concurrent Do calls on cc take turns instead of
+// failing with ErrBodyWaitingRead: each waits until the exchange before
+// it is over, its response body read to EOF or closed, then writes its
+// request. Writes never interleave on the socket either way; the queue
+// keeps a request from going out before the connection is free. max
+// bounds the callers waiting, beyond which Do fails with ErrPipeline;
+// zero or less means no bound. A caller whose context is done while
+// waiting returns its cause and leaves cc alone. The queue does not apply
+// under SetPipelining or SetWriteCoalescing, which let requests go out
+// back to back. One goroutine calling Do again before it closed the
+// previous body waits forever, so close bodies as soon as read.
+func WithRequestQueue(max int) Option {
+	return func(cc *ClientConn) {
+		q := &requestQueue{max: int32(max), turn: make(chan struct{}, 1)}
+		q.turn <- struct{}{}
+		cc.queue = q
+	}
+}
+
+// requestQueue hands out turns at the connection. turn holds a token
+// while no exchange is going on.
+type requestQueue struct {
+	max     int32
+	turn    chan struct{}
+	waiting int32
+}
+
+// acquire waits for the turn of a request with context ctx.
+func (q *requestQueue) acquire(cc *ClientConn, ctx context.Context) error {
+	select {
+	case <-q.turn:
+		return nil
+	default:
+	}
+	if n := atomic.AddInt32(&q.waiting, 1); q.max > 0 && n > q.max {
+		atomic.AddInt32(&q.waiting, -1)
+		return ErrPipeline
+	}
+	defer atomic.AddInt32(&q.waiting, -1)
+	select {
+	case <-q.turn:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case <-cc.readDone:
+		select {
+		case <-cc.closech:
+			return ErrClosed // readLoop stopped because of Close
+		default:
+		}
+		return cc.readError()
+	case <-cc.closech:
+		return ErrClosed
+	}
+}
+
+// release gives the turn to the next request. There is one turn, so a
+// release without acquire is harmless once the connection is idle.
+func (q *requestQueue) release() {
+	select {
+	case q.turn <- struct{}{}:
+	default:
+	}
+}
+
+// doQueued is do under the request queue. The turn passes on in
+// endExchange once the response is over, or here if there is none.
+func (cc *ClientConn) doQueued(q *requestQueue, req *http.Request) (*http.Response, error) {
+	if err := q.acquire(cc, req.Context()); err != nil {
+		return nil, err
+	}
+	pr, err := cc.write(req, false)
+	if err != nil {
+		q.release()
+		return nil, err
+	}
+	resp, err := cc.read(pr)
+	if err != nil {
+		q.release()
+	}
+	return resp, err
+}
@@ -0,0 +1,132 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRequestQueueSerializes(t *testing.T) {
+	var overlapped int32
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		for {
+			req, err := http.ReadRequest(br)
+			if err != nil {
+				return
+			}
+			io.Copy(io.Discard, req.Body)
+			time.Sleep(time.Millisecond)
+			if br.Buffered() > 0 {
+				atomic.StoreInt32(&overlapped, 1)
+			}
+			writeResponse(c, req.URL.Path)
+		}
+	}, WithRequestQueue(0))
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequest("GET", "http://a.example/x", nil)
+			resp, err := cc.Do(req)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			b, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(b) != "/x" {
+				t.Errorf("body = %q", b)
+			}
+		}()
+	}
+	wg.Wait()
+	if atomic.LoadInt32(&overlapped) != 0 {
+		t.Error("a request went out before the previous response was read")
+	}
+	if !cc.Reusable() {
+		t.Error("conn unusable after queued requests")
+	}
+}
+
+func TestRequestQueueBound(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		for answer(c, br, "ok") {
+		}
+	}, WithRequestQueue(2))
+	req, _ := http.NewRequest("GET", "http://a.example/", nil)
+	resp, err := cc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			req, _ := http.NewRequest("GET", "http://a.example/", nil)
+			resp, err := cc.Do(req)
+			if err == nil {
+				io.ReadAll(resp.Body)
+				resp.Body.Close()
+			}
+			errc <- err
+		}()
+	}
+	waitFor(t, "two queued requests", func() bool { return atomic.LoadInt32(&cc.queue.waiting) == 2 })
+	if _, err := cc.Do(req); err != ErrPipeline {
+		t.Errorf("Do with a full queue = %v, want ErrPipeline", err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	for i := 0; i < 2; i++ {
+		if err := <-errc; err != nil {
+			t.Errorf("queued Do = %v", err)
+		}
+	}
+}
+
+func TestRequestQueueCancelAndClose(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		for answer(c, br, "ok") {
+		}
+	}, WithRequestQueue(0))
+	req, _ := http.NewRequest("GET", "http://a.example/", nil)
+	resp, err := cc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	waiting, _ := http.NewRequestWithContext(ctx, "GET", "http://a.example/", nil)
+	errc := make(chan error, 1)
+	go func() {
+		_, err := cc.Do(waiting)
+		errc <- err
+	}()
+	waitFor(t, "the request to queue", func() bool { return atomic.LoadInt32(&cc.queue.waiting) == 1 })
+	cancel(errTestCause)
+	if err := <-errc; !errors.Is(err, errTestCause) {
+		t.Errorf("canceled while queued: %v, want its cause", err)
+	}
+	if !cc.Reusable() {
+		t.Error("a request canceled in the queue broke the conn")
+	}
+
+	go func() {
+		req, _ := http.NewRequest("GET", "http://a.example/", nil)
+		_, err := cc.Do(req)
+		errc <- err
+	}()
+	waitFor(t, "the request to queue", func() bool { return atomic.LoadInt32(&cc.queue.waiting) == 1 })
+	cc.Close()
+	if err := <-errc; err != ErrClosed {
+		t.Errorf("queued across Close: %v, want ErrClosed", err)
+	}
+	resp.Body.Close()
+}
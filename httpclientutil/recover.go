@@ -0,0 +1,47 @@
+package httpclientutil
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+)
+
+// ErrHookPanic matches, with errors.Is, the error a panic in a hook was
+// turned into.
+var ErrHookPanic = errors.New("http: hook panicked")
+
+// HookPanicError is a panic in user code the package calls back, recovered
+// and returned as the error of the request it ran for, so that one bad
+// hook neither takes down the process from a connection's read loop nor
+// leaves the connection half used. Hook names the callback, such as
+// "Hooks.Request" or "Collector.ResponseRead"; Value is what was passed to
+// panic and Stack is where. A panic on a connection's read or write path
+// breaks the connection, which no longer knows where the next response
+// starts.
+type HookPanicError struct {
+	Hook  string
+	Value interface{}
+	Stack []byte
+}
+
+func (e *HookPanicError) Error() string {
+	return fmt.Sprintf("http: %s panicked: %v", e.Hook, e.Value)
+}
+
+func (e *HookPanicError) Is(target error) bool { return target == ErrHookPanic }
+
+// Unwrap returns the value of a panic with an error.
+func (e *HookPanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// runHook calls fn, turning a panic into a *HookPanicError for hook.
+func runHook(hook string, fn func() error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &HookPanicError{Hook: hook, Value: v, Stack: debug.Stack()}
+		}
+	}()
+	return fn()
+}
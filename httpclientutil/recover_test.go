@@ -0,0 +1,101 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func checkHookPanic(t *testing.T, err error, hook string) {
+	t.Helper()
+	var hp *HookPanicError
+	if !errors.Is(err, ErrHookPanic) || !errors.As(err, &hp) || hp.Hook != hook || hp.Value != "boom" || len(hp.Stack) == 0 {
+		t.Errorf("err = %v, want a panic in %s", err, hook)
+	}
+}
+
+func TestHookDoerPanic(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer s.Close()
+	hd := &HookDoer{Doer: http.DefaultClient}
+	hd.SetHooks(&Hooks{Request: func(*http.Request) error { panic("boom") }})
+	req, _ := http.NewRequest("GET", s.URL, nil)
+	_, err := hd.Do(req)
+	checkHookPanic(t, err, "Hooks.Request")
+
+	hd.SetHooks(&Hooks{Response: func(*http.Response) error { panic("boom") }})
+	_, err = hd.Do(req)
+	checkHookPanic(t, err, "Hooks.Response")
+}
+
+// panicCollector panics in the callback named by in.
+type panicCollector struct{ in string }
+
+func (c panicCollector) RequestWritten(*http.Request, int64, bool) {
+	if c.in == "RequestWritten" {
+		panic("boom")
+	}
+}
+
+func (c panicCollector) ResponseRead(*http.Request, *http.Response, time.Duration) {
+	if c.in == "ResponseRead" {
+		panic("boom")
+	}
+}
+
+func TestConnHookPanic(t *testing.T) {
+	for _, c := range []struct {
+		hook string
+		opt  Option
+	}{
+		{"Collector.RequestWritten", WithCollector(panicCollector{"RequestWritten"})},
+		{"Collector.ResponseRead", WithCollector(panicCollector{"ResponseRead"})},
+		{"On1xxResponse", WithOn1xxResponse(func(*http.Request, *http.Response) { panic("boom") })},
+	} {
+		cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+			if _, err := http.ReadRequest(br); err != nil {
+				return
+			}
+			io.WriteString(c, "HTTP/1.1 103 Early Hints\r\n\r\n")
+			writeResponse(c, "ok")
+			br.ReadByte()
+		}, c.opt)
+		req, _ := http.NewRequest("GET", "http://a.example/", nil)
+		_, err := cc.Do(req)
+		checkHookPanic(t, err, c.hook)
+		if cc.Reusable() {
+			t.Errorf("%s: connection reusable after the panic", c.hook)
+		}
+	}
+}
+
+func TestPoolNewConnPanic(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer s.Close()
+	p := &ClientConnPool{
+		MaxConnsPerHost: 1,
+		NewConn:         func(*ClientConn) { panic("boom") },
+	}
+	defer p.Close()
+	req, _ := http.NewRequest("GET", s.URL, nil)
+	_, err := p.Do(req)
+	checkHookPanic(t, err, "ClientConnPool.NewConn")
+
+	// The slot was given back.
+	p.NewConn = nil
+	resp, err := p.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
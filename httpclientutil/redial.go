@@ -0,0 +1,63 @@
+package httpclientutil
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// RetryingConn sends requests over a connection it keeps, and dials a
+// fresh one with Dial when the server has closed it. A kept connection
+// already known to be closed is replaced before a request goes out. When
+// a request fails with ErrServerClosedConn or ErrPersistEOF, the server
+// closed the connection before any byte of the response; such a request
+// may still have been processed, so it is resent on a new connection only
+// if it is idempotent (by method or an Idempotency-Key header) and its
+// body can be rewound with GetBody. Any other failure is returned as is.
+type RetryingConn struct {
+	Dial func(ctx context.Context) (*ClientConn, error)
+
+	// MaxAttempts bounds the connections used for one request; defaults
+	// to 3.
+	MaxAttempts int
+
+	kept keptConn
+}
+
+func (rc *RetryingConn) Do(req *http.Request) (*http.Response, error) {
+	attempts := rc.MaxAttempts
+	if attempts <= 0 {
+		attempts = 3
+	}
+	ctx := req.Context()
+	for try := 1; ; try++ {
+		cc, err := rc.kept.get(ctx, rc.Dial)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := cc.Do(req)
+		if err == nil {
+			return resp, nil
+		}
+		rc.kept.discard(cc)
+		if !isServerClose(err) || try == attempts || ctx.Err() != nil {
+			return nil, err
+		}
+		retry, ok := rewindRequests([]*http.Request{req})
+		if !ok {
+			return nil, err
+		}
+		req = retry[0]
+	}
+}
+
+// Close closes the kept connection.
+func (rc *RetryingConn) Close() error {
+	return rc.kept.close()
+}
+
+// isServerClose reports whether err means the server closed the
+// connection before answering.
+func isServerClose(err error) bool {
+	return errors.Is(err, ErrServerClosedConn) || errors.Is(err, ErrPersistEOF)
+}
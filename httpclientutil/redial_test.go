@@ -0,0 +1,118 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// dropServer closes its first connection after reading one request,
+// without answering, and echoes "METHOD BODY" on the others.
+func dropServer(t *testing.T) *RetryingConn {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var accepted int32
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			first := atomic.AddInt32(&accepted, 1) == 1
+			go func() {
+				defer c.Close()
+				br := bufio.NewReader(c)
+				for {
+					req, err := http.ReadRequest(br)
+					if err != nil || first {
+						return
+					}
+					b, _ := io.ReadAll(req.Body)
+					writeResponse(c, req.Method+" "+string(b))
+				}
+			}()
+		}
+	}()
+	rc := &RetryingConn{Dial: func(ctx context.Context) (*ClientConn, error) {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return nil, err
+		}
+		return NewClientConn(c), nil
+	}}
+	t.Cleanup(func() { rc.Close() })
+	return rc
+}
+
+func retryBody(t *testing.T, rc *RetryingConn, method, body string) (string, error) {
+	t.Helper()
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req, _ := http.NewRequest(method, "http://a.example/", r)
+	resp, err := rc.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	return string(b), err
+}
+
+func TestRetryingConnReplays(t *testing.T) {
+	rc := dropServer(t)
+	got, err := retryBody(t, rc, "PUT", "payload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "PUT payload" {
+		t.Errorf("got %q", got)
+	}
+	if got, err := retryBody(t, rc, "GET", ""); err != nil || got != "GET " {
+		t.Errorf("on the kept connection: %q, %v", got, err)
+	}
+}
+
+func TestRetryingConnUnsafe(t *testing.T) {
+	rc := dropServer(t)
+	if _, err := retryBody(t, rc, "POST", "order"); err != ErrServerClosedConn {
+		t.Fatalf("err = %v, want ErrServerClosedConn", err)
+	}
+	// The next request gets a fresh connection.
+	if got, err := retryBody(t, rc, "POST", "order"); err != nil || got != "POST order" {
+		t.Errorf("after the failure: %q, %v", got, err)
+	}
+}
+
+func TestRetryingConnReplacesClosed(t *testing.T) {
+	s := newRetryServer(t, 1) // every response closes the connection
+	var dials int32
+	rc := &RetryingConn{Dial: func(ctx context.Context) (*ClientConn, error) {
+		atomic.AddInt32(&dials, 1)
+		c, err := net.Dial("tcp", s.Listener.Addr().String())
+		if err != nil {
+			return nil, err
+		}
+		return NewClientConn(c), nil
+	}}
+	defer rc.Close()
+	for i := 0; i < 3; i++ {
+		// POST is never resent, so it only succeeds if the closed
+		// connection was replaced before it went out.
+		if got, err := retryBody(t, rc, "POST", "x"); err != nil || got != "POST / x" {
+			t.Fatalf("request %d: %q, %v", i, got, err)
+		}
+	}
+	if n := atomic.LoadInt32(&dials); n != 3 {
+		t.Errorf("dials = %d, want 3", n)
+	}
+}
@@ -0,0 +1,92 @@
+package httpclientutil
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// hopHeaders apply to a single connection and are not relayed (RFC 9110
+// section 7.6.1).
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// RelayBody copies src to dst for a proxy: the end-to-end headers, the
+// status, the body and then the trailers, and closes src.Body. Bodies
+// without a Content-Length and event streams are flushed after every read,
+// so each chunk reaches the client as soon as the upstream sent it;
+// others are left to dst's buffering. Trailers src declared are announced
+// before the body; ones that appear only at the end are sent with
+// http.TrailerPrefix.
+func RelayBody(dst http.ResponseWriter, src *http.Response) error {
+	defer src.Body.Close()
+	h := dst.Header()
+	for k, vv := range src.Header {
+		h[k] = append(h[k][:0:0], vv...)
+	}
+	for _, f := range src.Header.Values("Connection") {
+		for _, name := range strings.Split(f, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+	announced := make(map[string]bool, len(src.Trailer))
+	for k := range src.Trailer {
+		h.Add("Trailer", k)
+		announced[k] = true
+	}
+	dst.WriteHeader(src.StatusCode)
+
+	var w io.Writer = dst
+	if f, ok := dst.(http.Flusher); ok && isStreaming(src) {
+		f.Flush() // let the client see the headers before the first event
+		w = flushWriter{dst, f}
+	}
+	if _, err := copyBuffer(w, src.Body); err != nil {
+		return err
+	}
+	for k, vv := range src.Trailer {
+		if !announced[k] {
+			k = http.TrailerPrefix + k
+		}
+		h[k] = append(h[k][:0:0], vv...)
+	}
+	return nil
+}
+
+// isStreaming reports whether resp's body should reach the client as it
+// arrives.
+func isStreaming(resp *http.Response) bool {
+	if resp.ContentLength < 0 {
+		return true
+	}
+	mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mt == "text/event-stream"
+}
+
+type flushWriter struct {
+	w io.Writer
+	f http.Flusher
+}
+
+func (fw flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if n > 0 {
+		fw.f.Flush()
+	}
+	return n, err
+}
@@ -0,0 +1,102 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// relayProxy relays every request to upstream over a fresh ClientConn.
+func relayProxy(t *testing.T, upstream string) *httptest.Server {
+	p := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := net.Dial("tcp", upstream)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		cc := NewClientConn(c)
+		defer cc.Close()
+		req, _ := http.NewRequestWithContext(r.Context(), "GET", "http://"+upstream+r.URL.Path, nil)
+		resp, err := cc.Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		RelayBody(w, resp)
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+func TestRelayBodyStreams(t *testing.T) {
+	next := make(chan struct{})
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Connection", "X-Hop")
+		w.Header().Set("X-Hop", "1")
+		for i := 0; i < 3; i++ {
+			io.WriteString(w, "data: x\n\n")
+			w.(http.Flusher).Flush()
+			select {
+			case <-next:
+			case <-time.After(5 * time.Second):
+				return
+			}
+		}
+	}))
+	defer up.Close()
+	p := relayProxy(t, up.Listener.Addr().String())
+	resp, err := http.Get(p.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("X-Hop") != "" {
+		t.Error("relayed a header listed in Connection")
+	}
+	br := bufio.NewReader(resp.Body)
+	for i := 0; i < 3; i++ {
+		// The upstream waits for us, so each event must arrive on its own.
+		line, err := br.ReadString('\n')
+		if err != nil || line != "data: x\n" {
+			t.Fatalf("event %d: %q, %v", i, line, err)
+		}
+		br.ReadString('\n')
+		next <- struct{}{}
+	}
+}
+
+func TestRelayBodyTrailers(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Sum")
+		io.WriteString(w, "body")
+		w.(http.Flusher).Flush()
+		w.Header().Set("X-Sum", "42")
+		w.Header().Set(http.TrailerPrefix+"X-Late", "yes")
+	}))
+	defer up.Close()
+	p := relayProxy(t, up.Listener.Addr().String())
+	resp, err := http.Get(p.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "body" {
+		t.Fatalf("body = %q", b)
+	}
+	if got := resp.Trailer.Get("X-Sum"); got != "42" {
+		t.Errorf("declared trailer = %q", got)
+	}
+	if got := resp.Trailer.Get("X-Late"); got != "yes" {
+		t.Errorf("undeclared trailer = %q", got)
+	}
+	if strings.Contains(resp.Header.Get("Trailer"), "X-Late") {
+		t.Error("undeclared trailer announced")
+	}
+}
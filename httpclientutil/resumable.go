@@ -0,0 +1,257 @@
+package httpclientutil
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ResumableProtocol selects the wire protocol used by ResumableUpload.
+type ResumableProtocol int
+
+const (
+	// ResumableTus is tus 1.0 with the creation and checksum extensions.
+	ResumableTus ResumableProtocol = iota
+	// ResumableDraft is the IETF resumable uploads draft
+	// (draft-ietf-httpbis-resumable-upload, interop version 6).
+	ResumableDraft
+)
+
+const (
+	tusVersion          = "1.0.0"
+	draftInteropVersion = "6"
+	defaultChunkSize    = 4 << 20
+)
+
+var (
+	ErrChecksumMismatch = errors.New("http: upload chunk checksum mismatch")
+	ErrOffsetMismatch   = errors.New("http: upload offset does not match server")
+	ErrUploadStalled    = errors.New("http: server accepted a chunk without advancing the upload offset")
+)
+
+// ResumableUpload uploads a body of known size in chunks and, after a
+// failed chunk, asks the server for its offset and continues from there.
+// A chunk refused with 429 or 503 and a Retry-After header is retried once
+// the wait is over.
+// Doer should be able to replace broken connections for retries to help.
+type ResumableUpload struct {
+	Doer     Doer
+	Protocol ResumableProtocol
+	URL      string // upload resource, set by Create
+	Size     int64
+
+	ChunkSize int64       // bytes per PATCH; defaults to 4MB
+	Checksum  string      // tus checksum algorithm: "md5", "sha1" or "sha256"
+	Retries   int         // chunk attempts after a failure
+	Clock     Clock       // waits out Retry-After; nil is the system clock
+	Header    http.Header // added to every request, e.g. authorization
+}
+
+// Create registers a new upload of u.Size bytes at endpoint and stores the
+// upload URL in u.URL. metadata is sent as tus Upload-Metadata.
+func (u *ResumableUpload) Create(ctx context.Context, endpoint string, metadata map[string]string) error {
+	req, err := u.newRequest(ctx, "POST", endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Upload-Length", strconv.FormatInt(u.Size, 10))
+	if u.Protocol == ResumableDraft {
+		req.Header.Set("Upload-Complete", "?0")
+	} else if len(metadata) > 0 {
+		req.Header.Set("Upload-Metadata", encodeTusMetadata(metadata))
+	}
+	resp, err := u.Doer.Do(req)
+	if err != nil {
+		return err
+	}
+	defer drainBody(resp)
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("http: upload creation failed: %s", resp.Status)
+	}
+	loc, err := resp.Location()
+	if err != nil {
+		return err
+	}
+	u.URL = loc.String()
+	return nil
+}
+
+// Offset asks the server how many bytes of the upload it has stored.
+func (u *ResumableUpload) Offset(ctx context.Context) (int64, error) {
+	req, err := u.newRequest(ctx, "HEAD", u.URL, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := u.Doer.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer drainBody(resp)
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return 0, fmt.Errorf("http: upload offset probe failed: %s", resp.Status)
+	}
+	return parseUploadOffset(resp)
+}
+
+// Upload sends the rest of r, starting at the offset reported by the
+// server.
+func (u *ResumableUpload) Upload(ctx context.Context, r io.ReaderAt) error {
+	offset, err := u.Offset(ctx)
+	if err != nil {
+		return err
+	}
+	failures := 0
+	for offset < u.Size {
+		next, err := u.patch(ctx, r, offset)
+		if err == nil && next <= offset {
+			err = ErrUploadStalled
+		}
+		if err == nil {
+			offset, failures = next, 0
+			continue
+		}
+		if ctx.Err() != nil || failures >= u.Retries {
+			return err
+		}
+		failures++
+		var ra *retryAfterError
+		if errors.As(err, &ra) && !sleep(clockOrSystem(u.Clock), ra.wait, ctx.Done()) {
+			return context.Cause(ctx)
+		}
+		if offset, err = u.Offset(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *ResumableUpload) patch(ctx context.Context, r io.ReaderAt, offset int64) (int64, error) {
+	size := u.ChunkSize
+	if size <= 0 {
+		size = defaultChunkSize
+	}
+	if rest := u.Size - offset; rest < size {
+		size = rest
+	}
+	chunk := make([]byte, size)
+	n, err := r.ReadAt(chunk, offset)
+	if int64(n) < size {
+		if err == nil || err == io.EOF {
+			err = fmt.Errorf("http: upload source ended at byte %d of %d", offset+int64(n), u.Size)
+		}
+		return 0, err
+	}
+	req, err := u.newRequest(ctx, "PATCH", u.URL, chunk)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	if u.Protocol == ResumableDraft {
+		req.Header.Set("Content-Type", "application/partial-upload")
+		if offset+size == u.Size {
+			req.Header.Set("Upload-Complete", "?1")
+		} else {
+			req.Header.Set("Upload-Complete", "?0")
+		}
+	} else {
+		req.Header.Set("Content-Type", "application/offset+octet-stream")
+		if u.Checksum != "" {
+			sum, err := tusChecksum(u.Checksum, chunk)
+			if err != nil {
+				return 0, err
+			}
+			req.Header.Set("Upload-Checksum", sum)
+		}
+	}
+	resp, err := u.Doer.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer drainBody(resp)
+	switch resp.StatusCode {
+	case http.StatusNoContent, http.StatusOK, http.StatusCreated:
+	case http.StatusConflict:
+		return 0, ErrOffsetMismatch
+	case 460: // tus checksum extension
+		return 0, ErrChecksumMismatch
+	default:
+		err := fmt.Errorf("http: upload chunk failed: %s", resp.Status)
+		if wait, ok := retryAfter(resp, clockOrSystem(u.Clock).Now()); ok {
+			return 0, &retryAfterError{err, wait}
+		}
+		return 0, err
+	}
+	if resp.Header.Get("Upload-Offset") == "" {
+		return offset + size, nil
+	}
+	return parseUploadOffset(resp)
+}
+
+func (u *ResumableUpload) newRequest(ctx context.Context, method, target string, body []byte) (*http.Request, error) {
+	var rd io.Reader
+	if body != nil {
+		rd = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, target, rd)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for k, vv := range u.Header {
+		req.Header[k] = append([]string(nil), vv...)
+	}
+	if u.Protocol == ResumableDraft {
+		req.Header.Set("Upload-Draft-Interop-Version", draftInteropVersion)
+	} else {
+		req.Header.Set("Tus-Resumable", tusVersion)
+	}
+	return req, nil
+}
+
+func parseUploadOffset(resp *http.Response) (int64, error) {
+	n, err := strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("http: missing or invalid Upload-Offset")
+	}
+	return n, nil
+}
+
+func encodeTusMetadata(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + " " + base64.StdEncoding.EncodeToString([]byte(metadata[k]))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func tusChecksum(algo string, chunk []byte) (string, error) {
+	var h hash.Hash
+	switch algo {
+	case "md5":
+		h = md5.New()
+	case "sha1":
+		h = sha1.New()
+	case "sha256":
+		h = sha256.New()
+	default:
+		return "", errors.New("http: unsupported upload checksum " + strconv.Quote(algo))
+	}
+	h.Write(chunk)
+	return algo + " " + base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
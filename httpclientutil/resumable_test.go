@@ -0,0 +1,195 @@
+package httpclientutil
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tusServer stores one tus upload at /files/1. fail, if set, is asked
+// before each PATCH is applied and may answer it instead.
+type tusServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	data    []byte
+	patches []int64 // Upload-Offset of each PATCH
+	fail    func(w http.ResponseWriter, offset int64) bool
+}
+
+func newTusServer(t *testing.T) *tusServer {
+	s := new(tusServer)
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Tus-Resumable") != tusVersion {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch r.Method {
+		case "POST":
+			w.Header().Set("Location", "/files/1")
+			w.WriteHeader(http.StatusCreated)
+		case "HEAD":
+			w.Header().Set("Upload-Offset", strconv.Itoa(len(s.data)))
+			w.WriteHeader(http.StatusOK)
+		case "PATCH":
+			offset, _ := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+			s.patches = append(s.patches, offset)
+			if s.fail != nil && s.fail(w, offset) {
+				return
+			}
+			if offset != int64(len(s.data)) {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			var b bytes.Buffer
+			b.ReadFrom(r.Body)
+			s.data = append(s.data, b.Bytes()...)
+			w.Header().Set("Upload-Offset", strconv.Itoa(len(s.data)))
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestResumableResume(t *testing.T) {
+	s := newTusServer(t)
+	failed := false
+	s.fail = func(w http.ResponseWriter, offset int64) bool {
+		if offset != 4 || failed {
+			return false
+		}
+		// Keep half the chunk, as a server does when the link drops.
+		failed = true
+		s.data = append(s.data, "45"...)
+		w.WriteHeader(http.StatusInternalServerError)
+		return true
+	}
+	body := []byte("0123456789")
+	u := &ResumableUpload{Doer: http.DefaultClient, Size: int64(len(body)), ChunkSize: 4, Retries: 1, Checksum: "sha256"}
+	if err := u.Create(context.Background(), s.URL+"/files", map[string]string{"name": "a.bin"}); err != nil {
+		t.Fatal(err)
+	}
+	if u.URL != s.URL+"/files/1" {
+		t.Errorf("URL = %q", u.URL)
+	}
+	if err := u.Upload(context.Background(), bytes.NewReader(body)); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !bytes.Equal(s.data, body) {
+		t.Errorf("server has %q, want %q", s.data, body)
+	}
+	if got, want := fmt.Sprint(s.patches), "[0 4 6]"; got != want {
+		t.Errorf("PATCH offsets %s, want %s", got, want)
+	}
+}
+
+func TestResumableErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		answer func(w http.ResponseWriter)
+		want   error
+	}{
+		{"conflict", func(w http.ResponseWriter) { w.WriteHeader(http.StatusConflict) }, ErrOffsetMismatch},
+		{"checksum", func(w http.ResponseWriter) { w.WriteHeader(460) }, ErrChecksumMismatch},
+		{"stalled", func(w http.ResponseWriter) {
+			w.Header().Set("Upload-Offset", "0")
+			w.WriteHeader(http.StatusNoContent)
+		}, ErrUploadStalled},
+		{"bad offset", func(w http.ResponseWriter) {
+			w.Header().Set("Upload-Offset", "lots")
+			w.WriteHeader(http.StatusNoContent)
+		}, nil},
+	} {
+		s := newTusServer(t)
+		s.fail = func(w http.ResponseWriter, offset int64) bool {
+			tt.answer(w)
+			return true
+		}
+		u := &ResumableUpload{Doer: http.DefaultClient, URL: s.URL + "/files/1", Size: 4, Retries: 2}
+		err := u.Upload(context.Background(), bytes.NewReader([]byte("0123")))
+		if err == nil || tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+		s.mu.Lock()
+		if n := len(s.patches); n != 3 {
+			t.Errorf("%s: %d PATCHes for 2 retries, want 3", tt.name, n)
+		}
+		s.mu.Unlock()
+	}
+
+	// A source shorter than Size fails without a request.
+	s := newTusServer(t)
+	u := &ResumableUpload{Doer: http.DefaultClient, URL: s.URL + "/files/1", Size: 8}
+	if err := u.Upload(context.Background(), bytes.NewReader([]byte("0123"))); err == nil || !strings.Contains(err.Error(), "ended at byte 4 of 8") {
+		t.Errorf("short source: err = %v", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.patches) != 0 {
+		t.Errorf("short source sent %d PATCHes", len(s.patches))
+	}
+}
+
+func TestResumableRetryAfter(t *testing.T) {
+	s := newTusServer(t)
+	failed := false
+	s.fail = func(w http.ResponseWriter, offset int64) bool {
+		if offset != 4 || failed {
+			return false
+		}
+		failed = true
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return true
+	}
+	clock := newFakeClock()
+	body := []byte("0123456789")
+	u := &ResumableUpload{Doer: http.DefaultClient, Size: int64(len(body)), ChunkSize: 4, Retries: 1, Clock: clock}
+	if err := u.Create(context.Background(), s.URL+"/files", nil); err != nil {
+		t.Fatal(err)
+	}
+	start := clock.Now()
+	var err error
+	clock.Run(t, func() { err = u.Upload(context.Background(), bytes.NewReader(body)) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if waited := clock.Now().Sub(start); waited != 30*time.Second {
+		t.Errorf("waited %v after the 503, want its Retry-After of 30s", waited)
+	}
+	s.mu.Lock()
+	if !bytes.Equal(s.data, body) {
+		t.Errorf("server has %q, want %q", s.data, body)
+	}
+
+	// Canceling the wait ends the upload.
+	failed = false
+	s.data = s.data[:4]
+	s.mu.Unlock()
+	clock = newFakeClock()
+	u.Clock = clock
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- u.Upload(ctx, bytes.NewReader(body)) }()
+	waitFor(t, "the Retry-After wait", func() bool {
+		clock.mu.Lock()
+		defer clock.mu.Unlock()
+		return len(clock.timers) > 0
+	})
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,29 @@
+package httpclientutil
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/zhaojkun/client/httpclientutil/httpheader"
+)
+
+// retryAfter returns how long a 429 or 503 response asks the client to
+// hold off, from its Retry-After header. ok is false for other statuses
+// and for a missing or malformed header.
+func retryAfter(resp *http.Response, now time.Time) (d time.Duration, ok bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	return httpheader.RetryAfter(resp.Header, now)
+}
+
+// retryAfterError is a failed response whose server asked for wait before
+// the next attempt.
+type retryAfterError struct {
+	err  error
+	wait time.Duration
+}
+
+func (e *retryAfterError) Error() string { return e.err.Error() }
+
+func (e *retryAfterError) Unwrap() error { return e.err }
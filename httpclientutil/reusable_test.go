@@ -0,0 +1,72 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReusable(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		close    bool // request sets Close
+		readAll  bool
+		want     bool
+	}{
+		{"keep-alive", "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok", false, true, true},
+		{"body pending", "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok", false, false, true},
+		{"connection close", "HTTP/1.1 200 OK\r\nConnection: close\r\nContent-Length: 2\r\n\r\nok", false, true, false},
+		{"http/1.0", "HTTP/1.0 200 OK\r\nContent-Length: 2\r\n\r\nok", false, true, false},
+		{"http/1.0 keep-alive", "HTTP/1.0 200 OK\r\nConnection: keep-alive\r\nContent-Length: 2\r\n\r\nok", false, true, true},
+		{"request close", "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok", true, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+				if _, err := http.ReadRequest(br); err == nil {
+					io.WriteString(c, tt.response)
+				}
+				io.Copy(io.Discard, br)
+			})
+			req, _ := http.NewRequest("GET", "http://example.com/", nil)
+			req.Close = tt.close
+			resp, err := cc.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.readAll {
+				io.ReadAll(resp.Body)
+				resp.Body.Close()
+			}
+			if got := cc.Reusable(); got != tt.want {
+				t.Errorf("Reusable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReusableBodyClosedEarly(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		if _, err := http.ReadRequest(br); err == nil {
+			io.WriteString(c, "HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\n"+strings.Repeat("x", 100))
+		}
+		io.Copy(io.Discard, br)
+	})
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	resp, err := cc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Read(make([]byte, 10))
+	resp.Body.Close()
+	if cc.Reusable() {
+		t.Fatal("Reusable after closing a body before its end")
+	}
+	if _, err := cc.Do(req); err != ErrBodyLeftData {
+		t.Fatalf("next Do = %v, want ErrBodyLeftData", err)
+	}
+}
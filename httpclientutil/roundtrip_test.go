@@ -0,0 +1,54 @@
+package httpclientutil
+
+import (
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRoundTripWithClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "s1", Path: "/"})
+			http.Redirect(w, r, "/home", http.StatusFound)
+		case "/home":
+			c, err := r.Cookie("session")
+			if err != nil {
+				http.Error(w, "no session", http.StatusUnauthorized)
+				return
+			}
+			io.WriteString(w, "hello "+c.Value)
+		case "/away":
+			http.Redirect(w, r, "http://other.example/", http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{
+		Transport:     dialConn(t, srv.Listener.Addr().String()),
+		Jar:           jar,
+		CheckRedirect: SameOriginRedirects,
+	}
+
+	resp, err := client.Get(srv.URL + "/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "hello s1" {
+		t.Errorf("after redirect: %d %q", resp.StatusCode, b)
+	}
+
+	resp, err = client.Get(srv.URL + "/away")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Errorf("cross-origin redirect: status %d, want the 302 itself", resp.StatusCode)
+	}
+}
@@ -0,0 +1,89 @@
+package httpclientutil
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// TruncatedError reports a response body cut short by a failed connection.
+// The bytes before the failure have already been delivered by Read; Rest
+// is the range to request for the remainder.
+type TruncatedError struct {
+	Received int64 // body bytes delivered before the failure
+	Expected int64 // Content-Length, or -1 if unknown
+	Err      error // the failure
+}
+
+func (e *TruncatedError) Error() string {
+	if e.Expected >= 0 {
+		return fmt.Sprintf("http: response body truncated after %d of %d bytes: %v", e.Received, e.Expected, e.Err)
+	}
+	return fmt.Sprintf("http: response body truncated after %d bytes: %v", e.Received, e.Err)
+}
+
+func (e *TruncatedError) Unwrap() error { return e.Err }
+
+// Rest returns the byte range that did not arrive, for SetRanges.
+func (e *TruncatedError) Rest() ByteRange {
+	return ByteRange{Start: e.Received, End: -1}
+}
+
+// SalvagingDoer turns a connection failure partway through a response body
+// into a *TruncatedError, so that crawlers and players can tell a usable
+// prefix from a failed request and resume from it.
+type SalvagingDoer struct {
+	Doer Doer
+}
+
+func (s *SalvagingDoer) Do(req *http.Request) (*http.Response, error) {
+	resp, err := s.Doer.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	salvage(resp)
+	return resp, nil
+}
+
+// ReadPartial reads resp.Body to its end and closes it. A body cut short
+// returns the bytes received so far along with a *TruncatedError.
+func ReadPartial(resp *http.Response) ([]byte, error) {
+	salvage(resp)
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func salvage(resp *http.Response) {
+	if _, ok := resp.Body.(*salvageBody); !ok && resp.Body != nil && resp.Body != http.NoBody {
+		resp.Body = &salvageBody{ReadCloser: resp.Body, expected: resp.ContentLength}
+	}
+}
+
+type salvageBody struct {
+	io.ReadCloser
+	n, expected int64
+}
+
+func (b *salvageBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if truncation(err) {
+		err = &TruncatedError{Received: b.n, Expected: b.expected, Err: err}
+	}
+	return n, err
+}
+
+// truncation reports whether a body read error means data is missing,
+// rather than the end of the body or a caller's mistake.
+func truncation(err error) bool {
+	var lm *LengthMismatchError
+	switch {
+	case err == nil, err == io.EOF, err == errReadOnClosedResBody:
+		return false
+	case errors.As(err, &lm):
+		return !lm.Extra
+	}
+	var te *TruncatedError
+	return !errors.As(err, &te)
+}
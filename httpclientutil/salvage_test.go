@@ -0,0 +1,54 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestReadPartial(t *testing.T) {
+	for _, tt := range []struct {
+		name, raw, body string
+		expected        int64 // Content-Length reported, -2 for a complete body
+	}{
+		{"length", "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nabcd", "abcd", 10},
+		{"chunked", "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nabc\r\n", "abc", -1},
+		{"complete", "HTTP/1.1 200 OK\r\nContent-Length: 4\r\nConnection: close\r\n\r\nabcd", "abcd", -2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+				if _, err := http.ReadRequest(br); err == nil {
+					io.WriteString(c, tt.raw)
+				}
+			})
+			req, _ := http.NewRequest("GET", "http://a.example/", nil)
+			resp, err := (&SalvagingDoer{Doer: cc}).Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := ReadPartial(resp)
+			if string(b) != tt.body {
+				t.Errorf("body = %q, want %q", b, tt.body)
+			}
+			var te *TruncatedError
+			if tt.expected == -2 {
+				if err != nil {
+					t.Errorf("complete body: err = %v", err)
+				}
+				return
+			}
+			if !errors.As(err, &te) {
+				t.Fatalf("err = %v, want a TruncatedError", err)
+			}
+			if te.Received != int64(len(tt.body)) || te.Expected != tt.expected {
+				t.Errorf("TruncatedError = %+v", te)
+			}
+			if got, want := te.Rest(), (ByteRange{Start: int64(len(tt.body)), End: -1}); got != want {
+				t.Errorf("Rest = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package httpclientutil
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// ErrStopScan is returned by a ScanElements callback to end the scan
+// without error.
+var ErrStopScan = errors.New("http: stop scan")
+
+// ScanElements decodes resp's body incrementally and calls fn with each
+// start element; fn may consume the element with d.DecodeElement. When
+// html is set the decoder accepts HTML as browsers write it: unquoted
+// attributes, void elements and HTML entities.
+//
+// Once fn returns ErrStopScan or another error, or the document ends, the
+// body is closed. A rest shorter than the drain limit is read to keep the
+// connection reusable; a longer or unknown one is abandoned with the
+// connection, which saves the transfer. ScanElements returns fn's error,
+// nil for ErrStopScan, or the decoding error that ended the scan.
+func ScanElements(resp *http.Response, html bool, fn func(d *xml.Decoder, se xml.StartElement) error) error {
+	cr := &countingReader{r: resp.Body}
+	defer func() {
+		left := resp.ContentLength - cr.n
+		if resp.ContentLength >= 0 && left <= maxDrainBytes {
+			drainBody(resp)
+		} else {
+			resp.Body.Close()
+		}
+	}()
+	d := xml.NewDecoder(cr)
+	if html {
+		d.Strict = false
+		d.AutoClose = xml.HTMLAutoClose
+		d.Entity = xml.HTMLEntity
+	}
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if err := fn(d, se); err != nil {
+			if err == ErrStopScan {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
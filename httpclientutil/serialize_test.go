@@ -0,0 +1,40 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestWithRequestWriter(t *testing.T) {
+	uris := make(chan string, 2)
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		for {
+			req, err := http.ReadRequest(br)
+			if err != nil {
+				return
+			}
+			io.Copy(io.Discard, req.Body)
+			uris <- req.RequestURI
+			writeResponse(c, "ok")
+		}
+	})
+	ctx := WithRequestWriter(context.Background(), (*http.Request).WriteProxy)
+	for _, c := range []context.Context{context.Background(), ctx} {
+		req, _ := http.NewRequestWithContext(c, "GET", "http://example.com/a", nil)
+		resp, err := cc.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		drainBody(resp)
+	}
+	if got := <-uris; got != "/a" {
+		t.Errorf("default writer sent %q", got)
+	}
+	if got := <-uris; got != "http://example.com/a" {
+		t.Errorf("WriteProxy from the context sent %q", got)
+	}
+}
@@ -0,0 +1,83 @@
+package httpclientutil
+
+import (
+	"hash/fnv"
+	"runtime"
+	"sync"
+)
+
+// shardedMap spreads per-host state over independently locked shards so
+// that requests to different hosts rarely contend. The shard count is the
+// power of two at or above GOMAXPROCS when the map is first used. The zero
+// value is ready to use.
+type shardedMap struct {
+	once   sync.Once
+	shards []mapShard
+	mask   uint32
+}
+
+type mapShard struct {
+	sync.Mutex
+	m map[string]interface{}
+	_ [64 - 8 - 8]byte // keep neighbouring locks off one cache line
+}
+
+func (sm *shardedMap) shard(key string) *mapShard {
+	sm.once.Do(func() {
+		n := 1
+		for n < runtime.GOMAXPROCS(0) {
+			n <<= 1
+		}
+		sm.shards = make([]mapShard, n)
+		for i := range sm.shards {
+			sm.shards[i].m = make(map[string]interface{})
+		}
+		sm.mask = uint32(n - 1)
+	})
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return &sm.shards[h.Sum32()&sm.mask]
+}
+
+// load returns the value stored under key, creating it with newValue if
+// absent. newValue runs with the shard locked.
+func (sm *shardedMap) load(key string, newValue func() interface{}) interface{} {
+	s := sm.shard(key)
+	s.Lock()
+	defer s.Unlock()
+	v, ok := s.m[key]
+	if !ok && newValue != nil {
+		v = newValue()
+		s.m[key] = v
+	}
+	return v
+}
+
+func (sm *shardedMap) store(key string, v interface{}) {
+	s := sm.shard(key)
+	s.Lock()
+	s.m[key] = v
+	s.Unlock()
+}
+
+func (sm *shardedMap) delete(key string) {
+	s := sm.shard(key)
+	s.Lock()
+	delete(s.m, key)
+	s.Unlock()
+}
+
+// values returns a snapshot of the stored values, in no particular order.
+func (sm *shardedMap) values() []interface{} {
+	sm.shard("") // make the shards
+	var vs []interface{}
+	for i := range sm.shards {
+		s := &sm.shards[i]
+		s.Lock()
+		for _, v := range s.m {
+			vs = append(vs, v)
+		}
+		s.Unlock()
+	}
+	return vs
+}
@@ -0,0 +1,110 @@
+package httpclientutil
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// mutexMap is the single-lock map shardedMap replaced, kept for comparison.
+type mutexMap struct {
+	mu sync.Mutex
+	m  map[string]interface{}
+}
+
+func (mm *mutexMap) load(key string, newValue func() interface{}) interface{} {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+	if mm.m == nil {
+		mm.m = make(map[string]interface{})
+	}
+	v, ok := mm.m[key]
+	if !ok && newValue != nil {
+		v = newValue()
+		mm.m[key] = v
+	}
+	return v
+}
+
+func TestShardedMap(t *testing.T) {
+	var sm shardedMap
+	if v := sm.load("a", nil); v != nil {
+		t.Fatalf("load of missing key = %v", v)
+	}
+	if v := sm.load("a", func() interface{} { return 1 }); v != 1 {
+		t.Fatalf("load created %v", v)
+	}
+	if v := sm.load("a", func() interface{} { return 2 }); v != 1 {
+		t.Fatalf("load replaced the value: %v", v)
+	}
+	sm.store("a", 3)
+	if v := sm.load("a", nil); v != 3 {
+		t.Fatalf("after store = %v", v)
+	}
+	sm.store("b", 4)
+	if vs := sm.values(); len(vs) != 2 || vs[0].(int)+vs[1].(int) != 7 {
+		t.Fatalf("values = %v", vs)
+	}
+	sm.delete("a")
+	if v := sm.load("a", nil); v != nil {
+		t.Fatalf("after delete = %v", v)
+	}
+	if n := len(sm.shards); n < runtime.GOMAXPROCS(0) || n&(n-1) != 0 {
+		t.Fatalf("%d shards for GOMAXPROCS %d", n, runtime.GOMAXPROCS(0))
+	}
+}
+
+var benchHosts = func() []string {
+	hosts := make([]string, 256)
+	for i := range hosts {
+		hosts[i] = fmt.Sprintf("host%d.example.com", i)
+	}
+	return hosts
+}()
+
+// benchmarkHostMap runs load, the hot operation of PoliteDoer,
+// TenantLimiter and ClientConnPool, from all procs across many hosts. Run with
+// -cpu=1,4,16,64 to see contention grow with GOMAXPROCS.
+func benchmarkHostMap(b *testing.B, load func(string, func() interface{}) interface{}) {
+	newValue := func() interface{} { return new(int) }
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			load(benchHosts[i&255], newValue)
+			i += 7
+		}
+	})
+}
+
+func BenchmarkHostMapSharded(b *testing.B) {
+	var sm shardedMap
+	benchmarkHostMap(b, sm.load)
+}
+
+func BenchmarkHostMapMutex(b *testing.B) {
+	var mm mutexMap
+	benchmarkHostMap(b, mm.load)
+}
+
+// BenchmarkPoolHosts reserves and frees connection slots, as each pooled
+// request does, from all procs across many hosts.
+func BenchmarkPoolHosts(b *testing.B) {
+	p := new(ClientConnPool)
+	keys := make([]poolKey, len(benchHosts))
+	for i, host := range benchHosts {
+		keys[i] = poolKey{ConnKey: ConnKey{Scheme: "https", Addr: host + ":443"}}
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			key := keys[i&255]
+			h := p.host(key)
+			h.mu.Lock()
+			h.open++
+			h.mu.Unlock()
+			p.release(key)
+			i += 7
+		}
+	})
+}
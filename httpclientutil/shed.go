@@ -0,0 +1,128 @@
+package httpclientutil
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// ErrShed is returned for requests a Shedder dropped to make room for more
+// important ones.
+var ErrShed = errors.New("http: request shed under load")
+
+type priorityKey struct{}
+
+// WithPriority returns a context whose requests a Shedder ranks at p.
+// Higher is more important; requests without one rank at zero.
+func WithPriority(ctx context.Context, p int) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+func requestPriority(req *http.Request) int {
+	p, _ := req.Context().Value(priorityKey{}).(int)
+	return p
+}
+
+// Shedder bounds the requests in flight through a shared Doer and queues
+// the rest by priority, most important first and in arrival order within a
+// priority. Once more than MaxQueue are waiting it sheds the least
+// important, newest one with ErrShed, so critical traffic keeps a short
+// queue however much background traffic piles up behind it. A request
+// counts as in flight until its response body is closed or fully read.
+type Shedder struct {
+	Doer        Doer
+	MaxInFlight int // zero means no limit
+	MaxQueue    int // waiting requests before shedding; zero sheds whatever cannot start
+
+	// OnShed, if set, is called with each request shed, before its Do
+	// returns.
+	OnShed func(*http.Request)
+
+	mu       sync.Mutex
+	inFlight int
+	waiters  []*shedWaiter // by priority, then arrival
+}
+
+type shedWaiter struct {
+	req      *http.Request
+	priority int
+	ready    chan error // receives nil for a slot or ErrShed
+}
+
+func (s *Shedder) Do(req *http.Request) (*http.Response, error) {
+	if s.MaxInFlight <= 0 {
+		return s.Doer.Do(req)
+	}
+	if err := s.acquire(req); err != nil {
+		return nil, err
+	}
+	resp, err := s.Doer.Do(req)
+	if err != nil {
+		s.release()
+		return nil, err
+	}
+	resp.Body = newNotifyBody(resp.Body, func(error) { s.release() })
+	return resp, nil
+}
+
+func (s *Shedder) acquire(req *http.Request) error {
+	s.mu.Lock()
+	if s.inFlight < s.MaxInFlight {
+		s.inFlight++
+		s.mu.Unlock()
+		return nil
+	}
+	w := &shedWaiter{req: req, priority: requestPriority(req), ready: make(chan error, 1)}
+	i := len(s.waiters)
+	for i > 0 && s.waiters[i-1].priority < w.priority {
+		i--
+	}
+	s.waiters = append(s.waiters, nil)
+	copy(s.waiters[i+1:], s.waiters[i:])
+	s.waiters[i] = w
+	var victim *shedWaiter
+	if len(s.waiters) > s.MaxQueue {
+		victim = s.waiters[len(s.waiters)-1]
+		s.waiters = s.waiters[:len(s.waiters)-1]
+	}
+	s.mu.Unlock()
+	if victim != nil {
+		if s.OnShed != nil {
+			s.OnShed(victim.req)
+		}
+		victim.ready <- ErrShed
+	}
+	select {
+	case err := <-w.ready:
+		return err
+	case <-req.Context().Done():
+		s.mu.Lock()
+		for i, o := range s.waiters {
+			if o == w {
+				s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+				s.mu.Unlock()
+				return context.Cause(req.Context())
+			}
+		}
+		s.mu.Unlock()
+		// Granted or shed meanwhile; give a granted slot back.
+		if err := <-w.ready; err == nil {
+			s.release()
+		}
+		return context.Cause(req.Context())
+	}
+}
+
+// release hands the slot to the most important waiter, if any.
+func (s *Shedder) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.waiters) == 0 {
+		s.inFlight--
+		return
+	}
+	w := s.waiters[0]
+	s.waiters = s.waiters[1:]
+	w.ready <- nil
+}
@@ -0,0 +1,109 @@
+package httpclientutil
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gateDoer records the paths it is asked for and answers each once a value
+// arrives on release.
+type gateDoer struct {
+	release chan struct{}
+	mu      sync.Mutex
+	paths   []string
+}
+
+func (d *gateDoer) Do(req *http.Request) (*http.Response, error) {
+	d.mu.Lock()
+	d.paths = append(d.paths, req.URL.Path)
+	d.mu.Unlock()
+	<-d.release
+	return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+}
+
+func TestShedderPriority(t *testing.T) {
+	d := &gateDoer{release: make(chan struct{})}
+	var shed []string
+	s := &Shedder{Doer: d, MaxInFlight: 1, MaxQueue: 2, OnShed: func(req *http.Request) {
+		shed = append(shed, req.URL.Path)
+	}}
+	errs := make(map[string]chan error)
+	send := func(path string, priority int) {
+		errs[path] = make(chan error, 1)
+		req, _ := http.NewRequestWithContext(WithPriority(context.Background(), priority), "GET", "http://a.example"+path, nil)
+		go func() {
+			resp, err := s.Do(req)
+			if err == nil {
+				resp.Body.Close()
+			}
+			errs[path] <- err
+		}()
+		// Let it reach the queue before the next one.
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			s.mu.Lock()
+			n := s.inFlight + len(s.waiters)
+			s.mu.Unlock()
+			if n == len(errs) || n == s.MaxInFlight+s.MaxQueue {
+				break
+			}
+		}
+	}
+	send("/busy", 0)
+	send("/low1", 0)
+	send("/low2", 0)
+	send("/high", 5)
+	if err := <-errs["/low2"]; !errors.Is(err, ErrShed) {
+		t.Fatalf("newest low priority request: err = %v, want ErrShed", err)
+	}
+	if len(shed) != 1 || shed[0] != "/low2" {
+		t.Errorf("OnShed saw %q", shed)
+	}
+	for _, path := range []string{"/busy", "/high", "/low1"} {
+		d.release <- struct{}{}
+		if err := <-errs[path]; err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+	if got := strings.Join(d.paths, " "); got != "/busy /high /low1" {
+		t.Errorf("served %s, want the high priority request first", got)
+	}
+}
+
+func TestShedderCanceledWaiter(t *testing.T) {
+	d := &gateDoer{release: make(chan struct{})}
+	s := &Shedder{Doer: d, MaxInFlight: 1, MaxQueue: 1}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		resp, err := get(t, s, "http://a.example/busy")
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	for {
+		s.mu.Lock()
+		n := s.inFlight
+		s.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", "http://a.example/late", nil)
+	if _, err := s.Do(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want the context's", err)
+	}
+	d.release <- struct{}{}
+	<-done
+	if s.inFlight != 0 || len(s.waiters) != 0 {
+		t.Errorf("left %d in flight and %d waiting", s.inFlight, len(s.waiters))
+	}
+}
@@ -0,0 +1,218 @@
+package httpclientutil
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrSOCKSAuth is the error of a SOCKS5 proxy that accepted neither no
+// authentication nor the username and password offered.
+var ErrSOCKSAuth = errors.New("http: socks proxy rejected authentication")
+
+// SOCKSError is a SOCKS5 proxy's refusal to connect to Target, with the
+// reply code of RFC 1928.
+type SOCKSError struct {
+	Target string
+	Reply  byte
+}
+
+var socksReplies = [...]string{
+	1: "general failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+func (e *SOCKSError) Error() string {
+	msg := "reply " + strconv.Itoa(int(e.Reply))
+	if int(e.Reply) < len(socksReplies) && socksReplies[e.Reply] != "" {
+		msg = socksReplies[e.Reply]
+	}
+	return fmt.Sprintf("http: socks proxy refused connection to %s: %s", e.Target, msg)
+}
+
+func isSOCKS(proxy *url.URL) bool {
+	return proxy.Scheme == "socks5" || proxy.Scheme == "socks5h"
+}
+
+type socksProxyKey struct{}
+
+// withSOCKSProxy returns a context under which Dialer dials through proxy,
+// as if it were its SOCKS field.
+func withSOCKSProxy(ctx context.Context, proxy *url.URL) context.Context {
+	return context.WithValue(ctx, socksProxyKey{}, proxy)
+}
+
+// dialProxied dials addr directly or, for TCP, through the SOCKS proxy of
+// ctx or d.
+func (d *Dialer) dialProxied(ctx context.Context, network, addr string) (net.Conn, error) {
+	proxy, _ := ctx.Value(socksProxyKey{}).(*url.URL)
+	if proxy == nil {
+		proxy = d.SOCKS
+	}
+	if proxy == nil || !strings.HasPrefix(network, "tcp") {
+		return d.dial(ctx, network, addr)
+	}
+	target := d.mapAddr(ctx, addr)
+	if proxy.Scheme == "socks5" {
+		// The proxy gets an address resolved here.
+		host, port, err := net.SplitHostPort(target)
+		if err != nil {
+			return nil, err
+		}
+		if net.ParseIP(host) == nil {
+			ips, err := d.lookupIPAddr(ctx, host)
+			if err != nil {
+				return nil, err
+			}
+			if len(ips) == 0 {
+				return nil, &net.AddrError{Err: "no suitable address found", Addr: host}
+			}
+			target = net.JoinHostPort(ips[0].String(), port)
+		}
+	}
+	proxyAddr := proxy.Host
+	if proxy.Port() == "" {
+		proxyAddr = net.JoinHostPort(proxy.Hostname(), "1080")
+	}
+	c, err := d.dial(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if err := SOCKSHandshake(ctx, c, target, proxy.User); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// SOCKSHandshake asks the SOCKS5 proxy at the other end of c to connect to
+// target, a host:port, authenticating with user's name and password if
+// user is not nil (RFC 1929). Once it returns nil, c speaks to target, so
+// NewClientConn takes it like a direct connection, after tls.Client for
+// https. A host name in target is resolved by the proxy.
+func SOCKSHandshake(ctx context.Context, c net.Conn, target string, user *url.Userinfo) error {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("http: bad port in socks target %q", target)
+	}
+	if d, ok := ctx.Deadline(); ok {
+		c.SetDeadline(d)
+	}
+	done, exited := make(chan struct{}), make(chan struct{})
+	spawn("SOCKS handshake watcher", func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			c.SetDeadline(aLongTimeAgo)
+		case <-done:
+		}
+	})
+	err = socksHandshake(c, host, uint16(port), user)
+	close(done)
+	<-exited
+	c.SetDeadline(time.Time{})
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	return err
+}
+
+func socksHandshake(c net.Conn, host string, port uint16, user *url.Userinfo) error {
+	methods := []byte{0} // no authentication
+	if user != nil {
+		methods = append(methods, 2) // username and password
+	}
+	if _, err := c.Write(append([]byte{5, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	var b [2]byte
+	if _, err := io.ReadFull(c, b[:]); err != nil {
+		return err
+	}
+	if b[0] != 5 {
+		return fmt.Errorf("http: socks proxy speaks version %d, not 5", b[0])
+	}
+	switch b[1] {
+	case 0:
+	case 2:
+		if user == nil {
+			return ErrSOCKSAuth
+		}
+		name := user.Username()
+		password, _ := user.Password()
+		if len(name) > 255 || len(password) > 255 {
+			return errors.New("http: socks username or password longer than 255 bytes")
+		}
+		msg := append([]byte{1, byte(len(name))}, name...)
+		msg = append(append(msg, byte(len(password))), password...)
+		if _, err := c.Write(msg); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(c, b[:]); err != nil {
+			return err
+		}
+		if b[1] != 0 {
+			return ErrSOCKSAuth
+		}
+	default:
+		return ErrSOCKSAuth
+	}
+
+	req := []byte{5, 1, 0} // CONNECT
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("http: socks target host %q too long", host)
+		}
+		req = append(append(req, 3, byte(len(host))), host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(append(req, 1), ip4...)
+	} else {
+		req = append(append(req, 4), ip.To16()...)
+	}
+	req = binary.BigEndian.AppendUint16(req, port)
+	if _, err := c.Write(req); err != nil {
+		return err
+	}
+	// VER, REP, RSV, ATYP, then the bound address, which is of no use.
+	var reply [4]byte
+	if _, err := io.ReadFull(c, reply[:]); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return &SOCKSError{Target: net.JoinHostPort(host, strconv.Itoa(int(port))), Reply: reply[1]}
+	}
+	var n int
+	switch reply[3] {
+	case 1:
+		n = net.IPv4len
+	case 4:
+		n = net.IPv6len
+	case 3:
+		var l [1]byte
+		if _, err := io.ReadFull(c, l[:]); err != nil {
+			return err
+		}
+		n = int(l[0])
+	default:
+		return fmt.Errorf("http: socks reply with address type %d", reply[3])
+	}
+	_, err := io.ReadFull(c, make([]byte, n+2))
+	return err
+}
@@ -0,0 +1,168 @@
+package httpclientutil
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+// socksServer runs a SOCKS5 proxy that requires user and password if user
+// is not empty, and sends the targets it is asked for on targets.
+func socksServer(t *testing.T, user, password string) (addr string, targets chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	targets = make(chan string, 10)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSOCKS(c, user, password, targets)
+		}
+	}()
+	return ln.Addr().String(), targets
+}
+
+func serveSOCKS(c net.Conn, user, password string, targets chan string) {
+	defer c.Close()
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(c, b); err != nil {
+		return
+	}
+	methods := make([]byte, b[1])
+	io.ReadFull(c, methods)
+	if user == "" {
+		c.Write([]byte{5, 0})
+	} else {
+		c.Write([]byte{5, 2})
+		io.ReadFull(c, b)
+		name := make([]byte, b[1])
+		io.ReadFull(c, name)
+		io.ReadFull(c, b[:1])
+		pass := make([]byte, b[0])
+		io.ReadFull(c, pass)
+		if string(name) != user || string(pass) != password {
+			c.Write([]byte{1, 1})
+			return
+		}
+		c.Write([]byte{1, 0})
+	}
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(c, req); err != nil {
+		return
+	}
+	var host string
+	switch req[3] {
+	case 1:
+		ip := make([]byte, 4)
+		io.ReadFull(c, ip)
+		host = net.IP(ip).String()
+	case 3:
+		io.ReadFull(c, b[:1])
+		name := make([]byte, b[0])
+		io.ReadFull(c, name)
+		host = string(name)
+	}
+	io.ReadFull(c, b)
+	target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(b))))
+	targets <- target
+	if host == "localhost" {
+		target = net.JoinHostPort("127.0.0.1", strconv.Itoa(int(binary.BigEndian.Uint16(b))))
+	}
+	up, err := net.Dial("tcp", target)
+	if err != nil {
+		c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer up.Close()
+	c.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+	go io.Copy(up, c)
+	io.Copy(c, up)
+}
+
+func TestDialerSOCKS(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "via socks")
+	}))
+	defer s.Close()
+	_, port, _ := net.SplitHostPort(s.Listener.Addr().String())
+	proxy, targets := socksServer(t, "u", "p")
+	for _, scheme := range []string{"socks5h", "socks5"} {
+		d := &Dialer{SOCKS: &url.URL{Scheme: scheme, Host: proxy, User: url.UserPassword("u", "p")}}
+		cc, err := d.DialConn(context.Background(), "tcp", "localhost:"+port, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := doBody(t, cc); got != "via socks" {
+			t.Errorf("%s: body = %q", scheme, got)
+		}
+		cc.Close()
+		want := "localhost:" + port
+		if scheme == "socks5" {
+			want = "127.0.0.1:" + port // resolved before asking the proxy; /etc/hosts has localhost
+		}
+		if got := <-targets; got != want {
+			t.Errorf("%s: proxy asked for %s, want %s", scheme, got, want)
+		}
+	}
+}
+
+func TestSOCKSErrors(t *testing.T) {
+	proxy, _ := socksServer(t, "u", "p")
+	d := &Dialer{SOCKS: &url.URL{Scheme: "socks5h", Host: proxy, User: url.UserPassword("u", "wrong")}}
+	if _, err := d.Dial("tcp", "localhost:1"); err != ErrSOCKSAuth {
+		t.Errorf("wrong password: err = %v, want ErrSOCKSAuth", err)
+	}
+	d.SOCKS.User = nil
+	if _, err := d.Dial("tcp", "localhost:1"); err != ErrSOCKSAuth {
+		t.Errorf("no password: err = %v, want ErrSOCKSAuth", err)
+	}
+	d.SOCKS.User = url.UserPassword("u", "p")
+	// Nothing listens on port 1.
+	_, err := d.Dial("tcp", "127.0.0.1:1")
+	var se *SOCKSError
+	if !errors.As(err, &se) || se.Reply != 5 || se.Target != "127.0.0.1:1" {
+		t.Errorf("refused: err = %v", err)
+	}
+}
+
+func TestPoolProxySOCKS(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Host)
+	}))
+	defer s.Close()
+	proxy, targets := socksServer(t, "", "")
+	p := &ClientConnPool{
+		TLSConfig: &tls.Config{InsecureSkipVerify: true},
+		Proxy:     http.ProxyURL(&url.URL{Scheme: "socks5h", Host: proxy}),
+	}
+	defer p.Close()
+	target := s.Listener.Addr().String()
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("GET", "https://"+target+"/", nil)
+		resp, err := p.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(b) != target {
+			t.Errorf("body = %q", b)
+		}
+	}
+	if got := <-targets; got != target || len(targets) != 0 {
+		t.Errorf("proxy asked for %s and %d more, want one connection to %s", got, len(targets), target)
+	}
+}
@@ -0,0 +1,209 @@
+package httpclientutil
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Standby keeps connections to one host dialed ahead of need, so the
+// requests that cannot afford a dial, such as alerting, always find one.
+// Connections are redialed once older than MaxAge, which should be set
+// below the server's keep-alive timeout, and as soon as the server closes
+// them. It is safe for concurrent use.
+type Standby struct {
+	Dial   func(ctx context.Context) (*ClientConn, error)
+	Size   int           // connections kept warm, 1 if zero
+	MaxAge time.Duration // 30s if zero
+	Clock  Clock         // ages connections; nil is the system clock
+
+	fillMu  sync.Mutex // one fill at a time
+	mu      sync.Mutex
+	conns   []standbyConn // oldest first
+	started bool
+	kick    chan struct{}
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+type standbyConn struct {
+	cc *ClientConn
+	at time.Time
+}
+
+// standbyRetry is how long Standby waits to redial after a failed dial.
+const standbyRetry = time.Second
+
+// Warm dials until Size connections are ready and starts keeping them so.
+// The error is that of the first failed dial; the refresher keeps retrying
+// in the background regardless. Get calls it on first use.
+func (s *Standby) Warm(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.started {
+		s.started = true
+		s.kick = make(chan struct{}, 1)
+		s.done = make(chan struct{})
+		s.wg.Add(1)
+		spawn("standby refresh", s.refresh)
+	}
+	s.mu.Unlock()
+	return s.fill(ctx)
+}
+
+// Get takes a warm connection, which then belongs to the caller, and has
+// it replaced in the background. If none is ready, Get dials one itself.
+func (s *Standby) Get(ctx context.Context) (*ClientConn, error) {
+	s.mu.Lock()
+	started := s.started
+	s.mu.Unlock()
+	if !started {
+		s.Warm(ctx)
+	}
+	clock := clockOrSystem(s.Clock)
+	s.mu.Lock()
+	for len(s.conns) > 0 {
+		c := s.conns[len(s.conns)-1] // the freshest
+		s.conns = s.conns[:len(s.conns)-1]
+		if c.cc.Reusable() && clock.Now().Sub(c.at) < s.maxAge() {
+			s.mu.Unlock()
+			s.poke()
+			return c.cc, nil
+		}
+		c.cc.Close()
+	}
+	s.mu.Unlock()
+	s.poke()
+	return s.Dial(ctx)
+}
+
+// Ready returns how many warm connections are available.
+func (s *Standby) Ready() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// Close stops the refresher and closes the warm connections.
+func (s *Standby) Close() error {
+	s.mu.Lock()
+	if !s.started {
+		s.started = true
+		s.done = make(chan struct{})
+	}
+	if !s.closed() {
+		close(s.done)
+	}
+	conns := s.conns
+	s.conns = nil
+	s.mu.Unlock()
+	s.wg.Wait()
+	for _, c := range conns {
+		c.cc.Close()
+	}
+	return nil
+}
+
+func (s *Standby) size() int {
+	if s.Size <= 0 {
+		return 1
+	}
+	return s.Size
+}
+
+func (s *Standby) maxAge() time.Duration {
+	if s.MaxAge <= 0 {
+		return 30 * time.Second
+	}
+	return s.MaxAge
+}
+
+func (s *Standby) poke() {
+	select {
+	case s.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Standby) closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// fill drops expired and broken connections and dials up to Size.
+func (s *Standby) fill(ctx context.Context) error {
+	s.fillMu.Lock()
+	defer s.fillMu.Unlock()
+	clock := clockOrSystem(s.Clock)
+	s.mu.Lock()
+	live := s.conns[:0]
+	for _, c := range s.conns {
+		if c.cc.Reusable() && clock.Now().Sub(c.at) < s.maxAge() {
+			live = append(live, c)
+		} else {
+			c.cc.Close()
+		}
+	}
+	s.conns = live
+	missing := s.size() - len(s.conns)
+	s.mu.Unlock()
+	for ; missing > 0; missing-- {
+		cc, err := s.Dial(ctx)
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		if s.closed() {
+			s.mu.Unlock()
+			cc.Close()
+			return ErrClosed
+		}
+		s.conns = append(s.conns, standbyConn{cc: cc, at: clock.Now()})
+		s.wg.Add(1)
+		s.mu.Unlock()
+		spawn("standby watcher", func() {
+			defer s.wg.Done()
+			select {
+			case <-cc.readDone:
+				s.poke()
+			case <-s.done:
+			}
+		})
+	}
+	return nil
+}
+
+// refresh refills the standby set whenever a connection is taken or dies,
+// and replaces each one when it reaches MaxAge.
+func (s *Standby) refresh() {
+	defer s.wg.Done()
+	clock := clockOrSystem(s.Clock)
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(errStandbyClosed)
+	spawn("standby closer", func() {
+		<-s.done
+		cancel(errStandbyClosed)
+	})
+	for !s.closed() {
+		wait := standbyRetry
+		if s.fill(ctx) == nil {
+			s.mu.Lock()
+			wait = s.maxAge()
+			if len(s.conns) > 0 {
+				wait = s.conns[0].at.Add(s.maxAge()).Sub(clock.Now())
+			}
+			s.mu.Unlock()
+		}
+		t := clock.NewTimer(wait)
+		select {
+		case <-t.C():
+		case <-s.kick:
+			t.Stop()
+		case <-s.done:
+			t.Stop()
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package httpclientutil
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStandby(t *testing.T) {
+	srv := pathServer(t)
+	clock := newFakeClock()
+	var dials int32
+	s := &Standby{
+		Size:   2,
+		MaxAge: time.Minute,
+		Clock:  clock,
+		Dial: func(ctx context.Context) (*ClientConn, error) {
+			atomic.AddInt32(&dials, 1)
+			var d net.Dialer
+			c, err := d.DialContext(ctx, "tcp", srv.Listener.Addr().String())
+			if err != nil {
+				return nil, err
+			}
+			return NewClientConn(c), nil
+		},
+	}
+	defer s.Close()
+	if err := s.Warm(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.Ready(); n != 2 {
+		t.Fatalf("%d connections warm after Warm, want 2", n)
+	}
+
+	cc, err := s.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := doBody(t, cc); got != "/" {
+		t.Errorf("body = %q", got)
+	}
+	cc.Close()
+	waitFor(t, "a replacement for the taken connection", func() bool {
+		return s.Ready() == 2 && atomic.LoadInt32(&dials) == 3
+	})
+
+	clock.Advance(time.Minute)
+	waitFor(t, "expired connections to be redialed", func() bool {
+		return s.Ready() == 2 && atomic.LoadInt32(&dials) >= 5
+	})
+
+	before := atomic.LoadInt32(&dials)
+	srv.CloseClientConnections()
+	waitFor(t, "connections closed by the server to be redialed", func() bool {
+		return s.Ready() == 2 && atomic.LoadInt32(&dials) >= before+2
+	})
+}
+
+func TestStandbyGetDialsWhenEmpty(t *testing.T) {
+	srv := pathServer(t)
+	s := &Standby{Dial: func(ctx context.Context) (*ClientConn, error) {
+		c, err := net.Dial("tcp", srv.Listener.Addr().String())
+		if err != nil {
+			return nil, err
+		}
+		return NewClientConn(c), nil
+	}}
+	s.Close()
+	cc, err := s.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	if got := doBody(t, cc); got != "/" {
+		t.Errorf("body = %q", got)
+	}
+	if n := s.Ready(); n != 0 {
+		t.Errorf("closed Standby keeps %d connections", n)
+	}
+}
@@ -0,0 +1,131 @@
+package httpclientutil
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ConnStats counts events on a ClientConn.
+type ConnStats struct {
+	Requests         int64         // written, or being written
+	Responses        int64         // final responses whose headers were read
+	Reuses           int64         // requests after the first
+	BytesWritten     int64         // of requests, headers and bodies
+	BytesRead        int64         // from the conn; zero with WithReader
+	FirstByte        time.Duration // from writing the latest request to the first byte of its response
+	FirstByteTotal   time.Duration // of all responses, for the mean over Responses
+	LengthMismatches int64         // bodies that failed with a *LengthMismatchError
+}
+
+// connCounters is allocated apart so its int64s are 64-bit aligned for
+// the atomic operations.
+type connCounters struct {
+	lengthMismatches int64
+	requests         int64 // begun, see beginExchange
+	responses        int64
+	bytesWritten     int64
+	bytesRead        int64
+	firstByte        int64 // nanoseconds
+	firstByteTotal   int64
+	idleSince        int64 // UnixNano when the last exchange ended
+}
+
+// Stats returns the counts so far.
+func (cc *ClientConn) Stats() ConnStats {
+	st := ConnStats{
+		Requests:         atomic.LoadInt64(&cc.stats.requests),
+		Responses:        atomic.LoadInt64(&cc.stats.responses),
+		BytesWritten:     atomic.LoadInt64(&cc.stats.bytesWritten),
+		BytesRead:        atomic.LoadInt64(&cc.stats.bytesRead),
+		FirstByte:        time.Duration(atomic.LoadInt64(&cc.stats.firstByte)),
+		FirstByteTotal:   time.Duration(atomic.LoadInt64(&cc.stats.firstByteTotal)),
+		LengthMismatches: atomic.LoadInt64(&cc.stats.lengthMismatches),
+	}
+	if st.Requests > 1 {
+		st.Reuses = st.Requests - 1
+	}
+	return st
+}
+
+// Collector receives what a ClientConn counts in Stats as it happens, one
+// exchange at a time, for metrics per request. Its methods are called on
+// the goroutines writing requests and reading responses, so they must be
+// quick and, for a Collector shared by many connections, safe for
+// concurrent use.
+type Collector interface {
+	// RequestWritten is called once req is on the wire, with the bytes it
+	// took, its body included if sent; reused tells whether requests
+	// went before it on the connection.
+	RequestWritten(req *http.Request, n int64, reused bool)
+
+	// ResponseRead is called once the headers of req's final response
+	// are read, with the time from writing req to the response's first
+	// byte.
+	ResponseRead(req *http.Request, resp *http.Response, firstByte time.Duration)
+}
+
+// WithCollector reports each exchange to c.
+func WithCollector(c Collector) Option {
+	return func(cc *ClientConn) { cc.collector = c }
+}
+
+// wrote records pr's request as written in n bytes. It fails if the
+// collector panicked.
+func (cc *ClientConn) wrote(pr *pendingReq, n int64) error {
+	atomic.AddInt64(&cc.stats.bytesWritten, n)
+	if cc.collector == nil {
+		return nil
+	}
+	return runHook("Collector.RequestWritten", func() error {
+		cc.collector.RequestWritten(pr.req, n, atomic.LoadInt64(&cc.stats.requests) > 1)
+		return nil
+	})
+}
+
+// gotResponse records resp, the final response to pr, whose first byte
+// arrived at firstByteAt. It fails if the collector panicked.
+func (cc *ClientConn) gotResponse(pr *pendingReq, resp *http.Response, firstByteAt time.Time) error {
+	atomic.AddInt64(&cc.stats.responses, 1)
+	var ttfb time.Duration
+	if !pr.wroteAt.IsZero() && !firstByteAt.IsZero() {
+		if ttfb = firstByteAt.Sub(pr.wroteAt); ttfb < 0 {
+			ttfb = 0 // the response came early
+		}
+	}
+	atomic.StoreInt64(&cc.stats.firstByte, int64(ttfb))
+	atomic.AddInt64(&cc.stats.firstByteTotal, int64(ttfb))
+	if cc.collector == nil {
+		return nil
+	}
+	return runHook("Collector.ResponseRead", func() error {
+		cc.collector.ResponseRead(pr.req, resp, ttfb)
+		return nil
+	})
+}
+
+// countingWriter counts the bytes written through it into n.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// statsReader counts the bytes the connection's reader takes from the
+// conn.
+type statsReader struct {
+	r     io.Reader
+	stats *connCounters
+}
+
+func (sr statsReader) Read(p []byte) (int, error) {
+	n, err := sr.r.Read(p)
+	atomic.AddInt64(&sr.stats.bytesRead, int64(n))
+	return n, err
+}
@@ -0,0 +1,113 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countedConn counts the bytes a test server reads and writes.
+type countedConn struct {
+	net.Conn
+	read, written *int64
+}
+
+func (c countedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(c.read, int64(n))
+	return n, err
+}
+
+func (c countedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddInt64(c.written, int64(n))
+	return n, err
+}
+
+type recordingCollector struct {
+	mu        sync.Mutex
+	written   []int64
+	reused    []bool
+	firstByte []time.Duration
+}
+
+func (rc *recordingCollector) RequestWritten(req *http.Request, n int64, reused bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.written = append(rc.written, n)
+	rc.reused = append(rc.reused, reused)
+}
+
+func (rc *recordingCollector) ResponseRead(req *http.Request, resp *http.Response, firstByte time.Duration) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.firstByte = append(rc.firstByte, firstByte)
+}
+
+func TestConnStats(t *testing.T) {
+	var read, written int64
+	col := new(recordingCollector)
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		cc := countedConn{Conn: c, read: &read, written: &written}
+		br = bufio.NewReader(cc)
+		for {
+			req, err := http.ReadRequest(br)
+			if err != nil {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+			writeResponse(cc, req.URL.Path)
+		}
+	}, WithCollector(col))
+	for i := 0; i < 3; i++ {
+		if body := doBody(t, cc); body != "/" {
+			t.Fatalf("body = %q", body)
+		}
+	}
+	st := cc.Stats()
+	if st.Requests != 3 || st.Responses != 3 || st.Reuses != 2 {
+		t.Errorf("stats = %+v", st)
+	}
+	if st.BytesWritten != atomic.LoadInt64(&read) || st.BytesRead != atomic.LoadInt64(&written) {
+		t.Errorf("bytes written %d, read %d; the server read %d, wrote %d", st.BytesWritten, st.BytesRead, read, written)
+	}
+	if st.FirstByte < 20*time.Millisecond || st.FirstByteTotal < 60*time.Millisecond {
+		t.Errorf("first byte after %v, %v in total", st.FirstByte, st.FirstByteTotal)
+	}
+	col.mu.Lock()
+	defer col.mu.Unlock()
+	var sum int64
+	for _, n := range col.written {
+		sum += n
+	}
+	if len(col.written) != 3 || sum != st.BytesWritten || col.reused[0] || !col.reused[1] {
+		t.Errorf("collector saw %v bytes, reused %v", col.written, col.reused)
+	}
+	if len(col.firstByte) != 3 || col.firstByte[2] < 20*time.Millisecond {
+		t.Errorf("collector saw first bytes after %v", col.firstByte)
+	}
+}
+
+func TestConnStatsBatch(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		for {
+			req, err := http.ReadRequest(br)
+			if err != nil {
+				return
+			}
+			writeResponse(c, req.URL.Path)
+		}
+	})
+	reqs := batchRequests(t, "http://a.example", "GET", "POST", "GET")
+	if _, err := cc.DoBatch(reqs); err != nil {
+		t.Fatal(err)
+	}
+	if st := cc.Stats(); st.Requests != 3 || st.Responses != 3 || st.BytesWritten == 0 {
+		t.Errorf("stats = %+v", st)
+	}
+	waitFor(t, "the batch to end", func() bool { return atomic.LoadInt32(&cc.active) == 0 })
+}
@@ -0,0 +1,60 @@
+package httpclientutil
+
+import (
+	"io"
+	"net/http"
+)
+
+// DoStream sends req with a body the caller produces as it goes: it writes
+// the request header, and the returned writer sends each Write as one
+// chunk of the body, so the response can be read while the body is still
+// being written, as long-lived streaming POSTs need. DoStream returns once
+// the response header arrived, so the server must answer before it has
+// the whole body; for one that reads the body first, give Do an io.Pipe
+// as the body instead. req's own body is ignored. Close w to end the body: the
+// connection carries no other request until then, and a write timeout
+// covers the whole body.
+func (cc *ClientConn) DoStream(req *http.Request) (resp *http.Response, w io.WriteCloser, err error) {
+	if cc.iswaiting() && !cc.pipelining.Load() {
+		return nil, nil, ErrBodyWaitingRead
+	}
+	pr, pw := io.Pipe()
+	sb := &streamBody{ReadCloser: pr, handed: make(chan *pendingReq, 1)}
+	r := *req
+	r.Body, r.GetBody, r.ContentLength = sb, nil, -1
+	errc := make(chan error, 1)
+	spawn("stream writer", func() {
+		_, err := cc.write(&r, false)
+		if err != nil {
+			pr.CloseWithError(err)
+		}
+		errc <- err
+	})
+	select {
+	case p := <-sb.handed:
+		resp, err = cc.read(p)
+	case err = <-errc:
+		if err == nil {
+			// The body was sent without being read, so never handed.
+			err = ErrPipeline
+		}
+	}
+	if err != nil {
+		pw.CloseWithError(err)
+		return nil, nil, err
+	}
+	return resp, pw, nil
+}
+
+// streamBody is the body of a DoStream request. Like a body held back for
+// 100 Continue, it hands the request to readLoop on its first Read, once
+// the header was flushed, but then follows right away; see continueBody.
+type streamBody struct {
+	io.ReadCloser
+	handed chan *pendingReq // gets the request once readLoop has it
+}
+
+func isStream(req *http.Request) bool {
+	_, ok := req.Body.(*streamBody)
+	return ok
+}
@@ -0,0 +1,78 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDoStream(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		// Answer at once, then echo each line of the body in upper case.
+		io.WriteString(c, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n")
+		lines := bufio.NewReader(req.Body)
+		for {
+			line, err := lines.ReadString('\n')
+			if err != nil {
+				break
+			}
+			fmt.Fprintf(c, "%x\r\n%s\r\n", len(line), strings.ToUpper(line))
+		}
+		io.WriteString(c, "0\r\n\r\n")
+		answer(c, br, "next")
+	})
+	req, _ := http.NewRequest("POST", "http://a.example/stream", strings.NewReader("ignored"))
+	resp, w, err := cc.DoStream(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(resp.Body)
+	for _, line := range []string{"one\n", "two\n"} {
+		if _, err := io.WriteString(w, line); err != nil {
+			t.Fatal(err)
+		}
+		got, err := r.ReadString('\n')
+		if err != nil || got != strings.ToUpper(line) {
+			t.Fatalf("echo = %q, %v", got, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if rest, err := io.ReadAll(r); len(rest) != 0 || err != nil {
+		t.Errorf("rest = %q, %v", rest, err)
+	}
+	resp.Body.Close()
+	if got := doBody(t, cc); got != "next" {
+		t.Errorf("request after the stream got %q", got)
+	}
+}
+
+func TestDoStreamWriteAfterFailure(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		if _, err := http.ReadRequest(br); err != nil {
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+		c.Close()
+	})
+	req, _ := http.NewRequest("POST", "http://a.example/stream", nil)
+	resp, w, err := cc.DoStream(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	cc.Close()
+	if _, err := io.WriteString(w, strings.Repeat("x", 1<<20)); err == nil {
+		t.Error("body write on a closed connection succeeded")
+	}
+}
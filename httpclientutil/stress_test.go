@@ -0,0 +1,252 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// These tests share one ClientConn between goroutines and are meant to be
+// run with -race.
+
+// pathServer answers every request with its path, in the body or, for
+// paths ending in /empty, in an X-Path header on a 204.
+func pathServer(t *testing.T) *httptest.Server {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/empty") {
+			w.Header().Set("X-Path", r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		io.WriteString(w, r.URL.Path)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func dialConn(t *testing.T, addr string) *ClientConn {
+	t.Helper()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc := NewClientConn(c)
+	t.Cleanup(func() { cc.Close() })
+	return cc
+}
+
+func TestStressConcurrentDo(t *testing.T) {
+	s := pathServer(t)
+	cc := dialConn(t, s.Listener.Addr().String())
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				path := fmt.Sprintf("/%d/%d", g, i)
+				if i%2 == 0 {
+					path += "/empty"
+				}
+				req, _ := http.NewRequest("GET", s.URL+path, nil)
+				resp, err := cc.Do(req)
+				if err == ErrBodyWaitingRead {
+					continue
+				}
+				if err != nil {
+					t.Errorf("Do %s: %v", path, err)
+					return
+				}
+				got := resp.Header.Get("X-Path")
+				if got == "" {
+					b, _ := io.ReadAll(resp.Body)
+					got = string(b)
+				}
+				resp.Body.Close()
+				if resp.Request != req || got != path {
+					t.Errorf("Do %s got the response for %s", path, got)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestStressCloseDuringBodyRead(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				if _, err := http.ReadRequest(bufio.NewReader(c)); err != nil {
+					return
+				}
+				io.WriteString(c, "HTTP/1.1 200 OK\r\nContent-Length: 1000000\r\n\r\n")
+				for {
+					if _, err := c.Write(make([]byte, 100)); err != nil {
+						return
+					}
+					time.Sleep(time.Millisecond)
+				}
+			}()
+		}
+	}()
+	for round := 0; round < 10; round++ {
+		cc := dialConn(t, ln.Addr().String())
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		resp, err := cc.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		read := make(chan error)
+		go func() {
+			_, err := io.Copy(io.Discard, resp.Body)
+			read <- err
+		}()
+		time.Sleep(time.Duration(round) * time.Millisecond)
+		cc.Close()
+		select {
+		case err := <-read:
+			if err == nil {
+				t.Fatal("body read after Close ended without error")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("body read still blocked after Close")
+		}
+		resp.Body.Close()
+	}
+}
+
+// Hijack must not wait for requests whose responses never come.
+func TestStressHijackOutstanding(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		io.Copy(io.Discard, br)
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req, _ := http.NewRequestWithContext(ctx, "GET", "http://example.com/", nil)
+			if resp, err := cc.Do(req); err == nil {
+				resp.Body.Close()
+			}
+		}()
+	}
+	waitFor(t, "requests on the wire", func() bool {
+		cc.wmu.Lock()
+		defer cc.wmu.Unlock()
+		return cc.lastTurn != closedChan
+	})
+	hijacked := make(chan net.Conn)
+	go func() {
+		c, _ := cc.Hijack()
+		hijacked <- c
+	}()
+	select {
+	case c := <-hijacked:
+		if c != nil {
+			c.Close()
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Hijack blocked behind outstanding requests")
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		t.Error("requests only returned on their deadline")
+	}
+}
+
+func TestStressCancelCloseHijack(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 10000))
+	}))
+	defer s.Close()
+	for round := 0; round < 30; round++ {
+		cc := dialConn(t, s.Listener.Addr().String())
+		var wg sync.WaitGroup
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 20; i++ {
+					timeout := time.Duration(i%5+1) * time.Millisecond
+					ctx, cancel := context.WithTimeout(context.Background(), timeout)
+					req, _ := http.NewRequestWithContext(ctx, "GET", s.URL, nil)
+					if resp, err := cc.Do(req); err == nil {
+						io.Copy(io.Discard, resp.Body)
+						resp.Body.Close()
+					}
+					cancel()
+				}
+			}()
+		}
+		time.Sleep(time.Duration(round%4) * time.Millisecond)
+		if round%2 == 0 {
+			cc.Close()
+		} else if c, _ := cc.Hijack(); c != nil {
+			c.Close()
+		}
+		wg.Wait()
+	}
+}
+
+func TestCloseTwice(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		io.Copy(io.Discard, br)
+	})
+	if err := cc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.Close(); err != nil {
+		t.Fatalf("second Close = %v", err)
+	}
+	select {
+	case <-cc.readDone:
+	case <-time.After(time.Second):
+		t.Fatal("readLoop still running after Close")
+	}
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	if _, err := cc.Do(req); err == nil {
+		t.Fatal("Do on a closed conn succeeded")
+	} else if errors.Is(err, ErrBodyWaitingRead) {
+		t.Fatalf("Do on a closed conn = %v", err)
+	}
+}
+
+// Close after Hijack closes nothing the caller now owns.
+func TestCloseAfterHijack(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		io.Copy(io.Discard, br)
+	})
+	c, _ := cc.Hijack()
+	if c == nil {
+		t.Fatal("Hijack returned no conn")
+	}
+	defer c.Close()
+	if err := cc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Write([]byte("x")); err != nil {
+		t.Fatalf("hijacked conn closed by Close: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package httpclientutil
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+var ErrTenantQueueFull = errors.New("http: tenant request queue full")
+
+// TenantLimiter bounds the requests each tenant has in flight, identified
+// by the WithConnTag tag of the request context. Every tenant waits in its
+// own queue, so a tenant at its limit never delays the others. A request
+// counts as in flight until its response body is closed or fully read.
+// Requests without a tag are not limited.
+type TenantLimiter struct {
+	Doer        Doer
+	MaxInFlight int            // per tenant; zero means no limit
+	PerTenant   map[string]int // overrides MaxInFlight for some tenants
+	MaxQueue    int            // waiting requests per tenant; zero means no limit
+
+	tenants shardedMap // tag -> *tenantQueue, nil if unlimited
+}
+
+type tenantQueue struct {
+	sem     chan struct{}
+	mu      sync.Mutex
+	waiting int
+}
+
+func (tl *TenantLimiter) Do(req *http.Request) (*http.Response, error) {
+	tag, ok := req.Context().Value(connTagKey{}).(string)
+	if !ok {
+		return tl.Doer.Do(req)
+	}
+	q := tl.queue(tag)
+	if q == nil {
+		return tl.Doer.Do(req)
+	}
+	if err := tl.acquire(req, q); err != nil {
+		return nil, err
+	}
+	release := func(error) { <-q.sem }
+	resp, err := tl.Doer.Do(req)
+	if err != nil {
+		release(err)
+		return nil, err
+	}
+	resp.Body = newNotifyBody(resp.Body, release)
+	return resp, nil
+}
+
+// queue returns the queue of tag, or nil if tag is unlimited.
+func (tl *TenantLimiter) queue(tag string) *tenantQueue {
+	q, _ := tl.tenants.load(tag, func() interface{} {
+		limit := tl.MaxInFlight
+		if n, ok := tl.PerTenant[tag]; ok {
+			limit = n
+		}
+		if limit <= 0 {
+			return (*tenantQueue)(nil)
+		}
+		return &tenantQueue{sem: make(chan struct{}, limit)}
+	}).(*tenantQueue)
+	return q
+}
+
+func (tl *TenantLimiter) acquire(req *http.Request, q *tenantQueue) error {
+	select {
+	case q.sem <- struct{}{}:
+		return nil
+	default:
+	}
+	q.mu.Lock()
+	if tl.MaxQueue > 0 && q.waiting >= tl.MaxQueue {
+		q.mu.Unlock()
+		return ErrTenantQueueFull
+	}
+	q.waiting++
+	q.mu.Unlock()
+	defer func() {
+		q.mu.Lock()
+		q.waiting--
+		q.mu.Unlock()
+	}()
+	select {
+	case q.sem <- struct{}{}:
+		return nil
+	case <-req.Context().Done():
+		return context.Cause(req.Context())
+	}
+}
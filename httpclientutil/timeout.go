@@ -0,0 +1,260 @@
+package httpclientutil
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrWriteTimeout          = errors.New("http: timeout writing request")
+	ErrResponseHeaderTimeout = errors.New("http: timeout awaiting response headers")
+	ErrIdleTimeout           = errors.New("http: idle connection timed out")
+)
+
+// WithWriteTimeout bounds the time to write each request. When it runs
+// out Do fails with ErrWriteTimeout and the connection is closed, since
+// the server got part of a request.
+func WithWriteTimeout(d time.Duration) Option {
+	return func(cc *ClientConn) { cc.timeouts.write = d }
+}
+
+// WithResponseHeaderTimeout bounds the time from writing a request until
+// its response headers have been read. When it runs out Do fails with
+// ErrResponseHeaderTimeout and the connection is closed. Responses come
+// in order, so with pipelining or write coalescing the time a request
+// spends behind earlier responses counts too.
+func WithResponseHeaderTimeout(d time.Duration) Option {
+	return func(cc *ClientConn) { cc.timeouts.header = d }
+}
+
+// WithIdleTimeout closes the connection once it has carried no request
+// for d: none is being written, awaits its response, or has a body still
+// being read. Reusable turns false and Ping reports ErrIdleTimeout.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(cc *ClientConn) { cc.timeouts.idle = d }
+}
+
+type connTimeouts struct {
+	write, header, idle, expect time.Duration
+}
+
+// idleState is the idle timer. gen tells a timer that fired late that it
+// was replaced.
+type idleState struct {
+	timer stopper
+	gen   int
+}
+
+// writeTimed runs fn, which writes to c, under the write timeout and the
+// deadline of ctx, whichever comes first. Running into the deadline of ctx
+// returns its cause.
+func (cc *ClientConn) writeTimed(ctx context.Context, c net.Conn, fn func() error) error {
+	deadline, fromCtx := ctx.Deadline()
+	if cc.timeouts.write > 0 {
+		if d := time.Now().Add(cc.timeouts.write); !fromCtx || d.Before(deadline) {
+			deadline, fromCtx = d, false
+		}
+	}
+	if deadline.IsZero() {
+		return fn()
+	}
+	c.SetWriteDeadline(deadline)
+	err := fn()
+	c.SetWriteDeadline(time.Time{})
+	// The request writer does not always wrap the net.Error it got.
+	if err != nil && !time.Now().Before(deadline) {
+		if fromCtx {
+			<-ctx.Done() // due by now
+			return context.Cause(ctx)
+		}
+		return ErrWriteTimeout
+	}
+	return err
+}
+
+// readDeadline puts the deadline of pr's context, if any, on the reads of
+// the response header, so they fail on time even if nothing else closes
+// the connection. The returned clear lifts it; it reports the cause of the
+// context if the deadline passed.
+func (cc *ClientConn) readDeadline(pr *pendingReq) (clear func() error) {
+	ctx := pr.req.Context()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return func() error { return nil }
+	}
+	cc.setConnReadDeadline(deadline)
+	return func() error {
+		cc.setConnReadDeadline(time.Time{})
+		return deadlineCause(ctx)
+	}
+}
+
+// armReadDeadline puts the deadline of pr's context on the connection
+// before pr is handed to readLoop, which is likely waiting for the first
+// byte of a response already and only learns about pr from that byte.
+// That is only right when no earlier response is outstanding.
+func (cc *ClientConn) armReadDeadline(pr *pendingReq) {
+	if deadline, ok := pr.req.Context().Deadline(); ok && atomic.LoadInt32(&cc.active) == 1 {
+		cc.setConnReadDeadline(deadline)
+	}
+}
+
+// setConnReadDeadline sets the read deadline of the connection. It holds
+// mu, so as not to undo the deadline Hijack sets once it detached the
+// connection.
+func (cc *ClientConn) setConnReadDeadline(t time.Time) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.conn != nil {
+		cc.conn.SetReadDeadline(t)
+	}
+}
+
+// expiredHeaderWait returns the cause of the context of the request that
+// armReadDeadline armed, if err, which readLoop got awaiting a response,
+// is the deadline running out. readLoop is about to exit, so it takes the
+// request off reqch.
+func (cc *ClientConn) expiredHeaderWait(err error) error {
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		return nil
+	}
+	select {
+	case pr := <-cc.reqch:
+		pr.stopHeaderTimeout()
+		return deadlineCause(pr.req.Context())
+	default:
+		return nil
+	}
+}
+
+// deadlineCause returns the cause of ctx if its deadline has passed.
+func deadlineCause(ctx context.Context) error {
+	if deadline, ok := ctx.Deadline(); !ok || time.Now().Before(deadline) {
+		return nil
+	}
+	<-ctx.Done() // due by now
+	return context.Cause(ctx)
+}
+
+// armHeaderTimeout (re)starts pr's response header timeout. It must run
+// before pr is handed to readLoop, which stops the timer once it read the
+// final headers, and runs again when a body held back for 100 Continue
+// has been sent.
+func (cc *ClientConn) armHeaderTimeout(pr *pendingReq) {
+	if cc.timeouts.header <= 0 {
+		return
+	}
+	pr.timerMu.Lock()
+	defer pr.timerMu.Unlock()
+	if pr.headerDone {
+		return
+	}
+	if pr.headerTimer != nil {
+		pr.headerTimer.Stop()
+	}
+	pr.headerTimer = afterFunc(cc.clock, cc.timeouts.header, "response header timer", func() { cc.abort(ErrResponseHeaderTimeout) })
+}
+
+// pauseHeaderTimeout stops the timer until armHeaderTimeout runs again.
+func (pr *pendingReq) pauseHeaderTimeout() {
+	pr.timerMu.Lock()
+	defer pr.timerMu.Unlock()
+	if pr.headerTimer != nil {
+		pr.headerTimer.Stop()
+		pr.headerTimer = nil
+	}
+}
+
+// stopHeaderTimeout stops the timer for good.
+func (pr *pendingReq) stopHeaderTimeout() {
+	pr.timerMu.Lock()
+	defer pr.timerMu.Unlock()
+	pr.headerDone = true
+	if pr.headerTimer != nil {
+		pr.headerTimer.Stop()
+	}
+}
+
+// beginExchange counts req, about to be written on c; the count drops in
+// endExchange once its response is finished, or once writing or handing
+// it to readLoop failed, and the idle timeout runs while it is zero.
+func (cc *ClientConn) beginExchange(req *http.Request, c net.Conn) {
+	reused := atomic.AddInt64(&cc.stats.requests, 1) > 1
+	var idle time.Duration
+	if atomic.AddInt32(&cc.active, 1) == 1 {
+		cc.unpark(false)
+		cc.mu.Lock()
+		cc.stopIdle()
+		cc.mu.Unlock()
+		if reused {
+			idle = cc.clock.Now().Sub(time.Unix(0, atomic.LoadInt64(&cc.stats.idleSince)))
+		}
+	}
+	traceGotConn(req, c, reused, idle)
+}
+
+func (cc *ClientConn) endExchange() {
+	if atomic.AddInt32(&cc.active, -1) == 0 {
+		atomic.StoreInt64(&cc.stats.idleSince, cc.clock.Now().UnixNano())
+		cc.armIdle()
+		cc.exchangesDone()
+		if cc.queue != nil {
+			cc.queue.release()
+		}
+	}
+}
+
+func (cc *ClientConn) armIdle() {
+	if cc.timeouts.idle <= 0 {
+		return
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.stopIdle()
+	if cc.conn == nil {
+		return // closed or hijacked; the timer would outlive cc
+	}
+	cc.idle.gen++
+	gen := cc.idle.gen
+	cc.idle.timer = afterFunc(cc.clock, cc.timeouts.idle, "idle timer", func() { cc.idleExpired(gen) })
+}
+
+// stopIdle stops the idle timer. The caller holds cc.mu.
+func (cc *ClientConn) stopIdle() {
+	if cc.idle.timer != nil {
+		cc.idle.timer.Stop()
+		cc.idle.timer = nil
+	}
+}
+
+func (cc *ClientConn) idleExpired(gen int) {
+	cc.mu.Lock()
+	idle := cc.idle.gen == gen && cc.idle.timer != nil && cc.conn != nil && atomic.LoadInt32(&cc.active) == 0
+	cc.mu.Unlock()
+	if idle {
+		cc.abort(ErrIdleTimeout)
+	}
+}
+
+// abortedWith reports whether err, read from cc.re, is the cause abort
+// recorded, which may be whatever cause the context of a canceled request
+// carries, or one isAborted knows.
+func (cc *ClientConn) abortedWith(err error) bool {
+	return isAborted(err) || err != nil && errors.Is(err, cc.aborted.Load())
+}
+
+// isAborted reports whether err is a cause abort records, for a canceled
+// request, a timeout or Drain, or that Hijack records.
+func isAborted(err error) bool {
+	switch err {
+	case context.Canceled, context.DeadlineExceeded, errRequestCanceled,
+		ErrWriteTimeout, ErrResponseHeaderTimeout, ErrIdleTimeout, ErrDraining, http.ErrHijacked:
+		return true
+	}
+	return false
+}
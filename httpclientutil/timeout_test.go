@@ -0,0 +1,74 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestWriteTimeout(t *testing.T) {
+	hold := make(chan struct{})
+	defer close(hold)
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		<-hold // never read, so the request fills the socket buffers
+	}, WithWriteTimeout(50*time.Millisecond))
+	req, _ := http.NewRequest("POST", "http://a.example/", bytes.NewReader(make([]byte, 64<<20)))
+	if _, err := cc.Do(req); err != ErrWriteTimeout {
+		t.Fatalf("err = %v, want ErrWriteTimeout", err)
+	}
+	if cc.Reusable() {
+		t.Error("connection reusable after a cut-off request")
+	}
+}
+
+func TestResponseHeaderTimeout(t *testing.T) {
+	hold := make(chan struct{})
+	defer close(hold)
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		if !answer(c, br, "fast") {
+			return
+		}
+		http.ReadRequest(br)
+		<-hold
+	}, WithResponseHeaderTimeout(50*time.Millisecond))
+	if got := doBody(t, cc); got != "fast" {
+		t.Fatalf("body = %q", got)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := cc.Ping(); err != nil {
+		t.Fatalf("timeout fired after the headers came: %v", err)
+	}
+	req, _ := http.NewRequest("GET", "http://a.example/slow", nil)
+	if _, err := cc.Do(req); err != ErrResponseHeaderTimeout {
+		t.Fatalf("err = %v, want ErrResponseHeaderTimeout", err)
+	}
+	if err := cc.Ping(); err != ErrResponseHeaderTimeout {
+		t.Errorf("Ping = %v", err)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		for answer(c, br, "hello") {
+		}
+	}, WithIdleTimeout(50*time.Millisecond))
+	req, _ := http.NewRequest("GET", "http://a.example/", nil)
+	resp, err := cc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An unread body keeps the connection busy.
+	time.Sleep(100 * time.Millisecond)
+	if err := cc.Ping(); err != nil {
+		t.Fatalf("closed while a body was pending: %v", err)
+	}
+	if b, _ := io.ReadAll(resp.Body); string(b) != "hello" {
+		t.Fatalf("body = %q", b)
+	}
+	resp.Body.Close()
+	waitFor(t, "the idle timeout", func() bool { return cc.Ping() == ErrIdleTimeout })
+}
@@ -0,0 +1,59 @@
+package httpclientutil
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"time"
+)
+
+// A ClientConn reports to the httptrace.ClientTrace in a request's context,
+// if any. Request.Write already reports WroteHeaderField, WroteHeaders and
+// WroteRequest; the calls below cover the rest of an exchange: GotConn as
+// the request claims the connection, Wait100Continue, GotFirstResponseByte,
+// Got1xxResponse and Got100Continue while the response is read. A
+// ClientConnPool reports GetConn, and the handshakes of a Dialer report
+// TLSHandshakeStart and TLSHandshakeDone; the DNS and Connect callbacks
+// come from net.Dialer itself.
+
+func traceGetConn(ctx context.Context, hostPort string) {
+	if t := httptrace.ContextClientTrace(ctx); t != nil && t.GetConn != nil {
+		t.GetConn(hostPort)
+	}
+}
+
+func traceGotConn(req *http.Request, c net.Conn, reused bool, idle time.Duration) {
+	if t := httptrace.ContextClientTrace(req.Context()); t != nil && t.GotConn != nil {
+		t.GotConn(httptrace.GotConnInfo{Conn: c, Reused: reused, WasIdle: idle > 0, IdleTime: idle})
+	}
+}
+
+func traceFirstResponseByte(req *http.Request) {
+	if t := httptrace.ContextClientTrace(req.Context()); t != nil && t.GotFirstResponseByte != nil {
+		t.GotFirstResponseByte()
+	}
+}
+
+func traceWait100Continue(req *http.Request) {
+	if t := httptrace.ContextClientTrace(req.Context()); t != nil && t.Wait100Continue != nil {
+		t.Wait100Continue()
+	}
+}
+
+// trace1xx reports an interim response. An error from Got1xxResponse
+// fails the request.
+func trace1xx(req *http.Request, resp *http.Response) error {
+	t := httptrace.ContextClientTrace(req.Context())
+	if t == nil {
+		return nil
+	}
+	if resp.StatusCode == http.StatusContinue && t.Got100Continue != nil {
+		t.Got100Continue()
+	}
+	if t.Got1xxResponse != nil {
+		return t.Got1xxResponse(resp.StatusCode, textproto.MIMEHeader(resp.Header))
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventTrace returns a trace recording its callbacks by name.
+func eventTrace() (*httptrace.ClientTrace, func() []string) {
+	var mu sync.Mutex
+	var events []string
+	add := func(e string) {
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	}
+	t := &httptrace.ClientTrace{
+		GetConn: func(string) { add("GetConn") },
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				add("GotConn reused")
+			} else {
+				add("GotConn")
+			}
+		},
+		WroteHeaders:         func() { add("WroteHeaders") },
+		Wait100Continue:      func() { add("Wait100Continue") },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { add("WroteRequest") },
+		GotFirstResponseByte: func() { add("GotFirstResponseByte") },
+		Got100Continue:       func() { add("Got100Continue") },
+		Got1xxResponse: func(code int, _ textproto.MIMEHeader) error {
+			add("Got1xxResponse")
+			return nil
+		},
+	}
+	return t, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), events...)
+	}
+}
+
+func tracedRequest(req *http.Request, t *httptrace.ClientTrace) *http.Request {
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), t))
+}
+
+func TestClientTrace(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		answer(c, br, "one")
+		answer(c, br, "two")
+	})
+	for i, want := range []string{
+		"GotConn WroteHeaders WroteRequest GotFirstResponseByte",
+		"GotConn reused WroteHeaders WroteRequest GotFirstResponseByte",
+	} {
+		trace, events := eventTrace()
+		req, _ := http.NewRequest("GET", "http://a.example/", nil)
+		resp, err := cc.Do(tracedRequest(req, trace))
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if got := strings.Join(events(), " "); got != want {
+			t.Errorf("request %d: events %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestClientTraceContinue(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 100 Continue\r\n\r\n")
+		io.ReadAll(req.Body)
+		writeResponse(c, "ok")
+	}, WithExpectContinueTimeout(5*time.Second))
+	trace, events := eventTrace()
+	resp, err := cc.Do(tracedRequest(continueRequest("payload"), trace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	want := "GotConn WroteHeaders Wait100Continue GotFirstResponseByte Got100Continue Got1xxResponse WroteRequest"
+	if got := strings.Join(events(), " "); got != want {
+		t.Errorf("events %q, want %q", got, want)
+	}
+}
+
+func TestClientTrace1xxError(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		if _, err := http.ReadRequest(br); err != nil {
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 103 Early Hints\r\n\r\n")
+		writeResponse(c, "final")
+	})
+	stop := errors.New("no hints wanted")
+	trace := &httptrace.ClientTrace{Got1xxResponse: func(int, textproto.MIMEHeader) error { return stop }}
+	req, _ := http.NewRequest("GET", "http://a.example/", nil)
+	if _, err := cc.Do(tracedRequest(req, trace)); !errors.Is(err, stop) {
+		t.Errorf("err = %v, want the trace's error", err)
+	}
+}
+
+func TestDialerTraceTLS(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer s.Close()
+	var got []string
+	trace := &httptrace.ClientTrace{
+		TLSHandshakeStart: func() { got = append(got, "start") },
+		TLSHandshakeDone: func(st tls.ConnectionState, err error) {
+			got = append(got, "done "+st.NegotiatedProtocol)
+		},
+	}
+	ctx := httptrace.WithClientTrace(context.Background(), trace)
+	cc, err := DialConn(ctx, "tcp", s.Listener.Addr().String(), &tls.Config{RootCAs: poolOf(s), ServerName: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cc.Close()
+	if strings.Join(got, ", ") != "start, done http/1.1" {
+		t.Errorf("events %q", got)
+	}
+}
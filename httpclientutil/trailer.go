@@ -0,0 +1,50 @@
+package httpclientutil
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// TrailerError reports trailer fields that a response declared in its
+// Trailer header and did not send.
+type TrailerError struct {
+	Missing []string // canonical names, sorted
+}
+
+func (e *TrailerError) Error() string {
+	return fmt.Sprintf("http: declared trailers not received: %s", strings.Join(e.Missing, ", "))
+}
+
+// AwaitTrailers reads what is left of resp's body, discarding it, closes
+// the body and returns the trailer. Trailer fields only arrive after the
+// last byte of a chunked body, so reading it to EOF, here or before, is
+// what fills resp.Trailer; a body closed before its end loses them, and
+// the connection with them. Fields the response declared in its Trailer
+// header but did not send are reported in a *TrailerError, along with the
+// fields that did arrive.
+func AwaitTrailers(resp *http.Response) (http.Header, error) {
+	// The declared names are keys of resp.Trailer until their values come.
+	declared := make([]string, 0, len(resp.Trailer))
+	for name := range resp.Trailer {
+		declared = append(declared, name)
+	}
+	_, err := copyBuffer(struct{ io.Writer }{io.Discard}, resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, name := range declared {
+		if len(resp.Trailer[name]) == 0 {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return resp.Trailer, &TrailerError{Missing: missing}
+	}
+	return resp.Trailer, nil
+}
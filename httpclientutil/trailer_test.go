@@ -0,0 +1,85 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+// trailerServer answers the first request with a chunked body "abcdef"
+// declaring X-Sum and X-Sig as trailers and sending trailer, then answers
+// "next".
+func trailerServer(t *testing.T, trailer string) *ClientConn {
+	return rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		if _, err := http.ReadRequest(br); err != nil {
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nTrailer: X-Sum, X-Sig\r\n\r\n"+
+			"3\r\nabc\r\n3\r\ndef\r\n0\r\n"+trailer+"\r\n")
+		answer(c, br, "next")
+	})
+}
+
+func TestTrailersAfterEOF(t *testing.T) {
+	cc := trailerServer(t, "X-Sum: 42\r\nX-Sig: s\r\n")
+	req, _ := http.NewRequest("GET", "http://a.example/", nil)
+	resp, err := cc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(b) != "abcdef" {
+		t.Fatalf("body %q, %v", b, err)
+	}
+	if resp.Trailer.Get("X-Sum") != "42" || resp.Trailer.Get("X-Sig") != "s" {
+		t.Errorf("trailer = %v", resp.Trailer)
+	}
+	if got := doBody(t, cc); got != "next" {
+		t.Errorf("next request got %q", got)
+	}
+}
+
+func TestAwaitTrailers(t *testing.T) {
+	cc := trailerServer(t, "X-Sum: 42\r\nX-Sig: s\r\n")
+	req, _ := http.NewRequest("GET", "http://a.example/", nil)
+	resp, err := cc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 2)
+	io.ReadFull(resp.Body, b)
+	trailer, err := AwaitTrailers(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trailer.Get("X-Sum") != "42" {
+		t.Errorf("trailer = %v", trailer)
+	}
+	if got := doBody(t, cc); got != "next" {
+		t.Errorf("next request got %q", got)
+	}
+}
+
+func TestAwaitTrailersMissing(t *testing.T) {
+	cc := trailerServer(t, "X-Sum: 42\r\n")
+	req, _ := http.NewRequest("GET", "http://a.example/", nil)
+	resp, err := cc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trailer, err := AwaitTrailers(resp)
+	var te *TrailerError
+	if !errors.As(err, &te) || len(te.Missing) != 1 || te.Missing[0] != "X-Sig" {
+		t.Fatalf("err = %v", err)
+	}
+	if trailer.Get("X-Sum") != "42" {
+		t.Errorf("trailer = %v", trailer)
+	}
+	if !cc.Reusable() {
+		t.Error("a missing trailer broke the connection")
+	}
+}
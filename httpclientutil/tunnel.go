@@ -0,0 +1,66 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// ConnectError is a proxy's refusal of a CONNECT request.
+type ConnectError struct {
+	Target     string
+	StatusCode int
+	Status     string
+	Body       []byte // first KB of the proxy's explanation
+}
+
+func (e *ConnectError) Error() string {
+	return fmt.Sprintf("http: proxy refused tunnel to %s: %s", e.Target, e.Status)
+}
+
+// ConnectTunnel asks the proxy at the other end of cc to open a tunnel to
+// target, a host:port, sending proxyAuth, if not empty, as the
+// Proxy-Authorization header. On a 2xx response it hijacks the connection
+// and returns it as the tunnel, ready for tls.Client; bytes the target
+// sent along with the response are read first. cc is unusable afterwards.
+// Any other response is returned as a *ConnectError, and cc stays as
+// reusable as that response left it.
+func (cc *ClientConn) ConnectTunnel(ctx context.Context, target, proxyAuth string) (net.Conn, error) {
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: target},
+		Host:   target,
+		Header: make(http.Header),
+	}
+	if proxyAuth != "" {
+		req.Header.Set("Proxy-Authorization", proxyAuth)
+	}
+	resp, err := cc.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		resp.Body.Close()
+		return nil, &ConnectError{Target: target, StatusCode: resp.StatusCode, Status: resp.Status, Body: body}
+	}
+	c := cc.HijackConn()
+	if c == nil {
+		return nil, errClosed
+	}
+	return c, nil
+}
+
+// bufferedConn is a net.Conn whose reads drain r first.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,59 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestConnectTunnel(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		if req.Method != "CONNECT" || req.RequestURI != "db.internal:5432" || req.Header.Get("Proxy-Authorization") != "Basic dTpw" {
+			io.WriteString(c, "HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\n\r\n")
+			return
+		}
+		// The greeting comes in the same packet as the response.
+		io.WriteString(c, "HTTP/1.1 200 Connection Established\r\n\r\nhello\n")
+		line, _ := br.ReadString('\n')
+		io.WriteString(c, "echo "+line)
+	})
+	tunnel, err := cc.ConnectTunnel(context.Background(), "db.internal:5432", "Basic dTpw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tunnel.Close()
+	r := bufio.NewReader(tunnel)
+	if got, _ := r.ReadString('\n'); got != "hello\n" {
+		t.Errorf("greeting = %q", got)
+	}
+	io.WriteString(tunnel, "ping\n")
+	if got, _ := r.ReadString('\n'); got != "echo ping\n" {
+		t.Errorf("reply = %q", got)
+	}
+}
+
+func TestConnectTunnelRefused(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		if _, err := http.ReadRequest(br); err != nil {
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 407 Proxy Authentication Required\r\nContent-Length: 12\r\n\r\nlog in first")
+		answer(c, br, "still here")
+	})
+	_, err := cc.ConnectTunnel(context.Background(), "db.internal:5432", "")
+	var ce *ConnectError
+	if !errors.As(err, &ce) || ce.StatusCode != 407 || string(ce.Body) != "log in first" {
+		t.Fatalf("err = %#v", err)
+	}
+	if got := doBody(t, cc); got != "still here" {
+		t.Errorf("after a refusal got %q", got)
+	}
+}
@@ -0,0 +1,75 @@
+package httpclientutil
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// unixHost is the host of requests sent on a unix socket, which has no
+// name of its own; Docker and the like accept any Host header.
+const unixHost = "localhost"
+
+// DialUnix dials the unix socket at path, or at "@name" for name in the
+// abstract namespace of Linux, with a zero Dialer; see Dialer.DialUnix.
+func DialUnix(ctx context.Context, path string, opts ...Option) (*ClientConn, error) {
+	return new(Dialer).DialUnix(ctx, path, opts...)
+}
+
+// DialUnix dials the unix socket at path, or at "@name" for name in the
+// abstract namespace of Linux, and returns a ClientConn on it. The host of
+// the requests sent on it is only their Host header, so any will do, e.g.
+// http://localhost/containers/json for the Docker daemon. opts are passed
+// to NewClientConn.
+func (d *Dialer) DialUnix(ctx context.Context, path string, opts ...Option) (*ClientConn, error) {
+	c, err := d.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return NewClientConn(c, opts...), nil
+}
+
+// SplitUnixURL splits a URL naming a unix socket and a request to send on
+// it, such as unix:///var/run/docker.sock:/containers/json?all=1, into the
+// socket's path and the request's URL, http://localhost/containers/json?all=1.
+// The scheme is unix or http+unix, and the request path follows the first
+// ":/" of the path, "/" if there is none. A socket path "/@name" names name
+// in the abstract namespace of Linux. ok is false for other schemes.
+func SplitUnixURL(u *url.URL) (socket string, target *url.URL, ok bool) {
+	if !strings.EqualFold(u.Scheme, "unix") && !strings.EqualFold(u.Scheme, "http+unix") {
+		return "", nil, false
+	}
+	socket, path := u.Path, "/"
+	if i := strings.Index(socket, ":/"); i >= 0 {
+		socket, path = socket[:i], socket[i+1:]
+	}
+	if strings.HasPrefix(socket, "/@") {
+		socket = socket[1:]
+	}
+	target = &url.URL{Scheme: "http", Host: unixHost, Path: path, RawQuery: u.RawQuery, Fragment: u.Fragment}
+	return socket, target, socket != ""
+}
+
+type unixSocketKey struct{}
+
+// unixRequest returns req for the socket its unix URL names, if it has
+// one: addressed to target, and carrying the socket for the pool to dial.
+func unixRequest(req *http.Request) *http.Request {
+	socket, target, ok := SplitUnixURL(req.URL)
+	if !ok {
+		return req
+	}
+	r := req.WithContext(context.WithValue(req.Context(), unixSocketKey{}, socket))
+	r.URL = target
+	if r.Host == "" || r.Host == req.URL.Host {
+		r.Host = target.Host
+	}
+	return r
+}
+
+// unixSocket returns the socket unixRequest attached to req.
+func unixSocket(req *http.Request) (string, bool) {
+	socket, ok := req.Context().Value(unixSocketKey{}).(string)
+	return socket, ok
+}
@@ -0,0 +1,93 @@
+package httpclientutil
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// unixServer serves Host, path and query back on a unix socket at addr.
+func unixServer(t *testing.T, addr string) {
+	ln, err := net.Listen("unix", addr)
+	if err != nil {
+		t.Skipf("unix sockets: %v", err)
+	}
+	s := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Host+" "+r.URL.RequestURI())
+	})}
+	go s.Serve(ln)
+	t.Cleanup(func() { s.Close() })
+}
+
+func TestSplitUnixURL(t *testing.T) {
+	for _, c := range []struct{ in, socket, target string }{
+		{"unix:///var/run/docker.sock:/containers/json?all=1", "/var/run/docker.sock", "http://localhost/containers/json?all=1"},
+		{"http+unix:///run/app.sock:/a:/b", "/run/app.sock", "http://localhost/a:/b"},
+		{"unix:///run/app.sock", "/run/app.sock", "http://localhost/"},
+		{"unix:///@app:/x", "@app", "http://localhost/x"},
+	} {
+		u, _ := url.Parse(c.in)
+		socket, target, ok := SplitUnixURL(u)
+		if !ok || socket != c.socket || target.String() != c.target {
+			t.Errorf("%s: %q, %v, %v", c.in, socket, target, ok)
+		}
+	}
+	u, _ := url.Parse("http://localhost/x")
+	if _, _, ok := SplitUnixURL(u); ok {
+		t.Error("http URL taken for a unix one")
+	}
+}
+
+func TestDialUnix(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "s.sock")
+	unixServer(t, sock)
+	cc, err := DialUnix(context.Background(), sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	if got := doBody(t, cc); got != "example.com /" {
+		t.Errorf("body = %q", got)
+	}
+}
+
+func TestPoolUnix(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "s.sock")
+	unixServer(t, sock)
+	sockets := []string{sock}
+	if runtime.GOOS == "linux" {
+		abstract := "@httpclientutil-test-" + filepath.Base(filepath.Dir(sock))
+		unixServer(t, abstract)
+		sockets = append(sockets, "/"+abstract)
+	}
+	p := new(ClientConnPool)
+	defer p.Close()
+	for _, s := range sockets {
+		for i := 0; i < 2; i++ {
+			req, _ := http.NewRequest("GET", "unix://"+s+":/info?v=1", nil)
+			resp, err := p.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(b) != "localhost /info?v=1" {
+				t.Errorf("%s: body = %q", s, b)
+			}
+		}
+	}
+	st := p.State()
+	if len(st.Hosts) != len(sockets) {
+		t.Fatalf("%d hosts, want %d", len(st.Hosts), len(sockets))
+	}
+	for _, h := range st.Hosts {
+		if h.Key.Scheme != "unix" || h.Open != 1 {
+			t.Errorf("%+v, want one unix connection", h)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// upgradeBody is the body of a 101 Switching Protocols response: the
+// connection itself, now speaking the protocol the server switched to, as
+// net/http's Transport hands it out. Reads drain the bytes cc buffered
+// first; Close closes the connection.
+type upgradeBody struct {
+	r *bufio.Reader
+	c net.Conn
+}
+
+func (b *upgradeBody) Read(p []byte) (int, error)  { return b.r.Read(p) }
+func (b *upgradeBody) Write(p []byte) (int, error) { return b.c.Write(p) }
+func (b *upgradeBody) Close() error                { return b.c.Close() }
+
+// isUpgrade reports whether resp switches the connection to the protocol
+// named in its Upgrade header.
+func isUpgrade(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return false
+	}
+	for _, v := range resp.Header["Connection"] {
+		for _, opt := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(opt), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
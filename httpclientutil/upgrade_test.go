@@ -0,0 +1,72 @@
+package httpclientutil
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestUpgradeBody(t *testing.T) {
+	closed := make(chan bool, 1)
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		if _, err := http.ReadRequest(br); err != nil {
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: keep-alive, Upgrade\r\n\r\nhello\n")
+		line, _ := br.ReadString('\n')
+		io.WriteString(c, "echo "+line)
+		_, err := br.ReadByte()
+		closed <- err == io.EOF
+	})
+	req, _ := http.NewRequest("GET", "http://a.example/", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "echo")
+	resp, err := cc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		t.Fatalf("body is a %T", resp.Body)
+	}
+	r := bufio.NewReader(rw)
+	if got, _ := r.ReadString('\n'); got != "hello\n" {
+		t.Errorf("greeting = %q", got)
+	}
+	io.WriteString(rw, "ping\n")
+	if got, _ := r.ReadString('\n'); got != "echo ping\n" {
+		t.Errorf("reply = %q", got)
+	}
+	if cc.Reusable() {
+		t.Error("reusable after an upgrade")
+	}
+	rw.Close()
+	if !<-closed {
+		t.Error("closing the body left the connection open")
+	}
+}
+
+// A 101 that does not name the upgrade in Connection has no conn to hand
+// out; the connection is dead all the same.
+func TestSwitchingProtocolsWithoutUpgrade(t *testing.T) {
+	cc := rawServer(t, func(c net.Conn, br *bufio.Reader) {
+		if _, err := http.ReadRequest(br); err != nil {
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 101 Switching Protocols\r\n\r\n")
+	})
+	req, _ := http.NewRequest("GET", "http://a.example/", nil)
+	resp, err := cc.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := resp.Body.(io.Writer); ok {
+		t.Error("body is writable")
+	}
+	resp.Body.Close()
+	if cc.Reusable() {
+		t.Error("reusable after 101")
+	}
+}
@@ -0,0 +1,202 @@
+package httpclientutil
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// ValidationError reports a response body rejected by a Validator.
+type ValidationError struct {
+	Validator string
+	URL       string
+	Err       error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("http: response from %s failed %s validation: %v", e.URL, e.Validator, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+// Validator checks response bodies while the caller reads them.
+type Validator struct {
+	Name string // in errors and stats, e.g. "order-schema"
+
+	// Match selects the responses to check; nil checks the 2xx ones.
+	Match func(*http.Response) bool
+
+	// Check reads the body from r, which yields each chunk as the caller
+	// reads it, and returns why it is invalid. It runs on its own
+	// goroutine and may return before EOF once it has seen enough. A
+	// check against a protobuf descriptor, say, reads r to the end and
+	// unmarshals; JSONValidator streams.
+	Check func(resp *http.Response, r io.Reader) error
+}
+
+// JSONValidator returns a Check that decodes the body as one JSON value
+// into newValue(), rejecting fields the value has no place for, and
+// passes the result to validate, if not nil. A malformed body fails as
+// soon as the decoder reaches the offending bytes.
+func JSONValidator(newValue func() interface{}, validate func(interface{}) error) func(*http.Response, io.Reader) error {
+	return func(resp *http.Response, r io.Reader) error {
+		v := newValue()
+		dec := json.NewDecoder(r)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(v); err != nil {
+			return err
+		}
+		if _, err := dec.Token(); err != io.EOF {
+			return errors.New("data after the JSON value")
+		}
+		if validate != nil {
+			return validate(v)
+		}
+		return nil
+	}
+}
+
+// ValidationStats counts the bodies a Validator finished checking. Bodies
+// closed before the check completed are not counted.
+type ValidationStats struct {
+	Passed, Failed int
+}
+
+// ValidatingDoer runs the matching Validators over every response body
+// from Doer. Once a check fails, the caller's next Read returns a
+// *ValidationError instead of more data, and at the latest at EOF, so
+// an invalid body is never taken as complete.
+type ValidatingDoer struct {
+	Doer       Doer
+	Validators []*Validator
+
+	// OnResult, if set, is called once per finished check with its
+	// error, nil for a pass, e.g. to export metrics.
+	OnResult func(v *Validator, resp *http.Response, err error)
+
+	mu    sync.Mutex
+	stats map[string]ValidationStats
+}
+
+func (vd *ValidatingDoer) Do(req *http.Request) (*http.Response, error) {
+	resp, err := vd.Doer.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range vd.Validators {
+		match := v.Match
+		if match == nil {
+			match = func(resp *http.Response) bool { return resp.StatusCode/100 == 2 }
+		}
+		if match(resp) {
+			resp.Body = vd.validate(v, req, resp)
+		}
+	}
+	return resp, nil
+}
+
+// Stats returns the counts so far, by Validator name.
+func (vd *ValidatingDoer) Stats() map[string]ValidationStats {
+	vd.mu.Lock()
+	defer vd.mu.Unlock()
+	stats := make(map[string]ValidationStats, len(vd.stats))
+	for name, s := range vd.stats {
+		stats[name] = s
+	}
+	return stats
+}
+
+func (vd *ValidatingDoer) record(v *Validator, resp *http.Response, err error) {
+	vd.mu.Lock()
+	if vd.stats == nil {
+		vd.stats = make(map[string]ValidationStats)
+	}
+	s := vd.stats[v.Name]
+	if err == nil {
+		s.Passed++
+	} else {
+		s.Failed++
+	}
+	vd.stats[v.Name] = s
+	vd.mu.Unlock()
+	if vd.OnResult != nil {
+		vd.OnResult(v, resp, err)
+	}
+}
+
+func (vd *ValidatingDoer) validate(v *Validator, req *http.Request, resp *http.Response) io.ReadCloser {
+	pr, pw := io.Pipe()
+	b := &validatingBody{body: resp.Body, pw: pw, done: make(chan struct{})}
+	spawn("validator", func() {
+		err := v.Check(resp, pr)
+		pr.CloseWithError(errCheckDone)
+		if !b.abandoned.Load() {
+			if err != nil {
+				b.err = &ValidationError{Validator: v.Name, URL: req.URL.String(), Err: err}
+			}
+			vd.record(v, resp, err)
+		}
+		close(b.done)
+	})
+	return b
+}
+
+// errCheckDone tells validatingBody that Check returned before EOF.
+var errCheckDone = errors.New("http: validation finished")
+
+// validatingBody feeds what the caller reads to a check through pw.
+type validatingBody struct {
+	body      io.ReadCloser
+	pw        *io.PipeWriter // nil once the check has all it wants
+	done      chan struct{}  // closed when the check returned
+	err       error          // the check's failure; set before done closes
+	abandoned atomicBool     // closed before the check finished
+}
+
+func (b *validatingBody) Read(p []byte) (int, error) {
+	if err := b.failure(); err != nil {
+		return 0, err
+	}
+	n, err := b.body.Read(p)
+	if n > 0 && b.pw != nil {
+		if _, werr := b.pw.Write(p[:n]); werr != nil {
+			b.pw = nil
+			<-b.done
+		}
+		if err := b.failure(); err != nil {
+			return 0, err
+		}
+	}
+	if err == io.EOF && b.pw != nil {
+		b.pw.Close()
+		b.pw = nil
+		<-b.done
+		if err := b.failure(); err != nil {
+			return 0, err
+		}
+	}
+	return n, err
+}
+
+// failure returns the check's error if it has already failed.
+func (b *validatingBody) failure() error {
+	select {
+	case <-b.done:
+		return b.err
+	default:
+		return nil
+	}
+}
+
+func (b *validatingBody) Close() error {
+	if b.pw != nil {
+		b.abandoned.Store(true)
+		b.pw.CloseWithError(io.ErrUnexpectedEOF)
+		b.pw = nil
+		<-b.done
+	}
+	return b.body.Close()
+}
@@ -0,0 +1,105 @@
+package httpclientutil
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type doerFunc func(*http.Request) (*http.Response, error)
+
+func (f doerFunc) Do(req *http.Request) (*http.Response, error) { return f(req) }
+
+type order struct {
+	ID    string `json:"id"`
+	Items int    `json:"items"`
+}
+
+func TestValidatingDoer(t *testing.T) {
+	bodies := map[string]string{
+		"/ok":        `{"id": "o1", "items": 2}`,
+		"/unknown":   `{"id": "o1", "extra": true}`,
+		"/truncated": `{"id": "o1", "ite`,
+		"/empty":     `{"id": "", "items": 0}`,
+		"/trailing":  `{"id": "o1"} {}`,
+	}
+	d := &ValidatingDoer{
+		Doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(bodies[req.URL.Path])), Request: req}, nil
+		}),
+		Validators: []*Validator{{
+			Name: "order",
+			Check: JSONValidator(func() interface{} { return new(order) }, func(v interface{}) error {
+				if v.(*order).ID == "" {
+					return errors.New("missing id")
+				}
+				return nil
+			}),
+		}},
+	}
+	var results int
+	d.OnResult = func(v *Validator, resp *http.Response, err error) { results++ }
+	for path, wantErr := range map[string]bool{"/ok": false, "/unknown": true, "/truncated": true, "/empty": true, "/trailing": true} {
+		req, _ := http.NewRequest("GET", "http://a.example"+path, nil)
+		resp, err := d.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		var ve *ValidationError
+		if got := errors.As(err, &ve); got != wantErr {
+			t.Errorf("%s: err = %v, want a ValidationError: %v", path, err, wantErr)
+		}
+		if !wantErr && string(b) != bodies[path] {
+			t.Errorf("%s: body = %q", path, b)
+		}
+	}
+	if s := d.Stats()["order"]; s.Passed != 1 || s.Failed != 4 || results != 5 {
+		t.Errorf("stats = %+v, %d results", s, results)
+	}
+
+	// A body closed before its end goes unjudged.
+	req, _ := http.NewRequest("GET", "http://a.example/ok", nil)
+	resp, _ := d.Do(req)
+	resp.Body.Read(make([]byte, 3))
+	resp.Body.Close()
+	if s := d.Stats()["order"]; s.Passed+s.Failed != 5 {
+		t.Errorf("abandoned body counted: %+v", s)
+	}
+}
+
+func TestValidatorFailsFast(t *testing.T) {
+	d := &ValidatingDoer{
+		Doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+			body := io.MultiReader(strings.NewReader("bad"), strings.NewReader(strings.Repeat("x", 1<<20)))
+			return &http.Response{StatusCode: 200, Body: io.NopCloser(body), Request: req}, nil
+		}),
+		Validators: []*Validator{{
+			Name: "magic",
+			Check: func(resp *http.Response, r io.Reader) error {
+				magic := make([]byte, 3)
+				if _, err := io.ReadFull(r, magic); err != nil {
+					return err
+				}
+				if string(magic) != "BAD" {
+					return errors.New("wrong magic")
+				}
+				return nil
+			},
+		}},
+	}
+	req, _ := http.NewRequest("GET", "http://a.example/", nil)
+	resp, err := d.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	buf := make([]byte, 3)
+	resp.Body.Read(buf)
+	if n, err := resp.Body.Read(buf); n != 0 || err == nil {
+		t.Fatalf("read after a failed check = %d, %v", n, err)
+	}
+}
@@ -0,0 +1,59 @@
+package httpclientutil
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// ConnKey identifies the physical connection a request can travel on: the
+// address dialed and whether it is wrapped in TLS. Behind a gateway that
+// routes by Host header, requests for many virtual hosts share one key,
+// and so can share connections instead of each host dialing its own.
+type ConnKey struct {
+	Scheme string // "http" or "https"
+	Addr   string // host:port dialed, after Hosts and overrides
+}
+
+// ConnKey returns the key of u's origin under ctx, applying Hosts and
+// WithHostOverride as DialContext would. d may be nil.
+func (d *Dialer) ConnKey(ctx context.Context, u *url.URL) ConnKey {
+	addr := canonicalAddr(u)
+	if d != nil {
+		addr = d.mapAddr(ctx, addr)
+	}
+	return ConnKey{Scheme: strings.ToLower(u.Scheme), Addr: addr}
+}
+
+// canonicalAddr returns u's host:port, adding the scheme's default port.
+func canonicalAddr(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if strings.EqualFold(u.Scheme, "https") {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// CanServeHost reports whether c may carry requests for host as well as
+// the one it was dialed for. A plain connection always may; a TLS one only
+// if its certificate is valid for host, the rule HTTP/2 uses for
+// connection coalescing (RFC 9113 section 9.1.1).
+func CanServeHost(c net.Conn, host string) bool {
+	tc, ok := c.(*tls.Conn)
+	if !ok {
+		return true
+	}
+	state := tc.ConnectionState()
+	if !state.HandshakeComplete || len(state.PeerCertificates) == 0 {
+		return false
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return state.PeerCertificates[0].VerifyHostname(host) == nil
+}
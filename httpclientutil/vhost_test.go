@@ -0,0 +1,63 @@
+package httpclientutil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestConnKeyVirtualHosts(t *testing.T) {
+	d := &Dialer{Hosts: map[string]string{"a.example": "10.0.0.1", "b.example": "10.0.0.1:80"}}
+	ctx := context.Background()
+	key := func(d *Dialer, ctx context.Context, raw string) ConnKey {
+		u, _ := url.Parse(raw)
+		return d.ConnKey(ctx, u)
+	}
+	a, b := key(d, ctx, "http://a.example/x"), key(d, ctx, "http://b.example/y")
+	if a != b || a.Addr != "10.0.0.1:80" {
+		t.Errorf("virtual hosts on one gateway: %v and %v", a, b)
+	}
+	if k := key(d, ctx, "https://a.example/x"); k.Scheme != "https" || k.Addr != "10.0.0.1:443" {
+		t.Errorf("https key = %v", k)
+	}
+	ctx = WithHostOverride(ctx, "c.example", "10.0.0.1")
+	if k := key(d, ctx, "http://c.example/"); k != a {
+		t.Errorf("overridden host key = %v, want %v", k, a)
+	}
+	if k := key(nil, ctx, "http://[::1]:8080/"); k.Addr != "[::1]:8080" {
+		t.Errorf("nil Dialer key = %v", k)
+	}
+}
+
+func TestCanServeHost(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer s.Close()
+	c, err := tls.Dial("tcp", s.Listener.Addr().String(), &tls.Config{RootCAs: poolOf(s), ServerName: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if !CanServeHost(c, "example.com:443") {
+		t.Error("TLS conn refused the host it was dialed for")
+	}
+	if CanServeHost(c, "other.test") {
+		t.Error("TLS conn accepted a host outside its certificate")
+	}
+	plain, err := net.Dial("tcp", s.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	if !CanServeHost(plain, "other.test") {
+		t.Error("plain conn refused a virtual host")
+	}
+}
+
+func poolOf(s *httptest.Server) *x509.CertPool {
+	return s.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+}
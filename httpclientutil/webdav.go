@@ -0,0 +1,159 @@
+package httpclientutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Depth header values for WebDAV requests.
+const (
+	DepthZero     = "0"
+	DepthOne      = "1"
+	DepthInfinity = "infinity"
+)
+
+// NewPropfindRequest returns a PROPFIND request for the named properties of
+// target, or for all properties if none are named.
+func NewPropfindRequest(ctx context.Context, target, depth string, props ...xml.Name) (*http.Request, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString(`<D:propfind xmlns:D="DAV:">`)
+	if len(props) == 0 {
+		buf.WriteString(`<D:allprop/>`)
+	} else {
+		buf.WriteString(`<D:prop>`)
+		for _, p := range props {
+			if err := xml.NewEncoder(&buf).Encode(struct {
+				XMLName xml.Name
+			}{p}); err != nil {
+				return nil, err
+			}
+		}
+		buf.WriteString(`</D:prop>`)
+	}
+	buf.WriteString(`</D:propfind>`)
+	req, err := http.NewRequest("PROPFIND", target, &buf)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", `application/xml; charset="utf-8"`)
+	req.Header.Set("Depth", depth)
+	return req.WithContext(ctx), nil
+}
+
+// NewMkcolRequest returns a MKCOL request creating the collection target.
+func NewMkcolRequest(ctx context.Context, target string) (*http.Request, error) {
+	req, err := http.NewRequest("MKCOL", target, nil)
+	if err != nil {
+		return nil, err
+	}
+	return req.WithContext(ctx), nil
+}
+
+// NewMoveRequest returns a MOVE request from src to the absolute URL dst.
+func NewMoveRequest(ctx context.Context, src, dst string, overwrite bool) (*http.Request, error) {
+	return newTransferRequest(ctx, "MOVE", src, dst, DepthInfinity, overwrite)
+}
+
+// NewCopyRequest returns a COPY request from src to the absolute URL dst.
+// depth is DepthZero or DepthInfinity.
+func NewCopyRequest(ctx context.Context, src, dst, depth string, overwrite bool) (*http.Request, error) {
+	return newTransferRequest(ctx, "COPY", src, dst, depth, overwrite)
+}
+
+func newTransferRequest(ctx context.Context, method, src, dst, depth string, overwrite bool) (*http.Request, error) {
+	req, err := http.NewRequest(method, src, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Destination", dst)
+	req.Header.Set("Depth", depth)
+	if overwrite {
+		req.Header.Set("Overwrite", "T")
+	} else {
+		req.Header.Set("Overwrite", "F")
+	}
+	return req.WithContext(ctx), nil
+}
+
+// MultiStatus is a parsed 207 Multi-Status body.
+type MultiStatus struct {
+	Responses []DAVResponse `xml:"DAV: response"`
+}
+
+// DAVResponse is one resource entry of a Multi-Status body.
+type DAVResponse struct {
+	Hrefs     []string   `xml:"DAV: href"`
+	Status    string     `xml:"DAV: status"`
+	Propstats []Propstat `xml:"DAV: propstat"`
+	Error     *Property  `xml:"DAV: error"`
+}
+
+// Propstat groups properties that share a status.
+type Propstat struct {
+	Prop   PropList `xml:"DAV: prop"`
+	Status string   `xml:"DAV: status"`
+}
+
+// PropList is the content of a prop element.
+type PropList struct {
+	Props []Property `xml:",any"`
+}
+
+// Property is a raw WebDAV property element.
+type Property struct {
+	XMLName  xml.Name
+	InnerXML []byte `xml:",innerxml"`
+}
+
+// StatusCode returns the code of r's own status line, or 0 if r only
+// reports per-property statuses.
+func (r *DAVResponse) StatusCode() int {
+	return parseDAVStatus(r.Status)
+}
+
+// Prop returns the named property if the server reported it with a 2xx
+// status.
+func (r *DAVResponse) Prop(name xml.Name) (*Property, bool) {
+	for i := range r.Propstats {
+		ps := &r.Propstats[i]
+		if parseDAVStatus(ps.Status)/100 != 2 {
+			continue
+		}
+		for j := range ps.Prop.Props {
+			if ps.Prop.Props[j].XMLName == name {
+				return &ps.Prop.Props[j], true
+			}
+		}
+	}
+	return nil, false
+}
+
+// ReadMultiStatus decodes a 207 response and closes its body.
+func ReadMultiStatus(resp *http.Response) (*MultiStatus, error) {
+	defer drainBody(resp)
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("http: expected 207 Multi-Status, got %s", resp.Status)
+	}
+	ms := new(MultiStatus)
+	if err := xml.NewDecoder(resp.Body).Decode(ms); err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+// parseDAVStatus extracts the code from a status line like
+// "HTTP/1.1 404 Not Found".
+func parseDAVStatus(line string) int {
+	f := strings.Fields(line)
+	if len(f) < 2 {
+		return 0
+	}
+	code, _ := strconv.Atoi(f[1])
+	return code
+}
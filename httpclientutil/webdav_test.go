@@ -0,0 +1,119 @@
+package httpclientutil
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:multistatus xmlns:d="DAV:" xmlns:x="urn:example">
+  <d:response>
+    <d:href>/dav/</d:href>
+    <d:propstat>
+      <d:prop><d:resourcetype><d:collection/></d:resourcetype><d:displayname>root</d:displayname></d:prop>
+      <d:status>HTTP/1.1 200 OK</d:status>
+    </d:propstat>
+    <d:propstat>
+      <d:prop><x:color/></d:prop>
+      <d:status>HTTP/1.1 404 Not Found</d:status>
+    </d:propstat>
+  </d:response>
+  <d:response>
+    <d:href>/dav/a.txt</d:href>
+    <d:propstat>
+      <d:prop><d:getcontentlength>12</d:getcontentlength><x:color>red</x:color></d:prop>
+      <d:status>HTTP/1.1 200 OK</d:status>
+    </d:propstat>
+  </d:response>
+  <d:response>
+    <d:href>/dav/locked</d:href>
+    <d:status>HTTP/1.1 423 Locked</d:status>
+    <d:error><d:lock-token-submitted/></d:error>
+  </d:response>
+</d:multistatus>`
+
+func TestPropfind(t *testing.T) {
+	color := xml.Name{Space: "urn:example", Local: "color"}
+	var sent string
+	d := doerFunc(func(req *http.Request) (*http.Response, error) {
+		b, _ := io.ReadAll(req.Body)
+		sent = string(b)
+		if req.Method != "PROPFIND" || req.Header.Get("Depth") != DepthOne {
+			t.Errorf("%s with Depth %q", req.Method, req.Header.Get("Depth"))
+		}
+		return &http.Response{StatusCode: http.StatusMultiStatus, Status: "207 Multi-Status", Header: http.Header{}, Body: io.NopCloser(strings.NewReader(propfindBody))}, nil
+	})
+	req, err := NewPropfindRequest(context.Background(), "http://a.example/dav/", DepthOne, xml.Name{Space: "DAV:", Local: "displayname"}, color)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := d.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms, err := ReadMultiStatus(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sent, `<displayname xmlns="DAV:"></displayname>`) || !strings.Contains(sent, `<color xmlns="urn:example"></color>`) {
+		t.Errorf("request body %s lacks the named properties", sent)
+	}
+	if len(ms.Responses) != 3 {
+		t.Fatalf("%d responses, want 3", len(ms.Responses))
+	}
+	root, file, locked := &ms.Responses[0], &ms.Responses[1], &ms.Responses[2]
+	if root.Hrefs[0] != "/dav/" || root.StatusCode() != 0 {
+		t.Errorf("root: href %q, status %d", root.Hrefs, root.StatusCode())
+	}
+	if p, ok := root.Prop(xml.Name{Space: "DAV:", Local: "displayname"}); !ok || string(p.InnerXML) != "root" {
+		t.Errorf("root displayname = %v, %v", p, ok)
+	}
+	if p, ok := root.Prop(xml.Name{Space: "DAV:", Local: "resourcetype"}); !ok || !strings.Contains(string(p.InnerXML), "collection") {
+		t.Errorf("root resourcetype = %v, %v", p, ok)
+	}
+	if _, ok := root.Prop(color); ok {
+		t.Error("a property reported 404 was returned")
+	}
+	if p, ok := file.Prop(color); !ok || string(p.InnerXML) != "red" {
+		t.Errorf("file color = %v, %v", p, ok)
+	}
+	if locked.StatusCode() != http.StatusLocked || locked.Error == nil {
+		t.Errorf("locked: status %d, error %v", locked.StatusCode(), locked.Error)
+	}
+}
+
+func TestReadMultiStatusErrors(t *testing.T) {
+	for _, tt := range []struct {
+		status int
+		body   string
+		want   string
+	}{
+		{http.StatusOK, propfindBody, "expected 207"},
+		{http.StatusMultiStatus, "<d:multistatus xmlns:d='DAV:'><d:response>", "EOF"},
+	} {
+		resp := &http.Response{StatusCode: tt.status, Status: http.StatusText(tt.status), Body: io.NopCloser(strings.NewReader(tt.body))}
+		if _, err := ReadMultiStatus(resp); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("status %d: err = %v, want %q", tt.status, err, tt.want)
+		}
+	}
+	for line, want := range map[string]int{"HTTP/1.1 404 Not Found": 404, "HTTP/1.1": 0, "": 0, "HTTP/1.1 abc": 0} {
+		if got := parseDAVStatus(line); got != want {
+			t.Errorf("parseDAVStatus(%q) = %d, want %d", line, got, want)
+		}
+	}
+}
+
+func TestTransferRequests(t *testing.T) {
+	req, _ := NewMoveRequest(context.Background(), "http://a.example/a", "http://a.example/b", false)
+	if req.Method != "MOVE" || req.Header.Get("Destination") != "http://a.example/b" || req.Header.Get("Overwrite") != "F" || req.Header.Get("Depth") != DepthInfinity {
+		t.Errorf("MOVE: %s %v", req.Method, req.Header)
+	}
+	req, _ = NewCopyRequest(context.Background(), "http://a.example/a", "http://a.example/b", DepthZero, true)
+	if req.Method != "COPY" || req.Header.Get("Overwrite") != "T" || req.Header.Get("Depth") != DepthZero {
+		t.Errorf("COPY: %s %v", req.Method, req.Header)
+	}
+}
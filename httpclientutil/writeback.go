@@ -0,0 +1,98 @@
+package httpclientutil
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// ConflictError is a write that failed its If-Match precondition with 412:
+// the resource changed since ETag was fetched.
+type ConflictError struct {
+	Method string
+	URL    string
+	ETag   string // the tag the write was conditioned on
+	Header http.Header
+	Body   []byte // up to 64KB of the response body
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("http: %s %s: resource changed since ETag %s", e.Method, e.URL, e.ETag)
+}
+
+// ETagDoer carries ETags from reads to writes for optimistic concurrency.
+// It remembers the ETag of each successful GET or HEAD by URL, and sends
+// it as If-Match on a later PUT, PATCH or DELETE of the same URL that
+// sets neither If-Match nor If-None-Match. A 412 response to such a
+// request is returned as a *ConflictError, its body read and closed. A
+// successful write keeps the ETag it returns, or forgets the old one. It
+// is safe for concurrent use.
+type ETagDoer struct {
+	Doer Doer
+
+	mu   sync.Mutex
+	tags map[string]string
+}
+
+func (d *ETagDoer) Do(req *http.Request) (*http.Response, error) {
+	key := etagKey(req)
+	tag := ""
+	switch req.Method {
+	case "PUT", "PATCH", "DELETE":
+		if req.Header.Get("If-Match") == "" && req.Header.Get("If-None-Match") == "" {
+			tag = d.ETag(key)
+		}
+	}
+	if tag != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-Match", tag)
+	}
+	resp, err := d.Doer.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case tag != "" && resp.StatusCode == http.StatusPreconditionFailed:
+		body, _ := readLimited(resp, maxErrorBody)
+		d.set(key, "")
+		return nil, &ConflictError{Method: req.Method, URL: key, ETag: tag, Header: resp.Header, Body: body}
+	case resp.StatusCode/100 != 2:
+	case req.Method == "GET" || req.Method == "HEAD" || req.Method == "PUT" || req.Method == "PATCH":
+		d.set(key, resp.Header.Get("ETag"))
+	case req.Method == "DELETE":
+		d.set(key, "")
+	}
+	return resp, nil
+}
+
+// ETag returns the tag remembered for url, if any.
+func (d *ETagDoer) ETag(url string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.tags[url]
+}
+
+// Forget drops the tag of url, so the next write goes unconditioned.
+func (d *ETagDoer) Forget(url string) {
+	d.set(url, "")
+}
+
+func (d *ETagDoer) set(key, tag string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if tag == "" {
+		delete(d.tags, key)
+		return
+	}
+	if d.tags == nil {
+		d.tags = make(map[string]string)
+	}
+	d.tags[key] = tag
+}
+
+// etagKey is the URL of req without its fragment.
+func etagKey(req *http.Request) string {
+	u := *req.URL
+	u.Fragment, u.RawFragment = "", ""
+	return u.String()
+}
@@ -0,0 +1,121 @@
+package httpclientutil
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// versionedServer serves one resource whose ETag is its version number,
+// and refuses writes whose If-Match names an older version.
+func versionedServer(t *testing.T) (*httptest.Server, func(), *[]string) {
+	var (
+		mu      sync.Mutex
+		version = 1
+		matches []string
+	)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		tag := `"` + strconv.Itoa(version) + `"`
+		if r.Method == "GET" {
+			w.Header().Set("ETag", tag)
+			io.WriteString(w, tag)
+			return
+		}
+		im := r.Header.Get("If-Match")
+		matches = append(matches, im)
+		if im != "" && im != tag {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			io.WriteString(w, "stale")
+			return
+		}
+		version++
+		w.Header().Set("ETag", `"`+strconv.Itoa(version)+`"`)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(s.Close)
+	bump := func() {
+		mu.Lock()
+		version++
+		mu.Unlock()
+	}
+	return s, bump, &matches
+}
+
+func TestETagDoer(t *testing.T) {
+	s, bump, matches := versionedServer(t)
+	d := &ETagDoer{Doer: http.DefaultClient}
+	do := func(method, url string) (*http.Response, error) {
+		t.Helper()
+		req, _ := http.NewRequest(method, url, strings.NewReader("x"))
+		resp, err := d.Do(req)
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		return resp, err
+	}
+	if _, err := do("GET", s.URL+"/r#top"); err != nil {
+		t.Fatal(err)
+	}
+	if tag := d.ETag(s.URL + "/r"); tag != `"1"` {
+		t.Fatalf("ETag after GET = %q", tag)
+	}
+	// The write carries the tag, and keeps the one it returns.
+	if _, err := do("PUT", s.URL+"/r"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := do("PATCH", s.URL+"/r"); err != nil {
+		t.Fatal(err)
+	}
+	// Someone else writes; the next write conflicts.
+	bump()
+	_, err := do("PUT", s.URL+"/r")
+	var ce *ConflictError
+	if !errors.As(err, &ce) || ce.ETag != `"3"` || string(ce.Body) != "stale" || ce.Method != "PUT" {
+		t.Fatalf("err = %v, want a ConflictError", err)
+	}
+	if tag := d.ETag(s.URL + "/r"); tag != "" {
+		t.Errorf("ETag after conflict = %q", tag)
+	}
+	// With the tag dropped, the next write goes unconditioned.
+	if _, err := do("PUT", s.URL+"/r"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`"1"`, `"2"`, `"3"`, ""}
+	if strings.Join(*matches, ",") != strings.Join(want, ",") {
+		t.Errorf("If-Match sent = %q, want %q", *matches, want)
+	}
+}
+
+func TestETagDoerExplicitHeader(t *testing.T) {
+	s, _, matches := versionedServer(t)
+	d := &ETagDoer{Doer: http.DefaultClient}
+	req, _ := http.NewRequest("GET", s.URL, nil)
+	resp, err := d.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	// A caller's own If-Match wins, and a 412 to it is left alone.
+	req, _ = http.NewRequest("PUT", s.URL, nil)
+	req.Header.Set("If-Match", `"9"`)
+	resp, err = d.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPreconditionFailed || (*matches)[0] != `"9"` {
+		t.Errorf("status %d, If-Match %q", resp.StatusCode, (*matches)[0])
+	}
+	d.Forget(s.URL)
+	if tag := d.ETag(s.URL); tag != "" {
+		t.Errorf("ETag after Forget = %q", tag)
+	}
+}